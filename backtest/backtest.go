@@ -0,0 +1,256 @@
+/*
+ backtest --- 回测的成交记录、权益曲线和绩效统计
+
+ 1. okex的期货是币本位，每个品种的资金都是该品种的币，不能直接相加
+    所以权益统计使用美元，权益 = 初始权益 + 各个品种盈亏(币) * 该品种最新价
+ 2. 初始权益 = 各个品种初始余额 * 该品种第一个价格，只作为计算收益率的基数
+ 3. 币本身的涨跌不计入策略的盈亏
+*/
+package backtest
+
+import (
+	"math"
+
+	"chive/protocol"
+)
+
+// 一笔成交
+type Trade struct {
+	Ts           uint64  `json:"ts"` // 成交时间，毫秒
+	Exchange     string  `json:"exchange"`
+	Symbol       string  `json:"symbol"`
+	ContractType string  `json:"contract_type"`
+	OrderId      string  `json:"order_id"`
+	OrderType    int32   `json:"order_type"`
+	Amount       float32 `json:"amount"`     // 成交张数
+	Price        float32 `json:"price"`      // 成交价格
+	Fee          float32 `json:"fee"`        // 手续费，币
+	Profit       float32 `json:"profit"`     // 平仓盈亏，币，开仓为0
+	ProfitUsd    float64 `json:"profit_usd"` // 平仓盈亏，美元
+}
+
+// 权益曲线上的一个点
+type EquityPoint struct {
+	Ts     uint64  `json:"ts"`
+	Equity float64 `json:"equity"` // 美元
+}
+
+type Report struct {
+	Start            uint64        `json:"start"`
+	End              uint64        `json:"end"`
+	InitialEquity    float64       `json:"initial_equity"`
+	FinalEquity      float64       `json:"final_equity"`
+	PnL              float64       `json:"pnl"`
+	Return           float64       `json:"return"`
+	MaxDrawdown      float64       `json:"max_drawdown"`       // 最大回撤比例
+	MaxDrawdownValue float64       `json:"max_drawdown_value"` // 最大回撤，美元
+	TradeCount       int           `json:"trade_count"`
+	CloseCount       int           `json:"close_count"`
+	WinCount         int           `json:"win_count"`
+	WinRate          float64       `json:"win_rate"`
+	Sharpe           float64       `json:"sharpe"` // 年化夏普比率，无风险利率为0
+	Fees             float64       `json:"fees"`   // 手续费，美元
+	Trades           []Trade       `json:"trades"`
+	EquityCurve      []EquityPoint `json:"equity_curve"`
+}
+
+type Recorder struct {
+	interval   uint64             // 权益曲线采样间隔，毫秒
+	balances   map[string]float64 // key: exchange_symbol, 初始余额
+	prices     map[string]float64 // key: exchange_symbol, 第一个价格
+	trades     []Trade
+	pnls       []EquityPoint // 盈亏曲线，加上初始权益后就是权益曲线
+	lastSample uint64
+}
+
+const DEFAULT_SAMPLE_INTERVAL = 60 * 1000
+
+const year_ms = 365 * 24 * 3600 * 1000
+
+func NewRecorder(interval uint64) *Recorder {
+	if interval <= 0 {
+		interval = DEFAULT_SAMPLE_INTERVAL
+	}
+	return &Recorder{
+		interval: interval,
+		balances: make(map[string]float64),
+		prices:   make(map[string]float64),
+		trades:   make([]Trade, 0),
+		pnls:     make([]EquityPoint, 0),
+	}
+}
+
+// 记录品种的初始余额
+func (r *Recorder) SetBalance(symbol string, balance float64) {
+	r.balances[symbol] = balance
+}
+
+// 记录品种的价格，只有第一个价格用于计算初始权益
+func (r *Recorder) SetPrice(symbol string, price float64) {
+	if price <= 0 {
+		return
+	}
+	if _, ok := r.prices[symbol]; !ok {
+		r.prices[symbol] = price
+	}
+}
+
+func (r *Recorder) AddTrade(t Trade) {
+	r.trades = append(r.trades, t)
+}
+
+/*
+ 按采样间隔记录盈亏，pnl是各个品种盈亏折算成美元的和
+ force为true时不管间隔都记录，用于回测结束时记录最后一个点
+*/
+func (r *Recorder) UpdatePnL(ts uint64, pnl float64, force bool) {
+	if !force && len(r.pnls) > 0 && ts < r.lastSample+r.interval {
+		return
+	}
+	if len(r.pnls) > 0 && ts == r.pnls[len(r.pnls)-1].Ts {
+		r.pnls[len(r.pnls)-1].Equity = pnl
+		return
+	}
+	r.pnls = append(r.pnls, EquityPoint{Ts: ts, Equity: pnl})
+	r.lastSample = ts
+}
+
+func (r *Recorder) initialEquity() float64 {
+	var ret float64 = 0
+	for s, b := range r.balances {
+		if p, ok := r.prices[s]; ok {
+			ret += b * p
+		}
+	}
+	return ret
+}
+
+func (r *Recorder) Report() *Report {
+	rp := &Report{
+		InitialEquity: r.initialEquity(),
+		Trades:        r.trades,
+		EquityCurve:   make([]EquityPoint, 0, len(r.pnls)),
+	}
+	for _, v := range r.pnls {
+		rp.EquityCurve = append(rp.EquityCurve, EquityPoint{Ts: v.Ts, Equity: rp.InitialEquity + v.Equity})
+	}
+
+	rp.stat(r.interval)
+	return rp
+}
+
+/*
+ 拼接多段连续的回测报告，比如walk forward的样本外报告
+ 后一段的盈亏接在前一段的期末权益上，初始权益使用第一段的初始权益
+*/
+func Stitch(reports []*Report, interval uint64) *Report {
+	rp := &Report{
+		Trades:      make([]Trade, 0),
+		EquityCurve: make([]EquityPoint, 0),
+	}
+	if len(reports) == 0 {
+		return rp
+	}
+
+	rp.InitialEquity = reports[0].InitialEquity
+	equity := rp.InitialEquity
+	for _, v := range reports {
+		for _, p := range v.EquityCurve {
+			rp.EquityCurve = append(rp.EquityCurve, EquityPoint{Ts: p.Ts, Equity: equity + p.Equity - v.InitialEquity})
+		}
+		rp.Trades = append(rp.Trades, v.Trades...)
+		equity += v.PnL
+	}
+	rp.stat(interval)
+	return rp
+}
+
+// 根据权益曲线和成交计算绩效统计
+func (rp *Report) stat(interval uint64) {
+	rp.FinalEquity = rp.InitialEquity
+	if len(rp.EquityCurve) > 0 {
+		rp.Start = rp.EquityCurve[0].Ts
+		rp.End = rp.EquityCurve[len(rp.EquityCurve)-1].Ts
+		rp.FinalEquity = rp.EquityCurve[len(rp.EquityCurve)-1].Equity
+	}
+	rp.PnL = rp.FinalEquity - rp.InitialEquity
+	if rp.InitialEquity > 0 {
+		rp.Return = rp.PnL / rp.InitialEquity
+	}
+
+	rp.MaxDrawdown, rp.MaxDrawdownValue = MaxDrawdown(rp.EquityCurve)
+	rp.Sharpe = Sharpe(rp.EquityCurve, interval)
+
+	rp.TradeCount = len(rp.Trades)
+	for _, t := range rp.Trades {
+		rp.Fees += float64(t.Fee) * float64(t.Price)
+		if t.OrderType != protocol.ORDERTYPE_CLOSELONG && t.OrderType != protocol.ORDERTYPE_CLOSESHORT {
+			continue
+		}
+		rp.CloseCount += 1
+		if t.Profit > 0 {
+			rp.WinCount += 1
+		}
+	}
+	if rp.CloseCount > 0 {
+		rp.WinRate = float64(rp.WinCount) / float64(rp.CloseCount)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 最大回撤，返回回撤比例和回撤值
+func MaxDrawdown(curve []EquityPoint) (float64, float64) {
+	var peak float64 = 0
+	var ddRate float64 = 0
+	var ddValue float64 = 0
+	for i, v := range curve {
+		if i == 0 || v.Equity > peak {
+			peak = v.Equity
+			continue
+		}
+		d := peak - v.Equity
+		if d > ddValue {
+			ddValue = d
+		}
+		if peak > 0 && d/peak > ddRate {
+			ddRate = d / peak
+		}
+	}
+	return ddRate, ddValue
+}
+
+// 根据采样间隔的收益率计算年化夏普比率
+func Sharpe(curve []EquityPoint, interval uint64) float64 {
+	if len(curve) < 3 || interval <= 0 {
+		return 0
+	}
+
+	rets := make([]float64, 0, len(curve)-1)
+	for i := 1; i < len(curve); i++ {
+		prev := curve[i-1].Equity
+		if prev <= 0 {
+			continue
+		}
+		rets = append(rets, (curve[i].Equity-prev)/prev)
+	}
+	if len(rets) < 2 {
+		return 0
+	}
+
+	var sum float64 = 0
+	for _, v := range rets {
+		sum += v
+	}
+	mean := sum / float64(len(rets))
+
+	var sq float64 = 0
+	for _, v := range rets {
+		sq += (v - mean) * (v - mean)
+	}
+	std := math.Sqrt(sq / float64(len(rets)-1))
+	if std <= 0 {
+		return 0
+	}
+	return mean / std * math.Sqrt(float64(year_ms)/float64(interval))
+}
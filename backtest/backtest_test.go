@@ -0,0 +1,150 @@
+package backtest
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chive/config"
+	"chive/protocol"
+)
+
+func TestMaxDrawdown(t *testing.T) {
+	curve := []EquityPoint{
+		{Ts: 1, Equity: 100},
+		{Ts: 2, Equity: 120},
+		{Ts: 3, Equity: 90},
+		{Ts: 4, Equity: 130},
+		{Ts: 5, Equity: 117},
+	}
+	rate, value := MaxDrawdown(curve)
+	if value != 30 || rate != 0.25 {
+		t.Fatalf("max drawdown error, rate[%f], value[%f]", rate, value)
+	}
+}
+
+func TestRecorderReport(t *testing.T) {
+	r := NewRecorder(1000)
+	r.SetBalance("okex_ltc_usd", 10)
+	r.SetPrice("okex_ltc_usd", 100)
+	r.SetPrice("okex_ltc_usd", 200)
+
+	r.UpdatePnL(1000, 0, false)
+	r.UpdatePnL(1500, 5, false)
+	r.UpdatePnL(2000, 10, false)
+	r.UpdatePnL(3000, -20, false)
+	r.UpdatePnL(3500, 40, true)
+
+	r.AddTrade(Trade{OrderType: protocol.ORDERTYPE_OPENLONG})
+	r.AddTrade(Trade{OrderType: protocol.ORDERTYPE_CLOSELONG, Profit: 0.1})
+	r.AddTrade(Trade{OrderType: protocol.ORDERTYPE_OPENSHORT})
+	r.AddTrade(Trade{OrderType: protocol.ORDERTYPE_CLOSESHORT, Profit: -0.2})
+
+	rp := r.Report()
+	if rp.InitialEquity != 1000 || rp.FinalEquity != 1040 || rp.PnL != 40 {
+		t.Fatalf("equity error, %f %f %f", rp.InitialEquity, rp.FinalEquity, rp.PnL)
+	}
+	if len(rp.EquityCurve) != 4 {
+		t.Fatalf("equity curve should have 4 points, got %d", len(rp.EquityCurve))
+	}
+	if rp.TradeCount != 4 || rp.CloseCount != 2 || rp.WinCount != 1 || rp.WinRate != 0.5 {
+		t.Fatalf("trade statis error, %+v", rp)
+	}
+	if rp.MaxDrawdownValue != 30 {
+		t.Fatalf("max drawdown value error, %f", rp.MaxDrawdownValue)
+	}
+
+	dir, err := ioutil.TempDir("", "backtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := rp.Write(dir); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"report.json", "summary.csv", "trades.csv", "equity.csv"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Fatalf("report file [%s] missing", f)
+		}
+	}
+}
+
+func TestStitch(t *testing.T) {
+	r1 := &Report{
+		InitialEquity: 1000,
+		EquityCurve:   []EquityPoint{{Ts: 1, Equity: 1000}, {Ts: 2, Equity: 1050}},
+		Trades:        []Trade{{OrderType: protocol.ORDERTYPE_CLOSELONG, Profit: 0.1}},
+		PnL:           50,
+	}
+	r2 := &Report{
+		InitialEquity: 2000,
+		EquityCurve:   []EquityPoint{{Ts: 3, Equity: 2000}, {Ts: 4, Equity: 1970}},
+		Trades:        []Trade{{OrderType: protocol.ORDERTYPE_CLOSESHORT, Profit: -0.1}},
+		PnL:           -30,
+	}
+
+	// 第二段从第一段的期末权益1050开始
+	rp := Stitch([]*Report{r1, r2}, 1000)
+	if rp.InitialEquity != 1000 || rp.FinalEquity != 1020 || rp.PnL != 20 {
+		t.Fatalf("stitch equity error, %f %f %f", rp.InitialEquity, rp.FinalEquity, rp.PnL)
+	}
+	if rp.EquityCurve[2].Equity != 1050 || rp.MaxDrawdownValue != 30 {
+		t.Fatalf("stitch curve error, %+v", rp.EquityCurve)
+	}
+	if rp.Start != 1 || rp.End != 4 || rp.CloseCount != 2 || rp.WinRate != 0.5 {
+		t.Fatalf("stitch statis error, %+v", rp)
+	}
+}
+
+func TestCosts(t *testing.T) {
+	if _, err := NewCosts(config.CostCnf{Slippage: "unknown"}); err == nil {
+		t.Fatal("unknown slippage model should fail")
+	}
+	if _, err := NewCosts(config.CostCnf{Latency: 4000, LatencyJitter: 1000}); err == nil {
+		t.Fatal("latency reaching the track timeout should fail")
+	}
+
+	c, err := NewCosts(config.CostCnf{MakerFee: 0.0002, TakerFee: 0.0005, Slippage: SLIPPAGE_FIXED, SlippageRate: 0.001, Latency: 100, LatencyJitter: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Fee.Rate(true) != 0.0002 || c.Fee.Rate(false) != 0.0005 {
+		t.Fatal("fee rate is wrong")
+	}
+	if p := c.Slippage.Apply("ltc_usd_this_week", 100, true); math.Abs(p-100.1) > 1e-9 {
+		t.Fatalf("buy price with slippage is %f, want 100.1", p)
+	}
+	for i := 0; i < 100; i++ {
+		if d := c.Latency.Delay(); d < 100 || d > 150 {
+			t.Fatalf("latency %d is out of range", d)
+		}
+	}
+	if c.Fill != nil {
+		t.Fatal("default fill model should match by book")
+	}
+
+	// 价格不够window个时没有滑点
+	c, _ = NewCosts(config.CostCnf{Slippage: SLIPPAGE_VOLATILITY, SlippageRate: 1, VolWindow: 3, Fill: FILL_TRADE, FillProb: 0})
+	c.Slippage.Update("k", 100)
+	c.Slippage.Update("k", 101)
+	if p := c.Slippage.Apply("k", 100, false); p != 100 {
+		t.Fatalf("sell price is %f before window is full", p)
+	}
+	c.Slippage.Update("k", 100)
+	if p := c.Slippage.Apply("k", 100, false); p >= 100 {
+		t.Fatalf("sell price with slippage is %f, should be lower", p)
+	}
+
+	if n := c.Fill.Fill(true, 100, 99.9, 3); n != 3 {
+		t.Fatalf("trade through limit fills %f, want 3", n)
+	}
+	if n := c.Fill.Fill(true, 100, 100, 3); n != 0 {
+		t.Fatalf("trade at limit fills %f with probability 0", n)
+	}
+	if n := c.Fill.Fill(false, 100, 99.9, 3); n != 0 {
+		t.Fatalf("sell order fills %f by a lower trade", n)
+	}
+}
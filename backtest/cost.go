@@ -0,0 +1,217 @@
+package backtest
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+
+	"chive/config"
+)
+
+/*
+ 模拟成交的成本模型
+
+ 1. 手续费：按挂单(maker)和吃单(taker)区分费率
+ 2. 滑点：吃单的成交价向不利方向偏移，固定比例或者按最近价格的波动率
+ 3. 延迟：委托和撤单要过一段时间才到达交易所，期间行情继续变化
+ 4. 挂单成交：限价挂单可以只在逐笔成交穿过委托价时成交，等于委托价时按概率成交
+
+ 每个交易所一套模型，有随机数的模型使用配置的种子，同样的配置回测结果一样
+*/
+
+// 委托和撤单延迟的上限，毫秒，要小于krang跟踪请求的截止时间，否则请求还没到达交易所就超时了
+const MAX_LATENCY = 5000
+
+const (
+	SLIPPAGE_FIXED      = "fixed"
+	SLIPPAGE_VOLATILITY = "volatility"
+	FILL_TRADE          = "trade"
+)
+
+// 手续费
+type FeeModel interface {
+	// 手续费率，maker为true表示挂单成交
+	Rate(maker bool) float64
+}
+
+// 滑点
+type SlippageModel interface {
+	// 更新最新价，key是symbol_contractType
+	Update(key string, price float64)
+
+	// 吃单的成交价，buy为true表示买入
+	Apply(key string, price float64, buy bool) float64
+}
+
+// 委托和撤单到达交易所的延迟
+type LatencyModel interface {
+	// 毫秒
+	Delay() uint64
+}
+
+// 限价挂单按逐笔成交撮合
+type FillModel interface {
+	// 一笔成交能让挂单成交多少张，limit是委托价，price和amount是逐笔成交的价格和张数
+	Fill(buy bool, limit float64, price float64, amount float64) float64
+}
+
+type Costs struct {
+	Fee      FeeModel
+	Slippage SlippageModel
+	Latency  LatencyModel
+	Fill     FillModel // 为nil时挂单按盘口撮合
+}
+
+/*
+ 按配置创建成本模型，全部为0时和没有成本一样
+*/
+func NewCosts(c config.CostCnf) (*Costs, error) {
+	if c.Latency < 0 || c.LatencyJitter < 0 {
+		return nil, errors.New("latency should not be negative")
+	}
+	if c.Latency+c.LatencyJitter >= MAX_LATENCY {
+		return nil, fmt.Errorf("latency plus latency jitter should be less than %d ms", MAX_LATENCY)
+	}
+	rnd := rand.New(rand.NewSource(c.Seed))
+	costs := &Costs{
+		Fee:     &feeRate{maker: c.MakerFee, taker: c.TakerFee},
+		Latency: &latency{fixed: uint64(c.Latency), jitter: c.LatencyJitter, rnd: rnd},
+	}
+
+	switch c.Slippage {
+	case "":
+		costs.Slippage = &fixedSlippage{rate: 0}
+	case SLIPPAGE_FIXED:
+		costs.Slippage = &fixedSlippage{rate: c.SlippageRate}
+	case SLIPPAGE_VOLATILITY:
+		if c.VolWindow < 2 {
+			return nil, errors.New("volatility slippage window should be at least 2")
+		}
+		costs.Slippage = &volSlippage{rate: c.SlippageRate, window: c.VolWindow, prices: make(map[string][]float64)}
+	default:
+		return nil, fmt.Errorf("unknown slippage model %s", c.Slippage)
+	}
+
+	switch c.Fill {
+	case "":
+	case FILL_TRADE:
+		if c.FillProb < 0 || c.FillProb > 1 {
+			return nil, errors.New("fill probability should be in [0, 1]")
+		}
+		costs.Fill = &tradeFill{prob: c.FillProb, rnd: rnd}
+	default:
+		return nil, fmt.Errorf("unknown fill model %s", c.Fill)
+	}
+	return costs, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type feeRate struct {
+	maker float64
+	taker float64
+}
+
+func (f *feeRate) Rate(maker bool) float64 {
+	if maker {
+		return f.maker
+	}
+	return f.taker
+}
+
+func slip(price float64, d float64, buy bool) float64 {
+	if buy {
+		return price + d
+	}
+	return price - d
+}
+
+// 成交价偏移固定的比例
+type fixedSlippage struct {
+	rate float64
+}
+
+func (s *fixedSlippage) Update(key string, price float64) {}
+
+func (s *fixedSlippage) Apply(key string, price float64, buy bool) float64 {
+	return slip(price, price*s.rate, buy)
+}
+
+/*
+ 成交价偏移 rate * 波动率 * 价格
+ 波动率是最近window个价格的对数收益率的标准差，价格不够时没有滑点
+*/
+type volSlippage struct {
+	rate   float64
+	window int
+	prices map[string][]float64
+}
+
+func (s *volSlippage) Update(key string, price float64) {
+	if price <= 0 {
+		return
+	}
+	ps := append(s.prices[key], price)
+	if len(ps) > s.window {
+		ps = ps[len(ps)-s.window:]
+	}
+	s.prices[key] = ps
+}
+
+func (s *volSlippage) volatility(key string) float64 {
+	ps := s.prices[key]
+	if len(ps) < s.window {
+		return 0
+	}
+	rs := make([]float64, 0, len(ps)-1)
+	var sum float64
+	for i := 1; i < len(ps); i++ {
+		r := math.Log(ps[i] / ps[i-1])
+		rs = append(rs, r)
+		sum += r
+	}
+	mean := sum / float64(len(rs))
+	var sq float64
+	for _, r := range rs {
+		sq += (r - mean) * (r - mean)
+	}
+	return math.Sqrt(sq / float64(len(rs)))
+}
+
+func (s *volSlippage) Apply(key string, price float64, buy bool) float64 {
+	return slip(price, price*s.rate*s.volatility(key), buy)
+}
+
+// 固定延迟加上[0, jitter]的随机延迟
+type latency struct {
+	fixed  uint64
+	jitter int
+	rnd    *rand.Rand
+}
+
+func (l *latency) Delay() uint64 {
+	if l.jitter <= 0 {
+		return l.fixed
+	}
+	return l.fixed + uint64(l.rnd.Intn(l.jitter+1))
+}
+
+/*
+ 成交价穿过委托价时按成交的张数成交
+ 成交价等于委托价时，按概率prob成交，模拟排队的位置
+*/
+type tradeFill struct {
+	prob float64
+	rnd  *rand.Rand
+}
+
+func (f *tradeFill) Fill(buy bool, limit float64, price float64, amount float64) float64 {
+	if (buy && price < limit) || (!buy && price > limit) {
+		return amount
+	}
+	if price == limit && f.rnd.Float64() < f.prob {
+		return amount
+	}
+	return 0
+}
@@ -0,0 +1,102 @@
+package backtest
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"chive/protocol"
+	"chive/utils"
+)
+
+/*
+ 回测报告输出到一个目录下
+ report.json  全部内容
+ summary.csv  绩效统计
+ trades.csv   成交列表
+ equity.csv   权益曲线
+*/
+
+func tsStr(ts uint64) string {
+	return time.Unix(int64(ts/1000), 0).Format(protocol.TM_LAYOUT_STR)
+}
+
+func (rp *Report) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	if err := rp.WriteJSON(filepath.Join(dir, "report.json")); err != nil {
+		return err
+	}
+	return rp.WriteCSV(dir)
+}
+
+func (rp *Report) WriteJSON(filename string) error {
+	bs, err := json.MarshalIndent(rp, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, bs, 0644)
+}
+
+func (rp *Report) WriteCSV(dir string) error {
+	f := func(v float64) string {
+		return fmt.Sprintf("%f", v)
+	}
+
+	summary := [][]string{
+		{"start", tsStr(rp.Start)},
+		{"end", tsStr(rp.End)},
+		{"initial_equity", f(rp.InitialEquity)},
+		{"final_equity", f(rp.FinalEquity)},
+		{"pnl", f(rp.PnL)},
+		{"return", f(rp.Return)},
+		{"max_drawdown", f(rp.MaxDrawdown)},
+		{"max_drawdown_value", f(rp.MaxDrawdownValue)},
+		{"trade_count", fmt.Sprintf("%d", rp.TradeCount)},
+		{"close_count", fmt.Sprintf("%d", rp.CloseCount)},
+		{"win_count", fmt.Sprintf("%d", rp.WinCount)},
+		{"win_rate", f(rp.WinRate)},
+		{"sharpe", f(rp.Sharpe)},
+		{"fees", f(rp.Fees)},
+	}
+	if err := writeCSVFile(filepath.Join(dir, "summary.csv"), []string{"name", "value"}, summary); err != nil {
+		return err
+	}
+
+	trades := make([][]string, 0, len(rp.Trades))
+	for _, t := range rp.Trades {
+		trades = append(trades, []string{
+			tsStr(t.Ts), t.Exchange, t.Symbol, t.ContractType, t.OrderId, utils.OrderTypeStr(t.OrderType),
+			fmt.Sprintf("%f", t.Amount), fmt.Sprintf("%f", t.Price), fmt.Sprintf("%f", t.Fee),
+			fmt.Sprintf("%f", t.Profit), f(t.ProfitUsd),
+		})
+	}
+	th := []string{"time", "exchange", "symbol", "contract_type", "order_id", "order_type", "amount", "price", "fee", "profit", "profit_usd"}
+	if err := writeCSVFile(filepath.Join(dir, "trades.csv"), th, trades); err != nil {
+		return err
+	}
+
+	equity := make([][]string, 0, len(rp.EquityCurve))
+	for _, v := range rp.EquityCurve {
+		equity = append(equity, []string{tsStr(v.Ts), f(v.Equity)})
+	}
+	return writeCSVFile(filepath.Join(dir, "equity.csv"), []string{"time", "equity"}, equity)
+}
+
+func writeCSVFile(filename string, header []string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
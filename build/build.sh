@@ -24,3 +24,15 @@ cd -
 cd ../replay/main
 go build -o ../../build/bin/replay
 cd -
+
+cd ../optimize/main
+go build -o ../../build/bin/optimize
+cd -
+
+cd ../synth/main
+go build -o ../../build/bin/synth
+cd -
+
+cd ../stgtool/main
+go build -o ../../build/bin/stgtool
+cd -
@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"sort"
 )
 
 // app config
@@ -12,6 +13,23 @@ type AppCnf struct {
 	Broker    string
 	StgPath   string
 	Exchanges []string
+	Traders   map[string]string // 交易所使用的下单方式，live或者paper
+
+	// 交易日的定义，stg按它切换日期，replay和synth按它计算每天的时间范围
+	TradingDay struct {
+		Timezone string // 时区，+08:00或者Asia/Shanghai这样的名称，为空时使用本地时区
+		Boundary string // 交易日结束的时刻，格式15:04:05，00:00:00表示自然日
+	}
+
+	// stg的写入和维护参数
+	Storage struct {
+		BatchSize     int    // 攒够多少条记录写一次
+		FlushInterval int    // 最多隔多久写一次，毫秒
+		Compact       bool   // 是否压实已经结束的交易日
+		ArchiveAfter  int    // 多少天之前的交易日打包成一个压缩文件，0表示不打包
+		RetainDays    int    // 保留多少天，更早的交易日删除，0表示一直保留
+		MaintainCron  string // stg后台维护的时间，cron表达式，为空时不在stg里维护
+	}
 
 	Archer struct {
 		Keys []ArcherKeys
@@ -22,8 +40,118 @@ type AppCnf struct {
 	}
 
 	Replay struct {
-		Days []string
+		Days        []string
+		Start       string   // 回放开始时间，本地时间，格式2006-01-02 15:04:05，为空时不限制
+		End         string   // 回放结束时间，格式同上
+		Instruments []string // 只回放这些合约，exchange_symbol_contractType，为空时不限制
+		Fids        []string // 只回放这些行情，tick、kline、depth、trade、index，为空时不限制
+		SkipBad     bool     // 跳过缺失和损坏的记录，不设置时遇到读取失败的记录停止回放
+		Flow        string   // 把交易日志和行情按时间写到这个文件里，为空时不读交易日志
+	}
+
+	Paper struct {
+		Balance float32 // 模拟交易每个品种的初始余额，币
+
+		Cost struct {
+			Default   CostCnf            // 没有单独配置的交易所使用默认模型
+			Exchanges map[string]CostCnf // key: exchange
+		}
+	}
+
+	Snapshot struct {
+		Path     string // 快照leveldb的路径，为空时不保存快照
+		Interval int    // 保存快照的间隔，秒
+		Timeout  int    // 启动时等待对账回应的最长时间，秒
+	}
+
+	Admin struct {
+		Addr string // krang管理接口的监听地址，为空时不启动
+	}
+
+	Strategies map[string]*StrategyCnf // key: 策略名称
+
+	Optimize struct {
+		Strategy string                 // 优化哪个策略的参数
+		Method   string                 // 搜索方式，grid或者random
+		Samples  int                    // 随机搜索的次数
+		Seed     int64                  // 随机搜索的种子，相同的种子得到相同的参数
+		Workers  int                    // 同时运行的回测数量
+		Metric   string                 // 结果排序使用的指标
+		Top      int                    // 汇总表只输出前几名，0表示全部
+		Space    map[string]interface{} // 参数空间，key是策略参数的section::key
+
+		WalkForward struct {
+			InSample  int // 样本内的天数
+			OutSample int // 样本外的天数
+			Step      int // 每次向前移动的天数，0表示和样本外的天数一样
+		}
+	}
+
+	Synth struct {
+		Exchange      string
+		Days          []string
+		ContractTypes []string
+		Symbols       map[string]SynthSymbol // key: symbol
+		Interval      int                    // tick的间隔，毫秒
+		KLineInterval int                    // k线推送的间隔，毫秒
+		KLines        []string               // 生成哪些k线，KL1Min、KL5Min等
+		Spread        float64                // 买一卖一的价差占价格的比例
+		Volume        float64                // 每个tick平均成交的张数
+		Seed          int64                  // 随机数种子，相同的配置生成相同的行情
+		Model         map[string]interface{} // 价格模型
+		Scenario      []interface{}          // 预设的行情事件
+	}
+
+	// 导入外部的历史行情
+	Import struct {
+		Exchange     string
+		Type         string            // 行情类型，tick、kline或者trade
+		Format       string            // 文件格式，csv、jsonl或者json
+		Symbol       string            // 文件里没有symbol列时使用
+		ContractType string            // 文件里没有contract_type列时使用
+		KLine        string            // 文件里没有kind列时k线的类型，KL1Min、KL5Min等
+		TimeFormat   string            // 时间列的格式，ms、s或者2006-01-02 15:04:05这样的格式
+		Timezone     string            // 时间列没有时区时使用，为空时使用本地时区
+		Header       bool              // csv的第一行是否是列名
+		Comma        string            // csv的分隔符
+		Columns      map[string]string // key是行情的字段，value是文件里的列名，csv没有列名时是从0开始的列号
 	}
+
+	Risk struct {
+		Default    RiskLimit            // 没有单独配置的品种使用默认限制
+		Symbols    map[string]RiskLimit // key: exchange_symbol
+		Strategies map[string]RiskLimit // key: 策略名称
+	}
+}
+
+// 风控限制，为0表示不限制
+type RiskLimit struct {
+	MaxAmount    int32   // 单笔最大合约张数
+	MaxNotional  float32 // 单笔最大名义价值，美元
+	MaxLevel     int32   // 最大杠杆倍数
+	PriceBand    float32 // 限价单价格偏离最新价的最大比例
+	MaxOrderRate int32   // 每分钟最多下单次数
+	MaxDailyLoss float32 // 每日最大已实现亏损，币，策略的限制对每个品种分别生效
+}
+
+// 模拟成交的成本模型，为0表示没有该项成本
+type CostCnf struct {
+	MakerFee      float64 // 挂单成交的手续费率
+	TakerFee      float64 // 吃单成交的手续费率
+	Slippage      string  // 滑点模型，fixed或者volatility，为空时没有滑点
+	SlippageRate  float64 // fixed为价格的比例，volatility为波动率的倍数
+	VolWindow     int     // volatility使用最近多少个tick的价格计算波动率
+	Latency       int     // 委托和撤单到达交易所的延迟，毫秒
+	LatencyJitter int     // 延迟里随机的部分，毫秒
+	Fill          string  // 限价挂单的成交模型，trade按逐笔成交撮合，为空时按盘口撮合
+	FillProb      float64 // trade模型里成交价等于委托价时成交的概率
+	Seed          int64   // 随机数种子
+}
+
+// 合成行情的品种
+type SynthSymbol struct {
+	Price      float64 // 初始价格
+	UnitAmount float64 // 合约面值，美元
 }
 
 type ArcherKeys struct {
@@ -31,6 +159,52 @@ type ArcherKeys struct {
 	Secretkey string
 }
 
+const (
+	TRADER_LIVE  = "live"  // 通过archer在交易所下单
+	TRADER_PAPER = "paper" // 在krang本地模拟撮合
+)
+
+const default_paper_balance = 10
+
+// 默认和okex的结算对齐，每天16:00(UTC+8)
+const (
+	default_tradingday_timezone = "+08:00"
+	default_tradingday_boundary = "16:00:00"
+)
+
+const (
+	default_storage_batch_size     = 1000
+	default_storage_flush_interval = 200
+)
+
+const (
+	default_synth_interval       = 1000
+	default_synth_kline_interval = 10000
+	default_synth_price          = 100
+	default_synth_unit_amount    = 10
+	default_synth_spread         = 0.0002
+	default_synth_volume         = 10
+)
+
+const (
+	default_cost_vol_window = 20
+	default_cost_fill_prob  = 0.5
+)
+
+const (
+	default_snapshot_interval = 10
+	default_snapshot_timeout  = 10
+)
+
+const (
+	default_optimize_method  = "grid"
+	default_optimize_samples = 20
+	default_optimize_workers = 4
+	default_optimize_metric  = "sharpe"
+	default_wf_in_sample     = 3
+	default_wf_out_sample    = 1
+)
+
 var T *AppCnf
 
 func (c *AppCnf) LoadConfig(cnfPath string) (err error) {
@@ -43,6 +217,14 @@ func (c *AppCnf) LoadConfig(cnfPath string) (err error) {
 	c.Broker = cnf.String("kafka::broker")
 	c.StgPath = cnf.String("stg")
 	c.Exchanges = cnf.Strings("exchanges")
+	c.TradingDay.Timezone = cnf.DefaultString("tradingday::timezone", default_tradingday_timezone)
+	c.TradingDay.Boundary = cnf.DefaultString("tradingday::boundary", default_tradingday_boundary)
+	c.Storage.BatchSize = cnf.DefaultInt("storage::batch_size", default_storage_batch_size)
+	c.Storage.FlushInterval = cnf.DefaultInt("storage::flush_interval", default_storage_flush_interval)
+	c.Storage.Compact = cnf.DefaultBool("storage::compact", true)
+	c.Storage.ArchiveAfter = cnf.DefaultInt("storage::archive_after", 0)
+	c.Storage.RetainDays = cnf.DefaultInt("storage::retain_days", 0)
+	c.Storage.MaintainCron = cnf.DefaultString("storage::maintain_cron", "")
 
 	for _, e := range c.Exchanges {
 		sk1 := fmt.Sprintf("archer::%s::apikey", e)
@@ -52,17 +234,169 @@ func (c *AppCnf) LoadConfig(cnfPath string) (err error) {
 			Secretkey: cnf.String(sk2),
 		}
 		c.Archer.Keys = append(c.Archer.Keys, k)
+
+		st := fmt.Sprintf("trader::%s", e)
+		c.Traders[e] = cnf.DefaultString(st, TRADER_LIVE)
 	}
 
 	c.InfluxDB.Addr = cnf.String("influxDB::addr")
 	c.Replay.Days = cnf.Strings("replay::days")
+	c.Replay.Start = cnf.String("replay::start")
+	c.Replay.End = cnf.String("replay::end")
+	c.Replay.Instruments = cnf.Strings("replay::instruments")
+	c.Replay.Fids = cnf.Strings("replay::fids")
+	c.Replay.SkipBad = cnf.DefaultBool("replay::skip_bad", false)
+	c.Replay.Flow = cnf.String("replay::flow")
+	c.Paper.Balance = float32(cnf.DefaultFloat("paper::balance", default_paper_balance))
+	c.Paper.Cost.Default = loadCostCnf(cnf, "paper::cost::default")
+	for _, k := range sectionKeys(cnf, "paper::cost::exchanges") {
+		c.Paper.Cost.Exchanges[k] = loadCostCnf(cnf, "paper::cost::exchanges::"+k)
+	}
+
+	c.Snapshot.Path = cnf.DefaultString("snapshot::path", "")
+	c.Snapshot.Interval = cnf.DefaultInt("snapshot::interval", default_snapshot_interval)
+	c.Snapshot.Timeout = cnf.DefaultInt("snapshot::timeout", default_snapshot_timeout)
+	c.Admin.Addr = cnf.DefaultString("admin::addr", "")
+
+	for _, k := range sectionKeys(cnf, "strategies") {
+		c.Strategies[k] = loadStrategyCnf(cnf, k)
+	}
+
+	c.Optimize.Strategy = cnf.DefaultString("optimize::strategy", "")
+	c.Optimize.Method = cnf.DefaultString("optimize::method", default_optimize_method)
+	c.Optimize.Samples = cnf.DefaultInt("optimize::samples", default_optimize_samples)
+	c.Optimize.Seed = int64(cnf.DefaultInt("optimize::seed", 1))
+	c.Optimize.Workers = cnf.DefaultInt("optimize::workers", default_optimize_workers)
+	c.Optimize.Metric = cnf.DefaultString("optimize::metric", default_optimize_metric)
+	c.Optimize.Top = cnf.DefaultInt("optimize::top", 0)
+	c.Optimize.WalkForward.InSample = cnf.DefaultInt("optimize::walkforward::in_sample", default_wf_in_sample)
+	c.Optimize.WalkForward.OutSample = cnf.DefaultInt("optimize::walkforward::out_sample", default_wf_out_sample)
+	c.Optimize.WalkForward.Step = cnf.DefaultInt("optimize::walkforward::step", 0)
+	if v, err := cnf.DIY("optimize::space"); err == nil {
+		if m, ok := v.(map[string]interface{}); ok {
+			c.Optimize.Space = m
+		}
+	}
+
+	c.Synth.Exchange = cnf.DefaultString("synth::exchange", "okex")
+	c.Synth.Days = cnf.Strings("synth::days")
+	c.Synth.ContractTypes = cnf.DefaultStrings("synth::contract_types", []string{"this_week"})
+	for _, k := range sectionKeys(cnf, "synth::symbols") {
+		c.Synth.Symbols[k] = SynthSymbol{
+			Price:      cnf.DefaultFloat("synth::symbols::"+k+"::price", default_synth_price),
+			UnitAmount: cnf.DefaultFloat("synth::symbols::"+k+"::unit_amount", default_synth_unit_amount),
+		}
+	}
+	c.Synth.Interval = cnf.DefaultInt("synth::interval", default_synth_interval)
+	c.Synth.KLineInterval = cnf.DefaultInt("synth::kline_interval", default_synth_kline_interval)
+	c.Synth.KLines = cnf.DefaultStrings("synth::klines", []string{"KL1Min", "KL5Min", "KL15Min"})
+	c.Synth.Spread = cnf.DefaultFloat("synth::spread", default_synth_spread)
+	c.Synth.Volume = cnf.DefaultFloat("synth::volume", default_synth_volume)
+	c.Synth.Seed = int64(cnf.DefaultInt("synth::seed", 1))
+	if v, err := cnf.DIY("synth::model"); err == nil {
+		if m, ok := v.(map[string]interface{}); ok {
+			c.Synth.Model = m
+		}
+	}
+	if v, err := cnf.DIY("synth::scenario"); err == nil {
+		if a, ok := v.([]interface{}); ok {
+			c.Synth.Scenario = a
+		}
+	}
+
+	c.Import.Exchange = cnf.DefaultString("import::exchange", "okex")
+	c.Import.Type = cnf.DefaultString("import::type", "kline")
+	c.Import.Format = cnf.DefaultString("import::format", "csv")
+	c.Import.Symbol = cnf.DefaultString("import::symbol", "")
+	c.Import.ContractType = cnf.DefaultString("import::contract_type", "")
+	c.Import.KLine = cnf.DefaultString("import::kline", "KL1Min")
+	c.Import.TimeFormat = cnf.DefaultString("import::time_format", "ms")
+	c.Import.Timezone = cnf.DefaultString("import::timezone", "")
+	c.Import.Header = cnf.DefaultBool("import::header", true)
+	c.Import.Comma = cnf.DefaultString("import::comma", ",")
+	for _, k := range sectionKeys(cnf, "import::columns") {
+		c.Import.Columns[k] = cnf.String("import::columns::" + k)
+	}
+
+	c.Risk.Default = loadRiskLimit(cnf, "risk::default")
+	for _, k := range sectionKeys(cnf, "risk::symbols") {
+		c.Risk.Symbols[k] = loadRiskLimit(cnf, "risk::symbols::"+k)
+	}
+	for _, k := range sectionKeys(cnf, "risk::strategies") {
+		c.Risk.Strategies[k] = loadRiskLimit(cnf, "risk::strategies::"+k)
+	}
 	return err
 }
 
+func loadRiskLimit(cnf Configer, prefix string) RiskLimit {
+	return RiskLimit{
+		MaxAmount:    int32(cnf.DefaultInt(prefix+"::max_amount", 0)),
+		MaxNotional:  float32(cnf.DefaultFloat(prefix+"::max_notional", 0)),
+		MaxLevel:     int32(cnf.DefaultInt(prefix+"::max_level", 0)),
+		PriceBand:    float32(cnf.DefaultFloat(prefix+"::price_band", 0)),
+		MaxOrderRate: int32(cnf.DefaultInt(prefix+"::max_order_rate", 0)),
+		MaxDailyLoss: float32(cnf.DefaultFloat(prefix+"::max_daily_loss", 0)),
+	}
+}
+
+func loadCostCnf(cnf Configer, prefix string) CostCnf {
+	return CostCnf{
+		MakerFee:      cnf.DefaultFloat(prefix+"::maker_fee", 0),
+		TakerFee:      cnf.DefaultFloat(prefix+"::taker_fee", 0),
+		Slippage:      cnf.DefaultString(prefix+"::slippage", ""),
+		SlippageRate:  cnf.DefaultFloat(prefix+"::slippage_rate", 0),
+		VolWindow:     cnf.DefaultInt(prefix+"::vol_window", default_cost_vol_window),
+		Latency:       cnf.DefaultInt(prefix+"::latency", 0),
+		LatencyJitter: cnf.DefaultInt(prefix+"::latency_jitter", 0),
+		Fill:          cnf.DefaultString(prefix+"::fill", ""),
+		FillProb:      cnf.DefaultFloat(prefix+"::fill_prob", default_cost_fill_prob),
+		Seed:          int64(cnf.DefaultInt(prefix+"::seed", 1)),
+	}
+}
+
+// 返回某个配置节下的全部key
+func sectionKeys(cnf Configer, section string) []string {
+	ret := []string{}
+	v, err := cnf.DIY(section)
+	if err != nil {
+		return ret
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ret
+	}
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// 交易所模拟成交的成本模型
+func (c *AppCnf) GetCostCnf(exchange string) CostCnf {
+	if v, ok := c.Paper.Cost.Exchanges[exchange]; ok {
+		return v
+	}
+	return c.Paper.Cost.Default
+}
+
+// 该交易所是否使用模拟交易
+func (c *AppCnf) IsPaperTrade(exchange string) bool {
+	return c.Traders[exchange] == TRADER_PAPER
+}
+
 func newAppCnf() *AppCnf {
-	return &AppCnf{
-		AppID: 1,
+	c := &AppCnf{
+		AppID:      1,
+		Traders:    make(map[string]string),
+		Strategies: make(map[string]*StrategyCnf),
 	}
+	c.Paper.Cost.Exchanges = make(map[string]CostCnf)
+	c.Synth.Symbols = make(map[string]SynthSymbol)
+	c.Import.Columns = make(map[string]string)
+	c.Risk.Symbols = make(map[string]RiskLimit)
+	c.Risk.Strategies = make(map[string]RiskLimit)
+	return c
 }
 
 func init() {
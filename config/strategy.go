@@ -0,0 +1,188 @@
+package config
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ 策略配置，配置文件的strategies节，每个策略一个子节
+
+    "strategies" : {
+        "mavg": {
+            "enable": true,
+            "exchange": "okex",
+            "symbols": "ltc_usd;etc_usd",
+            "contract_types": "this_week",
+            "params": {
+                "normal": {"ltc_usd": {"stop_lose_rate": -0.13}}
+            }
+        }
+    }
+
+ 1. 没有配置的策略默认启用，品种和参数使用策略自己的默认值
+ 2. params的格式由策略自己决定，使用section::key的方式读取，和配置文件一样
+*/
+
+type StrategyCnf struct {
+	Name          string
+	Enable        bool
+	Exchange      string
+	Symbols       []string
+	ContractTypes []string
+	Params        StrategyParams
+}
+
+// 策略参数，json解析出来的原始数据
+type StrategyParams map[string]interface{}
+
+func loadStrategyCnf(cnf Configer, name string) *StrategyCnf {
+	prefix := "strategies::" + name
+	sc := &StrategyCnf{
+		Name:          name,
+		Enable:        cnf.DefaultBool(prefix+"::enable", true),
+		Exchange:      cnf.String(prefix + "::exchange"),
+		Symbols:       cnf.Strings(prefix + "::symbols"),
+		ContractTypes: cnf.Strings(prefix + "::contract_types"),
+		Params:        StrategyParams{},
+	}
+	if v, err := cnf.DIY(prefix + "::params"); err == nil {
+		if m, ok := v.(map[string]interface{}); ok {
+			sc.Params = StrategyParams(m)
+		}
+	}
+	return sc
+}
+
+// 复制一份配置，修改参数不影响原来的配置
+func (sc *StrategyCnf) Clone() *StrategyCnf {
+	c := *sc
+	c.Params = sc.Params.Clone()
+	return &c
+}
+
+// 查找策略的配置，没有配置时返回默认的配置
+func (c *AppCnf) GetStrategyCnf(name string) *StrategyCnf {
+	if sc, ok := c.Strategies[name]; ok {
+		return sc
+	}
+	return &StrategyCnf{
+		Name:   name,
+		Enable: true,
+		Params: StrategyParams{},
+	}
+}
+
+func (c *AppCnf) IsStrategyEnabled(name string) bool {
+	return c.GetStrategyCnf(name).Enable
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (p StrategyParams) get(key string) interface{} {
+	var cur interface{} = map[string]interface{}(p)
+	for _, k := range strings.Split(key, "::") {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		if cur, ok = m[k]; !ok {
+			return nil
+		}
+	}
+	return cur
+}
+
+func (p StrategyParams) Has(key string) bool {
+	return p.get(key) != nil
+}
+
+func (p StrategyParams) String(key string, def string) string {
+	if v, ok := p.get(key).(string); ok {
+		return v
+	}
+	return def
+}
+
+func (p StrategyParams) Float(key string, def float64) float64 {
+	switch v := p.get(key).(type) {
+	case float64:
+		return v
+	case string:
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f
+		}
+	}
+	return def
+}
+
+func (p StrategyParams) Int(key string, def int) int {
+	switch v := p.get(key).(type) {
+	case float64:
+		return int(v)
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
+		}
+	}
+	return def
+}
+
+func (p StrategyParams) Bool(key string, def bool) bool {
+	if v := p.get(key); v != nil {
+		if b, err := ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// 返回某个参数节下的全部key
+func (p StrategyParams) Keys(section string) []string {
+	ret := []string{}
+	var v interface{} = map[string]interface{}(p)
+	if section != "" {
+		v = p.get(section)
+	}
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return ret
+	}
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// 深度复制参数
+func (p StrategyParams) Clone() StrategyParams {
+	return StrategyParams(cloneMap(p))
+}
+
+func cloneMap(m map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		if sub, ok := v.(map[string]interface{}); ok {
+			v = cloneMap(sub)
+		}
+		ret[k] = v
+	}
+	return ret
+}
+
+// 设置参数，key的格式和读取时一样，中间的参数节不存在时会创建
+func (p StrategyParams) Set(key string, v interface{}) {
+	m := map[string]interface{}(p)
+	ks := strings.Split(key, "::")
+	for _, k := range ks[:len(ks)-1] {
+		sub, ok := m[k].(map[string]interface{})
+		if !ok {
+			sub = make(map[string]interface{})
+			m[k] = sub
+		}
+		m = sub
+	}
+	m[ks[len(ks)-1]] = v
+}
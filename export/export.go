@@ -0,0 +1,136 @@
+/*
+ export --- 把stg存储的行情导出成分析工具可以读的文件
+
+ 1. 按消息类型把FixPackage解成tick、kline、depth、trade、index，每种类型一张表，见table.go
+ 2. 每个合约的每种类型一个文件，<out>/<exchange_symbol_contractType>_<type>.<format>，多天的数据写在同一个文件里
+ 3. 格式是csv、jsonl或者parquet，ts是毫秒时间戳，pandas里用pd.to_datetime(df.ts, unit="ms")转换
+ 4. 可以按时间段、合约和行情类型过滤，有索引时只读取命中的记录
+*/
+package export
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"chive/protocol"
+	"chive/stg"
+)
+
+type Options struct {
+	Path     string // stg的存储目录
+	Exchange string
+	Days     []string
+	Query    stg.Query
+	Format   string
+	Out      string // 输出目录
+}
+
+// 导出的结果，key是文件名
+type Result struct {
+	Files   map[string]int // 每个文件的行数
+	Records uint64         // 导出的记录数
+	Skipped uint64         // 解包失败的记录数
+}
+
+type Exporter struct {
+	opts    Options
+	writers map[string]tableWriter
+	result  *Result
+}
+
+func NewExporter(opts Options) *Exporter {
+	return &Exporter{
+		opts:    opts,
+		writers: make(map[string]tableWriter),
+		result:  &Result{Files: make(map[string]int)},
+	}
+}
+
+/*
+ 按日期顺序导出，每个文件里的记录按时间排序
+ 没有的日期跳过，progress在每天导出之后调用
+*/
+func (e *Exporter) Run(progress func(day string, records uint64)) (*Result, error) {
+	if err := os.MkdirAll(e.opts.Out, 0755); err != nil {
+		return nil, err
+	}
+	err := e.run(progress)
+	for _, w := range e.writers {
+		if cerr := w.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e.result, nil
+}
+
+func (e *Exporter) run(progress func(day string, records uint64)) error {
+	days := append([]string{}, e.opts.Days...)
+	sort.Strings(days)
+	for _, day := range days {
+		r, err := stg.OpenDayReader(e.opts.Path, e.opts.Exchange, day)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s %s: %s", e.opts.Exchange, day, err.Error())
+		}
+
+		before := e.result.Records
+		var werr error
+		err = r.Query(&e.opts.Query, func(rec *stg.Record) bool {
+			werr = e.export(rec)
+			return werr == nil
+		})
+		r.Close()
+		if werr != nil {
+			return werr
+		}
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(day, e.result.Records-before)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) export(rec *stg.Record) error {
+	t, ok := tables[rec.Tid]
+	if !ok {
+		return nil
+	}
+	p := &protocol.FixPackage{}
+	if !p.ParseFromArray(rec.Value) {
+		e.result.Skipped += 1
+		return nil
+	}
+	rows, err := t.rows(p.GetPayload())
+	if err != nil {
+		e.result.Skipped += 1
+		return nil
+	}
+
+	name := fmt.Sprintf("%s_%s.%s", rec.Instrument, t.name, e.opts.Format)
+	w, ok := e.writers[name]
+	if !ok {
+		if w, err = newTableWriter(e.opts.Format, filepath.Join(e.opts.Out, name), t.allColumns()); err != nil {
+			return err
+		}
+		e.writers[name] = w
+	}
+	for _, row := range rows {
+		full := append([]interface{}{int64(rec.Seq), int64(rec.Ts)}, row...)
+		if err := w.Write(full); err != nil {
+			return err
+		}
+	}
+	e.result.Files[name] += len(rows)
+	e.result.Records += 1
+	return nil
+}
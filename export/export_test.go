@@ -0,0 +1,317 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestExport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sinfo := func(ts uint64) *protocol.PBQuoteSymbol {
+		return &protocol.PBQuoteSymbol{
+			Exchange:     proto.String("okex"),
+			Symbol:       proto.String("ltc_usd"),
+			ContractType: proto.String("this_week"),
+			Timestamp:    proto.Uint64(ts),
+		}
+	}
+	w, err := stg.NewDayWriter(dir+"/", "okex", "2017-12-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range []struct {
+		tid int
+		pb  proto.Message
+	}{
+		{protocol.FID_QUOTE_TICK, &protocol.PBFutureTick{Last: proto.Float32(0.1), Sinfo: sinfo(2000)}},
+		{protocol.FID_QUOTE_Depth, &protocol.PBFutureDepth{
+			Asks:  []*protocol.PBFutureOBItem{{Price: proto.Float32(101), Vol: proto.Float32(1)}},
+			Bids:  []*protocol.PBFutureOBItem{{Price: proto.Float32(99), Vol: proto.Float32(2)}, {Price: proto.Float32(98), Vol: proto.Float32(3)}},
+			Sinfo: sinfo(1000),
+		}},
+		{protocol.FID_QUOTE_Trade, &protocol.PBFutureTrade{TradeSeq: proto.String("a,b"), Price: proto.Float32(100), BsCode: proto.String("bid"), Sinfo: sinfo(1500)}},
+	} {
+		bin, _ := utils.PackMessage(m.tid, 0, m.pb)
+		if err := w.Write(bin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	for _, format := range []string{FORMAT_CSV, FORMAT_JSONL, FORMAT_PARQUET} {
+		out := filepath.Join(dir, "out")
+		ret, err := NewExporter(Options{
+			Path:     dir + "/",
+			Exchange: "okex",
+			Days:     []string{"2017-12-25", "2017-12-26"},
+			Format:   format,
+			Out:      out,
+		}).Run(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.Records != 3 || ret.Files["okex_ltc_usd_this_week_depth."+format] != 3 {
+			t.Fatalf("%s export %d records, files %v", format, ret.Records, ret.Files)
+		}
+		bs, err := ioutil.ReadFile(filepath.Join(out, "okex_ltc_usd_this_week_tick."+format))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		switch format {
+		case FORMAT_CSV:
+			want := "seq,ts,last,bid,ask,bid_vol,ask_vol,vol,high,low,day_vol,day_high,day_low\n0,2000,0.1,0,0,0,0,0,0,0,0,0,0\n"
+			if string(bs) != want {
+				t.Fatalf("tick csv is %q", bs)
+			}
+			trade, _ := ioutil.ReadFile(filepath.Join(out, "okex_ltc_usd_this_week_trade.csv"))
+			if !strings.Contains(string(trade), `2,1500,"a,b",100,0,0,bid`) {
+				t.Fatalf("trade csv is %q", trade)
+			}
+		case FORMAT_JSONL:
+			if !strings.HasPrefix(string(bs), `{"seq":0,"ts":2000,"last":0.1,`) {
+				t.Fatalf("tick jsonl is %q", bs)
+			}
+		case FORMAT_PARQUET:
+			checkParquet(t, bs, 1, []pqColumn{
+				{"seq", pq_int64, -1}, {"ts", pq_int64, pq_timestamp_millis},
+				{"last", pq_float, -1}, {"bid", pq_float, -1}, {"ask", pq_float, -1},
+				{"bid_vol", pq_float, -1}, {"ask_vol", pq_float, -1}, {"vol", pq_float, -1},
+				{"high", pq_float, -1}, {"low", pq_float, -1},
+				{"day_vol", pq_float, -1}, {"day_high", pq_float, -1}, {"day_low", pq_float, -1},
+			}, map[string]string{"seq": "0", "ts": "2000", "last": "0.1"})
+
+			depth, err := ioutil.ReadFile(filepath.Join(out, "okex_ltc_usd_this_week_depth.parquet"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkParquet(t, depth, 3, []pqColumn{
+				{"seq", pq_int64, -1}, {"ts", pq_int64, pq_timestamp_millis},
+				{"side", pq_byte_array, pq_utf8}, {"level", pq_int32, -1}, {"price", pq_float, -1}, {"vol", pq_float, -1},
+			}, map[string]string{"ts": "1000,1000,1000", "side": "ask,bid,bid", "level": "0,0,1", "price": "101,99,98"})
+		}
+	}
+}
+
+func TestCompact(t *testing.T) {
+	c := &compact{}
+	c.begin()
+	c.i32(1, 1)
+	c.i64(20, -2)
+	c.list(21, ct_i32, 1)
+	c.zigzag(3)
+	c.structField(22)
+	c.str(1, "a")
+	c.end()
+	c.end()
+	want := []byte{0x15, 0x02, 0x06, 0x28, 0x03, 0x19, 0x15, 0x06, 0x1c, 0x18, 0x01, 'a', 0x00, 0x00}
+	if !bytes.Equal(c.buf.Bytes(), want) {
+		t.Fatalf("compact encode % x", c.buf.Bytes())
+	}
+}
+
+// 期望的parquet列，converted为-1表示没有逻辑类型
+type pqColumn struct {
+	name      string
+	typ       int64
+	converted int64
+}
+
+// thrift compact协议的解码器，整数都解成int64，字符串解成string，结构体解成字段编号到值的map
+type thriftReader struct {
+	bs  []byte
+	pos int
+}
+
+func (r *thriftReader) varint() uint64 {
+	v, n := binary.Uvarint(r.bs[r.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.varint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(typ byte) interface{} {
+	switch typ {
+	case ct_i32, ct_i64:
+		return r.zigzag()
+	case ct_binary:
+		n := int(r.varint())
+		r.pos += n
+		return string(r.bs[r.pos-n : r.pos])
+	case ct_list:
+		h := r.bs[r.pos]
+		r.pos += 1
+		n := int(h >> 4)
+		if n == 15 {
+			n = int(r.varint())
+		}
+		ret := make([]interface{}, n)
+		for i := range ret {
+			ret[i] = r.value(h & 0x0f)
+		}
+		return ret
+	case ct_struct:
+		return r.readStruct()
+	}
+	panic(fmt.Sprintf("unexpected thrift type %d", typ))
+}
+
+func (r *thriftReader) readStruct() map[int16]interface{} {
+	ret := map[int16]interface{}{}
+	var last int16
+	for {
+		h := r.bs[r.pos]
+		r.pos += 1
+		if h == 0 {
+			return ret
+		}
+		id := last + int16(h>>4)
+		if h>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		ret[id] = r.value(h & 0x0f)
+		last = id
+	}
+}
+
+/*
+ 解出footer，检查行数、schema的名字和类型、列块的位置和大小，
+ 再按列块的位置解出数据页，检查values里的列，多行用,连接
+*/
+func checkParquet(t *testing.T, bs []byte, rows int64, columns []pqColumn, values map[string]string) {
+	n := len(bs)
+	if !bytes.HasPrefix(bs, parquetMagic) || !bytes.HasSuffix(bs, parquetMagic) {
+		t.Fatal("bad parquet magic")
+	}
+	flen := int(binary.LittleEndian.Uint32(bs[n-8 : n-4]))
+	footer := n - 8 - flen
+	if footer < len(parquetMagic) {
+		t.Fatalf("bad parquet footer length %d", flen)
+	}
+	r := &thriftReader{bs: bs[footer : n-8]}
+	meta := r.readStruct()
+	if r.pos != flen {
+		t.Fatalf("footer length %d, decoded %d bytes", flen, r.pos)
+	}
+	if meta[3] != rows || meta[6] != pq_created_by {
+		t.Fatalf("num_rows %v, created_by %v", meta[3], meta[6])
+	}
+
+	schema := meta[2].([]interface{})
+	root := schema[0].(map[int16]interface{})
+	if len(schema) != len(columns)+1 || root[4] != pq_schema_root || root[5] != int64(len(columns)) {
+		t.Fatalf("schema root %v, %d elements", root, len(schema))
+	}
+	for i, c := range columns {
+		e := schema[i+1].(map[int16]interface{})
+		conv, ok := e[6]
+		if !ok {
+			conv = int64(-1)
+		}
+		if e[4] != c.name || e[1] != c.typ || e[3] != int64(pq_required) || conv != c.converted {
+			t.Fatalf("schema column %d is %v, want %+v", i, e, c)
+		}
+	}
+
+	groups := meta[4].([]interface{})
+	if len(groups) != 1 {
+		t.Fatalf("row groups %d", len(groups))
+	}
+	g := groups[0].(map[int16]interface{})
+	chunks := g[1].([]interface{})
+	if g[3] != rows || len(chunks) != len(columns) {
+		t.Fatalf("row group rows %v, chunks %d", g[3], len(chunks))
+	}
+
+	// 列块首尾相连，从magic之后开始，到footer之前结束
+	offset := int64(len(parquetMagic))
+	var size int64
+	for i, v := range chunks {
+		chunk := v.(map[int16]interface{})
+		cm := chunk[3].(map[int16]interface{})
+		c := columns[i]
+		path := cm[3].([]interface{})
+		if chunk[2] != offset || cm[9] != offset || cm[1] != c.typ || cm[4] != int64(pq_gzip) ||
+			cm[5] != rows || len(path) != 1 || path[0] != c.name {
+			t.Fatalf("column chunk %d is %v, offset should be %d", i, cm, offset)
+		}
+
+		// 数据页：页头后面是gzip压缩的PLAIN编码
+		pr := &thriftReader{bs: bs[offset:footer]}
+		page := pr.readStruct()
+		dph := page[5].(map[int16]interface{})
+		compressed := page[3].(int64)
+		if page[1] != int64(pq_data_page) || dph[1] != rows || cm[7] != int64(pr.pos)+compressed ||
+			cm[6] != int64(pr.pos)+page[2].(int64) {
+			t.Fatalf("page header of column %s is %v, chunk %v", c.name, page, cm)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(pr.bs[pr.pos : pr.pos+int(compressed)]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := ioutil.ReadAll(zr)
+		if err != nil || int64(len(data)) != page[2].(int64) {
+			t.Fatalf("page data of column %s, %d bytes, err %v", c.name, len(data), err)
+		}
+		if want, ok := values[c.name]; ok {
+			if got := plainValues(c.typ, data); got != want {
+				t.Fatalf("column %s values [%s], want [%s]", c.name, got, want)
+			}
+		}
+
+		offset += cm[7].(int64)
+		size += cm[6].(int64)
+	}
+	if offset != int64(footer) || g[2] != size {
+		t.Fatalf("column chunks end at %d, footer at %d, total_byte_size %v", offset, footer, g[2])
+	}
+}
+
+// 把PLAIN编码的一页值转成文本
+func plainValues(typ int64, data []byte) string {
+	ret := []string{}
+	for len(data) > 0 {
+		switch typ {
+		case pq_int32:
+			ret = append(ret, fmt.Sprint(int32(binary.LittleEndian.Uint32(data))))
+			data = data[4:]
+		case pq_int64:
+			ret = append(ret, fmt.Sprint(int64(binary.LittleEndian.Uint64(data))))
+			data = data[8:]
+		case pq_float:
+			ret = append(ret, fmt.Sprint(math.Float32frombits(binary.LittleEndian.Uint32(data))))
+			data = data[4:]
+		default:
+			l := int(binary.LittleEndian.Uint32(data))
+			ret = append(ret, string(data[4:4+l]))
+			data = data[4+l:]
+		}
+	}
+	return strings.Join(ret, ",")
+}
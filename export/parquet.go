@@ -0,0 +1,335 @@
+package export
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"math"
+	"os"
+)
+
+/*
+ 最简单的parquet文件，pandas、DuckDB、Spark都可以读
+
+ 1. 扁平的表，所有列都是REQUIRED，没有定义级别和重复级别
+ 2. 每parquet_row_group行一个row group，每个列块只有一个数据页，PLAIN编码，页用GZIP压缩
+ 3. 元数据用thrift compact协议编码，字段编号见parquet-format的parquet.thrift
+*/
+
+const parquet_row_group = 64 * 1024
+
+var parquetMagic = []byte("PAR1")
+
+// parquet的物理类型
+const (
+	pq_int32      = 1
+	pq_int64      = 2
+	pq_float      = 4
+	pq_byte_array = 6
+)
+
+// parquet的逻辑类型，ConvertedType
+const (
+	pq_utf8             = 0
+	pq_timestamp_millis = 9
+)
+
+const (
+	pq_required     = 0
+	pq_plain        = 0
+	pq_rle          = 3
+	pq_gzip         = 2
+	pq_data_page    = 0
+	pq_created_by   = "chive export"
+	pq_schema_root  = "schema"
+	pq_file_version = 1
+)
+
+type columnChunk struct {
+	offset       int64
+	numValues    int64
+	uncompressed int64
+	compressed   int64
+}
+
+type rowGroup struct {
+	chunks []columnChunk
+	rows   int64
+	size   int64
+}
+
+type parquetWriter struct {
+	f       *os.File
+	columns []column
+	offset  int64
+	values  []bytes.Buffer // 当前row group每列PLAIN编码后的值
+	rows    int64          // 当前row group的行数
+	groups  []rowGroup
+	err     error
+}
+
+func newParquetWriter(f *os.File, columns []column) *parquetWriter {
+	w := &parquetWriter{f: f, columns: columns, values: make([]bytes.Buffer, len(columns))}
+	w.write(parquetMagic)
+	return w
+}
+
+func physicalType(kind int) int32 {
+	switch kind {
+	case COL_INT32:
+		return pq_int32
+	case COL_INT64, COL_TIME:
+		return pq_int64
+	case COL_FLOAT:
+		return pq_float
+	}
+	return pq_byte_array
+}
+
+func (w *parquetWriter) write(bs []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.f.Write(bs)
+	w.offset += int64(n)
+	w.err = err
+}
+
+func (w *parquetWriter) Write(row []interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+	var b [8]byte
+	for i, v := range row {
+		buf := &w.values[i]
+		switch w.columns[i].kind {
+		case COL_INT32:
+			x, _ := v.(int32)
+			binary.LittleEndian.PutUint32(b[:4], uint32(x))
+			buf.Write(b[:4])
+		case COL_INT64, COL_TIME:
+			var x int64
+			switch y := v.(type) {
+			case int64:
+				x = y
+			case uint64:
+				x = int64(y)
+			}
+			binary.LittleEndian.PutUint64(b[:], uint64(x))
+			buf.Write(b[:])
+		case COL_FLOAT:
+			x, _ := v.(float32)
+			binary.LittleEndian.PutUint32(b[:4], math.Float32bits(x))
+			buf.Write(b[:4])
+		default:
+			s, _ := v.(string)
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+			buf.Write(b[:4])
+			buf.WriteString(s)
+		}
+	}
+	w.rows += 1
+	if w.rows >= parquet_row_group {
+		w.flushGroup()
+	}
+	return w.err
+}
+
+// 把当前row group的每一列写成一个列块
+func (w *parquetWriter) flushGroup() {
+	if w.rows == 0 {
+		return
+	}
+	g := rowGroup{rows: w.rows}
+	for i := range w.columns {
+		data := w.values[i].Bytes()
+		var zbuf bytes.Buffer
+		zw := gzip.NewWriter(&zbuf)
+		zw.Write(data)
+		zw.Close()
+
+		h := &compact{}
+		h.begin()
+		h.i32(1, pq_data_page)
+		h.i32(2, int32(len(data)))
+		h.i32(3, int32(zbuf.Len()))
+		h.structField(5)
+		h.i32(1, int32(w.rows))
+		h.i32(2, pq_plain)
+		h.i32(3, pq_rle)
+		h.i32(4, pq_rle)
+		h.end()
+		h.end()
+
+		chunk := columnChunk{
+			offset:       w.offset,
+			numValues:    w.rows,
+			uncompressed: int64(h.buf.Len() + len(data)),
+			compressed:   int64(h.buf.Len() + zbuf.Len()),
+		}
+		w.write(h.buf.Bytes())
+		w.write(zbuf.Bytes())
+		g.chunks = append(g.chunks, chunk)
+		g.size += chunk.uncompressed
+		w.values[i].Reset()
+	}
+	w.groups = append(w.groups, g)
+	w.rows = 0
+}
+
+func (w *parquetWriter) Close() error {
+	w.flushGroup()
+
+	var total int64
+	for _, g := range w.groups {
+		total += g.rows
+	}
+
+	m := &compact{}
+	m.begin()
+	m.i32(1, pq_file_version)
+
+	m.list(2, ct_struct, len(w.columns)+1)
+	m.begin()
+	m.str(4, pq_schema_root)
+	m.i32(5, int32(len(w.columns)))
+	m.end()
+	for _, c := range w.columns {
+		m.begin()
+		m.i32(1, physicalType(c.kind))
+		m.i32(3, pq_required)
+		m.str(4, c.name)
+		if c.kind == COL_STRING {
+			m.i32(6, pq_utf8)
+		} else if c.kind == COL_TIME {
+			m.i32(6, pq_timestamp_millis)
+		}
+		m.end()
+	}
+
+	m.i64(3, total)
+	m.list(4, ct_struct, len(w.groups))
+	for _, g := range w.groups {
+		m.begin()
+		m.list(1, ct_struct, len(g.chunks))
+		for i, chunk := range g.chunks {
+			c := w.columns[i]
+			m.begin()
+			m.i64(2, chunk.offset)
+			m.structField(3)
+			m.i32(1, physicalType(c.kind))
+			m.list(2, ct_i32, 1)
+			m.zigzag(pq_plain)
+			m.list(3, ct_binary, 1)
+			m.binary([]byte(c.name))
+			m.i32(4, pq_gzip)
+			m.i64(5, chunk.numValues)
+			m.i64(6, chunk.uncompressed)
+			m.i64(7, chunk.compressed)
+			m.i64(9, chunk.offset)
+			m.end()
+			m.end()
+		}
+		m.i64(2, g.size)
+		m.i64(3, g.rows)
+		m.end()
+	}
+	m.str(6, pq_created_by)
+	m.end()
+
+	w.write(m.buf.Bytes())
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(m.buf.Len()))
+	w.write(b[:])
+	w.write(parquetMagic)
+
+	if err := w.f.Close(); err != nil && w.err == nil {
+		w.err = err
+	}
+	return w.err
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// thrift compact协议的类型
+const (
+	ct_i32    = 5
+	ct_i64    = 6
+	ct_binary = 8
+	ct_list   = 9
+	ct_struct = 12
+)
+
+// thrift compact协议的编码器，只实现了parquet元数据用到的部分
+type compact struct {
+	buf  bytes.Buffer
+	last []int16 // 每一层结构体上一个字段的编号
+}
+
+func (c *compact) varint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	c.buf.Write(b[:n])
+}
+
+func (c *compact) zigzag(v int64) {
+	c.varint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (c *compact) binary(bs []byte) {
+	c.varint(uint64(len(bs)))
+	c.buf.Write(bs)
+}
+
+func (c *compact) field(id int16, typ byte) {
+	n := len(c.last) - 1
+	if delta := id - c.last[n]; delta > 0 && delta <= 15 {
+		c.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		c.buf.WriteByte(typ)
+		c.zigzag(int64(id))
+	}
+	c.last[n] = id
+}
+
+// 开始一个结构体，顶层的或者列表里的
+func (c *compact) begin() {
+	c.last = append(c.last, 0)
+}
+
+func (c *compact) end() {
+	c.buf.WriteByte(0)
+	c.last = c.last[:len(c.last)-1]
+}
+
+// 结构体类型的字段，之后写字段，最后调用end
+func (c *compact) structField(id int16) {
+	c.field(id, ct_struct)
+	c.begin()
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.field(id, ct_i32)
+	c.zigzag(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.field(id, ct_i64)
+	c.zigzag(v)
+}
+
+func (c *compact) str(id int16, s string) {
+	c.field(id, ct_binary)
+	c.binary([]byte(s))
+}
+
+// 列表的头，之后依次写n个元素
+func (c *compact) list(id int16, elem byte, n int) {
+	c.field(id, ct_list)
+	if n < 15 {
+		c.buf.WriteByte(byte(n)<<4 | elem)
+	} else {
+		c.buf.WriteByte(0xf0 | elem)
+		c.varint(uint64(n))
+	}
+}
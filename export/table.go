@@ -0,0 +1,132 @@
+package export
+
+import (
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// 列的类型
+const (
+	COL_INT32 = iota
+	COL_INT64
+	COL_FLOAT
+	COL_STRING
+	COL_TIME // 毫秒时间戳，parquet里标记为TIMESTAMP_MILLIS
+)
+
+type column struct {
+	name string
+	kind int
+}
+
+/*
+ 一种行情消息导出成一张表，每条消息一行，depth每个档位一行
+ 每张表都有seq和ts两列，seq是记录在当天的序号，ts是行情里交易所的时间戳
+*/
+type table struct {
+	name    string
+	columns []column
+	rows    func(payload []byte) ([][]interface{}, error) // 不包括seq和ts
+}
+
+var baseColumns = []column{{"seq", COL_INT64}, {"ts", COL_TIME}}
+
+var tables = map[uint32]*table{
+	protocol.FID_QUOTE_TICK: {
+		name: "tick",
+		columns: []column{
+			{"last", COL_FLOAT}, {"bid", COL_FLOAT}, {"ask", COL_FLOAT}, {"bid_vol", COL_FLOAT}, {"ask_vol", COL_FLOAT},
+			{"vol", COL_FLOAT}, {"high", COL_FLOAT}, {"low", COL_FLOAT},
+			{"day_vol", COL_FLOAT}, {"day_high", COL_FLOAT}, {"day_low", COL_FLOAT},
+		},
+		rows: tickRows,
+	},
+	protocol.FID_QUOTE_KLine: {
+		name: "kline",
+		columns: []column{
+			{"kind", COL_STRING}, {"open", COL_FLOAT}, {"high", COL_FLOAT}, {"low", COL_FLOAT}, {"close", COL_FLOAT},
+			{"vol", COL_FLOAT}, {"amount", COL_FLOAT},
+		},
+		rows: klineRows,
+	},
+	protocol.FID_QUOTE_Depth: {
+		name:    "depth",
+		columns: []column{{"side", COL_STRING}, {"level", COL_INT32}, {"price", COL_FLOAT}, {"vol", COL_FLOAT}},
+		rows:    depthRows,
+	},
+	protocol.FID_QUOTE_Trade: {
+		name: "trade",
+		columns: []column{
+			{"trade_seq", COL_STRING}, {"price", COL_FLOAT}, {"vol", COL_FLOAT}, {"amount", COL_INT32}, {"bs_code", COL_STRING},
+		},
+		rows: tradeRows,
+	},
+	protocol.FID_QUOTE_Index: {
+		name:    "index",
+		columns: []column{{"future_index", COL_FLOAT}},
+		rows:    indexRows,
+	},
+}
+
+func (t *table) allColumns() []column {
+	return append(append([]column{}, baseColumns...), t.columns...)
+}
+
+func tickRows(payload []byte) ([][]interface{}, error) {
+	pb := &protocol.PBFutureTick{}
+	if err := proto.Unmarshal(payload, pb); err != nil {
+		return nil, err
+	}
+	return [][]interface{}{{
+		pb.GetLast(), pb.GetBid(), pb.GetAsk(), pb.GetBidVol(), pb.GetAskVol(),
+		pb.GetVol(), pb.GetHigh(), pb.GetLow(),
+		pb.GetDayVol(), pb.GetDayHigh(), pb.GetDayLow(),
+	}}, nil
+}
+
+func klineRows(payload []byte) ([][]interface{}, error) {
+	pb := &protocol.PBFutureKLine{}
+	if err := proto.Unmarshal(payload, pb); err != nil {
+		return nil, err
+	}
+	return [][]interface{}{{
+		utils.KLineStr(pb.GetKind()), pb.GetOpen(), pb.GetHigh(), pb.GetLow(), pb.GetClose(),
+		pb.GetVol(), pb.GetAmount(),
+	}}, nil
+}
+
+// level是档位在消息里的顺序，从0开始，和交易所推送的顺序一样
+func depthRows(payload []byte) ([][]interface{}, error) {
+	pb := &protocol.PBFutureDepth{}
+	if err := proto.Unmarshal(payload, pb); err != nil {
+		return nil, err
+	}
+	ret := make([][]interface{}, 0, len(pb.GetAsks())+len(pb.GetBids()))
+	for i, v := range pb.GetAsks() {
+		ret = append(ret, []interface{}{"ask", int32(i), v.GetPrice(), v.GetVol()})
+	}
+	for i, v := range pb.GetBids() {
+		ret = append(ret, []interface{}{"bid", int32(i), v.GetPrice(), v.GetVol()})
+	}
+	return ret, nil
+}
+
+func tradeRows(payload []byte) ([][]interface{}, error) {
+	pb := &protocol.PBFutureTrade{}
+	if err := proto.Unmarshal(payload, pb); err != nil {
+		return nil, err
+	}
+	return [][]interface{}{{
+		pb.GetTradeSeq(), pb.GetPrice(), pb.GetVol(), pb.GetAmount(), pb.GetBsCode(),
+	}}, nil
+}
+
+func indexRows(payload []byte) ([][]interface{}, error) {
+	pb := &protocol.PBFutureIndex{}
+	if err := proto.Unmarshal(payload, pb); err != nil {
+		return nil, err
+	}
+	return [][]interface{}{{pb.GetFutureIndex()}}, nil
+}
@@ -0,0 +1,136 @@
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+)
+
+const (
+	FORMAT_CSV     = "csv"
+	FORMAT_JSONL   = "jsonl"
+	FORMAT_PARQUET = "parquet"
+)
+
+// 一个输出文件，一次写一行
+type tableWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+func newTableWriter(format string, filename string, columns []column) (tableWriter, error) {
+	switch format {
+	case FORMAT_CSV, FORMAT_JSONL, FORMAT_PARQUET:
+	default:
+		return nil, errors.New("unknown export format " + format)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case FORMAT_CSV:
+		return newCSVWriter(f, columns)
+	case FORMAT_JSONL:
+		return &jsonlWriter{f: f, w: bufio.NewWriter(f), columns: columns}, nil
+	}
+	return newParquetWriter(f, columns), nil
+}
+
+// 数字按原来的精度输出，float32不会变成0.10000000149011612
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case int32:
+		return strconv.FormatInt(int64(x), 10)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case float32:
+		return strconv.FormatFloat(float64(x), 'f', -1, 32)
+	case string:
+		return x
+	}
+	return ""
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type csvWriter struct {
+	f *os.File
+	w *csv.Writer
+}
+
+func newCSVWriter(f *os.File, columns []column) (*csvWriter, error) {
+	w := &csvWriter{f: f, w: csv.NewWriter(f)}
+	header := make([]string, 0, len(columns))
+	for _, c := range columns {
+		header = append(header, c.name)
+	}
+	if err := w.w.Write(header); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *csvWriter) Write(row []interface{}) error {
+	record := make([]string, 0, len(row))
+	for _, v := range row {
+		record = append(record, formatValue(v))
+	}
+	return w.w.Write(record)
+}
+
+func (w *csvWriter) Close() error {
+	w.w.Flush()
+	if err := w.w.Error(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 每行一个json对象，字段的顺序和列的顺序一样
+type jsonlWriter struct {
+	f       *os.File
+	w       *bufio.Writer
+	columns []column
+}
+
+func (w *jsonlWriter) Write(row []interface{}) error {
+	line := []byte{'{'}
+	for i, v := range row {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		line = strconv.AppendQuote(line, w.columns[i].name)
+		line = append(line, ':')
+		if s, ok := v.(string); ok {
+			bs, err := json.Marshal(s)
+			if err != nil {
+				return err
+			}
+			line = append(line, bs...)
+		} else {
+			line = append(line, formatValue(v)...)
+		}
+	}
+	line = append(line, '}', '\n')
+	_, err := w.w.Write(line)
+	return err
+}
+
+func (w *jsonlWriter) Close() error {
+	if err := w.w.Flush(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
@@ -0,0 +1,351 @@
+/*
+ importer --- 把外部的历史行情导入成stg的格式，replay可以直接回放
+
+ 1. 读取csv、jsonl或者json文件，按配置文件import节的columns把列对应到行情的字段，
+    字段名和export导出的列名一样，没有配置的字段使用同名的列，所以export导出的csv可以直接导回来
+ 2. 每行生成一条PBFutureTick、PBFutureKLine或者PBFutureTrade，打包成FixPackage
+ 3. 按配置的交易日分到每一天，每天内按时间排序后写到<stg>/<exchange>/<day>/quote，同时写入当天的元数据
+ 4. k线的时间戳是k线的开始时间，按结束时间排序写入，回放时不会提前看到还没有结束的k线
+ 5. 全部数据先读到内存里再写，很长的历史最好分段导入
+
+ 字段：
+    公共    ts(必须)、symbol、contract_type
+    tick    last、bid、ask、bid_vol、ask_vol、vol、high、low、day_vol、day_high、day_low
+    kline   kind、open、high、low、close、vol、amount
+    trade   trade_seq、price、vol、amount、bs_code
+*/
+package importer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"chive/config"
+	"chive/logs"
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+const (
+	TYPE_TICK  = "tick"
+	TYPE_KLINE = "kline"
+	TYPE_TRADE = "trade"
+)
+
+// 最多在日志里报告多少行错误
+const max_error_lines = 10
+
+type record struct {
+	emit uint64 // 回放时的时间，毫秒
+	bin  []byte
+}
+
+type Importer struct {
+	cnf     config.AppCnf
+	path    string
+	td      *utils.TradingDay
+	loc     *time.Location
+	kind    int32
+	days    map[string][]*record
+	Rows    int // 导入的行数
+	Skipped int // 格式错误跳过的行数
+}
+
+/*
+ path是存储目录，格式和配置文件里的stg一样
+*/
+func NewImporter(cnf *config.AppCnf, path string) (*Importer, error) {
+	ic := cnf.Import
+	switch ic.Type {
+	case TYPE_TICK, TYPE_KLINE, TYPE_TRADE:
+	default:
+		return nil, errors.New("unknown import type " + ic.Type)
+	}
+
+	im := &Importer{cnf: *cnf, path: path, days: make(map[string][]*record)}
+	var err error
+	if im.td, err = utils.NewTradingDay(cnf.TradingDay.Timezone, cnf.TradingDay.Boundary); err != nil {
+		return nil, err
+	}
+	if im.loc, err = utils.ParseTimezone(ic.Timezone); err != nil {
+		return nil, err
+	}
+	if ic.Type == TYPE_KLINE {
+		k, ok := utils.ParseKLine(ic.KLine)
+		if !ok {
+			return nil, errors.New("unknown import kline " + ic.KLine)
+		}
+		im.kind = k
+	}
+	return im, nil
+}
+
+/*
+ 读取一个文件，返回读到的行数
+*/
+func (im *Importer) Read(filename string) (int, error) {
+	ic := im.cnf.Import
+	f, err := openFile(filename, ic.Format, ic.Comma, ic.Header)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	count := 0
+	for {
+		r, err := f.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("%s:%d: %s", filename, f.Line()+1, err.Error())
+		}
+		rec, err := im.convert(r)
+		if err != nil {
+			if im.Skipped < max_error_lines {
+				logs.Error("导入[%s]第[%d]行失败: %s", filename, f.Line(), err.Error())
+			}
+			im.Skipped += 1
+			continue
+		}
+		day := im.td.Day(msTime(rec.emit))
+		im.days[day] = append(im.days[day], rec)
+		count += 1
+	}
+	im.Rows += count
+	return count, nil
+}
+
+// 读到的全部交易日
+func (im *Importer) Days() []string {
+	ret := make([]string, 0, len(im.days))
+	for d := range im.days {
+		ret = append(ret, d)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+/*
+ 写到存储目录，日期已经存在时force为false返回错误，为true时覆盖
+*/
+func (im *Importer) Write(force bool, progress func(day string, count uint64)) error {
+	exchange := im.cnf.Import.Exchange
+	days := im.Days()
+	for _, day := range days {
+		filename := stg.DayFileName(im.path, exchange, day)
+		if _, err := os.Stat(filename); err != nil && !stg.IsArchived(im.path, exchange, day) {
+			continue
+		}
+		if !force {
+			return fmt.Errorf("%s already exists, use -f to overwrite", filename)
+		}
+		if err := stg.RemoveDay(im.path, exchange, day); err != nil {
+			return err
+		}
+	}
+
+	for _, day := range days {
+		count, err := im.writeDay(exchange, day)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(day, count)
+		}
+	}
+	return nil
+}
+
+func (im *Importer) writeDay(exchange string, day string) (uint64, error) {
+	recs := im.days[day]
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].emit < recs[j].emit
+	})
+
+	meta, err := stg.NewDayMeta(exchange, day, im.td)
+	if err != nil {
+		return 0, err
+	}
+	w, err := stg.NewDayWriter(im.path, exchange, day)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	if err := w.WriteMeta(meta); err != nil {
+		return 0, err
+	}
+	for _, rec := range recs {
+		if err := w.Write(rec.bin); err != nil {
+			return w.Count(), err
+		}
+	}
+	return w.Count(), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func msTime(ms uint64) time.Time {
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+}
+
+// 字段对应的列的值，没有这一列时返回false
+func (im *Importer) value(r row, field string) (string, bool) {
+	col, ok := im.cnf.Import.Columns[field]
+	if !ok {
+		col = field
+	}
+	v, ok := r[col]
+	v = strings.TrimSpace(v)
+	return v, ok && v != ""
+}
+
+// 没有这一列时返回0
+func (im *Importer) float(r row, field string) (*float32, error) {
+	v, ok := im.value(r, field)
+	if !ok {
+		return proto.Float32(0), nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s [%s] is not a number", field, v)
+	}
+	return proto.Float32(float32(f)), nil
+}
+
+func (im *Importer) timestamp(r row) (uint64, error) {
+	v, ok := im.value(r, "ts")
+	if !ok {
+		return 0, errors.New("no ts")
+	}
+	switch format := im.cnf.Import.TimeFormat; format {
+	case "ms", "s", "us":
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			return 0, fmt.Errorf("ts [%s] is not a %s timestamp", v, format)
+		}
+		if format == "s" {
+			f *= 1000
+		} else if format == "us" {
+			f /= 1000
+		}
+		return uint64(math.Floor(f + 0.5)), nil
+	default:
+		t, err := time.ParseInLocation(format, v, im.loc)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(t.UnixNano() / int64(time.Millisecond)), nil
+	}
+}
+
+func (im *Importer) sinfo(r row, ts uint64) (*protocol.PBQuoteSymbol, error) {
+	ic := im.cnf.Import
+	symbol, ok := im.value(r, "symbol")
+	if !ok {
+		symbol = ic.Symbol
+	}
+	contractType, ok := im.value(r, "contract_type")
+	if !ok {
+		contractType = ic.ContractType
+	}
+	if symbol == "" || contractType == "" {
+		return nil, errors.New("no symbol or contract_type")
+	}
+	return &protocol.PBQuoteSymbol{
+		Exchange:     proto.String(ic.Exchange),
+		Symbol:       proto.String(symbol),
+		ContractType: proto.String(contractType),
+		Timestamp:    proto.Uint64(ts),
+	}, nil
+}
+
+// 依次解析字段，遇到第一个错误停止
+func (im *Importer) floats(r row, fields map[string]**float32) error {
+	for name, p := range fields {
+		v, err := im.float(r, name)
+		if err != nil {
+			return err
+		}
+		*p = v
+	}
+	return nil
+}
+
+/*
+ 把一行转换成打包好的消息
+*/
+func (im *Importer) convert(r row) (*record, error) {
+	ts, err := im.timestamp(r)
+	if err != nil {
+		return nil, err
+	}
+	sinfo, err := im.sinfo(r, ts)
+	if err != nil {
+		return nil, err
+	}
+
+	rec := &record{emit: ts}
+	var tid int
+	var pb proto.Message
+	switch im.cnf.Import.Type {
+	case TYPE_TICK:
+		m := &protocol.PBFutureTick{Sinfo: sinfo}
+		err = im.floats(r, map[string]**float32{
+			"last": &m.Last, "bid": &m.Bid, "ask": &m.Ask, "bid_vol": &m.BidVol, "ask_vol": &m.AskVol,
+			"vol": &m.Vol, "high": &m.High, "low": &m.Low,
+			"day_vol": &m.DayVol, "day_high": &m.DayHigh, "day_low": &m.DayLow,
+		})
+		tid, pb = protocol.FID_QUOTE_TICK, m
+
+	case TYPE_KLINE:
+		m := &protocol.PBFutureKLine{Sinfo: sinfo, Kind: proto.Int32(im.kind)}
+		if v, ok := im.value(r, "kind"); ok {
+			k, ok := utils.ParseKLine(v)
+			if !ok {
+				return nil, fmt.Errorf("unknown kline kind [%s]", v)
+			}
+			m.Kind = proto.Int32(k)
+		}
+		err = im.floats(r, map[string]**float32{
+			"open": &m.Open, "high": &m.High, "low": &m.Low, "close": &m.Close, "vol": &m.Vol, "amount": &m.Amount,
+		})
+		rec.emit = ts + utils.KLineMillis(m.GetKind())
+		tid, pb = protocol.FID_QUOTE_KLine, m
+
+	case TYPE_TRADE:
+		m := &protocol.PBFutureTrade{Sinfo: sinfo}
+		if err = im.floats(r, map[string]**float32{"price": &m.Price, "vol": &m.Vol}); err != nil {
+			return nil, err
+		}
+		seq, _ := im.value(r, "trade_seq")
+		bs, _ := im.value(r, "bs_code")
+		m.TradeSeq, m.BsCode = proto.String(seq), proto.String(bs)
+		if v, ok := im.value(r, "amount"); ok {
+			f, perr := strconv.ParseFloat(v, 64)
+			if perr != nil {
+				return nil, fmt.Errorf("amount [%s] is not a number", v)
+			}
+			m.Amount = proto.Int32(int32(math.Floor(f + 0.5)))
+		}
+		tid, pb = protocol.FID_QUOTE_Trade, m
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if rec.bin, err = utils.PackMessage(tid, 0, pb); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
@@ -0,0 +1,83 @@
+package importer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"chive/config"
+	"chive/protocol"
+	"chive/stg"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 第二行的k线在16:00结束，属于下一个交易日
+	csvFile := filepath.Join(dir, "kline.csv")
+	ioutil.WriteFile(csvFile, []byte("time,o,h,l,c\n"+
+		"2017-12-25 15:59:00,100.5,102,100,101\n"+
+		"2017-12-25 15:58:00,100,101,99,100.5\n"+
+		"bad,1,1,1,1\n"), 0644)
+
+	cnf := &config.AppCnf{}
+	cnf.TradingDay.Timezone = "+08:00"
+	cnf.TradingDay.Boundary = "16:00:00"
+	ic := &cnf.Import
+	ic.Exchange, ic.Type, ic.Format = "okex", TYPE_KLINE, FORMAT_CSV
+	ic.Symbol, ic.ContractType, ic.KLine = "btc_usd", "quarter", "KL1Min"
+	ic.TimeFormat, ic.Timezone = "2006-01-02 15:04:05", "+08:00"
+	ic.Header, ic.Comma = true, ","
+	ic.Columns = map[string]string{"ts": "time", "open": "o", "high": "h", "low": "l", "close": "c"}
+
+	im, err := NewImporter(cnf, dir+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := im.Read(csvFile); err != nil || n != 2 || im.Skipped != 1 {
+		t.Fatalf("read %d rows, skip %d, %v", n, im.Skipped, err)
+	}
+	if days := im.Days(); len(days) != 2 || days[0] != "2017-12-25" {
+		t.Fatalf("days are %v", days)
+	}
+	if err := im.Write(false, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := im.Write(false, nil); err == nil {
+		t.Fatal("write the existing days should fail")
+	}
+
+	r, err := stg.OpenDayReader(dir+"/", "okex", "2017-12-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var kl *protocol.PBFutureKLine
+	r.Query(&stg.Query{}, func(rec *stg.Record) bool {
+		p := protocol.FixPackage{}
+		p.ParseFromArray(rec.Value)
+		kl = &protocol.PBFutureKLine{}
+		proto.Unmarshal(p.Payload, kl)
+		return true
+	})
+	if r.Count() != 1 || kl.GetClose() != 100.5 || kl.GetSinfo().GetTimestamp() != 1514188680000 || kl.GetKind() != protocol.KL1Min {
+		t.Fatalf("import %d records, kline %v", r.Count(), kl)
+	}
+
+	// json数组，列名和字段名一样
+	jsonFile := filepath.Join(dir, "trade.json")
+	ioutil.WriteFile(jsonFile, []byte(`[{"ts": 1514188680123, "symbol": "ltc_usd", "price": 250.5, "amount": 3, "bs_code": "ask"}]`), 0644)
+	ic.Type, ic.Format, ic.TimeFormat, ic.Columns = TYPE_TRADE, FORMAT_JSON, "ms", nil
+	ic.ContractType = "this_week"
+	im, _ = NewImporter(cnf, dir+"/")
+	if n, err := im.Read(jsonFile); err != nil || n != 1 {
+		t.Fatalf("read json %d rows, %v", n, err)
+	}
+}
@@ -0,0 +1,161 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+const (
+	FORMAT_CSV   = "csv"
+	FORMAT_JSONL = "jsonl"
+	FORMAT_JSON  = "json" // 一个对象的数组
+)
+
+// 文件里的一行，key是列名，csv没有列名时是从0开始的列号
+type row map[string]string
+
+// 按行读取文件，读完时返回io.EOF
+type source interface {
+	Next() (row, error)
+	Line() int // 当前行在文件里的位置，用来报告错误
+}
+
+type csvSource struct {
+	r     *csv.Reader
+	names []string
+	line  int
+}
+
+func newCSVSource(r io.Reader, comma string, header bool) (*csvSource, error) {
+	s := &csvSource{r: csv.NewReader(r)}
+	if comma == "\\t" || comma == "tab" {
+		comma = "\t"
+	}
+	if len([]rune(comma)) != 1 {
+		return nil, errors.New("import comma should be one character")
+	}
+	s.r.Comma = []rune(comma)[0]
+	s.r.FieldsPerRecord = -1
+	s.r.ReuseRecord = true
+	if header {
+		names, err := s.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		s.names = append([]string{}, names...)
+		s.line += 1
+	}
+	return s, nil
+}
+
+func (s *csvSource) Next() (row, error) {
+	record, err := s.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	s.line += 1
+	ret := make(row, len(record))
+	for i, v := range record {
+		if s.names == nil {
+			ret[strconv.Itoa(i)] = v
+		} else if i < len(s.names) {
+			ret[s.names[i]] = v
+		}
+	}
+	return ret, nil
+}
+
+func (s *csvSource) Line() int {
+	return s.line
+}
+
+// jsonl每行一个对象，json是对象的数组，数字保留原来的写法
+type jsonSource struct {
+	dec  *json.Decoder
+	line int
+}
+
+func newJSONSource(r io.Reader, array bool) (*jsonSource, error) {
+	s := &jsonSource{dec: json.NewDecoder(r)}
+	s.dec.UseNumber()
+	if array {
+		t, err := s.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		if d, ok := t.(json.Delim); !ok || d != '[' {
+			return nil, errors.New("import json file should be an array of objects")
+		}
+	}
+	return s, nil
+}
+
+func (s *jsonSource) Next() (row, error) {
+	if !s.dec.More() {
+		return nil, io.EOF
+	}
+	m := map[string]interface{}{}
+	if err := s.dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	s.line += 1
+	ret := make(row, len(m))
+	for k, v := range m {
+		switch x := v.(type) {
+		case string:
+			ret[k] = x
+		case json.Number:
+			ret[k] = x.String()
+		case bool:
+			ret[k] = strconv.FormatBool(x)
+		case nil:
+		default:
+			return nil, fmt.Errorf("column %s should be a string or a number", k)
+		}
+	}
+	return ret, nil
+}
+
+func (s *jsonSource) Line() int {
+	return s.line
+}
+
+type file struct {
+	f *os.File
+	source
+}
+
+func openFile(filename string, format string, comma string, header bool) (*file, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(f)
+
+	var src source
+	switch format {
+	case FORMAT_CSV:
+		src, err = newCSVSource(r, comma, header)
+	case FORMAT_JSONL:
+		src, err = newJSONSource(r, false)
+	case FORMAT_JSON:
+		src, err = newJSONSource(r, true)
+	default:
+		err = errors.New("unknown import format " + format)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &file{f: f, source: src}, nil
+}
+
+func (f *file) Close() error {
+	return f.f.Close()
+}
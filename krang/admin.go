@@ -0,0 +1,416 @@
+package krang
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"chive/logs"
+	"chive/protocol"
+)
+
+/*
+ krang的管理接口，HTTP/JSON
+
+ 1. 所有命令都投递到krang协程里执行，不需要加锁
+ 2. 暂停的策略不再收到行情和定时器回调，下单会被风控拒绝，订单和头寸的更新仍然会收到
+ 3. 平仓先撤销该品种全部未成交的订单，马上用市价单平掉可平的数量，
+    挂单冻结的数量要等撤单完成后才释放，剩下的由定时任务等撤单回应和订单撤销后再平
+    紧急平仓不受风控的杠杆和价格偏离等限制，撤单和平仓直接发给交易所，平仓的盈亏仍然记到admin上
+ 4. 回放时不启动
+
+ GET  /strategies                                        策略列表和状态
+ GET  /keeper                                            头寸、订单和资金
+ POST /strategy/pause?name=mavg                          暂停策略
+ POST /strategy/resume?name=mavg                         恢复策略
+ POST /strategy/state?name=mavg&state=defense            强制切换策略状态
+ POST /flatten?exchange=okex&symbol=ltc_usd&contract_type=this_week   撤单并平仓，contract_type为空时平掉全部合约
+*/
+
+const (
+	admin_timeout     = 5 * time.Second
+	admin_stname      = "admin" // 管理接口下单使用的策略名称
+	admin_close_level = 10      // 头寸没有杠杆信息时使用的杠杆
+
+	flatten_check_interval = 500 * time.Millisecond                                     // 检查撤单是否完成的间隔
+	flatten_wait           = track_timeout * (track_max_retries + 1) * time.Millisecond // 等待撤单完成的最长时间
+)
+
+type adminResult struct {
+	data []byte
+	err  error
+}
+
+type adminCmd struct {
+	f   func() (interface{}, error)
+	ret chan adminResult
+}
+
+type adminRsp struct {
+	Ok    bool            `json:"ok"`
+	Error string          `json:"error,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+type strategyInfo struct {
+	Name   string `json:"name"`
+	Paused bool   `json:"paused"`
+	State  string `json:"state,omitempty"`
+	Since  string `json:"since,omitempty"`
+}
+
+type keeperInfo struct {
+	Pos    []*Pos   `json:"pos"`
+	Orders []*Order `json:"orders"`
+	Moneys []*Money `json:"moneys"`
+}
+
+type flattenInfo struct {
+	Canceled []string      `json:"canceled"`
+	Closed   []SetOrderCmd `json:"closed"`
+	Deferred []SetOrderCmd `json:"deferred"` // 撤单完成后再发的平仓委托
+}
+
+func (kr *krang) startAdmin(bReplay bool) error {
+	addr := kr.cnf.Admin.Addr
+	if bReplay || addr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	kr.admin = ln
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/strategies", kr.adminGet(kr.listStrategies))
+	mux.HandleFunc("/keeper", kr.adminGet(kr.dumpKeeper))
+	mux.HandleFunc("/strategy/pause", kr.adminPost(func(r *http.Request) (interface{}, error) {
+		return nil, kr.pauseStrategy(r.FormValue("name"), true)
+	}))
+	mux.HandleFunc("/strategy/resume", kr.adminPost(func(r *http.Request) (interface{}, error) {
+		return nil, kr.pauseStrategy(r.FormValue("name"), false)
+	}))
+	mux.HandleFunc("/strategy/state", kr.adminPost(func(r *http.Request) (interface{}, error) {
+		return nil, kr.forceState(r.FormValue("name"), r.FormValue("state"))
+	}))
+	mux.HandleFunc("/flatten", kr.adminPost(func(r *http.Request) (interface{}, error) {
+		return kr.flatten(r.FormValue("exchange"), r.FormValue("symbol"), r.FormValue("contract_type"))
+	}))
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			logs.Info("krang admin server exit: %s", err.Error())
+		}
+	}()
+	logs.Info("krang管理接口监听[%s]", addr)
+	return nil
+}
+
+func (kr *krang) closeAdmin() {
+	if kr.admin != nil {
+		kr.admin.Close()
+		kr.admin = nil
+	}
+}
+
+// 在krang协程里执行管理命令
+func (kr *krang) execAdminCmd(c *adminCmd) {
+	v, err := c.f()
+	var data []byte
+	if err == nil && v != nil {
+		data, err = json.Marshal(v)
+	}
+	c.ret <- adminResult{data: data, err: err}
+}
+
+// 把命令投递到krang协程，等待执行结果
+func (kr *krang) postAdminCmd(f func() (interface{}, error)) ([]byte, error) {
+	c := &adminCmd{
+		f:   f,
+		ret: make(chan adminResult, 1),
+	}
+	select {
+	case kr.adminCh <- c:
+	case <-time.After(admin_timeout):
+		return nil, errors.New("krang is busy")
+	}
+	select {
+	case r := <-c.ret:
+		return r.data, r.err
+	case <-time.After(admin_timeout):
+		return nil, errors.New("wait krang timeout")
+	}
+}
+
+func (kr *krang) adminGet(f func() (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeAdminRsp(w, http.StatusMethodNotAllowed, nil, errors.New("method not allowed"))
+			return
+		}
+		data, err := kr.postAdminCmd(f)
+		writeAdminRsp(w, http.StatusOK, data, err)
+	}
+}
+
+func (kr *krang) adminPost(f func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeAdminRsp(w, http.StatusMethodNotAllowed, nil, errors.New("method not allowed"))
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			writeAdminRsp(w, http.StatusBadRequest, nil, err)
+			return
+		}
+		logs.Info("krang管理命令[%s], 参数[%s], 来自[%s]", r.URL.Path, r.Form.Encode(), r.RemoteAddr)
+		data, err := kr.postAdminCmd(func() (interface{}, error) {
+			return f(r)
+		})
+		writeAdminRsp(w, http.StatusOK, data, err)
+	}
+}
+
+func writeAdminRsp(w http.ResponseWriter, code int, data []byte, err error) {
+	rsp := &adminRsp{Ok: err == nil, Data: data}
+	if err != nil {
+		rsp.Error = err.Error()
+		if code == http.StatusOK {
+			code = http.StatusBadRequest
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(rsp)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+ 下面的函数都在krang协程里执行
+*/
+
+func (kr *krang) listStrategies() (interface{}, error) {
+	names := make([]string, 0, len(kr.stmgr.m))
+	for name := range kr.stmgr.m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ret := make([]strategyInfo, 0, len(names))
+	for _, name := range names {
+		info := strategyInfo{Name: name, Paused: kr.isPaused(name)}
+		if sr, ok := kr.stmgr.m[name].(StateReporter); ok {
+			st, since := sr.CurrentState()
+			info.State = st
+			if !since.IsZero() {
+				info.Since = since.Format("2006-01-02 15:04:05")
+			}
+		}
+		ret = append(ret, info)
+	}
+	return ret, nil
+}
+
+func (kr *krang) dumpKeeper() (interface{}, error) {
+	k := kr.keeper.(*keeper)
+	ret := &keeperInfo{
+		Pos:    k.pos,
+		Orders: make([]*Order, 0, k.orders.Len()),
+		Moneys: k.moneys,
+	}
+	for e := k.orders.Front(); e != nil; e = e.Next() {
+		ret.Orders = append(ret.Orders, e.Value.(*Order))
+	}
+	return ret, nil
+}
+
+func (kr *krang) pauseStrategy(name string, pause bool) error {
+	if _, ok := kr.stmgr.m[name]; !ok {
+		return errors.New("strategy not found")
+	}
+	if pause {
+		kr.paused[name] = true
+		logs.Info("策略[%s]被暂停", name)
+	} else {
+		delete(kr.paused, name)
+		logs.Info("策略[%s]恢复运行", name)
+	}
+	return nil
+}
+
+func (kr *krang) isPaused(name string) bool {
+	return kr.paused[name]
+}
+
+func (kr *krang) forceState(name string, state string) error {
+	st, ok := kr.stmgr.m[name]
+	if !ok {
+		return errors.New("strategy not found")
+	}
+	sf, ok := st.(StateForcer)
+	if !ok {
+		return errors.New("strategy does not support force state")
+	}
+	if err := sf.ForceState(kr.ctx, state); err != nil {
+		return err
+	}
+	logs.Info("策略[%s]被强制切换到[%s]状态", name, state)
+	return nil
+}
+
+func (kr *krang) flatten(exchange string, symbol string, contractType string) (interface{}, error) {
+	// 不经过风控，风控的限制不能阻止紧急平仓
+	trader, ok := kr.traders[exchange]
+	if !ok {
+		return nil, errors.New("exchange not found")
+	}
+	if symbol == "" {
+		return nil, errors.New("symbol is empty")
+	}
+	cts := trader.ContractTypes()
+	if contractType != "" {
+		cts = []string{contractType}
+	}
+
+	ret := &flattenInfo{
+		Canceled: []string{},
+		Closed:   []SetOrderCmd{},
+		Deferred: []SetOrderCmd{},
+	}
+	for _, c := range cts {
+		ids := []string{}
+		for _, o := range kr.keeper.GetOrderBySinfo(exchange, symbol, c) {
+			if o.OrderStatus == protocol.ORDERSTATUS_WAITTING || o.OrderStatus == protocol.ORDERSTATUS_PARTDONE {
+				ids = append(ids, o.OrderId)
+			}
+		}
+		if len(ids) > 0 {
+			trader.CancelOrder(SetOrderCmd{
+				Stname:       admin_stname,
+				Exchange:     exchange,
+				Symbol:       symbol,
+				ContractType: c,
+				OrderIDs:     strings.Join(ids, ","),
+			})
+			ret.Canceled = append(ret.Canceled, ids...)
+		}
+
+		pos := kr.keeper.GetPos(exchange, symbol, c)
+		if pos == nil {
+			continue
+		}
+		level := pos.Lever
+		if level <= 0 {
+			level = admin_close_level
+		}
+		// 撤单是异步的，挂单冻结的数量还不能平，先平可平的数量
+		deferred := []SetOrderCmd{}
+		if pos.LongAmount > 0 {
+			if avai := int32(pos.LongAvai); avai > 0 {
+				ret.Closed = append(ret.Closed, kr.closeAll(trader, pos, protocol.ORDERTYPE_CLOSELONG, avai, level))
+			}
+			if rest := int32(pos.LongAmount) - int32(pos.LongAvai); rest > 0 {
+				deferred = append(deferred, closeCmd(pos, protocol.ORDERTYPE_CLOSELONG, rest, level))
+			}
+		}
+		if pos.ShortAmount > 0 {
+			if avai := int32(pos.ShortAvai); avai > 0 {
+				ret.Closed = append(ret.Closed, kr.closeAll(trader, pos, protocol.ORDERTYPE_CLOSESHORT, avai, level))
+			}
+			if rest := int32(pos.ShortAmount) - int32(pos.ShortAvai); rest > 0 {
+				deferred = append(deferred, closeCmd(pos, protocol.ORDERTYPE_CLOSESHORT, rest, level))
+			}
+		}
+		pos.Disable()
+		if len(deferred) > 0 {
+			kr.closeAfterCancel(trader, ids, deferred)
+			ret.Deferred = append(ret.Deferred, deferred...)
+		}
+	}
+
+	logs.Info("管理接口平仓[%s_%s], 撤销订单[%d]个, 平仓委托[%d]个", exchange, symbol, len(ret.Canceled), len(ret.Closed))
+	if len(ret.Deferred) > 0 {
+		logs.Info("管理接口平仓[%s_%s], 等待撤单完成后平仓委托[%d]个", exchange, symbol, len(ret.Deferred))
+	}
+	return ret, nil
+}
+
+/*
+ 撤单回应收到、撤销的订单都不再挂着以后，平掉挂单冻结的数量
+ 等待超过flatten_wait还没有完成时放弃，需要人工处理
+*/
+func (kr *krang) closeAfterCancel(trader ExchangeTrade, ids []string, cmds []SetOrderCmd) {
+	cmd := cmds[0]
+	deadline := kr.nowMs() + durationMs(flatten_wait)
+	var id int64
+	id = kr.sched.every(flatten_check_interval, func(ctx Context) {
+		if kr.cancelPending(cmd.Exchange, cmd.Symbol, cmd.ContractType, ids) {
+			if kr.nowMs() >= deadline {
+				kr.sched.cancel(id)
+				logs.Error("管理接口平仓[%s_%s_%s], 等待撤单超时, 还有%d个平仓委托没有发出", cmd.Exchange, cmd.Symbol,
+					cmd.ContractType, len(cmds))
+			}
+			return
+		}
+		kr.sched.cancel(id)
+		for _, c := range cmds {
+			kr.adminSetOrder(trader, c)
+		}
+		if pos := kr.keeper.GetPos(cmd.Exchange, cmd.Symbol, cmd.ContractType); pos != nil {
+			pos.Disable()
+		}
+		logs.Info("管理接口平仓[%s_%s_%s], 撤单完成, 发出平仓委托[%d]个", cmd.Exchange, cmd.Symbol, cmd.ContractType, len(cmds))
+	})
+}
+
+// 撤单请求还没有回应，或者要撤的订单还挂着
+func (kr *krang) cancelPending(exchange string, symbol string, contractType string, ids []string) bool {
+	for _, item := range kr.keeper.GetTracker().FindByStrategy(admin_stname) {
+		c := item.Cmd
+		if item.Tid == protocol.FID_ReqCancelOrders && c.Exchange == exchange && c.Symbol == symbol &&
+			c.ContractType == contractType {
+			return true
+		}
+	}
+	waiting := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		waiting[id] = true
+	}
+	for _, o := range kr.keeper.GetOrderBySinfo(exchange, symbol, contractType) {
+		if waiting[o.OrderId] && (o.OrderStatus == protocol.ORDERSTATUS_WAITTING || o.OrderStatus == protocol.ORDERSTATUS_PARTDONE) {
+			return true
+		}
+	}
+	return false
+}
+
+func closeCmd(pos *Pos, ot int32, amount int32, level int32) SetOrderCmd {
+	return SetOrderCmd{
+		Stname:       admin_stname,
+		Exchange:     pos.Exchange,
+		Symbol:       pos.Symbol,
+		ContractType: pos.ContractType,
+		Amount:       amount,
+		OrderType:    ot,
+		PriceSt:      protocol.PRICE_ST_MARKET,
+		Level:        level,
+	}
+}
+
+func (kr *krang) closeAll(trader ExchangeTrade, pos *Pos, ot int32, amount int32, level int32) SetOrderCmd {
+	cmd := closeCmd(pos, ot, amount, level)
+	kr.adminSetOrder(trader, cmd)
+	return cmd
+}
+
+// 跳过风控检查下单，风控仍然记录这次平仓，之后的已平仓盈亏算到admin上
+func (kr *krang) adminSetOrder(trader ExchangeTrade, cmd SetOrderCmd) {
+	kr.risk.onOrder(cmd)
+	trader.SetOrder(cmd)
+}
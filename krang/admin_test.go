@@ -0,0 +1,192 @@
+package krang
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"chive/config"
+	"chive/protocol"
+)
+
+// 记录委托和撤单的trader，和真实的trader一样在Tracker里跟踪
+type adminTestTrade struct {
+	ExchangeTrade
+	kr      *krang
+	orders  []SetOrderCmd
+	cancels []SetOrderCmd
+	serials []uint32 // 撤单的reqSerial
+}
+
+func (t *adminTestTrade) SetOrder(cmd SetOrderCmd) {
+	t.orders = append(t.orders, cmd)
+	t.kr.keeper.GetTracker().Add(cmd, protocol.FID_ReqSetOrder, uint32(t.kr.incReqSeed()))
+}
+
+func (t *adminTestTrade) CancelOrder(cmd SetOrderCmd) {
+	serial := uint32(t.kr.incReqSeed())
+	t.cancels = append(t.cancels, cmd)
+	t.serials = append(t.serials, serial)
+	t.kr.keeper.GetTracker().Add(cmd, protocol.FID_ReqCancelOrders, serial)
+}
+
+// 支持强制切换状态的策略
+type adminTestStrategy struct {
+	trackTestStrategy
+	state string
+	since time.Time
+}
+
+func (s *adminTestStrategy) CurrentState() (string, time.Time) {
+	return s.state, s.since
+}
+
+func (s *adminTestStrategy) ForceState(ctx Context, state string) error {
+	if state != "normal" && state != "defense" {
+		return errors.New("unknown state " + state)
+	}
+	s.state, s.since = state, ctx.Now()
+	return nil
+}
+
+func newAdminTestKrang(cnf *config.AppCnf) (*krang, *adminTestTrade) {
+	kr := newKrang(cnf)
+	kr.useVirtualClock().Advance(1000)
+	kr.keeper = NewKeeper(kr)
+	kr.ctx = NewContext(kr)
+	t := &adminTestTrade{ExchangeTrade: NewOkexTrade(kr), kr: kr}
+	kr.traders["okex"] = t
+	kr.guards["okex"] = NewRiskTrade(kr, t)
+	return kr, t
+}
+
+func TestAdminPause(t *testing.T) {
+	kr, trader := newAdminTestKrang(&config.AppCnf{})
+	kr.stmgr.m["mavg"] = &trackTestStrategy{}
+	cmd := SetOrderCmd{Stname: "mavg", Exchange: "okex", Symbol: "ltc_usd", ContractType: "this_week",
+		Amount: 1, OrderType: protocol.ORDERTYPE_OPENLONG, PriceSt: protocol.PRICE_ST_MARKET, Level: 10}
+
+	if err := kr.pauseStrategy("none", true); err == nil {
+		t.Fatal("pause unknown strategy should fail")
+	}
+	if err := kr.pauseStrategy("mavg", true); err != nil {
+		t.Fatal(err)
+	}
+	ret, _ := kr.listStrategies()
+	if infos := ret.([]strategyInfo); len(infos) != 1 || !infos[0].Paused {
+		t.Fatalf("strategies %+v", infos)
+	}
+	kr.guards["okex"].SetOrder(cmd)
+	if len(trader.orders) != 0 {
+		t.Fatal("paused strategy should not send orders")
+	}
+
+	if err := kr.pauseStrategy("mavg", false); err != nil {
+		t.Fatal(err)
+	}
+	kr.guards["okex"].SetOrder(cmd)
+	if len(trader.orders) != 1 || kr.isPaused("mavg") {
+		t.Fatal("resumed strategy should send orders")
+	}
+}
+
+func TestAdminForceState(t *testing.T) {
+	kr, _ := newAdminTestKrang(&config.AppCnf{})
+	st := &adminTestStrategy{state: "normal"}
+	kr.stmgr.m["mavg"] = st
+	kr.stmgr.m["plain"] = &trackTestStrategy{}
+
+	if err := kr.forceState("none", "defense"); err == nil {
+		t.Fatal("force unknown strategy should fail")
+	}
+	if err := kr.forceState("plain", "defense"); err == nil {
+		t.Fatal("strategy without StateForcer should fail")
+	}
+	if err := kr.forceState("mavg", "radical"); err == nil || st.state != "normal" {
+		t.Fatal("unknown state should be rejected by the strategy")
+	}
+	if err := kr.forceState("mavg", "defense"); err != nil {
+		t.Fatal(err)
+	}
+
+	ret, _ := kr.listStrategies()
+	infos := ret.([]strategyInfo)
+	if len(infos) != 2 || infos[0].Name != "mavg" || infos[0].State != "defense" || infos[0].Since == "" || infos[1].State != "" {
+		t.Fatalf("strategies %+v", infos)
+	}
+}
+
+func TestAdminFlatten(t *testing.T) {
+	// 风控的杠杆限制低于admin_close_level，紧急平仓不受影响
+	cnf := &config.AppCnf{}
+	cnf.Risk.Default = config.RiskLimit{MaxLevel: 5}
+	kr, trader := newAdminTestKrang(cnf)
+	k := kr.keeper.(*keeper)
+
+	// 多头5张，3张可平，2张被挂着的平仓单冻结
+	pos := &Pos{Exchange: "okex", Symbol: "ltc_usd", ContractType: "this_week", IsValid: true,
+		LongAmount: 5, LongAvai: 3}
+	k.pos = append(k.pos, pos)
+	order := &Order{Exchange: "okex", Symbol: "ltc_usd", ContractType: "this_week", OrderId: "11",
+		Amount: 2, OrderType: protocol.ORDERTYPE_CLOSELONG, OrderStatus: protocol.ORDERSTATUS_WAITTING}
+	k.orders.PushBack(order)
+
+	if _, err := kr.flatten("huobi", "ltc_usd", ""); err == nil {
+		t.Fatal("unknown exchange should fail")
+	}
+	v, err := kr.flatten("okex", "ltc_usd", "this_week")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := v.(*flattenInfo)
+	if len(ret.Canceled) != 1 || ret.Canceled[0] != "11" || len(trader.cancels) != 1 {
+		t.Fatalf("canceled %v", ret.Canceled)
+	}
+	if len(ret.Closed) != 1 || ret.Closed[0].Amount != 3 || ret.Closed[0].Level != admin_close_level ||
+		ret.Closed[0].OrderType != protocol.ORDERTYPE_CLOSELONG || len(trader.orders) != 1 {
+		t.Fatalf("closed %+v, sent %+v", ret.Closed, trader.orders)
+	}
+	if len(ret.Deferred) != 1 || ret.Deferred[0].Amount != 2 || pos.IsValid {
+		t.Fatalf("deferred %+v", ret.Deferred)
+	}
+
+	run := func(ms uint64) {
+		kr.vclock.Advance(kr.nowMs() + ms)
+		kr.sched.run(kr.nowMs())
+	}
+
+	// 撤单没有回应，订单还挂着
+	run(600)
+	if len(trader.orders) != 1 {
+		t.Fatal("deferred close should wait for cancel response")
+	}
+	kr.keeper.GetTracker().OnRspCancel(trader.serials[0], protocol.ErrId_OK, "")
+	run(500)
+	if len(trader.orders) != 1 {
+		t.Fatal("deferred close should wait for the order to be canceled")
+	}
+
+	order.OrderStatus = protocol.ORDERSTATUS_CANCELED
+	pos.IsValid = true
+	run(500)
+	if len(trader.orders) != 2 || trader.orders[1].Amount != 2 || trader.orders[1].Stname != admin_stname || pos.IsValid {
+		t.Fatalf("deferred close not sent, %+v", trader.orders)
+	}
+	if len(kr.sched.entries) != 0 {
+		t.Fatal("check task should stop after sending")
+	}
+
+	// 撤单一直没有完成，超时后放弃
+	order.OrderStatus = protocol.ORDERSTATUS_WAITTING
+	pos.LongAmount, pos.LongAvai = 2, 0
+	if _, err := kr.flatten("okex", "ltc_usd", "this_week"); err != nil {
+		t.Fatal(err)
+	}
+	kr.keeper.GetTracker().OnRspCancel(trader.serials[1], protocol.ErrId_OK, "")
+	for i := 0; i < int(durationMs(flatten_wait)/500)+2; i++ {
+		run(500)
+	}
+	if len(trader.orders) != 2 || len(kr.sched.entries) != 0 {
+		t.Fatalf("flatten should give up, sent %d, entries %d", len(trader.orders), len(kr.sched.entries))
+	}
+}
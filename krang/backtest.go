@@ -0,0 +1,121 @@
+package krang
+
+import (
+	"chive/backtest"
+	"chive/config"
+	"chive/logs"
+	"chive/replay"
+)
+
+/*
+ 回测模式
+ 1. 回放时所有交易所都使用模拟交易，委托按照回放的行情撮合
+ 2. 模拟交易记录每一笔成交，并按照行情时间采样权益曲线
+ 3. 回放结束后，krang退出前输出回测报告
+ 4. Backtest是独立的回测实例，有自己的keeper、交易接口、时钟和策略，不使用全局的krang
+    多个实例可以在不同的协程里同时运行，参数优化时使用
+*/
+
+// 能统计盈亏的交易接口，模拟交易实现了这个接口
+type pnlReporter interface {
+	// 各个品种的盈亏折算成美元的和
+	pnl() float64
+
+	// 最新行情的时间，毫秒
+	lastTs() uint64
+}
+
+/*
+ 设置回测模式，需要在StartKrang之前调用
+ dir是回测报告的输出目录
+*/
+func SetKrangBacktest(dir string) {
+	defaultKrang.recorder = backtest.NewRecorder(backtest.DEFAULT_SAMPLE_INTERVAL)
+	defaultKrang.reportDir = dir
+}
+
+func (kr *krang) isBacktest() bool {
+	return kr.recorder != nil
+}
+
+func (kr *krang) recordPnL(ts uint64, force bool) {
+	if !kr.isBacktest() {
+		return
+	}
+
+	var sum float64 = 0
+	for _, t := range kr.traders {
+		if r, ok := t.(pnlReporter); ok {
+			sum += r.pnl()
+		}
+	}
+	kr.recorder.UpdatePnL(ts, sum, force)
+}
+
+func (kr *krang) writeBacktestReport() {
+	if !kr.isBacktest() {
+		return
+	}
+
+	var ts uint64 = 0
+	for _, t := range kr.traders {
+		if r, ok := t.(pnlReporter); ok && r.lastTs() > ts {
+			ts = r.lastTs()
+		}
+	}
+	if ts > 0 {
+		kr.recordPnL(ts, true)
+	}
+
+	rp := kr.recorder.Report()
+	kr.report = rp
+	if kr.reportDir == "" {
+		return
+	}
+	if err := rp.Write(kr.reportDir); err != nil {
+		logs.Error("write backtest report to [%s] error: %s", kr.reportDir, err.Error())
+		return
+	}
+	logs.Info("回测报告已输出到[%s], 盈亏[%f], 收益率[%f], 最大回撤[%f], 胜率[%f], 夏普比率[%f]",
+		kr.reportDir, rp.PnL, rp.Return, rp.MaxDrawdown, rp.WinRate, rp.Sharpe)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+ 回测实例，不保存快照，不启动管理接口，回放的消息由调用者提供
+ 实例只能运行一次
+*/
+type Backtest struct {
+	kr *krang
+}
+
+func NewBacktest(cnf *config.AppCnf, r *replay.Replay) *Backtest {
+	kr := newKrang(cnf)
+	kr.replay = r
+	kr.recorder = backtest.NewRecorder(backtest.DEFAULT_SAMPLE_INTERVAL)
+	kr.useVirtualClock()
+	return &Backtest{kr: kr}
+}
+
+// 添加策略，不检查配置里是否启用
+func (b *Backtest) AddStrategy(name string, st Strategy) {
+	if name == "" || st == nil {
+		panic("AddStrategy panic, parameter error")
+	}
+	if _, dup := b.kr.stmgr.m[name]; dup {
+		panic("Add one Strategy for twice")
+	}
+	b.kr.stmgr.m[name] = st
+}
+
+/*
+ 在当前协程里回放全部消息，回放结束后返回回测报告
+*/
+func (b *Backtest) Run() (*backtest.Report, error) {
+	if err := b.kr.setup(true); err != nil {
+		return nil, err
+	}
+	b.kr.krangLoop(true)
+	return b.kr.report, nil
+}
@@ -1,5 +1,11 @@
 package krang
 
+import (
+	"time"
+
+	"chive/config"
+)
+
 /*
   Context --- 是krang模块和各个strategy交互的接口
   因此开发策略只需要关注Context接口就好
@@ -45,33 +51,123 @@ type Tick struct {
 	AskVol float32 // 卖一价的量
 }
 
+// K线
+type KLine struct {
+	Exchange     string
+	Symbol       string
+	ContractType string
+	Timestamp    uint64
+	Kind         int32 // K线类型，protocol.KL1Min等
+
+	Open   float32
+	High   float32
+	Low    float32
+	Close  float32
+	Vol    float32 // 成交量，张
+	Amount float32 // 成交量，币
+}
+
+// 档口
+type DepthItem struct {
+	Price float32
+	Vol   float32
+}
+
+type Depth struct {
+	Exchange     string
+	Symbol       string
+	ContractType string
+	Timestamp    uint64
+
+	Asks []DepthItem // 卖盘
+	Bids []DepthItem // 买盘
+}
+
+// 逐笔成交
+type Trade struct {
+	Exchange     string
+	Symbol       string
+	ContractType string
+	Timestamp    uint64
+
+	TradeSeq string  // 成交序号
+	Price    float32 // 成交价
+	Vol      float32 // 成交量，币
+	Amount   int32   // 成交量，张
+	BsCode   string  // 买卖方向
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
 type Context interface {
 	GetKeeper() Keeper
 	GetQuoteDB() TSDB
 	GetTrader(exchange string) ExchangeTrade
+
+	// 延时d后执行一次，返回任务id
+	After(d time.Duration, f TimerFunc) int64
+
+	// 每隔d执行一次，返回任务id
+	Every(d time.Duration, f TimerFunc) int64
+
+	// 按cron表达式执行，表达式格式见utils.ParseCron，返回任务id
+	Cron(expr string, f TimerFunc) (int64, error)
+
+	// 取消定时任务
+	CancelTimer(id int64)
+
+	// 当前时间，回放时是回放行情的时间
+	Now() time.Time
+
+	// 策略的配置，包括交易的品种和策略参数，没有配置时返回默认配置
+	GetStrategyCnf(name string) *config.StrategyCnf
 }
 
 type context struct {
+	kr *krang
 }
 
-func NewContext() Context {
-	return &context{}
+func NewContext(kr *krang) Context {
+	return &context{kr: kr}
 }
 
 func (c *context) GetKeeper() Keeper {
-	return kr.keeper
+	return c.kr.keeper
 }
 
 func (c *context) GetQuoteDB() TSDB {
-	return kr.quotedb
+	return c.kr.quotedb
 }
 
+// 策略拿到的trader下单前都会经过风控
 func (c *context) GetTrader(exchange string) ExchangeTrade {
-	trader, ok := kr.traders[exchange]
+	trader, ok := c.kr.guards[exchange]
 	if !ok {
 		return nil
 	}
 	return trader
 }
+
+func (c *context) After(d time.Duration, f TimerFunc) int64 {
+	return c.kr.sched.after(d, f)
+}
+
+func (c *context) Every(d time.Duration, f TimerFunc) int64 {
+	return c.kr.sched.every(d, f)
+}
+
+func (c *context) Cron(expr string, f TimerFunc) (int64, error) {
+	return c.kr.sched.addCron(expr, f)
+}
+
+func (c *context) CancelTimer(id int64) {
+	c.kr.sched.cancel(id)
+}
+
+func (c *context) Now() time.Time {
+	return c.kr.clock.Now()
+}
+
+func (c *context) GetStrategyCnf(name string) *config.StrategyCnf {
+	return c.kr.cnf.GetStrategyCnf(name)
+}
@@ -39,8 +39,8 @@ type Keeper interface {
 	// 查找商品资金信息
 	GetMoney(exchange string, symbol string) *Money
 
-	// 查找回馈信息
-	GetFeedBack() FeedBack
+	// 查找请求跟踪器
+	GetTracker() Tracker
 }
 
 type Order struct {
@@ -68,7 +68,8 @@ type Pos struct {
 	Exchange     string
 	Symbol       string
 	ContractType string
-	IsValid      bool // 是否是最新的pos信息
+	IsValid      bool  // 是否是最新的pos信息
+	Lever        int32 // 杠杆倍数
 
 	LongAmount      float32 // 多头合约张数
 	LongAvai        float32 // 多头可平数量
@@ -91,12 +92,7 @@ type Pos struct {
 	ShortFloatPRate  float32 // 空头浮动盈亏比例
 }
 
-func (p *Pos) OnTick(exchange string, pb *protocol.PBFutureTick) {
-	trader, ok := kr.traders[exchange]
-	if !ok {
-		return
-	}
-
+func (p *Pos) OnTick(trader ExchangeTrade, pb *protocol.PBFutureTick) {
 	trader.computePosProfit(p, pb)
 }
 
@@ -107,6 +103,7 @@ func (p *Pos) Disable() {
 // 清除数据部分
 func (p *Pos) Reset() {
 	p.IsValid = true
+	p.Lever = 0
 	p.LongAmount = 0      // 多头合约张数
 	p.LongAvai = 0        // 多头可平数量
 	p.LongBond = 0        // 多头保证金
@@ -140,15 +137,17 @@ type keeper struct {
 	orders   *list.List
 	pos      []*Pos
 	moneys   []*Money
-	feedback FeedBack
+	tracker  Tracker
+	kr       *krang
 }
 
-func NewKeeper() *keeper {
+func NewKeeper(kr *krang) *keeper {
 	return &keeper{
 		orders:   list.New(),
 		pos:      make([]*Pos, 0),
 		moneys:   make([]*Money, 0),
-		feedback: NewFeedBack(),
+		tracker:  NewTracker(kr),
+		kr:       kr,
 	}
 }
 
@@ -200,25 +199,7 @@ func (k *keeper) HandleOrders(exchange string, pb *protocol.PBFRspQryOrders) boo
 			if !isUndoneOrder(v.GetStatus()) {
 				continue
 			}
-			o := &Order{}
-			o.Exchange = exchange
-			o.Symbol = string(v.GetSymbol())
-			o.ContractType = string(v.GetContractType())
-			o.Amount = v.GetAmount()
-			o.ContractName = string(v.GetContractName())
-			o.ContractDate = string(v.GetContractDate())
-			o.DealAmount = v.GetDealAmount()
-			o.Fee = v.GetFee()
-			o.OrderId = id
-			o.Price = v.GetPrice()
-			o.PriceAvg = v.GetPriceAvg()
-			o.OrderStatus = v.GetStatus()
-			o.OrderType = v.GetType()
-			o.UnitAmount = v.GetUnitAmount()
-			o.Lever = v.GetLeverRate()
-			o.FloatProfit = 0
-			o.CloseProfit = 0
-			k.orders.PushBack(o)
+			k.orders.PushBack(makeOrder(exchange, v))
 		} else {
 			if !isUndoneOrder(v.GetStatus()) {
 				k.orders.Remove(e)
@@ -228,6 +209,28 @@ func (k *keeper) HandleOrders(exchange string, pb *protocol.PBFRspQryOrders) boo
 	return true
 }
 
+func makeOrder(exchange string, v *protocol.PBFOrderInfo) *Order {
+	o := &Order{}
+	o.Exchange = exchange
+	o.Symbol = string(v.GetSymbol())
+	o.ContractType = string(v.GetContractType())
+	o.Amount = v.GetAmount()
+	o.ContractName = string(v.GetContractName())
+	o.ContractDate = string(v.GetContractDate())
+	o.DealAmount = v.GetDealAmount()
+	o.Fee = v.GetFee()
+	o.OrderId = string(v.GetOrderId())
+	o.Price = v.GetPrice()
+	o.PriceAvg = v.GetPriceAvg()
+	o.OrderStatus = v.GetStatus()
+	o.OrderType = v.GetType()
+	o.UnitAmount = v.GetUnitAmount()
+	o.Lever = v.GetLeverRate()
+	o.FloatProfit = 0
+	o.CloseProfit = 0
+	return o
+}
+
 /*
    头寸信息以服务器发来的回应为准
    1. 如果这个商品返回的头寸信息为空，删除或者reset本地的头寸信息
@@ -263,6 +266,7 @@ func (k *keeper) HandlePos(exchange string, pb *protocol.PBFRspQryPosInfo) bool
 
 		v := pb.GetPosInfos()[0]
 		pos.IsValid = true
+		pos.Lever = v.GetLeverRate()
 		pos.LongAmount = v.GetBuyAmount()          // 多头合约张数
 		pos.LongAvai = v.GetBuyAvailable()         // 多头可平数量
 		pos.LongBond = v.GetBuyBond()              // 多头保证金
@@ -306,8 +310,10 @@ func (k *keeper) HandleMoney(exchange string, pb *protocol.PBFRspQryMoneyInfo) b
 }
 
 func (k *keeper) OnTick(exchange string, pb *protocol.PBFutureTick) {
-	for _, p := range k.pos {
-		p.OnTick(exchange, pb)
+	if trader, ok := k.kr.traders[exchange]; ok {
+		for _, p := range k.pos {
+			p.OnTick(trader, pb)
+		}
 	}
 
 	for _, m := range k.moneys {
@@ -367,6 +373,6 @@ func (k *keeper) GetMoney(exchange string, symbol string) *Money {
 	return m
 }
 
-func (k *keeper) GetFeedBack() FeedBack {
-	return k.feedback
+func (k *keeper) GetTracker() Tracker {
+	return k.tracker
 }
@@ -1,7 +1,6 @@
 package krang
 
 import (
-	"fmt"
 	"testing"
 
 	"chive/protocol"
@@ -11,23 +10,53 @@ import (
 
 func TestKlmem(t *testing.T) {
 	pbk5 := &protocol.PBFutureKLine{
-		Kind: protocol.KL5Min,
+		Kind:  proto.Int32(protocol.KL5Min),
+		Sinfo: &protocol.PBQuoteSymbol{Symbol: proto.String("ltc_usd")},
 	}
 	pbk15 := &protocol.PBFutureKLine{
-		Kind: protocol.KL15Min,
+		Kind:  proto.Int32(protocol.KL15Min),
+		Sinfo: &protocol.PBQuoteSymbol{Symbol: proto.String("ltc_usd")},
 	}
 
 	klm := NewKLineMem("test")
+	var base uint64 = 1546272000000
 	for i := 0; i < 100; i++ {
 		pbk5.Close = proto.Float32(float32(i + 1))
+		pbk5.Sinfo.Timestamp = proto.Uint64(base + uint64(i)*5*60*1000)
 		pbk15.Close = proto.Float32(float32(i + 2))
-		klm.addKLine(pbk5)
-		klm.addKLine(pbk15)
+		pbk15.Sinfo.Timestamp = proto.Uint64(base + uint64(i)*15*60*1000)
+		if klm.addKLine(pbk5) != 1 || klm.addKLine(pbk15) != 1 {
+			t.Fatalf("kline %d should be a new kline", i)
+		}
+	}
+	if kc := klm.m[protocol.KL5Min].kc; kc != 100 {
+		t.Fatalf("5min kline count should be 100, got %d", kc)
+	}
+
+	// 同一根K线的更新替换最后一根
+	pbk5.Close = proto.Float32(200)
+	pbk5.Sinfo.Timestamp = proto.Uint64(base + 99*5*60*1000 + 1000)
+	if klm.addKLine(pbk5) != 0 {
+		t.Fatal("update of the same kline should not be a new kline")
+	}
+	k, ok := klm.getLastKLine(protocol.KL5Min)
+	if !ok || k.close != 200 || klm.m[protocol.KL5Min].kc != 100 {
+		t.Fatalf("last 5min kline error, %+v", k)
 	}
 
-	fmt.Println("5min ma7:", klm.queryMA7(KL5MIN, 200))
-	fmt.Println("5min ma15:", klm.queryMA15(KL5MIN, 50))
+	// 旧数据扔掉
+	pbk15.Sinfo.Timestamp = proto.Uint64(base)
+	if klm.addKLine(pbk15) != 0 {
+		t.Fatal("old kline should be dropped")
+	}
+	if k, _ := klm.getLastKLine(protocol.KL15Min); k.close != 101 {
+		t.Fatalf("last 15min kline close should be 101, got %f", k.close)
+	}
 
-	fmt.Println("15min ma7:", klm.queryMA7(KL15MIN, 50))
-	fmt.Println("15min ma15:", klm.queryMA15(KL15MIN, 50))
+	if _, ok := klm.getLastKLine(protocol.KL1Min); ok {
+		t.Fatal("1min kline should be empty")
+	}
+	if sum := sumRangeKList(klm.m[protocol.KL15Min].kl, 7); sum != 98 {
+		t.Fatalf("15min ma7 should be 98, got %f", sum)
+	}
 }
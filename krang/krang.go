@@ -9,15 +9,21 @@ package krang
 
 import (
 	"errors"
+	"net"
 	"sync/atomic"
+	"time"
 
+	"chive/backtest"
 	"chive/config"
 	"chive/kfc"
 	"chive/logs"
 	"chive/protocol"
 	"chive/replay"
+	"chive/utils"
 
 	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 type Handler interface {
@@ -60,21 +66,50 @@ type ExchangeTrade interface {
 	// 计算持仓盈亏，每个交易所计算方法不一样
 	// 这个方法不会暴露给策略使用
 	computePosProfit(pos *Pos, pb *protocol.PBFutureTick)
+
+	// 合约面值
+	unitAmount(symbol string) float32
 }
 
 type krang struct {
-	traders  map[string]ExchangeTrade
-	keeper   Keeper
-	handlers []Handler
-	quotedb  TSDB
-	stmgr    *StrategyManager
-	ctx      Context
-	exitCh   chan int
-	reqSeed  int64
-	replay   *replay.Replay
+	cnf       *config.AppCnf
+	traders   map[string]ExchangeTrade
+	guards    map[string]ExchangeTrade // 经过风控的trader，给策略使用
+	risk      *riskManager
+	sched     *scheduler
+	keeper    Keeper
+	handlers  []Handler
+	quotedb   TSDB
+	stmgr     *StrategyManager
+	ctx       Context
+	exitCh    chan int
+	reqSeed   int64
+	replay    *replay.Replay
+	tasks     []func() // 在当前消息处理完后执行的任务
+	clock     utils.Clock         // krang使用的时钟
+	vclock    *utils.VirtualClock // 回放时使用的虚拟时钟
+	snapdb    *leveldb.DB         // 状态快照
+	recon     *reconciler         // 启动时的对账，完成后为nil
+	paused    map[string]bool     // 被管理接口暂停的策略
+	adminCh   chan *adminCmd      // 管理命令，在krang协程里执行
+	admin     net.Listener
+	nextTimer uint64            // 下一次策略定时器的时间，毫秒
+	klineTs   map[string]uint64 // key: sinfo_kind, 最新K线的时间，毫秒
+	doneCh    chan struct{}
+
+	// 回测模式使用
+	recorder  *backtest.Recorder
+	reportDir string
+	report    *backtest.Report
 }
 
-var kr *krang
+// 实盘和回放使用的krang，回测实例见Backtest
+var defaultKrang *krang
+
+const (
+	timer_interval = 1000                   // 策略定时器间隔，毫秒
+	clock_interval = 100 * time.Millisecond // 检查定时任务的间隔
+)
 
 ////////////////////////////////////////////////////////
 
@@ -82,36 +117,74 @@ var kr *krang
  StartKrang --- 初始化工作和启动krang协程
 */
 func StartKrang(exitCh chan int, bReplay bool) error {
+	kr := defaultKrang
+	kr.cnf = config.T
 	kr.exitCh = exitCh
-	kr.keeper = NewKeeper()
+
+	// 回放时使用虚拟时钟，日志在回放开始前仍然使用本机时间
+	if bReplay {
+		vc := kr.useVirtualClock()
+		utils.SetClock(vc)
+		logs.SetNowFunc(func() time.Time {
+			if vc.Started() {
+				return vc.Now()
+			}
+			return time.Now()
+		})
+	}
+
+	// 获得注册的策略
+	kr.stmgr = GetStrategyMgr()
+
+	if err := kr.setup(bReplay); err != nil {
+		return err
+	}
+
+	// 管理接口
+	if err := kr.startAdmin(bReplay); err != nil {
+		return err
+	}
+
+	go kr.krangLoop(bReplay)
+	return nil
+}
+
+/*
+ 创建keeper、交易接口、handlers和context
+ 实盘、回放和回测实例都使用
+*/
+func (kr *krang) setup(bReplay bool) error {
+	kr.keeper = NewKeeper(kr)
+
+	// 恢复快照
+	if err := kr.openSnapshot(bReplay); err != nil {
+		return err
+	}
+	kr.restoreSnapshot()
 
 	// 启动tsdb
-	kr.quotedb = NewTSDBClient(bReplay)
-	for _, v := range config.T.Exchanges {
-		t, err := createTrader(v)
+	kr.quotedb = NewTSDBClient(kr.cnf.InfluxDB.Addr, bReplay)
+	for _, v := range kr.cnf.Exchanges {
+		t, err := kr.createTrader(v)
 		if err != nil {
 			return err
 		}
 		kr.traders[v] = t
+		kr.guards[v] = NewRiskTrade(kr, t)
 		kr.quotedb.Check(v, t.Symbols(), t.ContractTypes())
 	}
 	kr.quotedb.Start()
 
 	// 消息处理handlers,处理顺序为：trade -> quote -> strategy
-	h := NewTradeHandler()
+	h := NewTradeHandler(kr)
 	kr.handlers = append(kr.handlers, h)
-	h1 := NewQuoteHandler()
+	h1 := NewQuoteHandler(kr)
 	kr.handlers = append(kr.handlers, h1)
-	h2 := NewStrategyHandler()
+	h2 := NewStrategyHandler(kr)
 	kr.handlers = append(kr.handlers, h2)
 
-	// 获得注册的策略
-	kr.stmgr = GetStrategyMgr()
-
 	// 初始化context
-	kr.ctx = NewContext()
-
-	go krangLoop(bReplay)
+	kr.ctx = NewContext(kr)
 	return nil
 }
 
@@ -122,17 +195,28 @@ func SetKrangReplay(r *replay.Replay) {
 	if r == nil {
 		panic("SetKrangReplay param vaild")
 	}
-	kr.replay = r
+	defaultKrang.replay = r
+}
+
+/*
+ krang协程退出后关闭，回放时可以用来等待krang处理完全部消息
+*/
+func Done() <-chan struct{} {
+	return defaultKrang.doneCh
 }
 
 ////////////////////////////////////////////////////////
-func krangLoop(bReplay bool) {
-	defer krangExit()
+func (kr *krang) krangLoop(bReplay bool) {
+	defer kr.krangExit()
 
-	// 初始化策略
+	// 初始化策略，然后恢复策略快照和对账
 	for _, v := range kr.stmgr.m {
 		v.Init(kr.ctx)
 	}
+	kr.restoreStrategies()
+	kr.startReconcile()
+	kr.startSnapshotTimer()
+	kr.runTasks()
 
 	var pumpCh <-chan *sarama.ConsumerMessage
 	if bReplay {
@@ -141,10 +225,28 @@ func krangLoop(bReplay bool) {
 		pumpCh = kfc.ReadMessages()
 	}
 
+	tc := time.NewTicker(clock_interval)
+	defer tc.Stop()
+
 	for {
 		select {
-		case msg := <-pumpCh:
-			handlemsg(msg)
+		case msg, ok := <-pumpCh:
+			if !ok {
+				// 回放结束
+				return
+			}
+			kr.handlemsg(msg)
+			kr.runTasks()
+			if bReplay {
+				kr.onClock()
+			}
+
+		case <-tc.C:
+			kr.onClock()
+
+		case c := <-kr.adminCh:
+			kr.execAdminCmd(c)
+			kr.runTasks()
 
 		case <-kr.exitCh:
 			return
@@ -152,14 +254,17 @@ func krangLoop(bReplay bool) {
 	}
 }
 
-func handlemsg(msg *sarama.ConsumerMessage) bool {
+func (kr *krang) handlemsg(msg *sarama.ConsumerMessage) bool {
 	p := &protocol.FixPackage{}
 	if !p.ParseFromArray(msg.Value) {
 		logs.Error("krang consumer msg parse fail")
 		return false
 	}
 
-	key := string(msg.Key)
+	return kr.dispatch(p, string(msg.Key))
+}
+
+func (kr *krang) dispatch(p protocol.Package, key string) bool {
 	for _, h := range kr.handlers {
 		b := h.HandleMessage(p, key)
 		if b {
@@ -169,29 +274,144 @@ func handlemsg(msg *sarama.ConsumerMessage) bool {
 	return true
 }
 
-func krangExit() {
+/*
+ 投递一个任务，在krang协程处理完当前消息后执行
+ 任务里投递的任务也会在本轮执行，保证不会重入handlers
+*/
+func (kr *krang) postTask(f func()) {
+	kr.tasks = append(kr.tasks, f)
+}
+
+func (kr *krang) runTasks() {
+	for len(kr.tasks) > 0 {
+		f := kr.tasks[0]
+		kr.tasks = kr.tasks[1:]
+		f()
+	}
+}
+
+/*
+ 将回应打包成和archer一样的消息，投递给krang自己处理
+ 模拟交易使用这个函数回应请求
+*/
+func (kr *krang) postReply(exchange string, tid uint32, reqSerial uint32, pb proto.Message) {
+	bin, err := proto.Marshal(pb)
+	if err != nil {
+		logs.Error("post reply pb marshal error:%s, tid:%d", err.Error(), tid)
+		return
+	}
+
+	p := &protocol.FixPackage{}
+	p.Tid = tid
+	p.ReqSerial = reqSerial
+	p.Attribute = 0
+	p.Payload = bin
+	kr.postTask(func() {
+		kr.dispatch(p, exchange)
+	})
+}
+
+/*
+ 检查到期的请求、策略定时器和定时任务
+ 回放时每处理完一个消息调用一次，时间跟着行情走
+*/
+func (kr *krang) onClock() {
+	if !kr.clockStarted() {
+		return
+	}
+	now := kr.nowMs()
+	kr.keeper.GetTracker().Check(now)
+	kr.checkReconcile(now)
+	kr.sched.run(now)
+	if now >= kr.nextTimer {
+		kr.nextTimer = now - now%timer_interval + timer_interval
+		kr.notifyTimer(now)
+	}
+	kr.runTasks()
+}
+
+/*
+ krang使用的当前时间，毫秒
+ 回放时使用虚拟时钟，由行情的时间推进，否则使用本机时间
+*/
+func (kr *krang) nowMs() uint64 {
+	return uint64(kr.clock.Now().UnixNano() / int64(time.Millisecond))
+}
+
+// 回放时收到第一个行情后时间才有意义
+func (kr *krang) clockStarted() bool {
+	return kr.vclock == nil || kr.vclock.Started()
+}
+
+// 使用虚拟时钟，需要在收到行情之前调用
+func (kr *krang) useVirtualClock() *utils.VirtualClock {
+	kr.vclock = utils.NewVirtualClock()
+	kr.clock = kr.vclock
+	return kr.vclock
+}
+
+// 用行情的时间推进虚拟时钟
+func (kr *krang) advanceClock(sinfo *protocol.PBQuoteSymbol) {
+	if kr.vclock != nil {
+		kr.vclock.Advance(sinfo.GetTimestamp())
+	}
+}
+
+func (kr *krang) krangExit() {
+	kr.closeAdmin()
+	kr.writeBacktestReport()
+	kr.closeSnapshot()
 	kr.quotedb.Close()
+	close(kr.doneCh)
 }
 
 ////////////////////////////////////////////////////////
 
-func createTrader(exchange string) (ExchangeTrade, error) {
+func (kr *krang) createTrader(exchange string) (ExchangeTrade, error) {
+	var t ExchangeTrade
 	if exchange == "okex" {
-		return NewOkexTrade(), nil
+		t = NewOkexTrade(kr)
+	} else {
+		return nil, errors.New("create exchange trader, not supported exchange")
+	}
+
+	if kr.cnf.IsPaperTrade(exchange) || kr.isBacktest() {
+		costs, err := backtest.NewCosts(kr.cnf.GetCostCnf(exchange))
+		if err != nil {
+			return nil, err
+		}
+		logs.Info("exchange [%s] use paper trade", exchange)
+		return NewPaperTrade(kr, exchange, t, costs), nil
 	}
-	return nil, errors.New("create exchange trader, not supported exchange")
+	return t, nil
 }
 
-func incReqSeed() int64 {
+func (kr *krang) incReqSeed() int64 {
 	atomic.AddInt64(&kr.reqSeed, 1)
 	return kr.reqSeed
 }
 
-func init() {
-	kr = &krang{
+func newKrang(cnf *config.AppCnf) *krang {
+	kr := &krang{
+		cnf:      cnf,
 		traders:  make(map[string]ExchangeTrade),
+		guards:   make(map[string]ExchangeTrade),
 		handlers: make([]Handler, 0),
+		stmgr:    &StrategyManager{m: make(map[string]Strategy)},
 		reqSeed:  0,
 		replay:   nil,
+		tasks:    make([]func(), 0),
+		clock:    utils.NewWallClock(),
+		paused:   make(map[string]bool),
+		klineTs:  make(map[string]uint64),
+		adminCh:  make(chan *adminCmd),
+		doneCh:   make(chan struct{}),
 	}
+	kr.risk = newRiskManager(kr)
+	kr.sched = newScheduler(kr)
+	return kr
+}
+
+func init() {
+	defaultKrang = newKrang(nil)
 }
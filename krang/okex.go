@@ -17,11 +17,13 @@ import (
 type okexTrade struct {
 	exchange string
 	uam      map[string]float32 // 合约面值
+	kr       *krang
 }
 
-func NewOkexTrade() ExchangeTrade {
+func NewOkexTrade(kr *krang) ExchangeTrade {
 	return &okexTrade{
 		exchange: "okex",
+		kr:       kr,
 		uam: map[string]float32{
 			"btc_usd": 100,
 			"ltc_usd": 10,
@@ -41,7 +43,7 @@ func (t *okexTrade) packAndSend(tid uint32, pb proto.Message, tag string) uint32
 
 	p := &protocol.FixPackage{}
 	p.Tid = tid
-	p.ReqSerial = uint32(incReqSeed())
+	p.ReqSerial = uint32(t.kr.incReqSeed())
 	p.Attribute = 0
 	p.Payload = bin
 
@@ -93,7 +95,7 @@ func (t *okexTrade) SetOrder(cmd SetOrderCmd) {
 
 	reqSerial := t.packAndSend(protocol.FID_ReqSetOrder, pb, "setorder")
 	if reqSerial > 0 {
-		kr.keeper.GetFeedBack().Add(cmd.Stname, reqSerial, protocol.FID_ReqSetOrder, "")
+		t.kr.keeper.GetTracker().Add(cmd, protocol.FID_ReqSetOrder, reqSerial)
 	}
 }
 
@@ -128,7 +130,7 @@ func (t *okexTrade) CancelOrder(cmd SetOrderCmd) {
 
 	reqSerial := t.packAndSend(protocol.FID_ReqCancelOrders, pb, "cancel order")
 	if reqSerial > 0 {
-		kr.keeper.GetFeedBack().Add(cmd.Stname, reqSerial, protocol.FID_ReqCancelOrders, "")
+		t.kr.keeper.GetTracker().Add(cmd, protocol.FID_ReqCancelOrders, reqSerial)
 	}
 }
 
@@ -193,3 +195,12 @@ func (t *okexTrade) ComputeContractAmount(symbol string, price float32, vol floa
 	amount := (price * vol) / ua
 	return int32(amount)
 }
+
+// 合约面值
+func (t *okexTrade) unitAmount(symbol string) float32 {
+	ua, ok := t.uam[symbol]
+	if !ok {
+		return 0
+	}
+	return ua
+}
@@ -0,0 +1,852 @@
+package krang
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"chive/backtest"
+	"chive/logs"
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+ paperTrade --- 模拟交易，指令不会发给archer
+
+ 1. 委托在krang本地撮合，撮合价格来自最新的tick和depth行情
+ 2. 回应和archer的回应是一样的pb消息，经过krang的消息循环交给tradeHandler和Keeper处理
+ 3. 成交后主动推送订单、头寸和资金的回应，reqSerial为0
+ 4. 品种、合约类型、合约面值、盈亏计算沿用真实交易所的实现
+ 5. 保证金按照okex的全仓固定保证金计算，不模拟强平，未成交的开仓委托冻结保证金，可用余额不包括冻结的部分
+    平仓成交时持仓不够的部分撤销，订单以撤销结束
+ 6. 成本模型在paper::cost节里配置：手续费、吃单滑点、委托和撤单的延迟、限价挂单按逐笔成交撮合
+    委托经过延迟后才到达交易所，此时检查委托、回应下单结果并尝试立即成交
+    到达时没有成交的限价单变成挂单，挂单成交按maker收手续费，没有滑点
+    延迟的委托和撤单在该交易所的下一个行情到来时才执行，不是到期时马上执行，行情稀疏时实际的延迟更长
+*/
+
+// 需要行情驱动的交易接口
+type quoteFeeder interface {
+	onTick(pb *protocol.PBFutureTick)
+	onDepth(pb *protocol.PBFutureDepth)
+	onTrade(pb *protocol.PBFutureTrade)
+}
+
+type paperOrder struct {
+	symbol       string
+	contractType string
+	orderId      string
+	amount       float32 // 委托数量，张
+	dealAmount   float32 // 成交数量，张
+	price        float32 // 委托价格
+	priceAvg     float32 // 成交均价
+	priceSt      int32   // 价格类型
+	orderType    int32   // 订单类型
+	lever        int32   // 杠杆倍数
+	fee          float32 // 手续费
+	status       int32   // 订单状态
+	ts           uint64  // 委托时间，毫秒
+	rested       bool    // 限价单到达时没有全部成交，之后的成交是挂单成交
+}
+
+type paperPos struct {
+	symbol       string
+	contractType string
+	lever        int32
+
+	longAmount      float32
+	longBond        float32
+	longPriceAvg    float32
+	longCloseProfit float32
+
+	shortAmount      float32
+	shortBond        float32
+	shortPriceAvg    float32
+	shortCloseProfit float32
+}
+
+type paperLevel struct {
+	price  float32
+	amount float32 // 张
+}
+
+type paperQuote struct {
+	last float32
+	bid  float32
+	ask  float32
+	asks []paperLevel
+	bids []paperLevel
+	ts   uint64
+}
+
+// 延迟到达交易所的委托或者撤单
+type paperDelayed struct {
+	due uint64 // 到达时间，毫秒
+	fn  func()
+}
+
+type paperTrade struct {
+	ExchangeTrade // 真实交易所的实现
+
+	exchange  string
+	orderSeed int64
+	orders    []*paperOrder
+	pos       map[string]*paperPos   // key: symbol_contractType
+	balances  map[string]float32     // key: symbol, 可用余额
+	initials  map[string]float32     // key: symbol, 初始余额加上转入的资金
+	prices    map[string]float32     // key: symbol, 最新价
+	quotes    map[string]*paperQuote // key: symbol_contractType
+	ts        uint64                 // 最新行情时间
+	costs     *backtest.Costs
+	delayed   []paperDelayed // 按到达时间排序
+	kr        *krang
+}
+
+func NewPaperTrade(kr *krang, exchange string, real ExchangeTrade, costs *backtest.Costs) ExchangeTrade {
+	t := &paperTrade{
+		ExchangeTrade: real,
+		exchange:      exchange,
+		orderSeed:     0,
+		orders:        make([]*paperOrder, 0),
+		pos:           make(map[string]*paperPos),
+		balances:      make(map[string]float32),
+		initials:      make(map[string]float32),
+		prices:        make(map[string]float32),
+		quotes:        make(map[string]*paperQuote),
+		ts:            0,
+		costs:         costs,
+		delayed:       make([]paperDelayed, 0),
+		kr:            kr,
+	}
+	for _, s := range real.Symbols() {
+		t.balances[s] = kr.cnf.Paper.Balance
+		t.initials[s] = kr.cnf.Paper.Balance
+		if kr.isBacktest() {
+			kr.recorder.SetBalance(exchange+"_"+s, float64(kr.cnf.Paper.Balance))
+		}
+	}
+	return t
+}
+
+func paperKey(symbol string, contractType string) string {
+	return symbol + "_" + contractType
+}
+
+func isBuyOrder(orderType int32) bool {
+	return orderType == protocol.ORDERTYPE_OPENLONG || orderType == protocol.ORDERTYPE_CLOSESHORT
+}
+
+func makeRspInfo(eid int32, msg string) *protocol.RspInfo {
+	rsp := &protocol.RspInfo{}
+	rsp.ErrorId = proto.Int32(eid)
+	if msg != "" {
+		rsp.ErrorMsg = []byte(msg)
+	}
+	return rsp
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 查询资金账户
+func (t *paperTrade) QueryAccount() {
+	t.replyMoney(uint32(t.kr.incReqSeed()))
+}
+
+// 查询头寸
+func (t *paperTrade) QueryPos(symbol string, contractType string) {
+	t.replyPos(uint32(t.kr.incReqSeed()), symbol, contractType)
+}
+
+// 下单
+func (t *paperTrade) SetOrder(cmd SetOrderCmd) {
+	reqSerial := uint32(t.kr.incReqSeed())
+	t.kr.keeper.GetTracker().Add(cmd, protocol.FID_ReqSetOrder, reqSerial)
+	t.after(func() {
+		t.arriveOrder(cmd, reqSerial)
+	})
+}
+
+// 委托到达交易所
+func (t *paperTrade) arriveOrder(cmd SetOrderCmd, reqSerial uint32) {
+	pb := &protocol.PBFRspSetOrder{}
+	pb.Exchange = []byte(t.exchange)
+	pb.Symbol = []byte(cmd.Symbol)
+	pb.ContractType = []byte(cmd.ContractType)
+
+	errMsg := t.checkOrder(cmd)
+	if errMsg != "" {
+		pb.Rsp = makeRspInfo(protocol.ErrId_ApiError, errMsg)
+		t.kr.postReply(t.exchange, protocol.FID_RspSetOrder, reqSerial, pb)
+		return
+	}
+
+	o := t.newOrder(cmd)
+	pb.Rsp = makeRspInfo(protocol.ErrId_OK, "")
+	pb.OrderId = []byte(o.orderId)
+	t.kr.postReply(t.exchange, protocol.FID_RspSetOrder, reqSerial, pb)
+
+	logs.Info("模拟下单，商品[%s], 合约类型[%s], 合约张数[%d], 订单类型[%s], 价格[%f], 杠杆[%d], 订单号[%s]",
+		cmd.Symbol, cmd.ContractType, cmd.Amount, utils.OrderTypeStr(cmd.OrderType), cmd.Price, cmd.Level, o.orderId)
+
+	q, ok := t.quotes[paperKey(o.symbol, o.contractType)]
+	if ok {
+		t.matchOrder(o, q)
+	}
+	if o.priceSt == protocol.PRICE_ST_LIMIT && isUndoneOrder(o.status) {
+		o.rested = true
+	}
+}
+
+// 查询单据，多个订单号用,分割
+func (t *paperTrade) QueryOrder(symbol string, contractType string, orderId string) {
+	ids := strings.Split(orderId, ",")
+	ret := []*paperOrder{}
+	for _, o := range t.orders {
+		for _, id := range ids {
+			if o.orderId == id {
+				ret = append(ret, o)
+			}
+		}
+	}
+	t.replyOrders(uint32(t.kr.incReqSeed()), ret)
+}
+
+// status使用protocol.ORDERSTATUS_XXX
+func (t *paperTrade) QueryOrderByStatus(symbol string, contractType string, status int32) {
+	ret := []*paperOrder{}
+	for _, o := range t.orders {
+		if o.symbol == symbol && o.contractType == contractType && o.status == status {
+			ret = append(ret, o)
+		}
+	}
+	t.replyOrders(uint32(t.kr.incReqSeed()), ret)
+}
+
+// 撤销单据，多个订单号用,分割
+func (t *paperTrade) CancelOrder(cmd SetOrderCmd) {
+	reqSerial := uint32(t.kr.incReqSeed())
+	t.kr.keeper.GetTracker().Add(cmd, protocol.FID_ReqCancelOrders, reqSerial)
+	t.after(func() {
+		t.arriveCancel(cmd, reqSerial)
+	})
+}
+
+// 撤单到达交易所，延迟期间委托可能已经成交
+func (t *paperTrade) arriveCancel(cmd SetOrderCmd, reqSerial uint32) {
+	pb := &protocol.PBFRspCancelOrders{}
+	pb.Rsp = makeRspInfo(protocol.ErrId_OK, "")
+	pb.Exchange = []byte(t.exchange)
+	pb.Symbol = []byte(cmd.Symbol)
+	pb.ContractType = []byte(cmd.ContractType)
+
+	for _, id := range strings.Split(cmd.OrderIDs, ",") {
+		o := t.findOrder(id)
+		if o == nil || !isUndoneOrder(o.status) {
+			pb.Errors = append(pb.Errors, []byte(id))
+			continue
+		}
+		o.status = protocol.ORDERSTATUS_CANCELED
+		pb.Success = append(pb.Success, []byte(id))
+	}
+	t.kr.postReply(t.exchange, protocol.FID_RspCancelOrders, reqSerial, pb)
+}
+
+// 合约和现货账户转账，模拟交易没有现货账户，只改变合约账户余额
+func (t *paperTrade) TransferMoney(symbol string, transType int32, vol float32) {
+	pb := &protocol.PBFRspTransferMoney{}
+	pb.Rsp = makeRspInfo(protocol.ErrId_OK, "")
+
+	if transType == protocol.TRANS_SPOT_TO_FUTURE {
+		t.balances[symbol] += vol
+		t.initials[symbol] += vol
+	} else if transType == protocol.TRANS_FUTURE_TO_SPOT {
+		if t.balances[symbol] < vol {
+			pb.Rsp = makeRspInfo(protocol.ErrId_TransferErr, "转账金额大于余额")
+		} else {
+			t.balances[symbol] -= vol
+			t.initials[symbol] -= vol
+		}
+	}
+	t.kr.postReply(t.exchange, protocol.FID_RspTransferMoney, uint32(t.kr.incReqSeed()), pb)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 经过延迟后执行，没有延迟时立即执行，到期后等下一个行情才执行，见runDelayed
+func (t *paperTrade) after(fn func()) {
+	delay := t.costs.Latency.Delay()
+	if delay == 0 {
+		fn()
+		return
+	}
+
+	d := paperDelayed{due: t.ts + delay, fn: fn}
+	i := len(t.delayed)
+	for i > 0 && t.delayed[i-1].due > d.due {
+		i--
+	}
+	t.delayed = append(t.delayed, paperDelayed{})
+	copy(t.delayed[i+1:], t.delayed[i:])
+	t.delayed[i] = d
+}
+
+// 执行到达时间不晚于ts的委托和撤单，使用的是ts之前的行情
+func (t *paperTrade) runDelayed(ts uint64) {
+	for len(t.delayed) > 0 && t.delayed[0].due <= ts {
+		d := t.delayed[0]
+		t.delayed = t.delayed[1:]
+		if d.due > t.ts {
+			t.ts = d.due
+		}
+		d.fn()
+	}
+}
+
+func (t *paperTrade) onTick(pb *protocol.PBFutureTick) {
+	sinfo := pb.GetSinfo()
+	t.runDelayed(sinfo.GetTimestamp())
+	q := t.getQuote(sinfo.GetSymbol(), sinfo.GetContractType())
+	q.last = pb.GetLast()
+	q.bid = pb.GetBid()
+	q.ask = pb.GetAsk()
+	q.ts = sinfo.GetTimestamp()
+	t.ts = q.ts
+	if q.last > 0 {
+		t.costs.Slippage.Update(paperKey(sinfo.GetSymbol(), sinfo.GetContractType()), float64(q.last))
+		t.prices[sinfo.GetSymbol()] = q.last
+		if t.kr.isBacktest() {
+			t.kr.recorder.SetPrice(t.exchange+"_"+sinfo.GetSymbol(), float64(q.last))
+		}
+	}
+	t.matchAll(sinfo.GetSymbol(), sinfo.GetContractType(), q)
+	t.kr.recordPnL(t.ts, false)
+}
+
+func (t *paperTrade) onDepth(pb *protocol.PBFutureDepth) {
+	sinfo := pb.GetSinfo()
+	ua := t.unitAmount(sinfo.GetSymbol())
+	if ua <= 0 {
+		return
+	}
+
+	// depth里的量是币，转换成合约张数
+	toLevels := func(items []*protocol.PBFutureOBItem) []paperLevel {
+		ret := make([]paperLevel, 0, len(items))
+		for _, v := range items {
+			ret = append(ret, paperLevel{price: v.GetPrice(), amount: v.GetVol() * v.GetPrice() / ua})
+		}
+		return ret
+	}
+
+	t.runDelayed(sinfo.GetTimestamp())
+	q := t.getQuote(sinfo.GetSymbol(), sinfo.GetContractType())
+	q.asks = toLevels(pb.GetAsks())
+	q.bids = toLevels(pb.GetBids())
+	q.ts = sinfo.GetTimestamp()
+	t.ts = q.ts
+	t.matchAll(sinfo.GetSymbol(), sinfo.GetContractType(), q)
+}
+
+/*
+ 逐笔成交，使用逐笔成交撮合挂单时，按成交的张数依次撮合挂单
+*/
+func (t *paperTrade) onTrade(pb *protocol.PBFutureTrade) {
+	sinfo := pb.GetSinfo()
+	t.runDelayed(sinfo.GetTimestamp())
+	if sinfo.GetTimestamp() > t.ts {
+		t.ts = sinfo.GetTimestamp()
+	}
+	if t.costs.Fill == nil || pb.GetPrice() <= 0 {
+		return
+	}
+
+	// okex的逐笔成交是合约张数，没有张数时用币的数量换算
+	left := float32(pb.GetAmount())
+	if left <= 0 {
+		ua := t.unitAmount(sinfo.GetSymbol())
+		if ua <= 0 {
+			return
+		}
+		left = pb.GetVol() * pb.GetPrice() / ua
+	}
+
+	for _, o := range t.orders {
+		if left < 1 {
+			break
+		}
+		if !o.rested || o.symbol != sinfo.GetSymbol() || o.contractType != sinfo.GetContractType() || !isUndoneOrder(o.status) {
+			continue
+		}
+		n := o.amount - o.dealAmount
+		if n > left {
+			n = left
+		}
+		n = float32(t.costs.Fill.Fill(isBuyOrder(o.orderType), float64(o.price), float64(pb.GetPrice()), float64(n)))
+		n = float32(int32(n))
+		if n <= 0 {
+			continue
+		}
+		left -= n
+		t.fillOrder(o, n, o.price, sinfo.GetTimestamp())
+	}
+}
+
+func (t *paperTrade) getQuote(symbol string, contractType string) *paperQuote {
+	key := paperKey(symbol, contractType)
+	q, ok := t.quotes[key]
+	if !ok {
+		q = &paperQuote{}
+		t.quotes[key] = q
+	}
+	return q
+}
+
+func (t *paperTrade) matchAll(symbol string, contractType string, q *paperQuote) {
+	for _, o := range t.orders {
+		if o.symbol == symbol && o.contractType == contractType && isUndoneOrder(o.status) {
+			// 挂单只用逐笔成交撮合
+			if o.rested && t.costs.Fill != nil {
+				continue
+			}
+			t.matchOrder(o, q)
+		}
+	}
+}
+
+/*
+ 撮合一个委托
+ 有depth行情的时候按照档口的量成交，可能部分成交
+ 没有depth行情的时候按照买一卖一价全部成交
+ 吃单的成交价加上滑点，限价单不超过委托价
+*/
+func (t *paperTrade) matchOrder(o *paperOrder, q *paperQuote) {
+	left := o.amount - o.dealAmount
+	if left <= 0 {
+		return
+	}
+
+	var levels []paperLevel
+	buy := isBuyOrder(o.orderType)
+	if buy {
+		levels = q.asks
+		if len(levels) <= 0 && q.ask > 0 {
+			levels = []paperLevel{{price: q.ask, amount: left}}
+		}
+	} else {
+		levels = q.bids
+		if len(levels) <= 0 && q.bid > 0 {
+			levels = []paperLevel{{price: q.bid, amount: left}}
+		}
+	}
+
+	var amount float32 = 0
+	var cost float32 = 0
+	for _, l := range levels {
+		if amount >= left {
+			break
+		}
+		if o.priceSt == protocol.PRICE_ST_LIMIT {
+			if (buy && l.price > o.price) || (!buy && l.price < o.price) {
+				break
+			}
+		}
+		n := l.amount
+		if n > left-amount {
+			n = left - amount
+		}
+		amount += n
+		cost += n * l.price
+	}
+
+	// 合约张数是整数
+	amount = float32(int32(amount))
+	if amount <= 0 {
+		return
+	}
+	price := cost / amount
+	if !o.rested {
+		price = float32(t.costs.Slippage.Apply(paperKey(o.symbol, o.contractType), float64(price), buy))
+		if o.priceSt == protocol.PRICE_ST_LIMIT && ((buy && price > o.price) || (!buy && price < o.price)) {
+			price = o.price
+		}
+	}
+	t.fillOrder(o, amount, price, t.ts)
+}
+
+/*
+ 成交一个委托，平仓的数量超过持仓时按持仓平，剩下的数量撤销
+ 持仓已经没有了的平仓委托直接撤销，委托都会到达最终状态，不会一直挂着
+*/
+func (t *paperTrade) fillOrder(o *paperOrder, amount float32, price float32, ts uint64) {
+	p := t.getPos(o.symbol, o.contractType)
+	ua := t.unitAmount(o.symbol)
+	var profit float32 = 0
+	clamped := false
+
+	switch o.orderType {
+	case protocol.ORDERTYPE_OPENLONG:
+		p.longPriceAvg = openPriceAvg(p.longAmount, p.longPriceAvg, amount, price)
+		p.longAmount += amount
+		bond := amount * ua / price / float32(o.lever)
+		p.longBond += bond
+		t.balances[o.symbol] -= bond
+
+	case protocol.ORDERTYPE_OPENSHORT:
+		p.shortPriceAvg = openPriceAvg(p.shortAmount, p.shortPriceAvg, amount, price)
+		p.shortAmount += amount
+		bond := amount * ua / price / float32(o.lever)
+		p.shortBond += bond
+		t.balances[o.symbol] -= bond
+
+	case protocol.ORDERTYPE_CLOSELONG:
+		if amount > p.longAmount {
+			amount, clamped = p.longAmount, true
+		}
+		if amount <= 0 {
+			t.cancelRest(o)
+			return
+		}
+		profit = (ua/p.longPriceAvg - ua/price) * amount
+		bond := p.longBond * amount / p.longAmount
+		p.longAmount -= amount
+		p.longBond -= bond
+		p.longCloseProfit += profit
+		t.balances[o.symbol] += bond + profit
+		if p.longAmount <= 0 {
+			p.longPriceAvg = 0
+		}
+
+	case protocol.ORDERTYPE_CLOSESHORT:
+		if amount > p.shortAmount {
+			amount, clamped = p.shortAmount, true
+		}
+		if amount <= 0 {
+			t.cancelRest(o)
+			return
+		}
+		profit = (ua/price - ua/p.shortPriceAvg) * amount
+		bond := p.shortBond * amount / p.shortAmount
+		p.shortAmount -= amount
+		p.shortBond -= bond
+		p.shortCloseProfit += profit
+		t.balances[o.symbol] += bond + profit
+		if p.shortAmount <= 0 {
+			p.shortPriceAvg = 0
+		}
+	}
+	p.lever = o.lever
+
+	// 手续费按成交的价值收取，币
+	fee := amount * ua / price * float32(t.costs.Fee.Rate(o.rested))
+	t.balances[o.symbol] -= fee
+	o.fee += fee
+
+	o.priceAvg = (o.priceAvg*o.dealAmount + price*amount) / (o.dealAmount + amount)
+	o.dealAmount += amount
+	if o.dealAmount >= o.amount {
+		o.status = protocol.ORDERSTATUS_COMPLETE
+	} else if clamped {
+		o.status = protocol.ORDERSTATUS_CANCELED
+	} else {
+		o.status = protocol.ORDERSTATUS_PARTDONE
+	}
+	logs.Info("模拟成交，订单号[%s], 商品[%s_%s], 订单类型[%s], 成交张数[%f], 成交价[%f], 手续费[%f], 时间[%s]",
+		o.orderId, o.symbol, o.contractType, utils.OrderTypeStr(o.orderType), amount, price, fee, paperTimeStr(ts))
+
+	if t.kr.isBacktest() {
+		t.kr.recorder.AddTrade(backtest.Trade{
+			Ts:           ts,
+			Exchange:     t.exchange,
+			Symbol:       o.symbol,
+			ContractType: o.contractType,
+			OrderId:      o.orderId,
+			OrderType:    o.orderType,
+			Amount:       amount,
+			Price:        price,
+			Fee:          fee,
+			Profit:       profit,
+			ProfitUsd:    float64(profit) * float64(price),
+		})
+	}
+
+	// 主动推送成交后的订单、头寸和资金
+	t.replyOrders(0, []*paperOrder{o})
+	t.replyPos(0, o.symbol, o.contractType)
+	t.replyMoney(0)
+}
+
+// 平仓委托没有持仓可平，撤销剩下的数量
+func (t *paperTrade) cancelRest(o *paperOrder) {
+	o.status = protocol.ORDERSTATUS_CANCELED
+	logs.Info("模拟撤单，订单号[%s], 商品[%s_%s], 没有持仓可平，撤销剩下的[%f]张", o.orderId, o.symbol, o.contractType, o.amount-o.dealAmount)
+	t.replyOrders(0, []*paperOrder{o})
+}
+
+// 反向合约的开仓均价是按照张数对价格倒数加权
+func openPriceAvg(oldAmount float32, oldAvg float32, amount float32, price float32) float32 {
+	if oldAmount <= 0 || oldAvg <= 0 {
+		return price
+	}
+	return (oldAmount + amount) / (oldAmount/oldAvg + amount/price)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 检查委托是否合法，返回错误信息
+func (t *paperTrade) checkOrder(cmd SetOrderCmd) string {
+	ua := t.unitAmount(cmd.Symbol)
+	if ua <= 0 {
+		return "币种不存在"
+	}
+	if cmd.Amount <= 0 || cmd.Level <= 0 {
+		return "参数错误"
+	}
+	if cmd.PriceSt == protocol.PRICE_ST_LIMIT && cmd.Price <= 0 {
+		return "下单价格不得≤0或≥1000000"
+	}
+
+	p := t.getPos(cmd.Symbol, cmd.ContractType)
+	if p.lever > 0 && p.lever != cmd.Level && (p.longAmount > 0 || p.shortAmount > 0) {
+		return "杠杆比率错误"
+	}
+
+	amount := float32(cmd.Amount)
+	switch cmd.OrderType {
+	case protocol.ORDERTYPE_OPENLONG, protocol.ORDERTYPE_OPENSHORT:
+		price := cmd.Price
+		if price <= 0 {
+			price = t.getQuote(cmd.Symbol, cmd.ContractType).last
+		}
+		if price <= 0 {
+			return "暂无对手价"
+		}
+		// 未成交的开仓委托已经占用了保证金
+		if amount*ua/price/float32(cmd.Level) > t.balances[cmd.Symbol]-t.frozenMargin(cmd.Symbol) {
+			return "余额不足"
+		}
+
+	case protocol.ORDERTYPE_CLOSELONG:
+		if amount > p.longAmount-t.frozenAmount(cmd.Symbol, cmd.ContractType, cmd.OrderType) {
+			return "平仓数量是否大于同方向可用持仓数量"
+		}
+
+	case protocol.ORDERTYPE_CLOSESHORT:
+		if amount > p.shortAmount-t.frozenAmount(cmd.Symbol, cmd.ContractType, cmd.OrderType) {
+			return "平仓数量是否大于同方向可用持仓数量"
+		}
+
+	default:
+		return "没有订单类型"
+	}
+	return ""
+}
+
+func (t *paperTrade) newOrder(cmd SetOrderCmd) *paperOrder {
+	t.orderSeed += 1
+	o := &paperOrder{
+		symbol:       cmd.Symbol,
+		contractType: cmd.ContractType,
+		orderId:      fmt.Sprintf("%d", t.orderSeed),
+		amount:       float32(cmd.Amount),
+		dealAmount:   0,
+		price:        cmd.Price,
+		priceAvg:     0,
+		priceSt:      cmd.PriceSt,
+		orderType:    cmd.OrderType,
+		lever:        cmd.Level,
+		fee:          0,
+		status:       protocol.ORDERSTATUS_WAITTING,
+		ts:           t.getQuote(cmd.Symbol, cmd.ContractType).ts,
+	}
+	t.orders = append(t.orders, o)
+	return o
+}
+
+func (t *paperTrade) findOrder(orderId string) *paperOrder {
+	for _, o := range t.orders {
+		if o.orderId == orderId {
+			return o
+		}
+	}
+	return nil
+}
+
+// 未成交的平仓委托冻结的张数
+func (t *paperTrade) frozenAmount(symbol string, contractType string, orderType int32) float32 {
+	var ret float32 = 0
+	for _, o := range t.orders {
+		if o.symbol == symbol && o.contractType == contractType && o.orderType == orderType && isUndoneOrder(o.status) {
+			ret += o.amount - o.dealAmount
+		}
+	}
+	return ret
+}
+
+/*
+ 未成交的开仓委托冻结的保证金，币
+ 限价单按委托价计算，市价单按最新价计算，成交和撤单后自然释放
+*/
+func (t *paperTrade) frozenMargin(symbol string) float32 {
+	ua := t.unitAmount(symbol)
+	var ret float32 = 0
+	for _, o := range t.orders {
+		if o.symbol != symbol || !isUndoneOrder(o.status) || o.lever <= 0 {
+			continue
+		}
+		if o.orderType != protocol.ORDERTYPE_OPENLONG && o.orderType != protocol.ORDERTYPE_OPENSHORT {
+			continue
+		}
+		price := o.price
+		if o.priceSt != protocol.PRICE_ST_LIMIT || price <= 0 {
+			price = t.getQuote(o.symbol, o.contractType).last
+		}
+		if price <= 0 {
+			continue
+		}
+		ret += (o.amount - o.dealAmount) * ua / price / float32(o.lever)
+	}
+	return ret
+}
+
+func (t *paperTrade) getPos(symbol string, contractType string) *paperPos {
+	key := paperKey(symbol, contractType)
+	p, ok := t.pos[key]
+	if !ok {
+		p = &paperPos{
+			symbol:       symbol,
+			contractType: contractType,
+		}
+		t.pos[key] = p
+	}
+	return p
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func paperTimeStr(ts uint64) string {
+	return time.Unix(int64(ts/1000), 0).Format(protocol.TM_LAYOUT_STR)
+}
+
+func (t *paperTrade) replyOrders(reqSerial uint32, orders []*paperOrder) {
+	pb := &protocol.PBFRspQryOrders{}
+	pb.Rsp = makeRspInfo(protocol.ErrId_OK, "")
+	for _, o := range orders {
+		subp := &protocol.PBFOrderInfo{}
+		subp.Amount = proto.Float32(o.amount)
+		subp.ContractName = []byte(o.symbol + "_" + o.contractType)
+		subp.ContractDate = []byte(paperTimeStr(o.ts))
+		subp.DealAmount = proto.Float32(o.dealAmount)
+		subp.Fee = proto.Float32(o.fee)
+		subp.OrderId = []byte(o.orderId)
+		subp.Price = proto.Float32(o.price)
+		subp.PriceAvg = proto.Float32(o.priceAvg)
+		subp.Status = proto.Int32(o.status)
+		subp.Symbol = []byte(o.symbol)
+		subp.Type = proto.Int32(o.orderType)
+		subp.UnitAmount = proto.Float32(t.unitAmount(o.symbol))
+		subp.LeverRate = proto.Int32(o.lever)
+		subp.ContractType = []byte(o.contractType)
+		pb.Orders = append(pb.Orders, subp)
+	}
+	t.kr.postReply(t.exchange, protocol.FID_RspQryOrders, reqSerial, pb)
+}
+
+func (t *paperTrade) replyPos(reqSerial uint32, symbol string, contractType string) {
+	pb := &protocol.PBFRspQryPosInfo{}
+	pb.Rsp = makeRspInfo(protocol.ErrId_OK, "")
+	pb.Exchange = []byte(t.exchange)
+	pb.Symbol = []byte(symbol)
+	pb.ContractType = []byte(contractType)
+
+	p, ok := t.pos[paperKey(symbol, contractType)]
+	if ok {
+		ua := t.unitAmount(symbol)
+		subp := &protocol.PBFContractPosInfo{}
+		subp.BuyAmount = proto.Float32(p.longAmount)
+		subp.BuyAvailable = proto.Float32(p.longAmount - t.frozenAmount(symbol, contractType, protocol.ORDERTYPE_CLOSELONG))
+		subp.BuyBond = proto.Float32(p.longBond)
+		subp.BuyPriceAvg = proto.Float32(p.longPriceAvg)
+		subp.BuyPriceCost = proto.Float32(p.longPriceAvg)
+		subp.BuyProfitReal = proto.Float32(p.longCloseProfit)
+		if p.longAmount > 0 {
+			// 亏损等于保证金时的价格
+			subp.BuyFlatprice = proto.Float32(ua / (ua/p.longPriceAvg + p.longBond/p.longAmount))
+		}
+
+		subp.SellAmount = proto.Float32(p.shortAmount)
+		subp.SellAvailable = proto.Float32(p.shortAmount - t.frozenAmount(symbol, contractType, protocol.ORDERTYPE_CLOSESHORT))
+		subp.SellBond = proto.Float32(p.shortBond)
+		subp.SellPriceAvg = proto.Float32(p.shortPriceAvg)
+		subp.SellPriceCost = proto.Float32(p.shortPriceAvg)
+		subp.SellProfitReal = proto.Float32(p.shortCloseProfit)
+		if p.shortAmount > 0 {
+			d := ua/p.shortPriceAvg - p.shortBond/p.shortAmount
+			if d > 0 {
+				subp.SellFlatprice = proto.Float32(ua / d)
+			}
+		}
+
+		subp.ContractType = []byte(contractType)
+		subp.Symbol = []byte(symbol)
+		subp.LeverRate = proto.Int32(p.lever)
+		pb.PosInfos = append(pb.PosInfos, subp)
+	}
+	t.kr.postReply(t.exchange, protocol.FID_RspQryPosInfo, reqSerial, pb)
+}
+
+// 权益 = 余额 + 保证金 + 浮动盈亏
+func (t *paperTrade) rights(symbol string) float32 {
+	rights := t.balances[symbol]
+	ua := t.unitAmount(symbol)
+	for _, p := range t.pos {
+		if p.symbol != symbol {
+			continue
+		}
+		rights += p.longBond + p.shortBond
+		q, ok := t.quotes[paperKey(p.symbol, p.contractType)]
+		if !ok || q.last <= 0 {
+			continue
+		}
+		if p.longAmount > 0 {
+			rights += (ua/p.longPriceAvg - ua/q.last) * p.longAmount
+		}
+		if p.shortAmount > 0 {
+			rights += (ua/q.last - ua/p.shortPriceAvg) * p.shortAmount
+		}
+	}
+	return rights
+}
+
+func (t *paperTrade) replyMoney(reqSerial uint32) {
+	pb := &protocol.PBFRspQryMoneyInfo{}
+	pb.Rsp = makeRspInfo(protocol.ErrId_OK, "")
+
+	for _, s := range t.Symbols() {
+		balance, ok := t.balances[s]
+		if !ok {
+			continue
+		}
+		subp := &protocol.PBFMoneyInfo{}
+		subp.Symbol = []byte(s)
+		subp.Balance = proto.Float32(balance - t.frozenMargin(s))
+		subp.Rights = proto.Float32(t.rights(s))
+		pb.MoneyInfos = append(pb.MoneyInfos, subp)
+	}
+	t.kr.postReply(t.exchange, protocol.FID_RspQryMoneyInfo, reqSerial, pb)
+}
+
+// 盈亏 = (权益 - 初始余额) * 最新价，美元
+func (t *paperTrade) pnl() float64 {
+	var ret float64 = 0
+	for s, price := range t.prices {
+		ret += float64(t.rights(s)-t.initials[s]) * float64(price)
+	}
+	return ret
+}
+
+func (t *paperTrade) lastTs() uint64 {
+	return t.ts
+}
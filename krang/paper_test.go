@@ -0,0 +1,212 @@
+package krang
+
+import (
+	"math"
+	"testing"
+
+	"chive/backtest"
+	"chive/config"
+	"chive/protocol"
+)
+
+// 没有成本的模拟交易，okex的ltc_usd合约面值10美元
+func newTestPaper(t *testing.T, balance float32) *paperTrade {
+	cnf := &config.AppCnf{}
+	cnf.Paper.Balance = balance
+	kr := newKrang(cnf)
+	kr.useVirtualClock()
+	kr.keeper = NewKeeper(kr)
+	costs, err := backtest.NewCosts(config.CostCnf{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewPaperTrade(kr, "okex", NewOkexTrade(kr), costs).(*paperTrade)
+}
+
+func testOrder(pt *paperTrade, orderType int32, priceSt int32, price float32, amount float32) *paperOrder {
+	return pt.newOrder(SetOrderCmd{
+		Symbol:       "ltc_usd",
+		ContractType: "this_week",
+		Amount:       int32(amount),
+		Price:        price,
+		PriceSt:      priceSt,
+		OrderType:    orderType,
+		Level:        10,
+	})
+}
+
+func near(a float32, b float64) bool {
+	return math.Abs(float64(a)-b) < 1e-5
+}
+
+func TestPaperLatency(t *testing.T) {
+	if backtest.MAX_LATENCY > track_timeout {
+		t.Fatalf("paper latency limit %d exceeds track timeout %d", backtest.MAX_LATENCY, track_timeout)
+	}
+}
+
+func TestPaperMatchOrder(t *testing.T) {
+	book := &paperQuote{
+		bid:  99,
+		ask:  100,
+		asks: []paperLevel{{100, 2}, {101, 2}, {102, 10}},
+		bids: []paperLevel{{99, 3}, {98, 1}, {97, 10}},
+	}
+	top := &paperQuote{bid: 99, ask: 100}
+
+	cases := []struct {
+		name      string
+		q         *paperQuote
+		orderType int32
+		priceSt   int32
+		price     float32
+		amount    float32
+		deal      float32
+		avg       float64
+		status    int32
+	}{
+		{"市价买吃三档", book, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 5, 5, (200 + 202 + 102) / 5.0, protocol.ORDERSTATUS_COMPLETE},
+		{"限价买部分成交", book, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_LIMIT, 101, 5, 4, (200 + 202) / 4.0, protocol.ORDERSTATUS_PARTDONE},
+		{"限价买没有成交", book, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_LIMIT, 99, 5, 0, 0, protocol.ORDERSTATUS_WAITTING},
+		{"市价卖吃两档", book, protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_MARKET, 0, 4, 4, (297 + 98) / 4.0, protocol.ORDERSTATUS_COMPLETE},
+		{"没有深度按卖一成交", top, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 7, 7, 100, protocol.ORDERSTATUS_COMPLETE},
+		{"没有深度按买一成交", top, protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_LIMIT, 98, 3, 3, 99, protocol.ORDERSTATUS_COMPLETE},
+	}
+	for _, c := range cases {
+		pt := newTestPaper(t, 10)
+		o := testOrder(pt, c.orderType, c.priceSt, c.price, c.amount)
+		pt.matchOrder(o, c.q)
+		if o.dealAmount != c.deal || !near(o.priceAvg, c.avg) || o.status != c.status {
+			t.Fatalf("%s: deal[%f] avg[%f] status[%d], want deal[%f] avg[%f] status[%d]",
+				c.name, o.dealAmount, o.priceAvg, o.status, c.deal, c.avg, c.status)
+		}
+
+		// 反向合约的保证金 = 张数 * 面值 / 价格 / 杠杆
+		p := pt.getPos("ltc_usd", "this_week")
+		bond := p.longBond + p.shortBond
+		if c.deal > 0 && !near(bond, float64(c.deal)*10/c.avg/10) {
+			t.Fatalf("%s: bond[%f] error", c.name, bond)
+		}
+		if !near(pt.balances["ltc_usd"], 10-float64(bond)) {
+			t.Fatalf("%s: balance[%f] error", c.name, pt.balances["ltc_usd"])
+		}
+	}
+}
+
+func TestPaperFillOrder(t *testing.T) {
+	pt := newTestPaper(t, 10)
+
+	// 开多3张@100，再开1张@50，均价按价格倒数加权
+	pt.fillOrder(testOrder(pt, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 3), 3, 100, 0)
+	pt.fillOrder(testOrder(pt, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 1), 1, 50, 0)
+	p := pt.getPos("ltc_usd", "this_week")
+	if p.longAmount != 4 || !near(p.longPriceAvg, 4/(3/100.0+1/50.0)) || !near(p.longBond, 0.05) {
+		t.Fatalf("long pos error, %+v", p)
+	}
+
+	// 平仓数量超过持仓时按持仓平，剩下的撤销
+	o := testOrder(pt, protocol.ORDERTYPE_CLOSELONG, protocol.PRICE_ST_MARKET, 0, 6)
+	pt.fillOrder(o, 6, 100, 0)
+	profit := (10/(4/(3/100.0+1/50.0)) - 10/100.0) * 4
+	if p.longAmount != 0 || p.longBond != 0 || p.longPriceAvg != 0 || !near(p.longCloseProfit, profit) {
+		t.Fatalf("close long error, %+v", p)
+	}
+	if o.dealAmount != 4 || o.status != protocol.ORDERSTATUS_CANCELED {
+		t.Fatalf("close order error, deal[%f] status[%d]", o.dealAmount, o.status)
+	}
+	if !near(pt.balances["ltc_usd"], 10+profit) {
+		t.Fatalf("balance after close long error, %f", pt.balances["ltc_usd"])
+	}
+
+	// 空头价格下跌盈利，盈利是币
+	pt.fillOrder(testOrder(pt, protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_MARKET, 0, 2), 2, 100, 0)
+	pt.fillOrder(testOrder(pt, protocol.ORDERTYPE_CLOSESHORT, protocol.PRICE_ST_MARKET, 0, 2), 2, 80, 0)
+	if p.shortAmount != 0 || !near(p.shortCloseProfit, (10/80.0-10/100.0)*2) {
+		t.Fatalf("close short error, %+v", p)
+	}
+
+	// 没有持仓时平仓不成交，直接撤销
+	o = testOrder(pt, protocol.ORDERTYPE_CLOSESHORT, protocol.PRICE_ST_MARKET, 0, 1)
+	pt.fillOrder(o, 1, 80, 0)
+	if o.dealAmount != 0 || o.status != protocol.ORDERSTATUS_CANCELED {
+		t.Fatalf("close without pos should be canceled, deal[%f] status[%d]", o.dealAmount, o.status)
+	}
+}
+
+func TestPaperCheckOrder(t *testing.T) {
+	pt := newTestPaper(t, 0.1)
+	pt.getQuote("ltc_usd", "this_week").last = 100
+
+	cmd := func(orderType int32, priceSt int32, price float32, amount int32, level int32) SetOrderCmd {
+		return SetOrderCmd{
+			Symbol:       "ltc_usd",
+			ContractType: "this_week",
+			Amount:       amount,
+			Price:        price,
+			PriceSt:      priceSt,
+			OrderType:    orderType,
+			Level:        level,
+		}
+	}
+
+	cases := []struct {
+		name string
+		cmd  SetOrderCmd
+		err  string
+	}{
+		{"币种不存在", SetOrderCmd{Symbol: "xrp_usd", Amount: 1, Level: 10}, "币种不存在"},
+		{"张数为0", cmd(protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 0, 10), "参数错误"},
+		{"限价单没有价格", cmd(protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_LIMIT, 0, 1, 10), "下单价格不得≤0或≥1000000"},
+		{"没有订单类型", cmd(99, protocol.PRICE_ST_MARKET, 0, 1, 10), "没有订单类型"},
+		// 余额0.1，100美元时每张保证金0.01
+		{"余额刚好够", cmd(protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 10, 10), ""},
+		{"余额不足", cmd(protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_LIMIT, 100, 11, 10), "余额不足"},
+		{"没有持仓平仓", cmd(protocol.ORDERTYPE_CLOSELONG, protocol.PRICE_ST_MARKET, 0, 1, 10), "平仓数量是否大于同方向可用持仓数量"},
+	}
+	for _, c := range cases {
+		if err := pt.checkOrder(c.cmd); err != c.err {
+			t.Fatalf("%s: got [%s], want [%s]", c.name, err, c.err)
+		}
+	}
+
+	// 没有最新价的合约市价开仓
+	if err := pt.checkOrder(SetOrderCmd{Symbol: "ltc_usd", ContractType: "quarter", Amount: 1, Level: 10,
+		OrderType: protocol.ORDERTYPE_OPENLONG}); err != "暂无对手价" {
+		t.Fatalf("open without price: %s", err)
+	}
+
+	// 挂着的开仓委托占用保证金，撤单后释放
+	pt.arriveOrder(cmd(protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_LIMIT, 100, 8, 10), 1)
+	if len(pt.orders) != 1 || pt.orders[0].status != protocol.ORDERSTATUS_WAITTING {
+		t.Fatal("limit order should rest")
+	}
+	if !near(pt.frozenMargin("ltc_usd"), 0.08) {
+		t.Fatalf("frozen margin error, %f", pt.frozenMargin("ltc_usd"))
+	}
+	if err := pt.checkOrder(cmd(protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_LIMIT, 100, 3, 10)); err != "余额不足" {
+		t.Fatalf("open over reserved margin: [%s]", err)
+	}
+	if err := pt.checkOrder(cmd(protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_LIMIT, 100, 2, 10)); err != "" {
+		t.Fatalf("open within reserved margin: [%s]", err)
+	}
+	pt.arriveCancel(SetOrderCmd{Symbol: "ltc_usd", ContractType: "this_week", OrderIDs: pt.orders[0].orderId}, 2)
+	if pt.orders[0].status != protocol.ORDERSTATUS_CANCELED || pt.frozenMargin("ltc_usd") != 0 {
+		t.Fatal("cancel should release margin")
+	}
+	if err := pt.checkOrder(cmd(protocol.ORDERTYPE_OPENSHORT, protocol.PRICE_ST_LIMIT, 100, 10, 10)); err != "" {
+		t.Fatalf("open after cancel: [%s]", err)
+	}
+
+	// 持仓5张，挂着3张平仓单，只能再平2张；杠杆和持仓不一样时拒绝
+	pt.fillOrder(testOrder(pt, protocol.ORDERTYPE_OPENLONG, protocol.PRICE_ST_MARKET, 0, 5), 5, 100, 0)
+	pt.arriveOrder(cmd(protocol.ORDERTYPE_CLOSELONG, protocol.PRICE_ST_LIMIT, 200, 3, 10), 3)
+	if err := pt.checkOrder(cmd(protocol.ORDERTYPE_CLOSELONG, protocol.PRICE_ST_MARKET, 0, 3, 10)); err != "平仓数量是否大于同方向可用持仓数量" {
+		t.Fatalf("close over available: [%s]", err)
+	}
+	if err := pt.checkOrder(cmd(protocol.ORDERTYPE_CLOSELONG, protocol.PRICE_ST_MARKET, 0, 2, 10)); err != "" {
+		t.Fatalf("close within available: [%s]", err)
+	}
+	if err := pt.checkOrder(cmd(protocol.ORDERTYPE_CLOSELONG, protocol.PRICE_ST_MARKET, 0, 1, 20)); err != "杠杆比率错误" {
+		t.Fatalf("lever mismatch: [%s]", err)
+	}
+}
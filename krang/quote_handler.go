@@ -8,10 +8,11 @@ import (
 )
 
 type quoteHandler struct {
+	kr *krang
 }
 
-func NewQuoteHandler() Handler {
-	return &quoteHandler{}
+func NewQuoteHandler(kr *krang) Handler {
+	return &quoteHandler{kr: kr}
 }
 
 /*
@@ -23,23 +24,23 @@ func (t *quoteHandler) HandleMessage(p protocol.Package, key string) bool {
 	switch tid {
 	// 行情--分笔
 	case protocol.FID_QUOTE_TICK:
-		return quoteTick(p, key)
+		return t.kr.quoteTick(p, key)
 
 		// 行情--k线
 	case protocol.FID_QUOTE_KLine:
-		return quoteKLine(p, key)
+		return t.kr.quoteKLine(p, key)
 
 		// 行情--档口
 	case protocol.FID_QUOTE_Depth:
-		return quoteDepth(p, key)
+		return t.kr.quoteDepth(p, key)
 
 		// 行情--报价
 	case protocol.FID_QUOTE_Trade:
-		return quoteTrade(p, key)
+		return t.kr.quoteTrade(p, key)
 
 		// 行情--指数
 	case protocol.FID_QUOTE_Index:
-		return quoteIndex(p, key)
+		return t.kr.quoteIndex(p, key)
 
 	}
 
@@ -49,7 +50,7 @@ func (t *quoteHandler) HandleMessage(p protocol.Package, key string) bool {
 /*
   tick消息要放给后面的Handler处理,除非无法解包等错误
 */
-func quoteTick(p protocol.Package, key string) bool {
+func (kr *krang) quoteTick(p protocol.Package, key string) bool {
 	pb := &protocol.PBFutureTick{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -60,29 +61,33 @@ func quoteTick(p protocol.Package, key string) bool {
 	return false
 }
 
-func quoteKLine(p protocol.Package, key string) bool {
+func (kr *krang) quoteKLine(p protocol.Package, key string) bool {
 	pb := &protocol.PBFutureKLine{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
 		logs.Error("pb unmarshal fail, tid:%d", p.GetTid())
 		return true
 	}
+	kr.advanceClock(pb.GetSinfo())
 	kr.quotedb.StoreKLine(pb)
+	kr.notifyKLine(pb)
 	return true
 }
 
-func quoteDepth(p protocol.Package, key string) bool {
+func (kr *krang) quoteDepth(p protocol.Package, key string) bool {
 	pb := &protocol.PBFutureDepth{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
 		logs.Error("pb unmarshal fail, tid:%d", p.GetTid())
 		return true
 	}
+	kr.advanceClock(pb.GetSinfo())
 	kr.quotedb.StoreDepth(pb)
+	kr.notifyDepth(pb)
 	return true
 }
 
-func quoteTrade(p protocol.Package, key string) bool {
+func (kr *krang) quoteTrade(p protocol.Package, key string) bool {
 	pb := &protocol.PBFutureTrade{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -90,11 +95,13 @@ func quoteTrade(p protocol.Package, key string) bool {
 		return true
 	}
 
+	kr.advanceClock(pb.GetSinfo())
 	kr.quotedb.StoreTrade(pb)
+	kr.notifyTrade(pb)
 	return true
 }
 
-func quoteIndex(p protocol.Package, key string) bool {
+func (kr *krang) quoteIndex(p protocol.Package, key string) bool {
 	pb := &protocol.PBFutureIndex{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -102,6 +109,7 @@ func quoteIndex(p protocol.Package, key string) bool {
 		return true
 	}
 
+	kr.advanceClock(pb.GetSinfo())
 	kr.quotedb.StoreIndex(pb)
 	return true
 }
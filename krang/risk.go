@@ -0,0 +1,244 @@
+package krang
+
+import (
+	"fmt"
+	"time"
+
+	"chive/config"
+	"chive/logs"
+	"chive/protocol"
+	"chive/utils"
+)
+
+/*
+ 下单前的风控检查，策略通过Context拿到的trader下单都要经过风控
+
+ 1. 限制按品种(exchange_symbol)和策略两个维度配置，两个维度的限制都要满足
+    品种没有单独配置时使用默认限制，策略没有配置时不做策略维度的限制
+ 2. 杠杆和价格偏离对所有委托检查，价格偏离只检查限价单
+ 3. 单笔张数、名义价值、下单频率、每日亏损只对开仓检查，不能阻止策略减仓
+ 4. 每日已实现亏损根据头寸的已平仓盈亏变化计算，变化算到最近一次平仓的策略上
+    盈亏是币，不同品种不能相加，策略维度的亏损也按品种分开计算和限制
+ 5. 时间使用nowMs，回放时和行情保持一致，每日亏损按配置的交易日清零，不是本地的0点
+ 6. 被拒绝的委托不会发给交易所，通过RiskRejecter接口通知策略，同时在Tracker里记录为被拒绝状态
+*/
+
+// 可选接口，策略实现这个接口后，委托被风控拒绝时会被回调
+type RiskRejecter interface {
+	OnRiskReject(ctx Context, cmd SetOrderCmd, reason string)
+}
+
+const risk_rate_window = 60 * 1000
+
+type riskManager struct {
+	lasts        map[string]float32  // key: sinfo, 最新价
+	symOrders    map[string][]uint64 // key: exchange_symbol, 最近一分钟的下单时间
+	stOrders     map[string][]uint64 // key: 策略名称, 最近一分钟的下单时间
+	closeProfits map[string]float32  // key: sinfo, 头寸的已平仓盈亏
+	closers      map[string]string   // key: sinfo, 最近一次平仓的策略
+	symLoss      map[string]float32  // key: exchange_symbol, 当日已实现盈亏
+	stLoss       map[string]float32  // key: 策略名称_exchange_symbol, 当日已实现盈亏
+	day          string
+	td           *utils.TradingDay
+	kr           *krang
+}
+
+func newRiskManager(kr *krang) *riskManager {
+	return &riskManager{
+		lasts:        make(map[string]float32),
+		symOrders:    make(map[string][]uint64),
+		stOrders:     make(map[string][]uint64),
+		closeProfits: make(map[string]float32),
+		closers:      make(map[string]string),
+		symLoss:      make(map[string]float32),
+		stLoss:       make(map[string]float32),
+		kr:           kr,
+	}
+}
+
+// 配置的交易日，配置错误时使用本地时区的自然日
+func (r *riskManager) tradingDay() *utils.TradingDay {
+	if r.td != nil {
+		return r.td
+	}
+	td, err := utils.NewTradingDay(r.kr.cnf.TradingDay.Timezone, r.kr.cnf.TradingDay.Boundary)
+	if err != nil {
+		logs.Error("risk trading day config error [%s], use local day", err.Error())
+		td, _ = utils.NewTradingDay("", "")
+	}
+	r.td = td
+	return td
+}
+
+// 跨交易日后清空当日亏损
+func (r *riskManager) checkDay() {
+	now := r.kr.nowMs()
+	day := r.tradingDay().Day(time.Unix(int64(now/1000), int64(now%1000)*int64(time.Millisecond)))
+	if day == r.day {
+		return
+	}
+	r.day = day
+	r.symLoss = make(map[string]float32)
+	r.stLoss = make(map[string]float32)
+}
+
+func (r *riskManager) onTick(exchange string, pb *protocol.PBFutureTick) {
+	si := pb.GetSinfo()
+	sinfo := utils.MakeupSinfo(exchange, si.GetSymbol(), si.GetContractType())
+	r.lasts[sinfo] = pb.GetLast()
+}
+
+/*
+ 头寸更新后计算已平仓盈亏的变化
+ 第一次收到头寸只记录基数，头寸被清空时重新记录基数
+*/
+func (r *riskManager) onPos(pos *Pos) {
+	if pos == nil {
+		return
+	}
+	sinfo := utils.MakeupSinfo(pos.Exchange, pos.Symbol, pos.ContractType)
+	profit := pos.LongCloseProfit + pos.ShortCloseProfit
+	prev, ok := r.closeProfits[sinfo]
+	r.closeProfits[sinfo] = profit
+
+	empty := pos.LongAmount == 0 && pos.ShortAmount == 0 && profit == 0
+	if !ok || empty || profit == prev {
+		return
+	}
+
+	r.checkDay()
+	delta := profit - prev
+	symKey := pos.Exchange + "_" + pos.Symbol
+	r.symLoss[symKey] += delta
+	if st, ok := r.closers[sinfo]; ok {
+		r.stLoss[st+"_"+symKey] += delta
+	}
+}
+
+func (r *riskManager) symbolLimit(exchange string, symbol string) config.RiskLimit {
+	if l, ok := r.kr.cnf.Risk.Symbols[exchange+"_"+symbol]; ok {
+		return l
+	}
+	return r.kr.cnf.Risk.Default
+}
+
+// 返回拒绝的原因，空字符串表示通过
+func (r *riskManager) check(cmd SetOrderCmd, trader ExchangeTrade) string {
+	r.checkDay()
+	if r.kr.isPaused(cmd.Stname) {
+		return "策略已暂停"
+	}
+	symKey := cmd.Exchange + "_" + cmd.Symbol
+	limits := []config.RiskLimit{r.symbolLimit(cmd.Exchange, cmd.Symbol)}
+	if l, ok := r.kr.cnf.Risk.Strategies[cmd.Stname]; ok {
+		limits = append(limits, l)
+	}
+
+	sinfo := utils.MakeupSinfo(cmd.Exchange, cmd.Symbol, cmd.ContractType)
+	isOpen := cmd.OrderType == protocol.ORDERTYPE_OPENLONG || cmd.OrderType == protocol.ORDERTYPE_OPENSHORT
+	for i, l := range limits {
+		tag := "品种[" + symKey + "]"
+		orders := r.symOrders[symKey]
+		loss := r.symLoss[symKey]
+		if i > 0 {
+			tag = "策略[" + cmd.Stname + "]"
+			orders = r.stOrders[cmd.Stname]
+			loss = r.stLoss[cmd.Stname+"_"+symKey]
+		}
+
+		if l.MaxLevel > 0 && cmd.Level > l.MaxLevel {
+			return fmt.Sprintf("%s杠杆[%d]超过限制[%d]", tag, cmd.Level, l.MaxLevel)
+		}
+		if l.PriceBand > 0 && cmd.PriceSt == protocol.PRICE_ST_LIMIT {
+			last, ok := r.lasts[sinfo]
+			if !ok || last <= 0 {
+				return fmt.Sprintf("%s没有最新价，无法检查价格偏离", tag)
+			}
+			band := (cmd.Price - last) / last
+			if band < 0 {
+				band = -band
+			}
+			if band > l.PriceBand {
+				return fmt.Sprintf("%s委托价[%f]偏离最新价[%f]超过限制[%f]", tag, cmd.Price, last, l.PriceBand)
+			}
+		}
+
+		if !isOpen {
+			continue
+		}
+		if l.MaxAmount > 0 && cmd.Amount > l.MaxAmount {
+			return fmt.Sprintf("%s合约张数[%d]超过限制[%d]", tag, cmd.Amount, l.MaxAmount)
+		}
+		notional := float32(cmd.Amount) * trader.unitAmount(cmd.Symbol)
+		if l.MaxNotional > 0 && notional > l.MaxNotional {
+			return fmt.Sprintf("%s名义价值[%f]超过限制[%f]", tag, notional, l.MaxNotional)
+		}
+		if l.MaxOrderRate > 0 && int32(len(r.trimOrders(orders))) >= l.MaxOrderRate {
+			return fmt.Sprintf("%s一分钟内下单次数超过限制[%d]", tag, l.MaxOrderRate)
+		}
+		if l.MaxDailyLoss > 0 && -loss >= l.MaxDailyLoss {
+			return fmt.Sprintf("%s当日已实现亏损[%f]超过限制[%f]", tag, -loss, l.MaxDailyLoss)
+		}
+	}
+	return ""
+}
+
+func (r *riskManager) trimOrders(orders []uint64) []uint64 {
+	now := r.kr.nowMs()
+	i := 0
+	for ; i < len(orders); i++ {
+		if orders[i]+risk_rate_window > now {
+			break
+		}
+	}
+	return orders[i:]
+}
+
+// 记录通过风控的委托
+func (r *riskManager) onOrder(cmd SetOrderCmd) {
+	now := r.kr.nowMs()
+	isOpen := cmd.OrderType == protocol.ORDERTYPE_OPENLONG || cmd.OrderType == protocol.ORDERTYPE_OPENSHORT
+	if isOpen {
+		symKey := cmd.Exchange + "_" + cmd.Symbol
+		r.symOrders[symKey] = append(r.trimOrders(r.symOrders[symKey]), now)
+		r.stOrders[cmd.Stname] = append(r.trimOrders(r.stOrders[cmd.Stname]), now)
+		return
+	}
+	sinfo := utils.MakeupSinfo(cmd.Exchange, cmd.Symbol, cmd.ContractType)
+	r.closers[sinfo] = cmd.Stname
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 给策略使用的trader，下单前先做风控检查
+type riskTrade struct {
+	ExchangeTrade
+	kr *krang
+}
+
+func NewRiskTrade(kr *krang, t ExchangeTrade) ExchangeTrade {
+	return &riskTrade{ExchangeTrade: t, kr: kr}
+}
+
+func (t *riskTrade) SetOrder(cmd SetOrderCmd) {
+	reason := t.kr.risk.check(cmd, t.ExchangeTrade)
+	if reason == "" {
+		t.kr.risk.onOrder(cmd)
+		t.ExchangeTrade.SetOrder(cmd)
+		return
+	}
+
+	logs.Error("风控拒绝[%s]策略的委托, [%s_%s_%s], 合约张数[%d], 订单类型[%s], 原因[%s]",
+		cmd.Stname, cmd.Exchange, cmd.Symbol, cmd.ContractType, cmd.Amount, utils.OrderTypeStr(cmd.OrderType), reason)
+	t.kr.keeper.GetTracker().Reject(cmd, reason)
+
+	st, ok := t.kr.stmgr.m[cmd.Stname]
+	if !ok {
+		return
+	}
+	if rj, ok := st.(RiskRejecter); ok {
+		t.kr.postTask(func() {
+			rj.OnRiskReject(t.kr.ctx, cmd, reason)
+		})
+	}
+}
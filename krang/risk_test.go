@@ -0,0 +1,62 @@
+package krang
+
+import (
+	"testing"
+	"time"
+
+	"chive/config"
+	"chive/protocol"
+)
+
+func TestRiskDailyLoss(t *testing.T) {
+	cnf := &config.AppCnf{}
+	cnf.TradingDay.Timezone = "+08:00"
+	cnf.TradingDay.Boundary = "16:00:00"
+	cnf.Risk.Strategies = map[string]config.RiskLimit{"mavg": {MaxDailyLoss: 1}}
+	kr := newKrang(cnf)
+	vc := kr.useVirtualClock()
+	kr.keeper = NewKeeper(kr)
+	trader := NewOkexTrade(kr)
+	r := kr.risk
+
+	loc := time.FixedZone("UTC+08:00", 8*3600)
+	advance := func(s string) {
+		tm, err := time.ParseInLocation("2006-01-02 15:04:05", s, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vc.Advance(uint64(tm.UnixNano() / int64(time.Millisecond)))
+	}
+	open := func(symbol string) SetOrderCmd {
+		return SetOrderCmd{Stname: "mavg", Exchange: "okex", Symbol: symbol, ContractType: "this_week",
+			Amount: 1, OrderType: protocol.ORDERTYPE_OPENLONG, PriceSt: protocol.PRICE_ST_MARKET, Level: 10}
+	}
+
+	// ltc_usd平仓亏损1.2个币
+	advance("2019-01-02 17:00:00")
+	r.onOrder(SetOrderCmd{Stname: "mavg", Exchange: "okex", Symbol: "ltc_usd", ContractType: "this_week",
+		OrderType: protocol.ORDERTYPE_CLOSELONG})
+	pos := &Pos{Exchange: "okex", Symbol: "ltc_usd", ContractType: "this_week", LongAmount: 2}
+	r.onPos(pos)
+	pos.LongAmount = 1
+	pos.LongCloseProfit = -1.2
+	r.onPos(pos)
+
+	if reason := r.check(open("ltc_usd"), trader); reason == "" {
+		t.Fatal("ltc_usd open should be rejected by daily loss")
+	}
+	// 亏损是币，不影响其它品种
+	if reason := r.check(open("etc_usd"), trader); reason != "" {
+		t.Fatalf("etc_usd open should pass, got [%s]", reason)
+	}
+
+	// 自然日变了还是同一个交易日，不清零，交易日16:00结束后清零
+	advance("2019-01-03 09:00:00")
+	if reason := r.check(open("ltc_usd"), trader); reason == "" {
+		t.Fatal("daily loss should not reset at midnight")
+	}
+	advance("2019-01-03 16:00:01")
+	if reason := r.check(open("ltc_usd"), trader); reason != "" {
+		t.Fatalf("daily loss should reset at trading day boundary, got [%s]", reason)
+	}
+}
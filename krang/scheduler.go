@@ -0,0 +1,196 @@
+package krang
+
+import (
+	"container/heap"
+	"time"
+
+	"chive/utils"
+)
+
+/*
+ 定时任务调度，回调都在krang协程里执行，策略不需要加锁
+
+ 1. 时间使用nowMs，回放时跟着回放消息的时间走，保证回放结果一样
+ 2. 回放时在第一个行情之前添加的任务，等收到第一个行情后才开始计时
+ 3. 周期任务错过多个周期时只执行一次，下一次执行时间按原来的节奏对齐
+ 4. cron表达式使用本地时区
+*/
+
+// 定时任务回调
+type TimerFunc func(ctx Context)
+
+type timerEntry struct {
+	id       int64
+	due      uint64 // 毫秒
+	interval uint64 // 延时或者周期任务的间隔，毫秒
+	repeat   bool   // 是否是周期任务
+	cron     *utils.CronSpec
+	f        TimerFunc
+	index    int
+}
+
+type timerHeap []*timerEntry
+
+func (h timerHeap) Len() int { return len(h) }
+
+// 执行时间一样时，先添加的先执行
+func (h timerHeap) Less(i, j int) bool {
+	if h[i].due == h[j].due {
+		return h[i].id < h[j].id
+	}
+	return h[i].due < h[j].due
+}
+
+func (h timerHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *timerHeap) Push(x interface{}) {
+	e := x.(*timerEntry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *timerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[0 : n-1]
+	return e
+}
+
+type scheduler struct {
+	seed     int64
+	entries  timerHeap
+	pending  []*timerEntry // 还没有确定执行时间的任务
+	running  int64         // 正在执行的任务
+	canceled bool          // 正在执行的任务在回调里被取消了
+	kr       *krang
+}
+
+func newScheduler(kr *krang) *scheduler {
+	return &scheduler{
+		entries: make(timerHeap, 0),
+		pending: make([]*timerEntry, 0),
+		kr:      kr,
+	}
+}
+
+func (s *scheduler) add(e *timerEntry) int64 {
+	s.seed += 1
+	e.id = s.seed
+	if !s.kr.clockStarted() {
+		s.pending = append(s.pending, e)
+		return e.id
+	}
+	s.anchor(e, s.kr.nowMs())
+	return e.id
+}
+
+func (s *scheduler) anchor(e *timerEntry, now uint64) {
+	if e.cron != nil {
+		e.due = nextCron(e.cron, now)
+	} else {
+		e.due = now + e.interval
+	}
+	if e.due > 0 {
+		heap.Push(&s.entries, e)
+	}
+}
+
+func nextCron(spec *utils.CronSpec, now uint64) uint64 {
+	t := time.Unix(int64(now/1000), int64(now%1000)*int64(time.Millisecond))
+	n := spec.Next(t)
+	if n.IsZero() {
+		return 0
+	}
+	return uint64(n.UnixNano() / int64(time.Millisecond))
+}
+
+func (s *scheduler) after(d time.Duration, f TimerFunc) int64 {
+	return s.add(&timerEntry{interval: durationMs(d), f: f})
+}
+
+func (s *scheduler) every(d time.Duration, f TimerFunc) int64 {
+	ms := durationMs(d)
+	if ms == 0 {
+		ms = 1
+	}
+	return s.add(&timerEntry{interval: ms, repeat: true, f: f})
+}
+
+func (s *scheduler) addCron(expr string, f TimerFunc) (int64, error) {
+	spec, err := utils.ParseCron(expr)
+	if err != nil {
+		return 0, err
+	}
+	return s.add(&timerEntry{cron: spec, f: f}), nil
+}
+
+func (s *scheduler) cancel(id int64) {
+	for i, e := range s.pending {
+		if e.id == id {
+			s.pending = append(s.pending[:i], s.pending[i+1:]...)
+			return
+		}
+	}
+	for _, e := range s.entries {
+		if e.id == id {
+			heap.Remove(&s.entries, e.index)
+			return
+		}
+	}
+	// 正在执行的任务，执行完后不再放回
+	if id == s.running {
+		s.canceled = true
+	}
+}
+
+// 执行到期的任务
+func (s *scheduler) run(now uint64) {
+	if !s.kr.clockStarted() {
+		return
+	}
+	if len(s.pending) > 0 {
+		ps := s.pending
+		s.pending = make([]*timerEntry, 0)
+		for _, e := range ps {
+			s.anchor(e, now)
+		}
+	}
+
+	for len(s.entries) > 0 && s.entries[0].due <= now {
+		e := heap.Pop(&s.entries).(*timerEntry)
+		s.running = e.id
+		s.canceled = false
+		e.f(s.kr.ctx)
+		s.running = 0
+
+		if (e.cron == nil && !e.repeat) || s.canceled {
+			continue
+		}
+		if e.cron != nil {
+			e.due = nextCron(e.cron, now)
+			if e.due == 0 {
+				continue
+			}
+		} else {
+			e.due += e.interval
+			if e.due <= now {
+				e.due = now - (now-e.due)%e.interval + e.interval
+			}
+		}
+		heap.Push(&s.entries, e)
+	}
+}
+
+func durationMs(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64(d / time.Millisecond)
+}
@@ -0,0 +1,305 @@
+package krang
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chive/logs"
+	"chive/protocol"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+ krang状态快照，krang重启后用来恢复
+
+ 1. 定时把Keeper的订单、头寸、资金，Tracker里的请求，暂停的策略和各个策略的状态保存到leveldb
+    退出时也会保存一次
+ 2. 策略实现了Snapshotter接口才会保存策略自己的状态，比如FSM的当前状态
+ 3. 启动时先恢复快照，然后向交易所查询资金、头寸和未完成的订单来对账
+    对账的回应都收到或者超时之前，策略不会收到行情和其他回调
+ 4. 回放时不使用快照
+*/
+
+// 可选接口，策略实现这个接口后，状态会被保存到快照里
+type Snapshotter interface {
+	// 返回策略需要保存的状态
+	Snapshot() ([]byte, error)
+
+	// 用快照恢复状态，在策略的Init之后调用
+	Restore(ctx Context, data []byte) error
+}
+
+const (
+	snap_key_keeper   = "keeper"
+	snap_key_tracker  = "tracker"
+	snap_key_reqseed  = "reqseed"
+	snap_key_paused   = "paused"
+	snap_key_strategy = "strategy/"
+)
+
+type keeperSnap struct {
+	Orders []*Order
+	Pos    []*Pos
+	Moneys []*Money
+}
+
+// 对账中，key: exchange_tid，value: 还没有收到的回应个数
+type reconciler struct {
+	pending  map[string]int
+	deadline uint64
+}
+
+func (kr *krang) openSnapshot(bReplay bool) error {
+	path := kr.cnf.Snapshot.Path
+	if bReplay || path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return err
+	}
+	kr.snapdb = db
+	logs.Info("open krang snapshot [%s]", path)
+	return nil
+}
+
+// 恢复Keeper，Tracker和请求序号，在StartKrang里调用
+func (kr *krang) restoreSnapshot() {
+	if kr.snapdb == nil {
+		return
+	}
+
+	k := kr.keeper.(*keeper)
+	ks := &keeperSnap{}
+	if kr.loadSnap(snap_key_keeper, ks) {
+		for _, o := range ks.Orders {
+			k.orders.PushBack(o)
+		}
+		// 头寸需要对账后才有效
+		for _, p := range ks.Pos {
+			p.IsValid = false
+		}
+		k.pos = ks.Pos
+		k.moneys = ks.Moneys
+		logs.Info("从快照恢复订单[%d]个, 头寸[%d]个, 资金[%d]个", len(ks.Orders), len(ks.Pos), len(ks.Moneys))
+	}
+
+	items := []*TrackItem{}
+	if kr.loadSnap(snap_key_tracker, &items) {
+		t := k.GetTracker().(*tracker)
+		now := kr.nowMs()
+		for _, v := range items {
+			// 重启前的请求不会再有回应，到期后按订单号或者状态查询
+			v.ReqSerial = 0
+			v.Retries = 0
+			v.Deadline = now + track_timeout
+			t.items = append(t.items, v)
+		}
+		logs.Info("从快照恢复跟踪中的请求[%d]个", len(items))
+	}
+
+	var seed int64 = 0
+	if kr.loadSnap(snap_key_reqseed, &seed) {
+		kr.reqSeed = seed
+	}
+
+	// 管理接口暂停的策略重启后仍然暂停
+	paused := map[string]bool{}
+	if kr.loadSnap(snap_key_paused, &paused) {
+		kr.paused = paused
+	}
+}
+
+// 恢复策略的状态，在策略的Init之后调用
+func (kr *krang) restoreStrategies() {
+	if kr.snapdb == nil {
+		return
+	}
+	for name, st := range kr.stmgr.m {
+		sn, ok := st.(Snapshotter)
+		if !ok {
+			continue
+		}
+		data, err := kr.snapdb.Get([]byte(snap_key_strategy+name), nil)
+		if err != nil {
+			continue
+		}
+		if err := sn.Restore(kr.ctx, data); err != nil {
+			logs.Error("restore strategy [%s] snapshot error: %s", name, err.Error())
+			continue
+		}
+		logs.Info("策略[%s]从快照恢复", name)
+	}
+}
+
+func (kr *krang) loadSnap(key string, v interface{}) bool {
+	data, err := kr.snapdb.Get([]byte(key), nil)
+	if err != nil {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		logs.Error("snapshot [%s] unmarshal error: %s", key, err.Error())
+		return false
+	}
+	return true
+}
+
+func (kr *krang) saveSnapshot() {
+	if kr.snapdb == nil {
+		return
+	}
+
+	k := kr.keeper.(*keeper)
+	ks := &keeperSnap{
+		Orders: make([]*Order, 0, k.orders.Len()),
+		Pos:    k.pos,
+		Moneys: k.moneys,
+	}
+	for e := k.orders.Front(); e != nil; e = e.Next() {
+		ks.Orders = append(ks.Orders, e.Value.(*Order))
+	}
+
+	batch := new(leveldb.Batch)
+	putSnap(batch, snap_key_keeper, ks)
+	putSnap(batch, snap_key_tracker, k.GetTracker().(*tracker).items)
+	putSnap(batch, snap_key_reqseed, kr.reqSeed)
+	putSnap(batch, snap_key_paused, kr.paused)
+
+	for name, st := range kr.stmgr.m {
+		sn, ok := st.(Snapshotter)
+		if !ok {
+			continue
+		}
+		data, err := sn.Snapshot()
+		if err != nil {
+			logs.Error("strategy [%s] snapshot error: %s", name, err.Error())
+			continue
+		}
+		batch.Put([]byte(snap_key_strategy+name), data)
+	}
+
+	if err := kr.snapdb.Write(batch, nil); err != nil {
+		logs.Error("write krang snapshot error: %s", err.Error())
+	}
+}
+
+func putSnap(batch *leveldb.Batch, key string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logs.Error("snapshot [%s] marshal error: %s", key, err.Error())
+		return
+	}
+	batch.Put([]byte(key), data)
+}
+
+func (kr *krang) closeSnapshot() {
+	if kr.snapdb == nil {
+		return
+	}
+	kr.saveSnapshot()
+	kr.snapdb.Close()
+	kr.snapdb = nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+ 启动时向交易所查询资金、头寸、未完成的订单和快照里的订单
+ 回应按交易所和消息类型计数，不区分是哪一个查询的回应
+*/
+func (kr *krang) startReconcile() {
+	if kr.snapdb == nil {
+		return
+	}
+
+	rc := &reconciler{
+		pending:  make(map[string]int),
+		deadline: kr.nowMs() + uint64(kr.cnf.Snapshot.Timeout)*1000,
+	}
+	for ex, t := range kr.traders {
+		t.QueryAccount()
+		rc.pending[reconcileKey(ex, protocol.FID_RspQryMoneyInfo)] += 1
+
+		for _, s := range t.Symbols() {
+			for _, c := range t.ContractTypes() {
+				t.QueryPos(s, c)
+				t.QueryOrderByStatus(s, c, protocol.ORDERSTATUS_WAITTING)
+				t.QueryOrderByStatus(s, c, protocol.ORDERSTATUS_PARTDONE)
+				rc.pending[reconcileKey(ex, protocol.FID_RspQryPosInfo)] += 1
+				rc.pending[reconcileKey(ex, protocol.FID_RspQryOrders)] += 2
+			}
+		}
+
+		// 快照里的订单可能在krang停止期间已经成交或者撤销
+		for e := kr.keeper.(*keeper).orders.Front(); e != nil; e = e.Next() {
+			o := e.Value.(*Order)
+			if o.Exchange != ex {
+				continue
+			}
+			t.QueryOrder(o.Symbol, o.ContractType, o.OrderId)
+			rc.pending[reconcileKey(ex, protocol.FID_RspQryOrders)] += 1
+		}
+	}
+	kr.recon = rc
+	logs.Info("开始对账，等待交易所回应")
+}
+
+func reconcileKey(exchange string, tid uint32) string {
+	return fmt.Sprintf("%s_%d", exchange, tid)
+}
+
+// 收到对账的回应
+func (kr *krang) onReconcileRsp(exchange string, tid uint32) {
+	if kr.recon == nil {
+		return
+	}
+	key := reconcileKey(exchange, tid)
+	if n, ok := kr.recon.pending[key]; ok {
+		if n <= 1 {
+			delete(kr.recon.pending, key)
+		} else {
+			kr.recon.pending[key] = n - 1
+		}
+	}
+	if len(kr.recon.pending) == 0 {
+		kr.recon = nil
+		logs.Info("对账完成，策略开始运行")
+	}
+}
+
+func (kr *krang) checkReconcile(now uint64) {
+	if kr.recon == nil || now < kr.recon.deadline {
+		return
+	}
+	keys := []string{}
+	for k, n := range kr.recon.pending {
+		keys = append(keys, fmt.Sprintf("%s:%d", k, n))
+	}
+	logs.Error("对账超时，还没有收到的回应[%s]，策略开始运行", strings.Join(keys, ","))
+	kr.recon = nil
+}
+
+// 对账完成后策略才开始运行
+func (kr *krang) strategiesReady() bool {
+	return kr.recon == nil
+}
+
+// 定时保存快照
+func (kr *krang) startSnapshotTimer() {
+	if kr.snapdb == nil {
+		return
+	}
+	d := time.Duration(kr.cnf.Snapshot.Interval) * time.Second
+	kr.sched.every(d, func(ctx Context) {
+		kr.saveSnapshot()
+	})
+	logs.Info("krang每%s保存一次快照", d)
+}
@@ -1,5 +1,12 @@
 package krang
 
+import (
+	"time"
+
+	"chive/config"
+	"chive/logs"
+)
+
 type Strategy interface {
 
 	/*
@@ -19,6 +26,51 @@ type Strategy interface {
 	OnTick(ctx Context, tick *Tick)
 }
 
+/*
+  下面是策略可以选择实现的接口，krang发现策略实现了这些接口就会在krang协程里回调
+  没有实现的策略不受影响
+*/
+
+// 订单信息更新，包括成交和撤单
+type OrderListener interface {
+	OnOrderUpdate(ctx Context, order *Order)
+}
+
+// 头寸信息更新
+type PosListener interface {
+	OnPosUpdate(ctx Context, pos *Pos)
+}
+
+// 新的K线，同一根K线的更新只在第一次推送时通知
+type KLineListener interface {
+	OnKLine(ctx Context, kl *KLine)
+}
+
+// 档口行情
+type DepthListener interface {
+	OnDepth(ctx Context, depth *Depth)
+}
+
+// 逐笔成交行情
+type TradeListener interface {
+	OnTrade(ctx Context, trade *Trade)
+}
+
+// 定时器，每秒回调一次，now是krang的当前时间，毫秒
+type TimerListener interface {
+	OnTimer(ctx Context, now uint64)
+}
+
+// 策略当前的状态名称和进入时间，管理接口查询策略时使用
+type StateReporter interface {
+	CurrentState() (string, time.Time)
+}
+
+// 强制切换策略的状态，管理接口使用
+type StateForcer interface {
+	ForceState(ctx Context, state string) error
+}
+
 type StrategyManager struct {
 	m map[string]Strategy
 }
@@ -39,5 +91,11 @@ func RegisterStrategy(name string, st Strategy) {
 	if _, dup := stmgr.m[name]; dup {
 		panic("Register one Strategy for twice")
 	}
+
+	// 配置里禁用的策略不注册
+	if !config.T.IsStrategyEnabled(name) {
+		logs.Info("策略[%s]在配置里被禁用", name)
+		return
+	}
 	stmgr.m[name] = st
 }
@@ -1,17 +1,21 @@
 package krang
 
 import (
+	"fmt"
+
 	"chive/logs"
 	"chive/protocol"
+	"chive/utils"
 
 	"github.com/golang/protobuf/proto"
 )
 
 type strategyHandler struct {
+	kr *krang
 }
 
-func NewStrategyHandler() Handler {
-	return &strategyHandler{}
+func NewStrategyHandler(kr *krang) Handler {
+	return &strategyHandler{kr: kr}
 }
 
 /*
@@ -19,7 +23,7 @@ func NewStrategyHandler() Handler {
   返回false表示需要给后面的handler处理
 */
 func (t *strategyHandler) HandleMessage(p protocol.Package, key string) bool {
-	if p.GetTid() != protocol.FID_QUOTE_TICK {
+	if p.GetTid() != protocol.FID_QUOTE_TICK || !t.kr.strategiesReady() {
 		return false
 	}
 
@@ -48,12 +52,133 @@ func (t *strategyHandler) HandleMessage(p protocol.Package, key string) bool {
 	}
 
 	// 策略处理
-	for _, v := range kr.stmgr.m {
-		if !v.CheckFeedBack(kr.ctx) {
+	for name, v := range t.kr.stmgr.m {
+		if t.kr.isPaused(name) || !v.CheckFeedBack(t.kr.ctx) {
 			continue
 		}
-		v.OnTick(kr.ctx, tick)
+		v.OnTick(t.kr.ctx, tick)
 	}
 
 	return false
 }
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+  下面的函数由trade和quote handler调用，把事件分发给实现了对应接口的策略
+  启动对账完成前不分发，暂停的策略只收到订单和头寸的更新
+*/
+
+func (kr *krang) notifyOrder(o *Order) {
+	if !kr.strategiesReady() {
+		return
+	}
+	for _, v := range kr.stmgr.m {
+		if l, ok := v.(OrderListener); ok {
+			l.OnOrderUpdate(kr.ctx, o)
+		}
+	}
+}
+
+func (kr *krang) notifyPos(pos *Pos) {
+	if pos == nil || !kr.strategiesReady() {
+		return
+	}
+	for _, v := range kr.stmgr.m {
+		if l, ok := v.(PosListener); ok {
+			l.OnPosUpdate(kr.ctx, pos)
+		}
+	}
+}
+
+/*
+ 同一根K线会推送多次，K线的时间比上一次新的时候才是新的K线，只通知这一次
+ 策略没有就绪时也记录K线的时间，就绪后从下一根新的K线开始通知
+*/
+func (kr *krang) notifyKLine(pb *protocol.PBFutureKLine) {
+	sinfo := pb.GetSinfo()
+	key := fmt.Sprintf("%s_%d", utils.MakeupSinfo(sinfo.GetExchange(), sinfo.GetSymbol(), sinfo.GetContractType()), pb.GetKind())
+	if sinfo.GetTimestamp() <= kr.klineTs[key] {
+		return
+	}
+	kr.klineTs[key] = sinfo.GetTimestamp()
+	if !kr.strategiesReady() {
+		return
+	}
+	kl := &KLine{
+		Exchange:     pb.GetSinfo().GetExchange(),
+		Symbol:       pb.GetSinfo().GetSymbol(),
+		ContractType: pb.GetSinfo().GetContractType(),
+		Timestamp:    pb.GetSinfo().GetTimestamp(),
+		Kind:         pb.GetKind(),
+		Open:         pb.GetOpen(),
+		High:         pb.GetHigh(),
+		Low:          pb.GetLow(),
+		Close:        pb.GetClose(),
+		Vol:          pb.GetVol(),
+		Amount:       pb.GetAmount(),
+	}
+	for name, v := range kr.stmgr.m {
+		if l, ok := v.(KLineListener); ok && !kr.isPaused(name) {
+			l.OnKLine(kr.ctx, kl)
+		}
+	}
+}
+
+func (kr *krang) notifyDepth(pb *protocol.PBFutureDepth) {
+	if !kr.strategiesReady() {
+		return
+	}
+	depth := &Depth{
+		Exchange:     pb.GetSinfo().GetExchange(),
+		Symbol:       pb.GetSinfo().GetSymbol(),
+		ContractType: pb.GetSinfo().GetContractType(),
+		Timestamp:    pb.GetSinfo().GetTimestamp(),
+		Asks:         make([]DepthItem, 0, len(pb.GetAsks())),
+		Bids:         make([]DepthItem, 0, len(pb.GetBids())),
+	}
+	for _, v := range pb.GetAsks() {
+		depth.Asks = append(depth.Asks, DepthItem{Price: v.GetPrice(), Vol: v.GetVol()})
+	}
+	for _, v := range pb.GetBids() {
+		depth.Bids = append(depth.Bids, DepthItem{Price: v.GetPrice(), Vol: v.GetVol()})
+	}
+	for name, v := range kr.stmgr.m {
+		if l, ok := v.(DepthListener); ok && !kr.isPaused(name) {
+			l.OnDepth(kr.ctx, depth)
+		}
+	}
+}
+
+func (kr *krang) notifyTrade(pb *protocol.PBFutureTrade) {
+	if !kr.strategiesReady() {
+		return
+	}
+	trade := &Trade{
+		Exchange:     pb.GetSinfo().GetExchange(),
+		Symbol:       pb.GetSinfo().GetSymbol(),
+		ContractType: pb.GetSinfo().GetContractType(),
+		Timestamp:    pb.GetSinfo().GetTimestamp(),
+		TradeSeq:     pb.GetTradeSeq(),
+		Price:        pb.GetPrice(),
+		Vol:          pb.GetVol(),
+		Amount:       pb.GetAmount(),
+		BsCode:       pb.GetBsCode(),
+	}
+	for name, v := range kr.stmgr.m {
+		if l, ok := v.(TradeListener); ok && !kr.isPaused(name) {
+			l.OnTrade(kr.ctx, trade)
+		}
+	}
+}
+
+func (kr *krang) notifyTimer(now uint64) {
+	if !kr.strategiesReady() {
+		return
+	}
+	for name, v := range kr.stmgr.m {
+		if l, ok := v.(TimerListener); ok && !kr.isPaused(name) {
+			l.OnTimer(kr.ctx, now)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package krang
+
+import (
+	"testing"
+
+	"chive/config"
+	"chive/protocol"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// 记录收到的K线
+type klineTestStrategy struct {
+	trackTestStrategy
+	kls []*KLine
+}
+
+func (s *klineTestStrategy) OnKLine(ctx Context, kl *KLine) {
+	s.kls = append(s.kls, kl)
+}
+
+func TestNotifyKLine(t *testing.T) {
+	kr := newKrang(&config.AppCnf{})
+	st := &klineTestStrategy{}
+	kr.stmgr.m["test"] = st
+
+	push := func(kind int32, ts uint64, close float32) {
+		kr.notifyKLine(&protocol.PBFutureKLine{
+			Kind:  proto.Int32(kind),
+			Close: proto.Float32(close),
+			Sinfo: &protocol.PBQuoteSymbol{
+				Exchange:     proto.String("okex"),
+				Symbol:       proto.String("ltc_usd"),
+				ContractType: proto.String("this_week"),
+				Timestamp:    proto.Uint64(ts),
+			},
+		})
+	}
+
+	// 同一根K线的更新只通知第一次，旧的K线不通知，不同周期分开计算
+	push(protocol.KL1Min, 60000, 1)
+	push(protocol.KL1Min, 60000, 2)
+	push(protocol.KL5Min, 60000, 3)
+	push(protocol.KL1Min, 120000, 4)
+	push(protocol.KL1Min, 120000, 5)
+	push(protocol.KL1Min, 60000, 6)
+
+	want := []float32{1, 3, 4}
+	if len(st.kls) != len(want) {
+		t.Fatalf("got %d klines, want %d", len(st.kls), len(want))
+	}
+	for i, c := range want {
+		if st.kls[i].Close != c {
+			t.Fatalf("kline %d close %f, want %f", i, st.kls[i].Close, c)
+		}
+	}
+}
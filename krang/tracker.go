@@ -0,0 +1,302 @@
+package krang
+
+import (
+	"fmt"
+
+	"chive/logs"
+	"chive/protocol"
+)
+
+/*
+ Tracker --- 跟踪每一个发出去的下单和撤单请求
+
+ 1. 每个请求都有一个截止时间，到期没有回应时自动查询
+    下单没有回应时还不知道订单号，按状态查询未成交的订单和头寸
+    下单有回应后按订单号查询订单
+ 2. 查询重试次数用完还没有任何回应，请求进入超时状态
+ 3. 挂单一直没有成交时，每个截止时间都会查询一次订单，收到订单信息后重新计算重试次数
+ 4. 状态变化时回调策略的OrderStateHandler接口，进入结束状态后不再跟踪
+*/
+
+// 请求状态
+const (
+	ORDER_STATE_SENT      = 0 // 已发送，还没有回应
+	ORDER_STATE_ACKED     = 1 // 交易所已接受
+	ORDER_STATE_PARTDONE  = 2 // 部分成交
+	ORDER_STATE_FILLED    = 3 // 全部成交
+	ORDER_STATE_CANCELLED = 4 // 已撤销
+	ORDER_STATE_REJECTED  = 5 // 被拒绝，包括风控拒绝和交易所拒绝
+	ORDER_STATE_TIMEOUT   = 6 // 超时没有回应
+)
+
+const (
+	track_timeout     = 5 * 1000 // 请求截止时间，毫秒，模拟交易的延迟不能超过它，见backtest.MAX_LATENCY
+	track_max_retries = 3        // 超时后最多查询次数
+)
+
+// 可选接口，策略实现这个接口后，委托状态变化时会被回调
+type OrderStateHandler interface {
+	OnOrderState(ctx Context, item *TrackItem)
+}
+
+type TrackItem struct {
+	Cmd       SetOrderCmd // 原始的下单或者撤单指令
+	Tid       uint32      // FID_ReqSetOrder或者FID_ReqCancelOrders
+	ReqSerial uint32
+	OrderId   string // 下单回应后才有订单号
+	State     int32
+	PrevState int32
+	Reason    string  // 被拒绝或者超时的原因
+	Deal      float32 // 成交张数
+	Deadline  uint64  // 毫秒
+	Retries   int32
+}
+
+type Tracker interface {
+	// 记录一个发出去的请求
+	Add(cmd SetOrderCmd, tid uint32, reqSerial uint32)
+
+	// 请求在发出去之前就被拒绝
+	Reject(cmd SetOrderCmd, reason string)
+
+	// 下单回应
+	OnRspSetOrder(reqSerial uint32, orderId string, errId int32, errMsg string)
+
+	// 撤单回应
+	OnRspCancel(reqSerial uint32, errId int32, errMsg string)
+
+	// 订单信息更新
+	OnOrder(exchange string, orderId string, status int32, deal float32)
+
+	// 检查到期的请求
+	Check(now uint64)
+
+	// 策略还在跟踪中的请求
+	FindByStrategy(stname string) []*TrackItem
+
+	// 策略是否有还没有回应的请求
+	HasPending(stname string) bool
+}
+
+type tracker struct {
+	items []*TrackItem
+	kr    *krang
+}
+
+func NewTracker(kr *krang) Tracker {
+	return &tracker{
+		items: make([]*TrackItem, 0),
+		kr:    kr,
+	}
+}
+
+func StateStr(state int32) string {
+	switch state {
+	case ORDER_STATE_SENT:
+		return "已发送"
+	case ORDER_STATE_ACKED:
+		return "已接受"
+	case ORDER_STATE_PARTDONE:
+		return "部分成交"
+	case ORDER_STATE_FILLED:
+		return "全部成交"
+	case ORDER_STATE_CANCELLED:
+		return "已撤销"
+	case ORDER_STATE_REJECTED:
+		return "被拒绝"
+	case ORDER_STATE_TIMEOUT:
+		return "超时"
+	}
+	return fmt.Sprintf("未知状态%d", state)
+}
+
+func isFinalState(state int32) bool {
+	return state >= ORDER_STATE_FILLED
+}
+
+func (t *tracker) Add(cmd SetOrderCmd, tid uint32, reqSerial uint32) {
+	item := &TrackItem{
+		Cmd:       cmd,
+		Tid:       tid,
+		ReqSerial: reqSerial,
+		State:     ORDER_STATE_SENT,
+		PrevState: ORDER_STATE_SENT,
+		Deadline:  t.kr.nowMs() + track_timeout,
+	}
+	t.items = append(t.items, item)
+}
+
+func (t *tracker) Reject(cmd SetOrderCmd, reason string) {
+	item := &TrackItem{
+		Cmd:   cmd,
+		Tid:   protocol.FID_ReqSetOrder,
+		State: ORDER_STATE_SENT,
+	}
+	t.items = append(t.items, item)
+	t.setState(item, ORDER_STATE_REJECTED, reason)
+}
+
+func (t *tracker) OnRspSetOrder(reqSerial uint32, orderId string, errId int32, errMsg string) {
+	item := t.findBySerial(reqSerial, protocol.FID_ReqSetOrder)
+	if item == nil {
+		return
+	}
+	if errId != protocol.ErrId_OK {
+		t.setState(item, ORDER_STATE_REJECTED, errMsg)
+		return
+	}
+	item.OrderId = orderId
+	item.Retries = 0
+	item.Deadline = t.kr.nowMs() + track_timeout
+	if item.State == ORDER_STATE_SENT {
+		t.setState(item, ORDER_STATE_ACKED, "")
+	}
+}
+
+/*
+ 撤单回应只说明撤单请求被处理了，订单的状态以订单信息为准
+*/
+func (t *tracker) OnRspCancel(reqSerial uint32, errId int32, errMsg string) {
+	item := t.findBySerial(reqSerial, protocol.FID_ReqCancelOrders)
+	if item == nil {
+		return
+	}
+	if errId != protocol.ErrId_OK {
+		t.setState(item, ORDER_STATE_REJECTED, errMsg)
+		return
+	}
+	t.setState(item, ORDER_STATE_ACKED, "")
+	t.remove(item)
+}
+
+func (t *tracker) OnOrder(exchange string, orderId string, status int32, deal float32) {
+	for _, item := range t.items {
+		if item.Tid != protocol.FID_ReqSetOrder || item.OrderId != orderId || item.Cmd.Exchange != exchange {
+			continue
+		}
+		item.Retries = 0
+		item.Deadline = t.kr.nowMs() + track_timeout
+		item.Deal = deal
+
+		switch status {
+		case protocol.ORDERSTATUS_PARTDONE:
+			t.setState(item, ORDER_STATE_PARTDONE, "")
+		case protocol.ORDERSTATUS_COMPLETE:
+			t.setState(item, ORDER_STATE_FILLED, "")
+		case protocol.ORDERSTATUS_CANCELED:
+			t.setState(item, ORDER_STATE_CANCELLED, "")
+		}
+		return
+	}
+}
+
+func (t *tracker) Check(now uint64) {
+	// 超时的请求会从items里删除，遍历副本
+	items := append([]*TrackItem{}, t.items...)
+	for _, item := range items {
+		if isFinalState(item.State) || now < item.Deadline {
+			continue
+		}
+		if item.Retries >= track_max_retries {
+			t.setState(item, ORDER_STATE_TIMEOUT, fmt.Sprintf("查询%d次没有回应", item.Retries))
+			continue
+		}
+		item.Retries += 1
+		item.Deadline = now + track_timeout
+		t.query(item)
+	}
+}
+
+func (t *tracker) query(item *TrackItem) {
+	trader, ok := t.kr.traders[item.Cmd.Exchange]
+	if !ok {
+		return
+	}
+
+	cmd := item.Cmd
+	logs.Info("请求[%d]状态[%s]到期，第%d次查询, [%s_%s_%s], 订单号[%s]", item.ReqSerial, StateStr(item.State),
+		item.Retries, cmd.Exchange, cmd.Symbol, cmd.ContractType, item.OrderId)
+
+	if item.Tid == protocol.FID_ReqCancelOrders {
+		trader.QueryOrder(cmd.Symbol, cmd.ContractType, cmd.OrderIDs)
+		return
+	}
+	if item.OrderId != "" {
+		trader.QueryOrder(cmd.Symbol, cmd.ContractType, item.OrderId)
+		return
+	}
+	trader.QueryOrderByStatus(cmd.Symbol, cmd.ContractType, protocol.ORDERSTATUS_WAITTING)
+	trader.QueryPos(cmd.Symbol, cmd.ContractType)
+}
+
+func (t *tracker) FindByStrategy(stname string) []*TrackItem {
+	ret := []*TrackItem{}
+	for _, v := range t.items {
+		if v.Cmd.Stname == stname {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (t *tracker) HasPending(stname string) bool {
+	for _, v := range t.items {
+		if v.Cmd.Stname == stname && v.State == ORDER_STATE_SENT {
+			return true
+		}
+	}
+	return false
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func (t *tracker) findBySerial(reqSerial uint32, tid uint32) *TrackItem {
+	if reqSerial == 0 {
+		return nil
+	}
+	for _, v := range t.items {
+		if v.ReqSerial == reqSerial && v.Tid == tid {
+			return v
+		}
+	}
+	return nil
+}
+
+func (t *tracker) remove(item *TrackItem) {
+	for i, v := range t.items {
+		if v == item {
+			t.items = append(t.items[:i], t.items[i+1:]...)
+			return
+		}
+	}
+}
+
+// 更新状态并通知策略，进入结束状态后不再跟踪
+func (t *tracker) setState(item *TrackItem, state int32, reason string) {
+	if item.State == state {
+		return
+	}
+	item.PrevState = item.State
+	item.State = state
+	item.Reason = reason
+	if isFinalState(state) {
+		t.remove(item)
+	}
+
+	cmd := item.Cmd
+	if state == ORDER_STATE_REJECTED || state == ORDER_STATE_TIMEOUT {
+		logs.Error("[%s]策略的请求[%d]%s, [%s_%s_%s], 订单号[%s], 原因[%s]", cmd.Stname, item.ReqSerial, StateStr(state),
+			cmd.Exchange, cmd.Symbol, cmd.ContractType, item.OrderId, reason)
+	}
+
+	st, ok := t.kr.stmgr.m[cmd.Stname]
+	if !ok {
+		return
+	}
+	if h, ok := st.(OrderStateHandler); ok {
+		c := *item
+		t.kr.postTask(func() {
+			h.OnOrderState(t.kr.ctx, &c)
+		})
+	}
+}
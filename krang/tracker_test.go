@@ -0,0 +1,135 @@
+package krang
+
+import (
+	"testing"
+	"time"
+
+	"chive/config"
+	"chive/protocol"
+)
+
+// 记录状态变化的策略
+type trackTestStrategy struct {
+	states []int32
+}
+
+func (s *trackTestStrategy) Init(ctx Context)               {}
+func (s *trackTestStrategy) CheckFeedBack(ctx Context) bool { return true }
+func (s *trackTestStrategy) OnTick(ctx Context, tick *Tick) {}
+
+func (s *trackTestStrategy) OnOrderState(ctx Context, item *TrackItem) {
+	s.states = append(s.states, item.State)
+}
+
+func newTestKrang() *krang {
+	kr := newKrang(&config.AppCnf{})
+	kr.useVirtualClock().Advance(1000)
+	kr.keeper = NewKeeper(kr)
+	return kr
+}
+
+func TestTracker(t *testing.T) {
+	kr := newTestKrang()
+	st := &trackTestStrategy{}
+	kr.stmgr.m["test"] = st
+	tr := kr.keeper.GetTracker()
+	cmd := SetOrderCmd{Stname: "test", Exchange: "okex", Symbol: "ltc_usd", ContractType: "this_week"}
+
+	// 下单、回应、部分成交、全部成交
+	tr.Add(cmd, protocol.FID_ReqSetOrder, 1)
+	if !tr.HasPending("test") {
+		t.Fatal("sent order should be pending")
+	}
+	tr.OnRspSetOrder(1, "100", protocol.ErrId_OK, "")
+	tr.OnOrder("okex", "100", protocol.ORDERSTATUS_PARTDONE, 1)
+	tr.OnOrder("okex", "100", protocol.ORDERSTATUS_PARTDONE, 2)
+	tr.OnOrder("okex", "100", protocol.ORDERSTATUS_COMPLETE, 3)
+	if len(tr.FindByStrategy("test")) != 0 {
+		t.Fatal("filled order should not be tracked")
+	}
+
+	// 下单被拒绝
+	tr.Add(cmd, protocol.FID_ReqSetOrder, 2)
+	tr.OnRspSetOrder(2, "", protocol.ErrId_ApiError, "余额不足")
+
+	// 挂单被撤销
+	tr.Add(cmd, protocol.FID_ReqSetOrder, 3)
+	tr.OnRspSetOrder(3, "101", protocol.ErrId_OK, "")
+	tr.OnOrder("okex", "101", protocol.ORDERSTATUS_CANCELED, 0)
+
+	// 没有回应，查询3次后超时
+	tr.Add(cmd, protocol.FID_ReqSetOrder, 4)
+	for i := 1; i <= track_max_retries+1; i++ {
+		tr.Check(kr.nowMs() + uint64(i*track_timeout))
+	}
+	if len(tr.FindByStrategy("test")) != 0 {
+		t.Fatal("timeout order should not be tracked")
+	}
+
+	kr.runTasks()
+	want := []int32{
+		ORDER_STATE_ACKED, ORDER_STATE_PARTDONE, ORDER_STATE_FILLED,
+		ORDER_STATE_REJECTED,
+		ORDER_STATE_ACKED, ORDER_STATE_CANCELLED,
+		ORDER_STATE_TIMEOUT,
+	}
+	if len(st.states) != len(want) {
+		t.Fatalf("states %v, want %v", st.states, want)
+	}
+	for i := range want {
+		if st.states[i] != want[i] {
+			t.Fatalf("states %v, want %v", st.states, want)
+		}
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	kr := newKrang(&config.AppCnf{})
+	vc := kr.useVirtualClock()
+	s := kr.sched
+	ret := []string{}
+
+	// 时钟开始之前添加的任务从第一个行情开始计时
+	s.after(time.Second, func(ctx Context) { ret = append(ret, "a") })
+	vc.Advance(10000)
+	s.run(kr.nowMs())
+
+	s.after(500*time.Millisecond, func(ctx Context) { ret = append(ret, "b") })
+	s.after(500*time.Millisecond, func(ctx Context) { ret = append(ret, "c") })
+	canceled := s.after(200*time.Millisecond, func(ctx Context) { ret = append(ret, "x") })
+	s.cancel(canceled)
+	var ticks int
+	var every int64
+	every = s.every(300*time.Millisecond, func(ctx Context) {
+		ticks += 1
+		if ticks == 3 {
+			s.cancel(every)
+		}
+	})
+
+	s.run(10999)
+	if len(ret) != 2 || ret[0] != "b" || ret[1] != "c" {
+		t.Fatalf("order error, %v", ret)
+	}
+	s.run(11000)
+	if len(ret) != 3 || ret[2] != "a" {
+		t.Fatalf("pending task error, %v", ret)
+	}
+
+	// 错过多个周期只执行一次，之后按原来的节奏
+	if ticks != 1 {
+		t.Fatalf("ticks should be 1, got %d", ticks)
+	}
+	s.run(11850)
+	if ticks != 2 {
+		t.Fatalf("ticks should be 2, got %d", ticks)
+	}
+	if len(s.entries) != 1 || s.entries[0].due != 12100 {
+		t.Fatalf("next due error, %+v", s.entries)
+	}
+	s.run(20000)
+	s.run(30000)
+	if ticks != 3 || len(s.entries) != 0 {
+		t.Fatalf("canceled in callback should stop, ticks %d, entries %d", ticks, len(s.entries))
+	}
+}
@@ -8,10 +8,11 @@ import (
 )
 
 type tradeHandler struct {
+	kr *krang
 }
 
-func NewTradeHandler() Handler {
-	return &tradeHandler{}
+func NewTradeHandler(kr *krang) Handler {
+	return &tradeHandler{kr: kr}
 }
 
 /*
@@ -24,47 +25,100 @@ func (t *tradeHandler) HandleMessage(p protocol.Package, key string) bool {
 
 	// 响应最新行情
 	case protocol.FID_QUOTE_TICK:
-		return onTick(p, key)
+		return t.kr.onTick(p, key)
+
+	// 响应深度行情
+	case protocol.FID_QUOTE_Depth:
+		return t.kr.onDepth(p, key)
+
+	// 响应逐笔成交
+	case protocol.FID_QUOTE_Trade:
+		return t.kr.onTrade(p, key)
 
 	// 查询资金信息回应
 	case protocol.FID_RspQryMoneyInfo:
-		return rspQryMoneyInfo(p, key)
+		return t.kr.rspQryMoneyInfo(p, key)
 
 	// 查询头寸回应
 	case protocol.FID_RspQryPosInfo:
-		return rspQryPosInfo(p, key)
+		return t.kr.rspQryPosInfo(p, key)
 
 		// 下单回应
 	case protocol.FID_RspSetOrder:
-		return rspSetOrder(p, key)
+		return t.kr.rspSetOrder(p, key)
 
 		// 批量查询单据回应
 	case protocol.FID_RspQryOrders:
-		return rspQryOrders(p, key)
+		return t.kr.rspQryOrders(p, key)
 
 		// 批量撤销单据回应
 	case protocol.FID_RspCancelOrders:
-		return rspCancelOrders(p, key)
+		return t.kr.rspCancelOrders(p, key)
 
 		// 在现货和合约账号划转资金回应
 	case protocol.FID_RspTransferMoney:
-		return rspTransferMoney(p, key)
+		return t.kr.rspTransferMoney(p, key)
 	}
 	return false
 }
 
-func onTick(p protocol.Package, key string) bool {
+func (kr *krang) onTick(p protocol.Package, key string) bool {
 	pb := &protocol.PBFutureTick{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
 		logs.Error("pb unmarshal fail, tid:%d", p.GetTid())
 		return true
 	}
+	kr.advanceClock(pb.GetSinfo())
 	kr.keeper.OnTick(key, pb)
+	kr.risk.onTick(key, pb)
+
+	// 模拟交易需要行情撮合
+	if f, ok := kr.traders[key].(quoteFeeder); ok {
+		f.onTick(pb)
+	}
+	return false
+}
+
+/*
+  depth消息要放给后面的Handler处理,除非无法解包等错误
+*/
+func (kr *krang) onDepth(p protocol.Package, key string) bool {
+	f, ok := kr.traders[key].(quoteFeeder)
+	if !ok {
+		return false
+	}
+
+	pb := &protocol.PBFutureDepth{}
+	err := proto.Unmarshal(p.GetPayload(), pb)
+	if err != nil {
+		logs.Error("pb unmarshal fail, tid:%d", p.GetTid())
+		return true
+	}
+	f.onDepth(pb)
+	return false
+}
+
+/*
+  逐笔成交消息要放给后面的Handler处理,除非无法解包等错误
+*/
+func (kr *krang) onTrade(p protocol.Package, key string) bool {
+	f, ok := kr.traders[key].(quoteFeeder)
+	if !ok {
+		return false
+	}
+
+	pb := &protocol.PBFutureTrade{}
+	err := proto.Unmarshal(p.GetPayload(), pb)
+	if err != nil {
+		logs.Error("pb unmarshal fail, tid:%d", p.GetTid())
+		return true
+	}
+	f.onTrade(pb)
 	return false
 }
 
-func rspQryMoneyInfo(p protocol.Package, key string) bool {
+func (kr *krang) rspQryMoneyInfo(p protocol.Package, key string) bool {
 	pb := &protocol.PBFRspQryMoneyInfo{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -73,10 +127,11 @@ func rspQryMoneyInfo(p protocol.Package, key string) bool {
 	}
 
 	kr.keeper.HandleMoney(key, pb)
+	kr.onReconcileRsp(key, p.GetTid())
 	return true
 }
 
-func rspQryPosInfo(p protocol.Package, key string) bool {
+func (kr *krang) rspQryPosInfo(p protocol.Package, key string) bool {
 	pb := &protocol.PBFRspQryPosInfo{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -84,12 +139,17 @@ func rspQryPosInfo(p protocol.Package, key string) bool {
 		return true
 	}
 
-	kr.keeper.HandlePos(key, pb)
+	if kr.keeper.HandlePos(key, pb) {
+		pos := kr.keeper.GetPos(key, string(pb.GetSymbol()), string(pb.GetContractType()))
+		kr.risk.onPos(pos)
+		kr.notifyPos(pos)
+	}
+	kr.onReconcileRsp(key, p.GetTid())
 	return true
 }
 
 // 下单回应后，立即查询该订单状态和资金头寸
-func rspSetOrder(p protocol.Package, key string) bool {
+func (kr *krang) rspSetOrder(p protocol.Package, key string) bool {
 	pb := &protocol.PBFRspSetOrder{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -106,19 +166,18 @@ func rspSetOrder(p protocol.Package, key string) bool {
 	trader.QueryAccount()
 	trader.QueryPos(s, c)
 
+	// 更新请求状态
+	id := string(pb.GetOrderId())
+	kr.keeper.GetTracker().OnRspSetOrder(p.GetReqSerial(), id, pb.GetRsp().GetErrorId(), string(pb.GetRsp().GetErrorMsg()))
 	if pb.GetRsp().GetErrorId() != protocol.ErrId_OK {
 		logs.Info("下单失败，原因：%s", string(pb.GetRsp().GetErrorMsg()))
 		return true
 	}
-
-	// 更新反馈信息
-	kr.keeper.GetFeedBack().Remove(p.GetReqSerial())
-	id := string(pb.GetOrderId())
 	trader.QueryOrder(s, c, id)
 	return true
 }
 
-func rspQryOrders(p protocol.Package, key string) bool {
+func (kr *krang) rspQryOrders(p protocol.Package, key string) bool {
 	pb := &protocol.PBFRspQryOrders{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
@@ -126,26 +185,31 @@ func rspQryOrders(p protocol.Package, key string) bool {
 		return true
 	}
 
-	kr.keeper.HandleOrders(key, pb)
+	if kr.keeper.HandleOrders(key, pb) {
+		for _, v := range pb.GetOrders() {
+			kr.keeper.GetTracker().OnOrder(key, string(v.GetOrderId()), v.GetStatus(), v.GetDealAmount())
+			kr.notifyOrder(makeOrder(key, v))
+		}
+	}
+	kr.onReconcileRsp(key, p.GetTid())
 	return true
 }
 
 //撤单后， 查询撤销单据的状态
-func rspCancelOrders(p protocol.Package, key string) bool {
+func (kr *krang) rspCancelOrders(p protocol.Package, key string) bool {
 	pb := &protocol.PBFRspCancelOrders{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
 		logs.Error("pb unmarshal fail, tid:%d", p.GetTid())
 		return true
 	}
+	// 先更新请求状态
+	kr.keeper.GetTracker().OnRspCancel(p.GetReqSerial(), pb.GetRsp().GetErrorId(), string(pb.GetRsp().GetErrorMsg()))
 	if pb.GetRsp().GetErrorId() != protocol.ErrId_OK {
 		logs.Info("撤单失败，原因：%s", string(pb.GetRsp().GetErrorMsg()))
 		return true
 	}
 
-	// 先更新反馈信息
-	kr.keeper.GetFeedBack().Remove(p.GetReqSerial())
-
 	trader, ok := kr.traders[key]
 	if !ok {
 		return true
@@ -168,7 +232,7 @@ func rspCancelOrders(p protocol.Package, key string) bool {
 }
 
 // 转账后，立即查询资金状态
-func rspTransferMoney(p protocol.Package, key string) bool {
+func (kr *krang) rspTransferMoney(p protocol.Package, key string) bool {
 	pb := &protocol.PBFRspTransferMoney{}
 	err := proto.Unmarshal(p.GetPayload(), pb)
 	if err != nil {
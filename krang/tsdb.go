@@ -8,7 +8,6 @@ import (
 	"sync"
 	"time"
 
-	"chive/config"
 	"chive/logs"
 	"chive/protocol"
 	"chive/utils"
@@ -64,9 +63,9 @@ type ant struct {
 	client   *http.Client
 }
 
-func NewTSDBClient(bReplay bool) TSDB {
+func NewTSDBClient(addr string, bReplay bool) TSDB {
 	return &influxdb{
-		addr:    config.T.InfluxDB.Addr,
+		addr:    addr,
 		client:  &http.Client{},
 		dbNames: []string{},
 		antm:    make(map[string]*ant),
@@ -91,6 +90,11 @@ func (t *influxdb) Check(ex string, symbols []string, contractTypes []string) {
 	}
 	t.dbNames = append(t.dbNames, names...)
 
+	// 回放时不写入influxdb，不需要创建数据库
+	if t.bReplay {
+		return
+	}
+
 	miss := []string{}
 	dbs := t.ShowDatabase()
 	tm := make(map[string]int)
@@ -152,7 +156,7 @@ func (t *influxdb) QueryMAGraphic(db string, kl int32) *MaGraphic {
 
  时序数据库是以timestamp来做primary key的，key相同的话会被后面写入的覆盖掉
  现在okex的行情是ms为时间戳，但有可能几个ticker的时间戳是相同的，所以这里的
- timestamp使用本地纳秒timestamp来作为primary key，我们行情里的timestamp暂时不使用
+ timestamp使用时钟的纳秒timestamp来作为primary key，我们行情里的timestamp暂时不使用
 */
 func makeInsertSql(table string, fieldm map[string]float32, timestamp uint64) string {
 	if len(fieldm) <= 0 {
@@ -166,7 +170,7 @@ func makeInsertSql(table string, fieldm map[string]float32, timestamp uint64) st
 		fields += k + "=" + fmt.Sprintf("%f", v) + ","
 	}
 	bs := []byte(fields)
-	sql += string(bs[0:len(bs)-1]) + " " + fmt.Sprintf("%d", time.Now().UnixNano())
+	sql += string(bs[0:len(bs)-1]) + " " + fmt.Sprintf("%d", utils.Now().UnixNano())
 	return sql
 }
 
@@ -124,15 +124,18 @@ func (w *fileLogWriter) WriteMsg(when time.Time, msg string, level int) error {
 	if level > w.Level {
 		return nil
 	}
-	h, d := formatTimeHeader(when)
+	h, _ := formatTimeHeader(when)
 	msg = string(h) + msg + "\n"
+	// when may come from a replay clock, rotate by the wall clock
+	now := time.Now()
+	d := now.Day()
 	if w.Rotate {
 		w.RLock()
 		if w.needRotate(len(msg), d) {
 			w.RUnlock()
 			w.Lock()
 			if w.needRotate(len(msg), d) {
-				if err := w.doRotate(when); err != nil {
+				if err := w.doRotate(now); err != nil {
 					fmt.Fprintf(os.Stderr, "FileLogWriter(%q): %s\n", w.Filename, err)
 				}
 			}
@@ -42,6 +42,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -279,7 +280,7 @@ func (bl *BeeLogger) writeMsg(logLevel int, msg string, v ...interface{}) error
 	if len(v) > 0 {
 		msg = fmt.Sprintf(msg, v...)
 	}
-	when := time.Now()
+	when := logNow()
 	if bl.enableFuncCallDepth {
 		_, file, line, ok := runtime.Caller(bl.loggerFuncCallDepth)
 		if !ok {
@@ -541,6 +542,22 @@ func Async(msgLen ...int64) *BeeLogger {
 	return beeLogger.Async(msgLen...)
 }
 
+// nowFunc gives the time written in front of each log line.
+var nowFunc atomic.Value
+
+// SetNowFunc sets the time source of log lines, replay uses it to log with the replayed time.
+// File rotation still uses the wall clock.
+func SetNowFunc(f func() time.Time) {
+	nowFunc.Store(f)
+}
+
+func logNow() time.Time {
+	if f, ok := nowFunc.Load().(func() time.Time); ok && f != nil {
+		return f()
+	}
+	return time.Now()
+}
+
 // SetLevel sets the global log level used by the simple logger.
 func SetLevel(l int) {
 	beeLogger.SetLevel(l)
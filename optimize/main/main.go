@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"chive/config"
+	"chive/krang"
+	"chive/logs"
+	"chive/optimize"
+	"chive/replay"
+	"chive/strategy/mavg"
+	"chive/utils"
+)
+
+// 设置后覆盖配置文件里的optimize节
+var (
+	method  = flag.String("method", "", "search method, grid or random")
+	samples = flag.Int("n", 0, "samples of random search")
+	workers = flag.Int("w", 0, "backtests run at the same time")
+	metric  = flag.String("metric", "", "rank by pnl, return, sharpe, win_rate or max_drawdown")
+	top     = flag.Int("top", -1, "print top N results, 0 for all")
+)
+
+// 汇总表的输出目录
+var outDir = flag.String("o", "", "write summary.csv to this dir")
+
+// walk forward分析，日期用replay节的days，没有配置时用存储目录下全部的日期
+var walkForward = flag.Bool("wf", false, "run walk forward analysis")
+
+// 回测的日志很多，默认只记录错误
+var verbose = flag.Bool("v", false, "write info log")
+
+// 可以优化参数的策略
+var factories = map[string]optimize.Factory{
+	mavg.THIS_STRATEGY_NAME: func() krang.Strategy {
+		return mavg.NewMavgStrategy()
+	},
+}
+
+func main() {
+	utils.InitCnf()
+	level := logs.LevelError
+	if *verbose {
+		level = logs.LevelInfo
+	}
+	utils.InitLogger("optimize", level)
+	applyFlags()
+
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func applyFlags() {
+	oc := &config.T.Optimize
+	if *method != "" {
+		oc.Method = *method
+	}
+	if *samples > 0 {
+		oc.Samples = *samples
+	}
+	if *workers > 0 {
+		oc.Workers = *workers
+	}
+	if *metric != "" {
+		oc.Metric = *metric
+	}
+	if *top >= 0 {
+		oc.Top = *top
+	}
+}
+
+func run() error {
+	oc := config.T.Optimize
+	f, ok := factories[oc.Strategy]
+	if !ok {
+		return fmt.Errorf("strategy %s can not be optimized", oc.Strategy)
+	}
+	o, err := optimize.NewOptimizer(config.T, f)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("optimize strategy %s, %s search, %d workers, rank by %s\n", oc.Strategy, oc.Method, oc.Workers, oc.Metric)
+	if *walkForward {
+		return runWalkForward(o)
+	}
+	o.SetProgress(func(t *optimize.Trial, done int, total int) {
+		if t.Err != nil {
+			fmt.Printf("[%d/%d] trial %d error: %s\n", done, total, t.Id, t.Err.Error())
+			return
+		}
+		v, _ := optimize.MetricValue(t.Report, oc.Metric)
+		fmt.Printf("[%d/%d] trial %d done, %s %f\n", done, total, t.Id, oc.Metric, v)
+	})
+
+	trials, err := o.Run()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if err := optimize.WriteTable(os.Stdout, trials, o.Keys(), oc.Top); err != nil {
+		return err
+	}
+	if *outDir != "" {
+		if err := os.MkdirAll(*outDir, 0755); err != nil {
+			return err
+		}
+		filename := filepath.Join(*outDir, "summary.csv")
+		if err := optimize.WriteCSV(filename, trials, o.Keys()); err != nil {
+			return err
+		}
+		fmt.Println("summary is written to", filename)
+	}
+	return nil
+}
+
+func runWalkForward(o *optimize.Optimizer) error {
+	days := config.T.Replay.Days
+	if len(days) == 0 {
+		var err error
+		if days, err = replay.AvailableDays(config.T); err != nil {
+			return err
+		}
+	}
+	sort.Strings(days)
+
+	oc := config.T.Optimize
+	wc := oc.WalkForward
+	if wc.Step == 0 {
+		wc.Step = wc.OutSample
+	}
+	fmt.Printf("walk forward on %d days, in sample %d, out of sample %d, step %d\n",
+		len(days), wc.InSample, wc.OutSample, wc.Step)
+	wf, err := o.WalkForward(days, func(w *optimize.Window, done int, total int) {
+		if w.Err != nil {
+			fmt.Printf("[%d/%d] window %d error: %s\n", done, total, w.Id, w.Err.Error())
+			return
+		}
+		fmt.Printf("[%d/%d] window %d done, in sample pnl %f, out of sample pnl %f\n",
+			done, total, w.Id, w.InReport.PnL, w.OutReport.PnL)
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Println()
+	if err := wf.WriteTable(os.Stdout, o.Keys(), oc.Metric); err != nil {
+		return err
+	}
+	if *outDir != "" {
+		if err := wf.Write(*outDir, o.Keys(), oc.Metric); err != nil {
+			return err
+		}
+		fmt.Println("walk forward result is written to", *outDir)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+/*
+ optimize --- 策略参数优化
+
+ 1. 在同一份回放数据上用不同的参数运行多次回测，回放的消息只读一次，所有回测共用
+ 2. 每次回测是一个独立的krang实例，有自己的交易接口、时钟和策略，多个回测在不同的协程里并行运行
+ 3. 参数空间支持网格搜索和随机搜索
+ 4. 结果按指定的指标排序，输出汇总表
+ 5. walk forward分析，滚动的样本内选参数，样本外检验
+*/
+package optimize
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"chive/backtest"
+	"chive/config"
+	"chive/krang"
+	"chive/replay"
+)
+
+const (
+	METHOD_GRID   = "grid"
+	METHOD_RANDOM = "random"
+)
+
+// 创建一个新的策略实例，每次回测使用一个
+type Factory func() krang.Strategy
+
+// 一组参数的回测结果
+type Trial struct {
+	Id     int
+	Params map[string]interface{} // key是策略参数的section::key
+	Report *backtest.Report
+	Err    error
+}
+
+type metric struct {
+	value  func(rp *backtest.Report) float64
+	higher bool // 是否越大越好
+}
+
+// 可以用来排序的指标，名称和回测报告里的一样
+var metrics = map[string]metric{
+	"pnl":          {func(rp *backtest.Report) float64 { return rp.PnL }, true},
+	"return":       {func(rp *backtest.Report) float64 { return rp.Return }, true},
+	"sharpe":       {func(rp *backtest.Report) float64 { return rp.Sharpe }, true},
+	"win_rate":     {func(rp *backtest.Report) float64 { return rp.WinRate }, true},
+	"max_drawdown": {func(rp *backtest.Report) float64 { return rp.MaxDrawdown }, false},
+}
+
+// 回测报告里指标的值，指标不存在时返回false
+func MetricValue(rp *backtest.Report, name string) (float64, bool) {
+	m, ok := metrics[name]
+	if !ok || rp == nil {
+		return 0, false
+	}
+	return m.value(rp), true
+}
+
+type Optimizer struct {
+	cnf      *config.AppCnf
+	factory  Factory
+	dims     []*dimension
+	metric   metric
+	progress func(t *Trial, done int, total int)
+}
+
+func NewOptimizer(cnf *config.AppCnf, factory Factory) (*Optimizer, error) {
+	oc := cnf.Optimize
+	if oc.Strategy == "" || factory == nil {
+		return nil, errors.New("optimize strategy is not set")
+	}
+	m, ok := metrics[oc.Metric]
+	if !ok {
+		return nil, fmt.Errorf("unknown optimize metric %s", oc.Metric)
+	}
+	if oc.Method != METHOD_GRID && oc.Method != METHOD_RANDOM {
+		return nil, errors.New("optimize method should be grid or random")
+	}
+	if oc.Workers <= 0 {
+		return nil, errors.New("optimize workers should be greater than 0")
+	}
+	dims, err := parseSpace(oc.Space)
+	if err != nil {
+		return nil, err
+	}
+	if len(dims) == 0 {
+		return nil, errors.New("optimize space is empty")
+	}
+
+	return &Optimizer{
+		cnf:     cnf,
+		factory: factory,
+		dims:    dims,
+		metric:  m,
+	}, nil
+}
+
+// 每完成一次回测回调一次，回调在运行回测的协程里执行
+func (o *Optimizer) SetProgress(f func(t *Trial, done int, total int)) {
+	o.progress = f
+}
+
+// 参数空间里的全部key，已经排序
+func (o *Optimizer) Keys() []string {
+	ret := make([]string, 0, len(o.dims))
+	for _, d := range o.dims {
+		ret = append(ret, d.key)
+	}
+	return ret
+}
+
+func (o *Optimizer) makeParams() ([]map[string]interface{}, error) {
+	oc := o.cnf.Optimize
+	if oc.Method == METHOD_RANDOM {
+		if oc.Samples <= 0 {
+			return nil, errors.New("optimize samples should be greater than 0")
+		}
+		return randomParams(o.dims, oc.Samples, oc.Seed), nil
+	}
+	return gridParams(o.dims)
+}
+
+/*
+ 运行全部回测，返回按指标排好序的结果，出错的回测排在最后
+*/
+func (o *Optimizer) Run() ([]*Trial, error) {
+	return o.runCnf(o.cnf)
+}
+
+// 只回放指定的日期，回放时间窗口不再生效
+func (o *Optimizer) RunDays(days []string) ([]*Trial, error) {
+	return o.runCnf(o.daysCnf(days))
+}
+
+/*
+ 用一组参数在指定的日期上回测一次
+*/
+func (o *Optimizer) Evaluate(days []string, params map[string]interface{}) *Trial {
+	t := &Trial{Id: 1, Params: params}
+	cnf := o.daysCnf(days)
+	msgs, err := replay.LoadMessages(cnf)
+	if err != nil {
+		t.Err = err
+		return t
+	}
+	if len(msgs) == 0 {
+		t.Err = errors.New("no replay message to evaluate")
+		return t
+	}
+	o.runTrial(cnf, t, replay.NewMemReplay(msgs))
+	return t
+}
+
+func (o *Optimizer) daysCnf(days []string) *config.AppCnf {
+	cnf := *o.cnf
+	cnf.Replay.Days = days
+	cnf.Replay.Start = ""
+	cnf.Replay.End = ""
+	return &cnf
+}
+
+func (o *Optimizer) runCnf(cnf *config.AppCnf) ([]*Trial, error) {
+	params, err := o.makeParams()
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := replay.LoadMessages(cnf)
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) == 0 {
+		return nil, errors.New("no replay message to optimize")
+	}
+
+	trials := make([]*Trial, 0, len(params))
+	for i, p := range params {
+		trials = append(trials, &Trial{Id: i + 1, Params: p})
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	done := 0
+	jobs := make(chan *Trial)
+	for i := 0; i < o.cnf.Optimize.Workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range jobs {
+				o.runTrial(cnf, t, replay.NewMemReplay(msgs))
+
+				mu.Lock()
+				done += 1
+				if o.progress != nil {
+					o.progress(t, done, len(trials))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, t := range trials {
+		jobs <- t
+	}
+	close(jobs)
+	wg.Wait()
+
+	o.rank(trials)
+	return trials, nil
+}
+
+func (o *Optimizer) runTrial(cnf *config.AppCnf, t *Trial, r *replay.Replay) {
+	bt := krang.NewBacktest(trialCnf(cnf, t), r)
+	bt.AddStrategy(cnf.Optimize.Strategy, o.factory())
+	t.Report, t.Err = bt.Run()
+	if t.Err == nil && t.Report == nil {
+		t.Err = errors.New("backtest has no report")
+	}
+}
+
+/*
+ 每次回测使用一份单独的配置，只有被优化的策略参数不一样
+*/
+func trialCnf(base *config.AppCnf, t *Trial) *config.AppCnf {
+	cnf := *base
+	cnf.Strategies = make(map[string]*config.StrategyCnf, len(base.Strategies)+1)
+	for k, v := range base.Strategies {
+		cnf.Strategies[k] = v
+	}
+
+	name := base.Optimize.Strategy
+	sc := base.GetStrategyCnf(name).Clone()
+	for k, v := range t.Params {
+		sc.Params.Set(k, v)
+	}
+	cnf.Strategies[name] = sc
+	return &cnf
+}
+
+// 指标一样时按参数的顺序
+func (o *Optimizer) rank(trials []*Trial) {
+	sort.SliceStable(trials, func(i, j int) bool {
+		a, b := trials[i], trials[j]
+		if a.Err != nil || b.Err != nil {
+			return a.Err == nil && b.Err != nil
+		}
+		va, vb := o.metric.value(a.Report), o.metric.value(b.Report)
+		if va == vb {
+			return a.Id < b.Id
+		}
+		if o.metric.higher {
+			return va > vb
+		}
+		return va < vb
+	})
+}
@@ -0,0 +1,131 @@
+package optimize
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"chive/backtest"
+	"chive/config"
+	"chive/krang"
+	"chive/protocol"
+	"chive/synth"
+)
+
+/*
+ 每个tick交替开平多仓，持仓hold个tick
+ 策略实例之间不共享状态，参数不同时成交次数和盈亏不同
+*/
+type holdStrategy struct {
+	hold  int
+	ticks int
+	open  bool
+}
+
+func (s *holdStrategy) Init(ctx krang.Context) {
+	s.hold = ctx.GetStrategyCnf("hold").Params.Int("test::hold", 1)
+}
+
+func (s *holdStrategy) CheckFeedBack(ctx krang.Context) bool { return true }
+
+func (s *holdStrategy) OnTick(ctx krang.Context, tick *krang.Tick) {
+	s.ticks += 1
+	if s.ticks%s.hold != 0 {
+		return
+	}
+	ot := int32(protocol.ORDERTYPE_OPENLONG)
+	if s.open {
+		ot = protocol.ORDERTYPE_CLOSELONG
+	}
+	ctx.GetTrader("okex").SetOrder(krang.SetOrderCmd{
+		Stname:       "hold",
+		Exchange:     "okex",
+		Symbol:       "ltc_usd",
+		ContractType: "this_week",
+		Amount:       1,
+		OrderType:    ot,
+		PriceSt:      protocol.PRICE_ST_MARKET,
+		Level:        10,
+	})
+	s.open = !s.open
+}
+
+func TestOptimizerRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "optimize")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	days := []string{"2017-12-25", "2017-12-26"}
+	cnf := &config.AppCnf{StgPath: dir + "/", Exchanges: []string{"okex"}}
+	cnf.Replay.Days = days
+	cnf.Paper.Balance = 10
+	sc := &cnf.Synth
+	sc.Exchange = "okex"
+	sc.Days = days
+	sc.ContractTypes = []string{"this_week"}
+	sc.Symbols = map[string]config.SynthSymbol{"ltc_usd": {Price: 100, UnitAmount: 10}}
+	sc.Interval = 60 * 1000
+	sc.KLineInterval = 60 * 1000
+	sc.Volume = 10
+	sc.Seed = 1
+	sc.Model = map[string]interface{}{"type": "gbm", "sigma": 0.5}
+	g, err := synth.NewGenerator(cnf, cnf.StgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	oc := &cnf.Optimize
+	oc.Strategy = "hold"
+	oc.Method = METHOD_GRID
+	oc.Workers = 3
+	oc.Metric = "pnl"
+	oc.Space = map[string]interface{}{"test::hold": "1;2;5;10;30"}
+	o, err := NewOptimizer(cnf, func() krang.Strategy { return &holdStrategy{} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mu sync.Mutex
+	progress := 0
+	o.SetProgress(func(t *Trial, done int, total int) {
+		mu.Lock()
+		progress += 1
+		mu.Unlock()
+	})
+	trials, err := o.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trials) != 5 || progress != 5 {
+		t.Fatalf("got %d trials, progress %d", len(trials), progress)
+	}
+
+	// 每组参数一份报告，成交次数只由自己的参数决定，结果按盈亏从大到小排序
+	ticks := 2 * 24 * 60
+	reports := map[*backtest.Report]bool{}
+	for i, tr := range trials {
+		if tr.Err != nil || tr.Report == nil {
+			t.Fatalf("trial %d failed: %v", tr.Id, tr.Err)
+		}
+		if reports[tr.Report] {
+			t.Fatalf("trial %d shares a report", tr.Id)
+		}
+		reports[tr.Report] = true
+
+		hold := int(tr.Params["test::hold"].(float64))
+		if want := ticks / hold; tr.Report.TradeCount != want {
+			t.Fatalf("hold %d has %d trades, want %d", hold, tr.Report.TradeCount, want)
+		}
+		if i > 0 && trials[i-1].Report.PnL < tr.Report.PnL {
+			t.Fatalf("trials are not ranked by pnl: %f < %f", trials[i-1].Report.PnL, tr.Report.PnL)
+		}
+	}
+	if trials[0].Report.PnL == trials[len(trials)-1].Report.PnL {
+		t.Fatal("different params should have different pnl")
+	}
+}
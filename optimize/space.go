@@ -0,0 +1,182 @@
+package optimize
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+ 参数空间，配置文件optimize节的space
+
+    "space": {
+        "macd::KL5Min::fkrate": "0.15;0.20;0.25",
+        "macd::KL5Min::skrate": {"min": 0.08, "max": 0.16, "step": 0.02},
+        "normal::ltc_usd::stop_lose_rate": {"min": -0.20, "max": -0.05}
+    }
+
+ 1. key是策略参数的section::key，和策略读取参数的方式一样
+ 2. 字符串或者数组是参数的全部取值，字符串用;分隔，能转成数字的转成数字
+ 3. min、max、step是一个范围，网格搜索按step取值
+    随机搜索有step时在网格上取值，没有step时在范围内均匀取值
+ 4. 网格搜索时每个参数都要有有限的取值
+*/
+
+// 网格搜索最多的参数组合数量
+const max_grid_trials = 10000
+
+type dimension struct {
+	key    string
+	values []interface{} // 取值列表，范围参数为nil
+	min    float64
+	max    float64
+	step   float64 // 为0时没有步长
+}
+
+// 参数按key排序，同样的配置得到同样的参数顺序
+func parseSpace(space map[string]interface{}) ([]*dimension, error) {
+	keys := make([]string, 0, len(space))
+	for k := range space {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	dims := make([]*dimension, 0, len(keys))
+	for _, k := range keys {
+		d, err := parseDimension(k, space[k])
+		if err != nil {
+			return nil, err
+		}
+		dims = append(dims, d)
+	}
+	return dims, nil
+}
+
+func parseDimension(key string, v interface{}) (*dimension, error) {
+	d := &dimension{key: key}
+	switch t := v.(type) {
+	case string:
+		for _, s := range strings.Split(t, ";") {
+			if s = strings.TrimSpace(s); s != "" {
+				d.values = append(d.values, parseValue(s))
+			}
+		}
+
+	case []interface{}:
+		d.values = append(d.values, t...)
+
+	case map[string]interface{}:
+		var ok1, ok2 bool
+		d.min, ok1 = t["min"].(float64)
+		d.max, ok2 = t["max"].(float64)
+		if !ok1 || !ok2 || d.min > d.max {
+			return nil, fmt.Errorf("optimize param %s should have min <= max", key)
+		}
+		if step, ok := t["step"].(float64); ok {
+			if step <= 0 {
+				return nil, fmt.Errorf("optimize param %s step should be greater than 0", key)
+			}
+			d.step = step
+		}
+		return d, nil
+
+	default:
+		return nil, fmt.Errorf("optimize param %s should be a list or a range", key)
+	}
+
+	if len(d.values) == 0 {
+		return nil, fmt.Errorf("optimize param %s has no value", key)
+	}
+	return d, nil
+}
+
+func parseValue(s string) interface{} {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// 网格上的全部取值
+func (d *dimension) grid() ([]interface{}, error) {
+	if d.values != nil {
+		return d.values, nil
+	}
+	if d.step == 0 {
+		return nil, fmt.Errorf("optimize param %s needs a step in grid search", d.key)
+	}
+
+	// 去掉浮点数累加的误差
+	n := int(math.Floor((d.max-d.min)/d.step+1e-9)) + 1
+	ret := make([]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		v := d.min + float64(i)*d.step
+		ret = append(ret, math.Round(v*1e10)/1e10)
+	}
+	return ret, nil
+}
+
+func (d *dimension) sample(rnd *rand.Rand) interface{} {
+	if d.values != nil {
+		return d.values[rnd.Intn(len(d.values))]
+	}
+	if d.step > 0 {
+		vs, _ := d.grid()
+		return vs[rnd.Intn(len(vs))]
+	}
+	return d.min + rnd.Float64()*(d.max-d.min)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// 网格搜索，全部参数取值的组合
+func gridParams(dims []*dimension) ([]map[string]interface{}, error) {
+	grids := make([][]interface{}, 0, len(dims))
+	total := 1
+	for _, d := range dims {
+		vs, err := d.grid()
+		if err != nil {
+			return nil, err
+		}
+		grids = append(grids, vs)
+		total *= len(vs)
+		if total > max_grid_trials {
+			return nil, errors.New("too many optimize params combinations, use random search instead")
+		}
+	}
+
+	ret := []map[string]interface{}{{}}
+	for i, d := range dims {
+		next := make([]map[string]interface{}, 0, len(ret)*len(grids[i]))
+		for _, p := range ret {
+			for _, v := range grids[i] {
+				np := make(map[string]interface{}, len(p)+1)
+				for k, pv := range p {
+					np[k] = pv
+				}
+				np[d.key] = v
+				next = append(next, np)
+			}
+		}
+		ret = next
+	}
+	return ret, nil
+}
+
+// 随机搜索，每组参数独立取值
+func randomParams(dims []*dimension, n int, seed int64) []map[string]interface{} {
+	rnd := rand.New(rand.NewSource(seed))
+	ret := make([]map[string]interface{}, 0, n)
+	for i := 0; i < n; i++ {
+		p := make(map[string]interface{}, len(dims))
+		for _, d := range dims {
+			p[d.key] = d.sample(rnd)
+		}
+		ret = append(ret, p)
+	}
+	return ret
+}
@@ -0,0 +1,55 @@
+package optimize
+
+import (
+	"testing"
+
+	"chive/config"
+)
+
+func TestGridParams(t *testing.T) {
+	dims, err := parseSpace(map[string]interface{}{
+		"macd::KL5Min::fkrate":            "0.15;0.20",
+		"normal::ltc_usd::stop_lose_rate": map[string]interface{}{"min": -0.18, "max": -0.08, "step": 0.05},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := gridParams(dims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(params) != 6 {
+		t.Fatalf("grid has %d params, want 6", len(params))
+	}
+	if v := params[5]["normal::ltc_usd::stop_lose_rate"]; v != -0.08 {
+		t.Fatalf("last stop_lose_rate is %v, want -0.08", v)
+	}
+
+	// 范围参数没有步长时不能网格搜索，可以随机搜索
+	dims, _ = parseSpace(map[string]interface{}{"macd::KL5Min::fsdiff": map[string]interface{}{"min": 1.0, "max": 2.0}})
+	if _, err := gridParams(dims); err == nil {
+		t.Fatal("grid search without step should fail")
+	}
+	a := randomParams(dims, 3, 7)
+	b := randomParams(dims, 3, 7)
+	for i := range a {
+		v := a[i]["macd::KL5Min::fsdiff"].(float64)
+		if v < 1 || v > 2 || v != b[i]["macd::KL5Min::fsdiff"] {
+			t.Fatalf("random param %v is out of range or not reproducible", v)
+		}
+	}
+}
+
+func TestTrialParams(t *testing.T) {
+	ps := config.StrategyParams{"macd": map[string]interface{}{"KL5Min": map[string]interface{}{"fkrate": 0.2}}}
+	c := ps.Clone()
+	c.Set("macd::KL5Min::fkrate", 0.25)
+	c.Set("normal::ltc_usd::level", 20.0)
+
+	if ps.Float("macd::KL5Min::fkrate", 0) != 0.2 {
+		t.Fatal("clone should not change the original params")
+	}
+	if c.Float("macd::KL5Min::fkrate", 0) != 0.25 || c.Int("normal::ltc_usd::level", 0) != 20 {
+		t.Fatalf("set params failed: %v", c)
+	}
+}
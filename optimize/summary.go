@@ -0,0 +1,92 @@
+package optimize
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+/*
+ 汇总表，每组参数一行，按排序后的名次输出
+ 列：名次、序号、各个参数、盈亏、收益率、最大回撤、胜率、夏普比率、成交笔数
+*/
+
+var summaryMetrics = []string{"pnl", "return", "max_drawdown", "win_rate", "sharpe", "trades"}
+
+func valueStr(v interface{}) string {
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+func summaryHeader(keys []string) []string {
+	h := []string{"rank", "id"}
+	h = append(h, keys...)
+	return append(h, summaryMetrics...)
+}
+
+func summaryRow(rank int, t *Trial, keys []string) []string {
+	row := []string{strconv.Itoa(rank), strconv.Itoa(t.Id)}
+	for _, k := range keys {
+		row = append(row, valueStr(t.Params[k]))
+	}
+	if t.Err != nil {
+		return append(row, "error: "+t.Err.Error())
+	}
+
+	rp := t.Report
+	f := func(v float64) string {
+		return fmt.Sprintf("%f", v)
+	}
+	return append(row, f(rp.PnL), f(rp.Return), f(rp.MaxDrawdown), f(rp.WinRate), f(rp.Sharpe),
+		strconv.Itoa(rp.TradeCount))
+}
+
+/*
+ 输出对齐的汇总表，top大于0时只输出前top名
+*/
+func WriteTable(w io.Writer, trials []*Trial, keys []string, top int) error {
+	rows := [][]string{}
+	for i, t := range trials {
+		if top > 0 && i >= top {
+			break
+		}
+		rows = append(rows, summaryRow(i+1, t, keys))
+	}
+	return writeTable(w, summaryHeader(keys), rows)
+}
+
+// 全部结果输出到csv文件
+func WriteCSV(filename string, trials []*Trial, keys []string) error {
+	rows := make([][]string, 0, len(trials))
+	for i, t := range trials {
+		rows = append(rows, summaryRow(i+1, t, keys))
+	}
+	return writeCSVFile(filename, summaryHeader(keys), rows)
+}
+
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, cols := range append([][]string{header}, rows...) {
+		fmt.Fprintln(tw, strings.Join(cols, "\t"))
+	}
+	return tw.Flush()
+}
+
+func writeCSVFile(filename string, header []string, rows [][]string) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Write(header)
+	w.WriteAll(rows)
+	return w.Error()
+}
@@ -0,0 +1,312 @@
+package optimize
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"chive/backtest"
+)
+
+/*
+ walk forward分析
+
+ 1. 把可回放的日期按顺序切成滚动的窗口，每个窗口是in_sample天的样本内加上紧接着的out_sample天的样本外
+    下一个窗口向前移动step天，step不能小于out_sample，保证样本外不重叠
+ 2. 每个窗口在样本内优化参数，取排名第一的参数在样本外回测
+ 3. 各窗口的样本外报告按时间拼接成一条权益曲线
+ 4. 统计每个参数在各窗口的取值，看最优参数是否稳定
+
+    [ in  | out ]
+          [ in  | out ]
+                [ in  | out ]
+*/
+
+type Window struct {
+	Id         int
+	InDays     []string
+	OutDays    []string
+	Params     map[string]interface{} // 样本内最优的参数
+	InReport   *backtest.Report
+	OutReport  *backtest.Report
+	Efficiency float64 // 样本外每天盈亏和样本内每天盈亏的比值，样本内不盈利时为0
+	Err        error
+}
+
+// 一个参数在各窗口的最优取值
+type ParamStat struct {
+	Key      string
+	Values   []interface{}
+	Numeric  bool // 全部是数字时才有下面的统计
+	Mean     float64
+	Std      float64
+	Min      float64
+	Max      float64
+	CV       float64 // 变异系数，std/|mean|
+	Distinct int     // 不同取值的个数
+	Changes  int     // 相邻窗口取值变化的次数
+}
+
+type WalkForward struct {
+	Windows    []*Window
+	OutReport  *backtest.Report // 拼接后的样本外报告
+	Efficiency float64          // 全部样本外每天盈亏和样本内平均每天盈亏的比值
+	Stability  []*ParamStat
+}
+
+func splitWindows(days []string, in int, out int, step int) []*Window {
+	ret := []*Window{}
+	for i := 0; i+in+out <= len(days); i += step {
+		ret = append(ret, &Window{
+			Id:      len(ret) + 1,
+			InDays:  days[i : i+in],
+			OutDays: days[i+in : i+in+out],
+		})
+	}
+	return ret
+}
+
+func efficiency(inPnl float64, inDays int, outPnl float64, outDays int) float64 {
+	if inPnl <= 0 || inDays == 0 || outDays == 0 {
+		return 0
+	}
+	return (outPnl / float64(outDays)) / (inPnl / float64(inDays))
+}
+
+/*
+ 在给定的日期上做walk forward分析，日期要按顺序排好
+ 某个窗口出错时记录在窗口里，不影响其它窗口
+*/
+func (o *Optimizer) WalkForward(days []string, progress func(w *Window, done int, total int)) (*WalkForward, error) {
+	wc := o.cnf.Optimize.WalkForward
+	step := wc.Step
+	if step == 0 {
+		step = wc.OutSample
+	}
+	if wc.InSample <= 0 || wc.OutSample <= 0 {
+		return nil, errors.New("walkforward in_sample and out_sample should be greater than 0")
+	}
+	if step < wc.OutSample {
+		return nil, errors.New("walkforward step should not be less than out_sample")
+	}
+
+	windows := splitWindows(days, wc.InSample, wc.OutSample, step)
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("%d days are not enough for walkforward, need at least %d", len(days), wc.InSample+wc.OutSample)
+	}
+
+	for i, w := range windows {
+		o.runWindow(w)
+		if progress != nil {
+			progress(w, i+1, len(windows))
+		}
+	}
+
+	wf := &WalkForward{Windows: windows}
+	reports := []*backtest.Report{}
+	var inPnl float64
+	inDays, outDays := 0, 0
+	for _, w := range windows {
+		if w.Err != nil {
+			continue
+		}
+		reports = append(reports, w.OutReport)
+		inPnl += w.InReport.PnL
+		inDays += len(w.InDays)
+		outDays += len(w.OutDays)
+	}
+	if len(reports) == 0 {
+		return nil, errors.New("all walkforward windows failed")
+	}
+	wf.OutReport = backtest.Stitch(reports, backtest.DEFAULT_SAMPLE_INTERVAL)
+	wf.Efficiency = efficiency(inPnl, inDays, wf.OutReport.PnL, outDays)
+	wf.Stability = paramStability(windows, o.Keys())
+	return wf, nil
+}
+
+func (o *Optimizer) runWindow(w *Window) {
+	trials, err := o.RunDays(w.InDays)
+	if err != nil {
+		w.Err = err
+		return
+	}
+	best := trials[0]
+	if best.Err != nil {
+		w.Err = best.Err
+		return
+	}
+	w.Params = best.Params
+	w.InReport = best.Report
+
+	t := o.Evaluate(w.OutDays, best.Params)
+	if t.Err != nil {
+		w.Err = t.Err
+		return
+	}
+	w.OutReport = t.Report
+	w.Efficiency = efficiency(w.InReport.PnL, len(w.InDays), w.OutReport.PnL, len(w.OutDays))
+}
+
+func paramStability(windows []*Window, keys []string) []*ParamStat {
+	ret := make([]*ParamStat, 0, len(keys))
+	for _, k := range keys {
+		ps := &ParamStat{Key: k, Numeric: true}
+		distinct := make(map[string]bool)
+		nums := []float64{}
+		for _, w := range windows {
+			if w.Err != nil {
+				continue
+			}
+			v := w.Params[k]
+			if n := len(ps.Values); n > 0 && valueStr(ps.Values[n-1]) != valueStr(v) {
+				ps.Changes += 1
+			}
+			ps.Values = append(ps.Values, v)
+			distinct[valueStr(v)] = true
+			if f, ok := v.(float64); ok {
+				nums = append(nums, f)
+			} else {
+				ps.Numeric = false
+			}
+		}
+		ps.Distinct = len(distinct)
+		if ps.Numeric && len(nums) > 0 {
+			ps.Mean, ps.Std, ps.Min, ps.Max = describe(nums)
+			if ps.Mean != 0 {
+				ps.CV = ps.Std / math.Abs(ps.Mean)
+			}
+		}
+		ret = append(ret, ps)
+	}
+	return ret
+}
+
+// 均值、总体标准差、最小值、最大值
+func describe(vs []float64) (float64, float64, float64, float64) {
+	var sum float64
+	min, max := vs[0], vs[0]
+	for _, v := range vs {
+		sum += v
+		min = math.Min(min, v)
+		max = math.Max(max, v)
+	}
+	mean := sum / float64(len(vs))
+
+	var sq float64
+	for _, v := range vs {
+		sq += (v - mean) * (v - mean)
+	}
+	return mean, math.Sqrt(sq / float64(len(vs))), min, max
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+ 窗口表，每个窗口一行
+ 列：序号、样本内日期、样本外日期、各个参数、样本内排序指标、样本外盈亏、样本外收益率、样本外最大回撤、样本外成交笔数、效率
+*/
+func windowHeader(keys []string, metric string) []string {
+	h := []string{"window", "in_sample", "out_sample"}
+	h = append(h, keys...)
+	return append(h, "in_"+metric, "out_pnl", "out_return", "out_max_drawdown", "out_trades", "efficiency")
+}
+
+func dayRange(days []string) string {
+	if len(days) == 1 {
+		return days[0]
+	}
+	return days[0] + "~" + days[len(days)-1]
+}
+
+func windowRow(w *Window, keys []string, metric string) []string {
+	row := []string{strconv.Itoa(w.Id), dayRange(w.InDays), dayRange(w.OutDays)}
+	for _, k := range keys {
+		row = append(row, valueStr(w.Params[k]))
+	}
+	if w.Err != nil {
+		return append(row, "error: "+w.Err.Error())
+	}
+
+	f := func(v float64) string {
+		return fmt.Sprintf("%f", v)
+	}
+	m, _ := MetricValue(w.InReport, metric)
+	out := w.OutReport
+	return append(row, f(m), f(out.PnL), f(out.Return), f(out.MaxDrawdown),
+		strconv.Itoa(out.TradeCount), f(w.Efficiency))
+}
+
+var stabilityHeader = []string{"param", "values", "mean", "std", "min", "max", "cv", "distinct", "changes"}
+
+func stabilityRow(ps *ParamStat) []string {
+	vs := make([]string, 0, len(ps.Values))
+	for _, v := range ps.Values {
+		vs = append(vs, valueStr(v))
+	}
+	row := []string{ps.Key, strings.Join(vs, ";")}
+	if ps.Numeric {
+		f := func(v float64) string {
+			return strconv.FormatFloat(v, 'f', 6, 64)
+		}
+		row = append(row, f(ps.Mean), f(ps.Std), f(ps.Min), f(ps.Max), f(ps.CV))
+	} else {
+		row = append(row, "", "", "", "", "")
+	}
+	return append(row, strconv.Itoa(ps.Distinct), strconv.Itoa(ps.Changes))
+}
+
+func (wf *WalkForward) rows(keys []string, metric string) ([][]string, [][]string) {
+	windows := make([][]string, 0, len(wf.Windows))
+	for _, w := range wf.Windows {
+		windows = append(windows, windowRow(w, keys, metric))
+	}
+	stability := make([][]string, 0, len(wf.Stability))
+	for _, ps := range wf.Stability {
+		stability = append(stability, stabilityRow(ps))
+	}
+	return windows, stability
+}
+
+/*
+ 输出窗口表、参数稳定性表和拼接后的样本外汇总
+*/
+func (wf *WalkForward) WriteTable(w io.Writer, keys []string, metric string) error {
+	windows, stability := wf.rows(keys, metric)
+	if err := writeTable(w, windowHeader(keys, metric), windows); err != nil {
+		return err
+	}
+	fmt.Fprintln(w)
+	if err := writeTable(w, stabilityHeader, stability); err != nil {
+		return err
+	}
+
+	rp := wf.OutReport
+	fmt.Fprintf(w, "\nout of sample: pnl %f, return %f, max_drawdown %f, win_rate %f, sharpe %f, trades %d, efficiency %f\n",
+		rp.PnL, rp.Return, rp.MaxDrawdown, rp.WinRate, rp.Sharpe, rp.TradeCount, wf.Efficiency)
+	return nil
+}
+
+/*
+ 输出到目录
+ windows.csv: 窗口表
+ stability.csv: 参数稳定性表
+ oos/: 拼接后的样本外回测报告，格式和回测报告一样
+*/
+func (wf *WalkForward) Write(dir string, keys []string, metric string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	windows, stability := wf.rows(keys, metric)
+	if err := writeCSVFile(filepath.Join(dir, "windows.csv"), windowHeader(keys, metric), windows); err != nil {
+		return err
+	}
+	if err := writeCSVFile(filepath.Join(dir, "stability.csv"), stabilityHeader, stability); err != nil {
+		return err
+	}
+	return wf.OutReport.Write(filepath.Join(dir, "oos"))
+}
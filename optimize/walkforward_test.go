@@ -0,0 +1,41 @@
+package optimize
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitWindows(t *testing.T) {
+	days := []string{"2017-12-25", "2017-12-26", "2017-12-27", "2017-12-28", "2017-12-29", "2017-12-30"}
+	ws := splitWindows(days, 3, 1, 1)
+	if len(ws) != 3 {
+		t.Fatalf("split into %d windows, want 3", len(ws))
+	}
+	if w := ws[2]; w.InDays[0] != "2017-12-27" || len(w.InDays) != 3 || w.OutDays[0] != "2017-12-30" {
+		t.Fatalf("last window is %v %v", w.InDays, w.OutDays)
+	}
+	if ws = splitWindows(days, 2, 2, 2); len(ws) != 2 || ws[1].OutDays[1] != "2017-12-30" {
+		t.Fatalf("split with step 2 got %d windows", len(ws))
+	}
+	if ws = splitWindows(days, 5, 2, 2); len(ws) != 0 {
+		t.Fatal("not enough days should have no window")
+	}
+}
+
+func TestParamStability(t *testing.T) {
+	key := "macd::KL5Min::fkrate"
+	ws := []*Window{
+		{Params: map[string]interface{}{key: 0.2}},
+		{Params: map[string]interface{}{key: 0.2}},
+		{Err: errors.New("failed")},
+		{Params: map[string]interface{}{key: 0.3}},
+		{Params: map[string]interface{}{key: 0.1}},
+	}
+	ps := paramStability(ws, []string{key})[0]
+	if len(ps.Values) != 4 || ps.Distinct != 3 || ps.Changes != 2 {
+		t.Fatalf("values %v, distinct %d, changes %d", ps.Values, ps.Distinct, ps.Changes)
+	}
+	if !ps.Numeric || ps.Min != 0.1 || ps.Max != 0.3 || ps.Mean < 0.1999 || ps.Mean > 0.2001 {
+		t.Fatalf("stat is wrong: %+v", ps)
+	}
+}
@@ -0,0 +1,176 @@
+package replay
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"chive/config"
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+)
+
+/*
+ 回放过滤，按时间段、合约和行情类型过滤
+
+ 1. 时间段使用目录的时间，也就是合并时使用的时间，K线不会因为时间戳早而被过滤
+ 2. 开始时间之前的记录用二分查找跳过，不需要逐条解包
+ 3. 超过结束时间后该目录不再读取
+ 4. 合约和行情类型只过滤行情消息
+*/
+
+const replay_time_layout = "2006-01-02 15:04:05"
+
+// 二分查找时，从一个位置向后最多查找多少条记录来找到有时间戳的消息
+const seek_max_probe = 64
+
+type filter struct {
+	start       uint64 // 毫秒，0表示不限制
+	end         uint64 // 毫秒，0表示不限制
+	instruments map[string]bool
+	fids        map[uint32]bool
+}
+
+func newFilter(start string, end string, instruments []string, fids []string) (*filter, error) {
+	f := &filter{
+		instruments: make(map[string]bool),
+		fids:        make(map[uint32]bool),
+	}
+
+	var err error
+	if f.start, err = parseReplayTime(start); err != nil {
+		return nil, err
+	}
+	if f.end, err = parseReplayTime(end); err != nil {
+		return nil, err
+	}
+	if f.start > 0 && f.end > 0 && f.start > f.end {
+		return nil, errors.New("replay start time is after end time")
+	}
+
+	for _, v := range instruments {
+		if v = strings.TrimSpace(v); v != "" {
+			f.instruments[v] = true
+		}
+	}
+	for _, v := range fids {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v == "" {
+			continue
+		}
+		fid, ok := utils.QuoteFidNames[v]
+		if !ok {
+			return nil, errors.New("unknown replay fid " + v)
+		}
+		f.fids[fid] = true
+	}
+	return f, nil
+}
+
+func newFilterFromCnf(cnf *config.AppCnf) (*filter, error) {
+	c := cnf.Replay
+	return newFilter(c.Start, c.End, c.Instruments, c.Fids)
+}
+
+// 本地时间转成毫秒，为空时返回0
+func parseReplayTime(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.ParseInLocation(replay_time_layout, s, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(t.UnixNano() / int64(time.Millisecond)), nil
+}
+
+/*
+ 配置里没有指定日期时，用开始和结束时间算出要回放的交易日
+*/
+func filterDays(f *filter, td *utils.TradingDay) []string {
+	if f.start == 0 || f.end == 0 {
+		return nil
+	}
+	return td.Days(msTime(f.start), msTime(f.end))
+}
+
+func msTime(ms uint64) time.Time {
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+}
+
+// 是否超过了结束时间
+func (f *filter) afterEnd(ts uint64) bool {
+	return f.end > 0 && ts > f.end
+}
+
+func (f *filter) match(ex string, c *cursor) bool {
+	if f.start > 0 && c.ts < f.start {
+		return false
+	}
+	if c.sinfo == nil {
+		return true
+	}
+	if len(f.fids) > 0 && !f.fids[c.tid] {
+		return false
+	}
+	if len(f.instruments) > 0 {
+		key := utils.MakeupSinfo(ex, c.sinfo.GetSymbol(), c.sinfo.GetContractType())
+		if !f.instruments[key] {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+ 二分查找第一个时间不早于start的记录，游标从这里开始读
+ 记录的时间基本是递增的，K线和没有时间戳的消息不参与比较
+*/
+func (c *cursor) seek(start uint64) error {
+	if start == 0 {
+		return nil
+	}
+	lo, hi := uint64(0), c.total
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		ts, ok, err := c.probe(mid)
+		if err != nil {
+			return err
+		}
+		if !ok || ts >= start {
+			hi = mid
+		} else {
+			lo = mid + 1
+		}
+	}
+	c.seq = lo
+	c.ts = start
+	return nil
+}
+
+// 从seq开始向后找第一个可以用来比较时间的消息
+func (c *cursor) probe(seq uint64) (uint64, bool, error) {
+	for i := seq; i < c.total && i < seq+seek_max_probe; i++ {
+		val, err := c.file.db.Get(utils.UintTobytes(i), nil)
+		if err != nil && c.skipBad {
+			continue
+		}
+		if err != nil {
+			return 0, false, err
+		}
+		if c.file.journal {
+			if e, ok := stg.DecodeJournal(val); ok {
+				return e.Recv, true, nil
+			}
+			continue
+		}
+		tid, sinfo := stg.DecodeQuote(val)
+		if sinfo == nil || tid == protocol.FID_QUOTE_KLine || sinfo.GetTimestamp() == 0 {
+			continue
+		}
+		return sinfo.GetTimestamp(), true, nil
+	}
+	return 0, false, nil
+}
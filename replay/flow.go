@@ -0,0 +1,140 @@
+package replay
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	"chive/config"
+	"chive/logs"
+	"chive/protocol"
+	"chive/stg"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+/*
+ 复盘实盘的下单过程，把stg记录的交易日志和行情按时间合并写到一个文件里
+
+ 1. 交易日志和行情目录一起放到合并的堆里，交易日志的时间是stg收到消息的时间
+    行情的时间是交易所的时间戳，两个时钟不同，本地时钟的偏差和kafka的延迟都会让顺序有出入，
+    文件开头用#注释说明，对照请求和回应时以serial为准
+ 2. 交易日志不发给krang，只写到文件里，行情照常回放，同时也写到文件里
+ 3. 每行：时间 来源(quote、req、rsp) 交易所 消息类型 protobuf的文本格式，解不开的交易日志写成bad package，
+    设置了replay::skip_bad时和行情一样跳过
+ 4. 行情很多，最好用-sinfo和-fids只留下下单的合约和需要的行情类型
+*/
+
+type flowWriter struct {
+	f     *os.File
+	w     *bufio.Writer
+	lines uint64
+}
+
+// 复盘文件开头的说明
+var flowHeader = []string{
+	"# 时间 来源 交易所 消息类型 内容",
+	"# quote的时间是交易所的行情时间戳，req和rsp的时间是stg收到消息时的本地时间",
+	"# 两个时钟不同，本地时钟的偏差和kafka的延迟会让行情和交易日志的先后有出入，请求和回应以serial对应",
+}
+
+func newFlowWriter(filename string) (*flowWriter, error) {
+	f, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+	fw := &flowWriter{f: f, w: bufio.NewWriter(f)}
+	for _, line := range flowHeader {
+		fmt.Fprintln(fw.w, line)
+	}
+	return fw, nil
+}
+
+func (fw *flowWriter) write(c *cursor) {
+	source := "quote"
+	name, text := stg.FormatMessage(c.val)
+	if c.file.journal {
+		source = stg.JournalDirStr(c.dir)
+		p := &protocol.FixPackage{}
+		if p.ParseFromArray(c.val) {
+			text = fmt.Sprintf("serial:%d %s", p.GetReqSerial(), text)
+		} else {
+			text = fmt.Sprintf("bad package, seq:%d, %d bytes", c.seq-1, len(c.val))
+			logs.Error("[%s_%s]交易日志第%d条解包失败, 长度%d", c.file.ex, c.file.day, c.seq-1, len(c.val))
+		}
+	}
+	fmt.Fprintf(fw.w, "%s %-5s %s %s %s\n", msTime(c.ts).Format("2006-01-02 15:04:05.000"), source, c.file.ex, name, text)
+	fw.lines += 1
+}
+
+func (fw *flowWriter) Close() error {
+	err := fw.w.Flush()
+	if cerr := fw.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+/*
+ 打开每个交易所每天的交易日志，没有交易日志的日期跳过
+*/
+func (r *Replay) openJournals(dataDir string, exchanges []string, days []string) error {
+	o := opt.Options{ReadOnly: true, ErrorIfMissing: true}
+	for _, ex := range exchanges {
+		for _, d := range days {
+			filename := stg.JournalFileName(dataDir, ex, d)
+			dir, cleanup, err := stg.OpenQuoteDir(filename)
+			if os.IsNotExist(err) {
+				logs.Info("[%s_%s]没有交易日志", ex, d)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			db, err := leveldb.OpenFile(dir, &o)
+			if err != nil {
+				cleanup()
+				if os.IsNotExist(err) {
+					logs.Info("[%s_%s]没有交易日志", ex, d)
+					continue
+				}
+				logs.Error("open journal file error [%s], file[%s]", err.Error(), filename)
+				return err
+			}
+			r.files = append(r.files, &replayFile{
+				ex:      ex,
+				day:     d,
+				db:      db,
+				index:   len(r.files),
+				cleanup: cleanup,
+				journal: true,
+			})
+		}
+	}
+	return nil
+}
+
+/*
+ 按配置打开交易日志和复盘文件，在open之后调用
+*/
+func (r *Replay) openFlow(cnf *config.AppCnf) error {
+	if cnf.Replay.Flow == "" {
+		return nil
+	}
+	days, err := replayDays(cnf, r.filter)
+	if err != nil {
+		return err
+	}
+	if err := r.openJournals(cnf.StgPath, cnf.Exchanges, days); err != nil {
+		return err
+	}
+
+	fw, err := newFlowWriter(cnf.Replay.Flow)
+	if err != nil {
+		return err
+	}
+	r.flow = fw
+	logs.Info("复盘文件[%s]", cnf.Replay.Flow)
+	return nil
+}
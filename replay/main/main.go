@@ -1,9 +1,13 @@
 package main
 
 import (
+	"bufio"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
 	"chive/config"
@@ -14,15 +18,39 @@ import (
 	"chive/utils"
 )
 
+// 设置了报告目录就是回测模式
+var backtestDir = flag.String("b", "", "backtest mode, write report to this dir")
+
+// 回放过滤，设置后覆盖配置文件里的replay节
+var (
+	startTime   = flag.String("start", "", "replay start time, 2006-01-02 15:04:05")
+	endTime     = flag.String("end", "", "replay end time, 2006-01-02 15:04:05")
+	instruments = flag.String("sinfo", "", "replay instruments, exchange_symbol_contractType, split by ;")
+	fids        = flag.String("fids", "", "replay quote types, tick;kline;depth;trade;index")
+	skipBad     = flag.Bool("skipbad", false, "skip missing and corrupt records instead of stopping the replay")
+	flow        = flag.String("flow", "", "write the archer journal and the quotes in time order to this file")
+)
+
+// 回放速度
+var (
+	pace   = flag.String("pace", "fast", "replay pace, fast, speed or step")
+	speed  = flag.Float64("speed", 1, "replay speed in speed pace, 1 is the original speed")
+	stepBy = flag.String("step", "msg", "step by msg or bar(1 minute kline) in step pace")
+)
+
 func main() {
 	utils.InitCnf()
 	utils.InitLogger("replay", logs.LevelDebug)
+	applyFlags()
 
 	logs.Info("****************************************************")
 	logs.Info("replay start...")
 	logs.Info("appId: ", config.T.AppID)
 	logs.Info("config file: ", config.T.CnfPath)
 	logs.Info("replay stay debug log level")
+	if *backtestDir != "" {
+		logs.Info("backtest mode, report dir: ", *backtestDir)
+	}
 	logs.Info("  ")
 	logs.Info("  ")
 	logs.Info("  ")
@@ -34,27 +62,84 @@ func main() {
 	}
 }
 
+func applyFlags() {
+	if *startTime != "" {
+		config.T.Replay.Start = *startTime
+	}
+	if *endTime != "" {
+		config.T.Replay.End = *endTime
+	}
+	if *instruments != "" {
+		config.T.Replay.Instruments = strings.Split(*instruments, ";")
+	}
+	if *fids != "" {
+		config.T.Replay.Fids = strings.Split(*fids, ";")
+	}
+	if *skipBad {
+		config.T.Replay.SkipBad = true
+	}
+	if *flow != "" {
+		config.T.Replay.Flow = *flow
+	}
+}
+
 func RunServer() error {
 	// 在这里注册需要测试回放的策略
 	mavg.RegisStrategy()
 
+	p, err := replay.NewPacer(*pace, *speed, *stepBy)
+	if err != nil {
+		return err
+	}
+	replay.SetPacer(p)
+
 	ch := make(chan int)
 	r, err := replay.StartReplay(ch)
 	if err != nil {
 		return err
 	}
+	if *pace == replay.PACE_STEP {
+		go stepLoop(r)
+	}
 
 	krang.SetKrangReplay(r)
-	err = krang.StartKrang(ch, true)
+	if *backtestDir != "" {
+		krang.SetKrangBacktest(*backtestDir)
+	}
+
+	// krang在回放的消息队列关闭后退出，不能和replay共用退出通道
+	kch := make(chan int)
+	err = krang.StartKrang(kch, true)
 	if err != nil {
 		return err
 	}
 
-	serverLoop(ch)
+	serverLoop(kch)
 	return nil
 }
 
-func serverLoop(ch chan int) {
+/*
+ 单步模式从标准输入读取命令，回车回放一步，输入数字N回放N步
+*/
+func stepLoop(r *replay.Replay) {
+	fmt.Println("step mode, press enter to replay one step, or input a number N to replay N steps")
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		n := 1
+		if line != "" {
+			v, err := strconv.Atoi(line)
+			if err != nil || v <= 0 {
+				fmt.Println("invalid step command:", line)
+				continue
+			}
+			n = v
+		}
+		r.Step(n)
+	}
+}
+
+func serverLoop(kch chan int) {
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, os.Interrupt)
 
@@ -62,10 +147,11 @@ func serverLoop(ch chan int) {
 		select {
 		case <-signals:
 			logs.Info("recv a break signal, exit replay ...")
+			close(kch)
 			<-time.After(3 * time.Second)
 			return
 
-		case <-ch:
+		case <-krang.Done():
 			logs.Info("replay is all done. ")
 			return
 		}
@@ -0,0 +1,238 @@
+package replay
+
+import (
+	"container/heap"
+	"fmt"
+
+	"chive/logs"
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+
+	"github.com/Shopify/sarama"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+ 多个回放目录按行情时间合并
+
+ 1. 每个目录一个游标，所有游标放在一个小根堆里，每次取时间最早的一条消息
+ 2. 消息的时间是行情里的时间戳，没有时间戳的消息使用该目录上一条消息的时间
+ 3. 每个目录的时间只会向前走，K线这种时间戳比前面的行情早的消息不会被提前，目录内的顺序和存储的顺序一样
+ 4. 时间一样时，按配置里交易所和日期的顺序回放
+*/
+
+type replayFile struct {
+	ex      string
+	day     string
+	db      *leveldb.DB
+	index   int    // 打开的顺序
+	cleanup func() // 删除打包日期解压的临时目录
+	journal bool   // 交易日志，不是行情
+}
+
+type cursor struct {
+	file    *replayFile
+	seq     uint64
+	total   uint64
+	ts      uint64                  // 当前消息的时间，毫秒
+	val     []byte                  // 当前消息
+	tid     uint32                  // 当前消息的类型
+	sinfo   *protocol.PBQuoteSymbol // 当前消息的商品信息，不是行情消息时为nil
+	skipBad bool                    // 跳过缺失和损坏的记录
+	skipped uint64                  // 跳过的记录数
+	dir     byte                    // 交易日志的方向
+}
+
+// 跳过记录时最多在日志里报告多少条
+const max_skip_logs = 10
+
+type cursorHeap []*cursor
+
+func (h cursorHeap) Len() int { return len(h) }
+
+func (h cursorHeap) Less(i, j int) bool {
+	if h[i].ts == h[j].ts {
+		return h[i].file.index < h[j].file.index
+	}
+	return h[i].ts < h[j].ts
+}
+
+func (h cursorHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *cursorHeap) Push(x interface{}) {
+	*h = append(*h, x.(*cursor))
+}
+
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	old[n-1] = nil
+	*h = old[0 : n-1]
+	return c
+}
+
+// 取出消息里行情的时间戳，不是行情消息或者没有时间戳返回false
+func msgTimestamp(val []byte) (uint64, bool) {
+	_, sinfo := stg.DecodeQuote(val)
+	ts := sinfo.GetTimestamp()
+	return ts, ts > 0
+}
+
+func newCursor(f *replayFile, skipBad bool) (*cursor, error) {
+	tdata, err := f.db.Get(countKey, nil)
+	if err != nil {
+		logs.Error("读取countkey失败, [%s_%s]", f.ex, f.day)
+		return nil, err
+	}
+	c := &cursor{
+		file:    f,
+		seq:     0,
+		total:   utils.BytesToUint(tdata),
+		skipBad: skipBad,
+	}
+	logs.Info("[%s_%s]共有[%d]条记录", f.ex, f.day, c.total)
+	return c, nil
+}
+
+/*
+ 读取游标的下一条消息，没有消息时返回false
+ 跳过模式下缺失、读取失败和解包失败的记录直接跳过，否则读取失败时停止回放
+ 交易日志的记录前面有接收时间和方向，跳过模式下检查去掉头之后的FixPackage
+*/
+func (c *cursor) next() (bool, error) {
+	for c.seq < c.total {
+		val, err := c.file.db.Get(utils.UintTobytes(c.seq), nil)
+		if err == nil {
+			if c.file.journal {
+				err = c.nextJournal(val)
+			} else {
+				err = c.nextQuote(val)
+			}
+		}
+		if err == nil {
+			return true, nil
+		}
+		if !c.skipBad {
+			logs.Error("[%s_%s]读取[%d]条记录时失败", c.file.ex, c.file.day, c.seq)
+			return false, err
+		}
+		c.skip(err)
+	}
+	return false, nil
+}
+
+func (c *cursor) nextQuote(val []byte) error {
+	if c.skipBad {
+		if err := stg.CheckRecord(val); err != nil {
+			return err
+		}
+	}
+	c.seq += 1
+	c.val = val
+	c.tid, c.sinfo = stg.DecodeQuote(val)
+	if ts := c.sinfo.GetTimestamp(); ts > c.ts {
+		c.ts = ts
+	}
+	return nil
+}
+
+// 交易日志的时间是stg收到消息的时间
+func (c *cursor) nextJournal(val []byte) error {
+	e, ok := stg.DecodeJournal(val)
+	if !ok {
+		return fmt.Errorf("bad journal record %d", c.seq)
+	}
+	if c.skipBad {
+		if err := stg.CheckRecord(e.Value); err != nil {
+			return err
+		}
+	}
+	p := &protocol.FixPackage{}
+	p.ParseFromArray(e.Value)
+	c.seq += 1
+	c.val = e.Value
+	c.tid, c.sinfo, c.dir = p.GetTid(), nil, e.Dir
+	if e.Recv > c.ts {
+		c.ts = e.Recv
+	}
+	return nil
+}
+
+// 跳过当前的记录
+func (c *cursor) skip(err error) {
+	if c.skipped < max_skip_logs {
+		logs.Error("[%s_%s]跳过第[%d]条记录: %s", c.file.ex, c.file.day, c.seq, err.Error())
+	}
+	c.skipped += 1
+	c.seq += 1
+}
+
+func makeMessage(c *cursor) *sarama.ConsumerMessage {
+	return &sarama.ConsumerMessage{
+		Key:   []byte(c.file.ex),
+		Value: sarama.ByteEncoder(c.val),
+	}
+}
+
+/*
+ 按时间顺序合并全部目录的消息，过滤后交给emit处理
+*/
+func mergeFiles(r *Replay, emit func(c *cursor)) error {
+	h := make(cursorHeap, 0, len(r.files))
+	all := make([]*cursor, 0, len(r.files))
+	defer func() {
+		for _, c := range all {
+			if c.skipped > 0 {
+				logs.Error("[%s_%s]共跳过[%d]条缺失或损坏的记录", c.file.ex, c.file.day, c.skipped)
+			}
+		}
+	}()
+
+	for _, f := range r.files {
+		c, err := newCursor(f, r.skipBad)
+		if err != nil {
+			return err
+		}
+		all = append(all, c)
+		if err := c.seek(r.filter.start); err != nil {
+			return err
+		}
+		if c.seq > 0 {
+			logs.Info("[%s_%s]跳过开始时间之前的[%d]条记录", f.ex, f.day, c.seq)
+		}
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, c)
+		}
+	}
+	heap.Init(&h)
+
+	for h.Len() > 0 {
+		c := h[0]
+		if r.filter.afterEnd(c.ts) {
+			logs.Info("[%s_%s]超过结束时间", c.file.ex, c.file.day)
+			heap.Pop(&h)
+			continue
+		}
+		if r.filter.match(c.file.ex, c) {
+			emit(c)
+		}
+
+		ok, err := c.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			logs.Info("[%s_%s]回放完毕", c.file.ex, c.file.day)
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package replay
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"chive/logs"
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+/*
+ 回放速度控制
+
+ 1. fast     尽快回放，默认
+ 2. speed    按行情时间的N倍速回放，1倍就是实盘的速度
+ 3. step     单步回放，每个命令回放一条消息或者一根1分钟K线
+*/
+
+const (
+	PACE_FAST  = "fast"
+	PACE_SPEED = "speed"
+	PACE_STEP  = "step"
+)
+
+const (
+	STEP_BY_MSG = "msg"
+	STEP_BY_BAR = "bar"
+)
+
+var (
+	errInvalidPace  = errors.New("replay pace should be fast, speed or step")
+	errInvalidSpeed = errors.New("replay speed should be greater than 0")
+	errInvalidStep  = errors.New("replay step should be msg or bar")
+)
+
+type Pacer interface {
+	// 投递一条消息之前调用，ts是消息的时间，毫秒，需要时会阻塞
+	Wait(ts uint64, tid uint32, val []byte)
+}
+
+func NewPacer(mode string, speed float64, stepBy string) (Pacer, error) {
+	switch mode {
+	case "", PACE_FAST:
+		return NewFastPacer(), nil
+	case PACE_SPEED:
+		if speed <= 0 {
+			return nil, errInvalidSpeed
+		}
+		return NewSpeedPacer(speed), nil
+	case PACE_STEP:
+		if stepBy != STEP_BY_MSG && stepBy != STEP_BY_BAR {
+			return nil, errInvalidStep
+		}
+		return NewStepPacer(stepBy == STEP_BY_BAR), nil
+	}
+	return nil, errInvalidPace
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type fastPacer struct {
+}
+
+func NewFastPacer() Pacer {
+	return &fastPacer{}
+}
+
+func (p *fastPacer) Wait(ts uint64, tid uint32, val []byte) {
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+ 按行情时间的间隔等待，第一条消息的时间对应开始回放的本机时间
+*/
+type speedPacer struct {
+	speed    float64
+	baseTs   uint64
+	baseWall time.Time
+}
+
+func NewSpeedPacer(speed float64) Pacer {
+	return &speedPacer{speed: speed}
+}
+
+func (p *speedPacer) Wait(ts uint64, tid uint32, val []byte) {
+	if p.baseTs == 0 {
+		p.baseTs = ts
+		p.baseWall = time.Now()
+		return
+	}
+	if ts <= p.baseTs {
+		return
+	}
+	d := time.Duration(float64(ts-p.baseTs) / p.speed * float64(time.Millisecond))
+	if wait := p.baseWall.Add(d).Sub(time.Now()); wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+/*
+ 单步回放，Step给出可以回放的步数
+ 按消息单步时每条消息一步，按K线单步时每根新的1分钟K线一步，同一根K线的更新不算
+*/
+type StepPacer struct {
+	byBar bool
+	bars  map[string]uint64 // 每个合约最新的1分钟K线时间
+	steps int
+	mu    sync.Mutex
+	cond  *sync.Cond
+}
+
+func NewStepPacer(byBar bool) *StepPacer {
+	p := &StepPacer{
+		byBar: byBar,
+		bars:  make(map[string]uint64),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	return p
+}
+
+// 增加n步
+func (p *StepPacer) Step(n int) {
+	if n <= 0 {
+		return
+	}
+	p.mu.Lock()
+	p.steps += n
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+func (p *StepPacer) Wait(ts uint64, tid uint32, val []byte) {
+	if p.byBar && !p.isNewBar(tid, val) {
+		return
+	}
+
+	p.mu.Lock()
+	for p.steps <= 0 {
+		logs.Info("单步回放暂停在[%s]", msTime(ts).Format(replay_time_layout))
+		p.cond.Wait()
+	}
+	p.steps -= 1
+	p.mu.Unlock()
+}
+
+// 是否是新的1分钟K线
+func (p *StepPacer) isNewBar(tid uint32, val []byte) bool {
+	if tid != protocol.FID_QUOTE_KLine {
+		return false
+	}
+	pkg := &protocol.FixPackage{}
+	if !pkg.ParseFromArray(val) {
+		return false
+	}
+	pb := &protocol.PBFutureKLine{}
+	if err := proto.Unmarshal(pkg.GetPayload(), pb); err != nil || pb.GetKind() != protocol.KL1Min {
+		return false
+	}
+
+	si := pb.GetSinfo()
+	key := utils.MakeupSinfo(si.GetExchange(), si.GetSymbol(), si.GetContractType())
+	if p.bars[key] == si.GetTimestamp() {
+		return false
+	}
+	p.bars[key] = si.GetTimestamp()
+	return true
+}
@@ -1,8 +1,13 @@
 package replay
 
 import (
+	"path/filepath"
+	"sort"
+	"time"
+
 	"chive/config"
 	"chive/logs"
+	"chive/stg"
 	"chive/utils"
 
 	"github.com/Shopify/sarama"
@@ -15,17 +20,37 @@ var countKey []byte = []byte("-1")
 
 const max_ch_len = 100
 
+var pacer Pacer
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
 type Replay struct {
-	msgq chan *sarama.ConsumerMessage
-	dbm  map[string][]*leveldb.DB
+	msgq    chan *sarama.ConsumerMessage
+	files   []*replayFile // 按配置里交易所和日期的顺序
+	filter  *filter
+	pacer   Pacer
+	skipBad bool        // 跳过缺失和损坏的记录
+	flow    *flowWriter // 复盘文件，没有配置时为nil
 }
 
 func NewReplay() *Replay {
 	return &Replay{
-		msgq: make(chan *sarama.ConsumerMessage, max_ch_len),
-		dbm:  make(map[string][]*leveldb.DB),
+		msgq:   make(chan *sarama.ConsumerMessage, max_ch_len),
+		files:  make([]*replayFile, 0),
+		filter: &filter{},
+		pacer:  NewFastPacer(),
+	}
+}
+
+// 设置回放速度，在StartReplay之前调用
+func SetPacer(p Pacer) {
+	pacer = p
+}
+
+// 单步模式下回放n步
+func (r *Replay) Step(n int) {
+	if sp, ok := r.pacer.(*StepPacer); ok {
+		sp.Step(n)
 	}
 }
 
@@ -37,11 +62,13 @@ func (r *Replay) ReadMessages() <-chan *sarama.ConsumerMessage {
 
 func StartReplay(ch chan int) (*Replay, error) {
 	r := NewReplay()
-	exchanges := config.T.Exchanges
-	days := config.T.Replay.Days
-	dirs, exs := makeupReplayDirs(exchanges, days)
-	err := openFiles(dirs, exs, r)
-	if err != nil {
+	if pacer != nil {
+		r.pacer = pacer
+	}
+	if err := r.open(config.T); err != nil {
+		return r, err
+	}
+	if err := r.openFlow(config.T); err != nil {
 		return r, err
 	}
 
@@ -49,11 +76,81 @@ func StartReplay(ch chan int) (*Replay, error) {
 	return r, nil
 }
 
+/*
+ 按配置读出全部要回放的消息，参数优化时多个回测共用一份数据
+*/
+func LoadMessages(cnf *config.AppCnf) ([]*sarama.ConsumerMessage, error) {
+	r := NewReplay()
+	defer r.closeFiles()
+	if err := r.open(cnf); err != nil {
+		return nil, err
+	}
+
+	msgs := make([]*sarama.ConsumerMessage, 0)
+	err := mergeFiles(r, func(c *cursor) {
+		msgs = append(msgs, makeMessage(c))
+	})
+	if err != nil {
+		return nil, err
+	}
+	logs.Info("读取了[%d]个目录的[%d]条消息", len(r.files), len(msgs))
+	return msgs, nil
+}
+
+/*
+ 回放LoadMessages读出的消息，全部投递后关闭消息队列
+*/
+func NewMemReplay(msgs []*sarama.ConsumerMessage) *Replay {
+	r := NewReplay()
+	go func() {
+		for _, msg := range msgs {
+			r.msgq <- msg
+		}
+		close(r.msgq)
+	}()
+	return r
+}
+
+// 按配置创建过滤器，打开要回放的目录
+func (r *Replay) open(cnf *config.AppCnf) error {
+	f, err := newFilterFromCnf(cnf)
+	if err != nil {
+		return err
+	}
+	r.filter = f
+	r.skipBad = cnf.Replay.SkipBad
+
+	days, err := replayDays(cnf, f)
+	if err != nil {
+		return err
+	}
+	dirs, exs := makeupReplayDirs(cnf.StgPath, cnf.Exchanges, days)
+	return openFiles(dirs, exs, r)
+}
+
+// 要回放的日期，没有配置日期时按开始和结束时间计算
+func replayDays(cnf *config.AppCnf, f *filter) ([]string, error) {
+	if len(cnf.Replay.Days) > 0 {
+		return cnf.Replay.Days, nil
+	}
+	td, err := utils.NewTradingDay(cnf.TradingDay.Timezone, cnf.TradingDay.Boundary)
+	if err != nil {
+		return nil, err
+	}
+	return filterDays(f, td), nil
+}
+
+func (r *Replay) closeFiles() {
+	for _, f := range r.files {
+		f.db.Close()
+		f.cleanup()
+	}
+}
+
 // 根据配置找到要回放的目录
-func makeupReplayDirs(exchanges []string, days []string) ([]string, []string) {
+func makeupReplayDirs(dataDir string, exchanges []string, days []string) ([]string, []string) {
 	ret := []string{}
 	exs := []string{}
-	dataDir := config.T.StgPath
 	for _, ex := range exchanges {
 		for _, d := range days {
 			name := dataDir + ex + "/" + d + "/" + dbName
@@ -64,80 +161,96 @@ func makeupReplayDirs(exchanges []string, days []string) ([]string, []string) {
 	return ret, exs
 }
 
-// 打开每一个目录文件, 不存在会报错
+/*
+ 存储目录下全部交易所都有行情的日期，包括打包的日期，按日期排序
+*/
+func AvailableDays(cnf *config.AppCnf) ([]string, error) {
+	counts := make(map[string]int)
+	for _, ex := range cnf.Exchanges {
+		days, err := stg.ListDays(cnf.StgPath, ex)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range days {
+			if _, err := time.Parse("2006-01-02", d); err != nil {
+				continue
+			}
+			counts[d] += 1
+		}
+	}
+
+	ret := []string{}
+	for d, n := range counts {
+		if n == len(cnf.Exchanges) {
+			ret = append(ret, d)
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
+// 打开每一个目录文件, 不存在会报错，打包的日期先解压到临时目录
 func openFiles(dirs []string, exs []string, r *Replay) error {
 	o := opt.Options{ErrorIfMissing: true}
 	for i, filename := range dirs {
-		db, err := leveldb.OpenFile(filename, &o)
+		dir, cleanup, err := stg.OpenQuoteDir(filename)
+		if err != nil {
+			logs.Error("open leveldb file error [%s], file[%s]", err.Error(), filename)
+			return err
+		}
+		db, err := leveldb.OpenFile(dir, &o)
 		if err != nil {
+			cleanup()
 			logs.Error("open leveldb file error [%s], file[%s]", err.Error(), filename)
 			return err
 		}
-		key := exs[i]
-		arr, ok := r.dbm[key]
-		if !ok {
-			arr = []*leveldb.DB{}
+		f := &replayFile{
+			ex:      exs[i],
+			day:     filepath.Base(filepath.Dir(filename)),
+			db:      db,
+			index:   i,
+			cleanup: cleanup,
 		}
-		arr = append(arr, db)
-		r.dbm[key] = arr
+		r.files = append(r.files, f)
 	}
 	return nil
 }
 
+/*
+ 全部目录按行情时间合并后回放，多个交易所和多天的行情交错的顺序和实盘一样
+*/
 func readLoop(r *Replay, ch chan int) {
 	defer doExit(r, ch)
 
-	var c = 1
-	var total = 0
-	for _, arr := range r.dbm {
-		total += len(arr)
-	}
-
-	for ex, arr := range r.dbm {
-		for _, v := range arr {
-			logs.Info("正在回放第[%d]个目录，共[%d]个目录", c, total)
-			err := readOneFile(r, v, ex)
-			if err != nil {
-				return
-			}
-			logs.Info("第[%d]个目录回放完毕", c)
-			c += 1
+	logs.Info("开始回放，共[%d]个目录", len(r.files))
+	err := mergeFiles(r, func(c *cursor) {
+		if r.flow != nil {
+			r.flow.write(c)
 		}
+		// 交易日志只写到复盘文件里
+		if c.file.journal {
+			return
+		}
+		r.pacer.Wait(c.ts, c.tid, c.val)
+		r.msgq <- makeMessage(c)
+	})
+	if err != nil {
+		logs.Error("回放失败: %s", err.Error())
+		return
 	}
+	logs.Info("全部目录回放完毕")
 }
 
+// 关闭消息队列，读消息的一方可以知道回放结束
 func doExit(r *Replay, ch chan int) {
-	for _, arr := range r.dbm {
-		for _, v := range arr {
-			v.Close()
+	r.closeFiles()
+	if r.flow != nil {
+		if err := r.flow.Close(); err != nil {
+			logs.Error("write flow file error [%s]", err.Error())
 		}
+		logs.Info("复盘文件写入[%d]行", r.flow.lines)
 	}
+	close(r.msgq)
 	close(ch)
 	logs.Info("replay read loop exit...")
 }
-
-func readOneFile(r *Replay, db *leveldb.DB, ex string) error {
-	tdata, err := db.Get(countKey, nil)
-	if err != nil {
-		logs.Error("读取countkey失败")
-		return err
-	}
-	total := utils.BytesToUint(tdata)
-	logs.Info("共有[%d]条记录", total)
-
-	var i uint64
-	for i = 0; i < total; i++ {
-		val, err := db.Get(utils.UintTobytes(i), nil)
-		if err != nil {
-			logs.Error("读取[%d]条记录时失败", i)
-			return err
-		}
-
-		msg := &sarama.ConsumerMessage{
-			Key:   []byte(ex),
-			Value: sarama.ByteEncoder(val),
-		}
-		r.msgq <- msg
-	}
-	return nil
-}
@@ -2,14 +2,26 @@ package replay
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"chive/config"
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
 )
 
 func TestReplay(t *testing.T) {
 	r := NewReplay()
 	exchanges := []string{"../build/data/okex"}
 	days := []string{"2017-12-24"}
-	dirs, exs := makeupReplayDirs(exchanges, days)
+	dirs, exs := makeupReplayDirs("", exchanges, days)
 
 	fmt.Println("dirs :", dirs)
 
@@ -28,3 +40,327 @@ func TestReplay(t *testing.T) {
 	}()
 	<-ch
 }
+
+func makeTick(exchange string, ts uint64) []byte {
+	return makeQuote(protocol.FID_QUOTE_TICK, exchange, "ltc_usd", ts)
+}
+
+func makeQuote(tid uint32, exchange string, symbol string, ts uint64) []byte {
+	sinfo := &protocol.PBQuoteSymbol{
+		Exchange:     proto.String(exchange),
+		Symbol:       proto.String(symbol),
+		ContractType: proto.String("this_week"),
+		Timestamp:    proto.Uint64(ts),
+	}
+	var pb proto.Message
+	switch tid {
+	case protocol.FID_QUOTE_KLine:
+		pb = &protocol.PBFutureKLine{Sinfo: sinfo, Kind: proto.Int32(protocol.KL1Min)}
+	case protocol.FID_QUOTE_Depth:
+		pb = &protocol.PBFutureDepth{Sinfo: sinfo}
+	default:
+		pb = &protocol.PBFutureTick{Sinfo: sinfo}
+	}
+	bin, _ := proto.Marshal(pb)
+	p := &protocol.FixPackage{}
+	p.Tid = tid
+	p.Payload = bin
+	return p.SerialToArray()
+}
+
+func writeDB(t *testing.T, dir string, vals [][]byte) {
+	db, err := leveldb.OpenFile(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for i, v := range vals {
+		db.Put(utils.UintTobytes(uint64(i)), v, nil)
+	}
+	db.Put(countKey, utils.UintTobytes(uint64(len(vals))), nil)
+}
+
+func TestMergeByTimestamp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 第二个目录里没有时间戳的消息跟着前一条消息
+	bad := (&protocol.FixPackage{Tid: protocol.FID_RspQryPosInfo}).SerialToArray()
+	d1 := dir + "/okex/2017-12-25/quote"
+	d2 := dir + "/bitmex/2017-12-25/quote"
+	writeDB(t, d1, [][]byte{makeTick("okex", 1000), makeTick("okex", 3000), makeTick("okex", 5000)})
+	writeDB(t, d2, [][]byte{makeTick("bitmex", 2000), bad, makeTick("bitmex", 3000), makeTick("bitmex", 6000)})
+
+	r := NewReplay()
+	if err := openFiles([]string{d1, d2}, []string{"okex", "bitmex"}, r); err != nil {
+		t.Fatal(err)
+	}
+	ch := make(chan int)
+	go readLoop(r, ch)
+
+	got := []string{}
+	for msg := range r.ReadMessages() {
+		ts, _ := msgTimestamp(msg.Value)
+		got = append(got, fmt.Sprintf("%s:%d", msg.Key, ts))
+	}
+	<-ch
+
+	want := []string{"okex:1000", "bitmex:2000", "bitmex:0", "okex:3000", "bitmex:3000", "okex:5000", "bitmex:6000"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("merge order %v, want %v", got, want)
+	}
+}
+
+func TestSkipBad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 第1条缺失，第2条不完整，countKey比记录多一条
+	d := dir + "/okex/2017-12-25/quote"
+	writeDB(t, d, [][]byte{makeTick("okex", 1000), makeTick("okex", 2000), makeTick("okex", 3000)[:20], makeTick("okex", 4000)})
+	db, _ := leveldb.OpenFile(d, nil)
+	db.Delete(utils.UintTobytes(1), nil)
+	db.Put(countKey, utils.UintTobytes(5), nil)
+	db.Close()
+
+	load := func(skipBad bool) ([]string, error) {
+		r := NewReplay()
+		r.skipBad = skipBad
+		defer r.closeFiles()
+		if err := openFiles([]string{d}, []string{"okex"}, r); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		err := mergeFiles(r, func(c *cursor) {
+			got = append(got, fmt.Sprintf("%d", c.ts))
+		})
+		return got, err
+	}
+
+	if _, err := load(false); err == nil {
+		t.Fatal("replay a missing record without error")
+	}
+	got, err := load(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "1000,4000" {
+		t.Fatalf("skip bad records %v", got)
+	}
+
+	// 修复后序号连续，不需要跳过
+	v, err := stg.RepairDay(dir+"/", "okex", "2017-12-25")
+	if err != nil || len(v.Gaps) != 2 || len(v.Corrupt) != 1 || v.OK() {
+		t.Fatalf("repair %+v, %v", v, err)
+	}
+	if v, err = stg.VerifyDay(dir+"/", "okex", "2017-12-25"); err != nil || !v.OK() || v.Count != 2 {
+		t.Fatalf("verify after repair %+v, %v", v, err)
+	}
+	if got, err = load(false); err != nil || strings.Join(got, ",") != "1000,4000" {
+		t.Fatalf("replay after repair %v, %v", got, err)
+	}
+}
+
+func TestFlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	req := (&protocol.FixPackage{Tid: protocol.FID_ReqSetOrder, ReqSerial: 7}).SerialToArray()
+	rsp := (&protocol.FixPackage{Tid: protocol.FID_RspSetOrder, ReqSerial: 7}).SerialToArray()
+	writeDB(t, dir+"/okex/2017-12-25/quote", [][]byte{makeTick("okex", 1000), makeTick("okex", 3000)})
+	writeDB(t, dir+"/okex/2017-12-25/journal", [][]byte{
+		stg.EncodeJournal(1500, stg.JOURNAL_REQ, req), stg.EncodeJournal(2000, stg.JOURNAL_REQ, []byte{1, 2, 3}),
+		stg.EncodeJournal(2500, stg.JOURNAL_RSP, rsp)})
+
+	// 跳过模式下只跳过解不开的交易日志
+	cases := []struct {
+		skipBad bool
+		want    []string
+	}{
+		{false, []string{"quote okex PBFutureTick", "req   okex PBFReqSetOrder serial:7", "req   okex unknown bad package, seq:1, 3 bytes",
+			"rsp   okex PBFRspSetOrder serial:7", "quote okex PBFutureTick"}},
+		{true, []string{"quote okex PBFutureTick", "req   okex PBFReqSetOrder serial:7",
+			"rsp   okex PBFRspSetOrder serial:7", "quote okex PBFutureTick"}},
+	}
+	for _, c := range cases {
+		cnf := &config.AppCnf{StgPath: dir + "/", Exchanges: []string{"okex"}}
+		cnf.Replay.Days = []string{"2017-12-25"}
+		cnf.Replay.Flow = dir + "/flow.txt"
+		cnf.Replay.SkipBad = c.skipBad
+		r := NewReplay()
+		if err := r.open(cnf); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.openFlow(cnf); err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan int)
+		go readLoop(r, ch)
+
+		// 交易日志不发给krang
+		got := []string{}
+		for msg := range r.ReadMessages() {
+			ts, _ := msgTimestamp(msg.Value)
+			got = append(got, fmt.Sprintf("%d", ts))
+		}
+		<-ch
+		if strings.Join(got, ",") != "1000,3000" {
+			t.Fatalf("skip_bad[%v] replay %v", c.skipBad, got)
+		}
+
+		bs, err := ioutil.ReadFile(cnf.Replay.Flow)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(bs)), "\n")
+		if len(lines) <= len(flowHeader) || lines[0] != flowHeader[0] {
+			t.Fatalf("skip_bad[%v] flow header %v", c.skipBad, lines)
+		}
+		lines = lines[len(flowHeader):]
+		if len(lines) != len(c.want) {
+			t.Fatalf("skip_bad[%v] flow %v", c.skipBad, lines)
+		}
+		for i, w := range c.want {
+			if !strings.Contains(lines[i], w) {
+				t.Fatalf("skip_bad[%v] flow line %d: %s, want %s", c.skipBad, i, lines[i], w)
+			}
+		}
+	}
+}
+
+func TestLoadMessages(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeDB(t, dir+"/okex/2017-12-25/quote", [][]byte{makeTick("okex", 1000), makeTick("okex", 3000)})
+	writeDB(t, dir+"/bitmex/2017-12-25/quote", [][]byte{makeTick("bitmex", 2000)})
+
+	cnf := &config.AppCnf{StgPath: dir + "/", Exchanges: []string{"okex", "bitmex"}}
+	cnf.Replay.Days = []string{"2017-12-25"}
+	msgs, err := LoadMessages(cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 两个回放实例读同一份消息
+	for i := 0; i < 2; i++ {
+		got := []string{}
+		for msg := range NewMemReplay(msgs).ReadMessages() {
+			ts, _ := msgTimestamp(msg.Value)
+			got = append(got, fmt.Sprintf("%s:%d", msg.Key, ts))
+		}
+		if want := "okex:1000,bitmex:2000,okex:3000"; strings.Join(got, ",") != want {
+			t.Fatalf("memory replay %v, want %s", got, want)
+		}
+	}
+}
+
+func TestFilter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// 每秒一个ltc的tick，每10秒一个etc的depth和一根时间戳早1分钟的K线
+	vals := [][]byte{}
+	for i := uint64(1); i <= 1000; i++ {
+		vals = append(vals, makeQuote(protocol.FID_QUOTE_TICK, "okex", "ltc_usd", i*1000))
+		if i%10 == 0 {
+			vals = append(vals, makeQuote(protocol.FID_QUOTE_Depth, "okex", "etc_usd", i*1000))
+			vals = append(vals, makeQuote(protocol.FID_QUOTE_KLine, "okex", "ltc_usd", i*1000-60000))
+		}
+	}
+	d := dir + "/okex/2017-12-25/quote"
+	writeDB(t, d, vals)
+
+	run := func(f *filter) []string {
+		r := NewReplay()
+		r.filter = f
+		if err := openFiles([]string{d}, []string{"okex"}, r); err != nil {
+			t.Fatal(err)
+		}
+		ch := make(chan int)
+		go readLoop(r, ch)
+		got := []string{}
+		for msg := range r.ReadMessages() {
+			tid, sinfo := stg.DecodeQuote(msg.Value)
+			got = append(got, fmt.Sprintf("%d:%s:%d", tid, sinfo.GetSymbol(), sinfo.GetTimestamp()))
+		}
+		<-ch
+		return got
+	}
+
+	// 11个tick，2个depth，2根K线，K线按目录的时间过滤
+	f := &filter{start: 500000, end: 510000}
+	got := run(f)
+	if len(got) != 15 || got[0] != "1000:ltc_usd:500000" || got[len(got)-1] != "1001:ltc_usd:450000" {
+		t.Fatalf("time window got %v", got)
+	}
+
+	f, err = newFilter("", "", []string{"okex_etc_usd_this_week"}, []string{"depth"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got = run(f); len(got) != 100 {
+		t.Fatalf("instrument and fid filter got %d messages", len(got))
+	}
+
+	if _, err := newFilter("", "", nil, []string{"book"}); err == nil {
+		t.Fatal("unknown fid should fail")
+	}
+}
+
+func TestPacer(t *testing.T) {
+	// 100倍速，行情过了2秒，本机大约过了20毫秒
+	p, err := NewPacer(PACE_SPEED, 100, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	begin := time.Now()
+	for ts := uint64(1000); ts <= 3000; ts += 500 {
+		p.Wait(ts, protocol.FID_QUOTE_TICK, nil)
+	}
+	if d := time.Since(begin); d < 20*time.Millisecond || d > 500*time.Millisecond {
+		t.Fatalf("speed pacer waited %s", d)
+	}
+
+	// 按K线单步，tick和同一根K线的更新不需要等待，新的1分钟K线需要一步
+	sp := NewStepPacer(true)
+	done := make(chan int, 10)
+	go func() {
+		for i := uint64(1); i <= 3; i++ {
+			bar := makeQuote(protocol.FID_QUOTE_KLine, "okex", "ltc_usd", i*60000)
+			sp.Wait(i*60000, protocol.FID_QUOTE_TICK, nil)
+			sp.Wait(i*60000, protocol.FID_QUOTE_KLine, bar)
+			sp.Wait(i*60000+10000, protocol.FID_QUOTE_KLine, bar)
+			done <- int(i)
+		}
+	}()
+	sp.Step(2)
+	<-done
+	<-done
+	select {
+	case <-done:
+		t.Fatal("step pacer should stop after 2 bars")
+	case <-time.After(50 * time.Millisecond):
+	}
+	sp.Step(1)
+	<-done
+
+	if _, err := NewPacer(PACE_STEP, 0, "line"); err == nil {
+		t.Fatal("invalid step should fail")
+	}
+}
@@ -0,0 +1,347 @@
+package stg
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"chive/config"
+	"chive/logs"
+	"chive/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+ 已经结束的交易日的维护
+
+ 1. 压实：对整个库做一次compaction，完成后在日期目录下放一个COMPACTED文件，不再重复压实
+ 2. 打包：补建索引、压实后把日期目录打包成<stg>/<exchange>/<day>.tar.gz，然后删除目录
+ 3. 删除：超过保留天数的日期连同打包文件一起删除
+ 4. 读取打包的日期时解压到临时目录，用完后删除，replay、stgtool和导出都可以直接读取
+*/
+
+const (
+	compacted_marker = "COMPACTED"
+	archive_ext      = ".tar.gz"
+)
+
+// 维护策略，天数为0表示不做这一项
+type MaintainPolicy struct {
+	Compact      bool
+	ArchiveAfter int
+	RetainDays   int
+}
+
+// 一次维护处理的日期
+type MaintainResult struct {
+	Compacted []string
+	Archived  []string
+	Removed   []string
+	Failed    []string // 处理失败的日期，下次维护时再处理
+}
+
+func dayDir(path string, exchange string, tradingDay string) string {
+	return path + exchange + "/" + tradingDay
+}
+
+func ArchiveFileName(path string, exchange string, tradingDay string) string {
+	return dayDir(path, exchange, tradingDay) + archive_ext
+}
+
+func IsArchived(path string, exchange string, tradingDay string) bool {
+	_, err := os.Stat(ArchiveFileName(path, exchange, tradingDay))
+	return err == nil
+}
+
+/*
+ 压实一天的库，已经压实过时返回false
+*/
+func CompactDay(path string, exchange string, tradingDay string) (bool, error) {
+	marker := filepath.Join(dayDir(path, exchange, tradingDay), compacted_marker)
+	if _, err := os.Stat(marker); err == nil {
+		return false, nil
+	}
+
+	db, err := leveldb.OpenFile(makeDBFileName(path, exchange, tradingDay), &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return false, err
+	}
+	err = db.CompactRange(util.Range{})
+	if cerr := db.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, ioutil.WriteFile(marker, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644)
+}
+
+/*
+ 打包一天的行情，先写到临时文件，完成后改名再删除目录，中途失败不会丢数据
+*/
+func ArchiveDay(path string, exchange string, tradingDay string) error {
+	if _, err := BuildIndex(path, exchange, tradingDay); err != nil {
+		return err
+	}
+	if _, err := CompactDay(path, exchange, tradingDay); err != nil {
+		return err
+	}
+
+	dir := dayDir(path, exchange, tradingDay)
+	archive := ArchiveFileName(path, exchange, tradingDay)
+	tmp := archive + ".tmp"
+	if err := writeArchive(dir, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, archive); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+func writeArchive(dir string, filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == dir {
+			return err
+		}
+		name, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		h, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		h.Name = filepath.ToSlash(name)
+		if err := tw.WriteHeader(h); err != nil || info.IsDir() {
+			return err
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		_, err = io.Copy(tw, src)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func extractArchive(filename string, dir string) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(zr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(h.Name))
+		if strings.HasPrefix(name, "..") || filepath.IsAbs(name) {
+			return errors.New("bad file name in archive " + h.Name)
+		}
+		target := filepath.Join(dir, name)
+		if h.Typeflag == tar.TypeDir {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		out, err := os.Create(target)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+/*
+ 找到一天的quote库，filename的格式是<stg>/<exchange>/<day>/quote
+ 目录不在但是有打包文件时解压到临时目录，返回临时目录下的库，用完后调用返回的函数删除临时目录
+*/
+func OpenQuoteDir(filename string) (string, func(), error) {
+	nop := func() {}
+	if _, err := os.Stat(filename); err == nil {
+		return filename, nop, nil
+	}
+	archive := filepath.Dir(filename) + archive_ext
+	if _, err := os.Stat(archive); err != nil {
+		return "", nop, &os.PathError{Op: "open", Path: filename, Err: os.ErrNotExist}
+	}
+
+	tmp, err := ioutil.TempDir("", "stg-"+filepath.Base(filepath.Dir(filename))+"-")
+	if err != nil {
+		return "", nop, err
+	}
+	cleanup := func() { os.RemoveAll(tmp) }
+	if err := extractArchive(archive, tmp); err != nil {
+		cleanup()
+		return "", nop, err
+	}
+	return filepath.Join(tmp, filepath.Base(filename)), cleanup, nil
+}
+
+func OpenDayDir(path string, exchange string, tradingDay string) (string, func(), error) {
+	return OpenQuoteDir(makeDBFileName(path, exchange, tradingDay))
+}
+
+// 删除一天的目录和打包文件
+func RemoveDay(path string, exchange string, tradingDay string) error {
+	if err := os.RemoveAll(dayDir(path, exchange, tradingDay)); err != nil {
+		return err
+	}
+	err := os.Remove(ArchiveFileName(path, exchange, tradingDay))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// 两个日期之间相差的天数
+func daysBetween(from string, to string) (int, error) {
+	t1, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return 0, err
+	}
+	t2, err := time.Parse("2006-01-02", to)
+	if err != nil {
+		return 0, err
+	}
+	return int(t2.Sub(t1).Hours() / 24), nil
+}
+
+/*
+ 按策略维护一个交易所today之前的交易日，today还在写入，不处理
+ 超过保留天数的删除，超过打包天数的打包，其余的压实
+ 一天失败时记录日志，继续处理后面的日期，全部处理完后返回第一个错误
+*/
+func Maintain(path string, exchange string, today string, policy MaintainPolicy) (*MaintainResult, error) {
+	days, err := ListDays(path, exchange)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &MaintainResult{}
+	var first error
+	for _, day := range days {
+		age, err := daysBetween(day, today)
+		if err != nil {
+			// 不是日期的目录
+			continue
+		}
+		if age <= 0 {
+			continue
+		}
+
+		switch {
+		case policy.RetainDays > 0 && age >= policy.RetainDays:
+			if err = RemoveDay(path, exchange, day); err == nil {
+				ret.Removed = append(ret.Removed, day)
+			}
+
+		case IsArchived(path, exchange, day):
+
+		case policy.ArchiveAfter > 0 && age >= policy.ArchiveAfter:
+			if err = ArchiveDay(path, exchange, day); err == nil {
+				ret.Archived = append(ret.Archived, day)
+			}
+
+		case policy.Compact:
+			var done bool
+			if done, err = CompactDay(path, exchange, day); err == nil && done {
+				ret.Compacted = append(ret.Compacted, day)
+			}
+		}
+
+		if err != nil {
+			logs.Error("stg maintain [%s_%s] error [%s]", exchange, day, err.Error())
+			ret.Failed = append(ret.Failed, day)
+			if first == nil {
+				first = fmt.Errorf("%s %s: %s", exchange, day, err.Error())
+			}
+		}
+	}
+	return ret, first
+}
+
+// 配置文件storage节的维护策略
+func CnfPolicy() MaintainPolicy {
+	return MaintainPolicy{
+		Compact:      config.T.Storage.Compact,
+		ArchiveAfter: config.T.Storage.ArchiveAfter,
+		RetainDays:   config.T.Storage.RetainDays,
+	}
+}
+
+/*
+ stg后台按cron表达式维护全部交易所
+ 刚切换交易日时前一天的库可能还没有关闭，这次失败的日期下次再处理
+*/
+func maintainLoop(spec *utils.CronSpec) {
+	for {
+		now := utils.Now()
+		next := spec.Next(now)
+		if next.IsZero() {
+			logs.Error("stg maintain cron never fires, stop maintain")
+			return
+		}
+		time.Sleep(next.Sub(now))
+
+		today := stgt.td.Day(utils.Now())
+		for _, ex := range config.T.Exchanges {
+			ret, err := Maintain(stgt.path, ex, today, CnfPolicy())
+			if err != nil {
+				logs.Error("stg maintain [%s] error [%s]", ex, err.Error())
+			}
+			if ret != nil {
+				logs.Info("stg维护[%s]完成, 压实%v, 打包%v, 删除%v, 失败%v", ex, ret.Compacted, ret.Archived, ret.Removed, ret.Failed)
+			}
+		}
+	}
+}
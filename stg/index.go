@@ -0,0 +1,155 @@
+package stg
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+ 行情记录的二级索引，和记录写在同一个batch里
+
+ 1. key是t/<exchange_symbol_contractType>/<ts>/<seq>，ts是行情里交易所的时间戳，毫秒，补齐到13位，seq补齐到12位
+    value是消息类型，按合约和时间范围查询时不需要读取记录
+ 2. 没有商品信息的消息不建索引，比如archer的应答
+ 3. indexKey保存已经建好索引的记录数，之前录制的数据没有索引，打开时补建或者用stgtool index补建
+*/
+
+var indexKey = []byte("index")
+
+const index_prefix = "t/"
+
+// 行情消息都有商品信息，里面有时间戳
+type sinfoMsg interface {
+	proto.Message
+	GetSinfo() *protocol.PBQuoteSymbol
+}
+
+/*
+ 解包消息，返回消息类型和行情的商品信息，不是行情消息或者解包失败时商品信息为nil
+*/
+func DecodeQuote(val []byte) (uint32, *protocol.PBQuoteSymbol) {
+	p := &protocol.FixPackage{}
+	if !p.ParseFromArray(val) {
+		return 0, nil
+	}
+
+	var pb sinfoMsg
+	switch p.GetTid() {
+	case protocol.FID_QUOTE_TICK:
+		pb = &protocol.PBFutureTick{}
+	case protocol.FID_QUOTE_KLine:
+		pb = &protocol.PBFutureKLine{}
+	case protocol.FID_QUOTE_Depth:
+		pb = &protocol.PBFutureDepth{}
+	case protocol.FID_QUOTE_Trade:
+		pb = &protocol.PBFutureTrade{}
+	case protocol.FID_QUOTE_Index:
+		pb = &protocol.PBFutureIndex{}
+	default:
+		return p.GetTid(), nil
+	}
+	if err := proto.Unmarshal(p.GetPayload(), pb); err != nil {
+		return p.GetTid(), nil
+	}
+	return p.GetTid(), pb.GetSinfo()
+}
+
+func instrumentPrefix(instrument string) string {
+	return index_prefix + instrument + "/"
+}
+
+func makeIndexKey(instrument string, ts uint64, seq uint64) []byte {
+	return []byte(fmt.Sprintf("%s%013d/%012d", instrumentPrefix(instrument), ts, seq))
+}
+
+// 解析索引key，返回合约、时间和序号
+func parseIndexKey(key []byte) (string, uint64, uint64, bool) {
+	s := strings.TrimPrefix(string(key), index_prefix)
+	i := strings.LastIndex(s, "/")
+	if i < 0 {
+		return "", 0, 0, false
+	}
+	j := strings.LastIndex(s[:i], "/")
+	if j < 0 {
+		return "", 0, 0, false
+	}
+	ts, err1 := strconv.ParseUint(s[j+1:i], 10, 64)
+	seq, err2 := strconv.ParseUint(s[i+1:], 10, 64)
+	if err1 != nil || err2 != nil {
+		return "", 0, 0, false
+	}
+	return s[:j], ts, seq, true
+}
+
+// 把一条记录的索引放到batch里，不是行情消息时什么都不做
+func putIndex(batch *leveldb.Batch, exchange string, seq uint64, val []byte) {
+	tid, sinfo := DecodeQuote(val)
+	if sinfo == nil {
+		return
+	}
+	inst := utils.MakeupSinfo(exchange, sinfo.GetSymbol(), sinfo.GetContractType())
+	batch.Put(makeIndexKey(inst, sinfo.GetTimestamp(), seq), utils.UintTobytes(uint64(tid)))
+}
+
+// 已经建好索引的记录数
+func indexedCount(db *leveldb.DB) (uint64, error) {
+	v, err := db.Get(indexKey, nil)
+	if err == leveldb.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return utils.BytesToUint(v), nil
+}
+
+/*
+ 给[indexKey, count)之间的记录补建索引，返回补建的记录数
+*/
+func buildIndex(db *leveldb.DB, exchange string, count uint64) (uint64, error) {
+	from, err := indexedCount(db)
+	if err != nil || from >= count {
+		return 0, err
+	}
+
+	batch := new(leveldb.Batch)
+	for seq := from; seq < count; seq++ {
+		val, err := db.Get(utils.UintTobytes(seq), nil)
+		if err != nil {
+			return seq - from, err
+		}
+		putIndex(batch, exchange, seq, val)
+		if batch.Len() >= 10000 || seq+1 == count {
+			batch.Put(indexKey, utils.UintTobytes(seq+1))
+			if err := db.Write(batch, nil); err != nil {
+				return seq - from, err
+			}
+			batch.Reset()
+		}
+	}
+	return count - from, nil
+}
+
+/*
+ 给一天的行情补建索引，返回补建的记录数
+*/
+func BuildIndex(path string, exchange string, tradingDay string) (uint64, error) {
+	db, err := leveldb.OpenFile(makeDBFileName(path, exchange, tradingDay), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	count, _, err := recoverCount(db)
+	if err != nil {
+		return 0, err
+	}
+	return buildIndex(db, exchange, count)
+}
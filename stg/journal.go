@@ -0,0 +1,190 @@
+package stg
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"chive/logs"
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/Shopify/sarama"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+/*
+ 交易日志 --- krang发给archer的请求和archer的回应
+
+ 1. stg同时订阅okex_archer_req和okex_archer_rsp，按收到的顺序写到<stg>/<exchange>/<day>/journal，和quote库分开
+ 2. key和quote库一样是从0开始的序号，countKey保存记录数，收到第一条消息时才创建当天的库
+ 3. value是8字节大端的接收时间(毫秒)、1字节的方向，后面是原来的FixPackage
+ 4. 接收时间是stg收到消息时的本地时间，回放时用来和行情按时间合并，见replay的flow
+    行情用的是交易所的时间戳，两个时钟之间的偏差不做修正，复盘文件的开头有说明
+*/
+
+var journalName = "journal"
+
+const (
+	JOURNAL_REQ = 1 // krang发给archer的请求
+	JOURNAL_RSP = 2 // archer的回应
+)
+
+const journal_header_len = 9
+
+type JournalEntry struct {
+	Recv  uint64 // 接收时间，毫秒
+	Dir   byte
+	Value []byte // FixPackage的字节流
+}
+
+// 消息的方向，不是archer的消息返回false
+func journalDir(topic string) (byte, bool) {
+	switch topic {
+	case protocol.TOPIC_OKEX_ARCHER_REQ:
+		return JOURNAL_REQ, true
+	case protocol.TOPIC_OKEX_ARCHER_RSP:
+		return JOURNAL_RSP, true
+	}
+	return 0, false
+}
+
+func JournalDirStr(dir byte) string {
+	switch dir {
+	case JOURNAL_REQ:
+		return "req"
+	case JOURNAL_RSP:
+		return "rsp"
+	}
+	return "unknown"
+}
+
+func EncodeJournal(recv uint64, dir byte, val []byte) []byte {
+	bs := make([]byte, journal_header_len+len(val))
+	binary.BigEndian.PutUint64(bs, recv)
+	bs[8] = dir
+	copy(bs[journal_header_len:], val)
+	return bs
+}
+
+// 解析一条交易日志，长度不够时返回false
+func DecodeJournal(bs []byte) (*JournalEntry, bool) {
+	if len(bs) < journal_header_len {
+		return nil, false
+	}
+	return &JournalEntry{
+		Recv:  binary.BigEndian.Uint64(bs),
+		Dir:   bs[8],
+		Value: bs[journal_header_len:],
+	}, true
+}
+
+func makeJournalFileName(path string, exchange string, tradingDay string) string {
+	return path + exchange + "/" + tradingDay + "/" + journalName
+}
+
+func JournalFileName(path string, exchange string, tradingDay string) string {
+	return makeJournalFileName(path, exchange, tradingDay)
+}
+
+// 打开交易所当前交易日的交易日志
+func openJournal(exchange string) (*dayDB, error) {
+	filename := makeJournalFileName(stgt.path, exchange, stgt.tradingDay)
+	db, err := leveldb.OpenFile(filename, nil)
+	if err != nil {
+		logs.Error("open journal file error [%s]", err.Error())
+		return nil, err
+	}
+	curr, _, err := recoverCount(db)
+	if err != nil {
+		db.Close()
+		logs.Error("stg recover count of [%s] error [%s]", filename, err.Error())
+		return nil, err
+	}
+	d := &dayDB{
+		filename: filename,
+		db:       db,
+		batch:    new(leveldb.Batch),
+		curr:     curr,
+		flushed:  curr,
+		journal:  true,
+	}
+	stgt.jdbm[exchange] = d
+	logs.Info("open journal [%s], has %d records", filename, curr)
+	return d, nil
+}
+
+// 把archer的消息加上接收时间写到交易日志里
+func handleJournalMsg(msg *sarama.ConsumerMessage, dir byte) bool {
+	exchange := string(msg.Key)
+	if _, ok := stgt.dbm[exchange]; !ok {
+		logs.Error("stg not supported journal msg, key: %s", exchange)
+		return false
+	}
+	d, ok := stgt.jdbm[exchange]
+	if !ok {
+		var err error
+		if d, err = openJournal(exchange); err != nil {
+			return false
+		}
+	}
+
+	d.batch.Put(utils.UintTobytes(d.curr), EncodeJournal(utils.NowMs(), dir, msg.Value))
+	d.curr += 1
+	if d.curr-d.flushed >= uint64(stgt.batchSize) {
+		return d.flush()
+	}
+	return true
+}
+
+/*
+ JournalReader --- 读取一个交易所一天的交易日志，打包的日期也可以读
+*/
+type JournalReader struct {
+	db      *leveldb.DB
+	count   uint64
+	cleanup func()
+}
+
+func OpenJournalReader(path string, exchange string, tradingDay string) (*JournalReader, error) {
+	dir, cleanup, err := OpenQuoteDir(makeJournalFileName(path, exchange, tradingDay))
+	if err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(dir, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+	r := &JournalReader{db: db, cleanup: cleanup}
+	v, err := db.Get(countKey, nil)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.count = utils.BytesToUint(v)
+	return r, nil
+}
+
+func (r *JournalReader) Count() uint64 {
+	return r.count
+}
+
+// 按序号读取一条日志
+func (r *JournalReader) Get(seq uint64) (*JournalEntry, error) {
+	v, err := r.db.Get(utils.UintTobytes(seq), nil)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := DecodeJournal(v)
+	if !ok {
+		return nil, errors.New("bad journal record")
+	}
+	return e, nil
+}
+
+func (r *JournalReader) Close() error {
+	err := r.db.Close()
+	r.cleanup()
+	return err
+}
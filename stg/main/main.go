@@ -35,7 +35,7 @@ func main() {
 
 func RunServer() error {
 	brokers := []string{config.T.Broker}
-	topics := []string{protocol.TOPIC_OKEX_QUOTE_PUB, protocol.TOPIC_OKEX_ARCHER_RSP}
+	topics := []string{protocol.TOPIC_OKEX_QUOTE_PUB, protocol.TOPIC_OKEX_ARCHER_REQ, protocol.TOPIC_OKEX_ARCHER_RSP}
 
 	kfc.InitClient(brokers)
 	err := kfc.TobeConsumer(topics)
@@ -45,16 +45,23 @@ func RunServer() error {
 	}
 	logs.Info("connect to kafka broker [%s] ok ...", config.T.Broker)
 
+	td, err := utils.NewTradingDay(config.T.TradingDay.Timezone, config.T.TradingDay.Boundary)
+	if err != nil {
+		logs.Error("trading day config error ", err.Error())
+		return err
+	}
+
 	ch := make(chan int)
 	if err := stg.StartStorage(ch); err != nil {
 		return err
 	}
 
-	serverLoop(ch)
+	serverLoop(ch, td)
 	return nil
 }
 
-func serverLoop(ch chan int) {
+// 交易日变化时通知stg切换到新的一天
+func serverLoop(ch chan int, td *utils.TradingDay) {
 	defer close(ch)
 
 	signals := make(chan os.Signal, 1)
@@ -62,6 +69,7 @@ func serverLoop(ch chan int) {
 
 	tc := time.NewTimer(time.Second)
 	defer tc.Stop()
+	tradingDay := td.Day(utils.Now())
 
 	for {
 		select {
@@ -74,17 +82,10 @@ func serverLoop(ch chan int) {
 
 		case <-tc.C:
 			tc.Reset(time.Second)
-			if isEndOfDay() {
+			if day := td.Day(utils.Now()); day != tradingDay {
+				tradingDay = day
 				ch <- stg.STG_CMD_SWITCH_TRADINGDAY
 			}
 		}
 	}
 }
-
-func isEndOfDay() bool {
-	t := time.Now()
-	if t.Hour() == 0 && t.Minute() == 0 && (t.Second() >= 0 || t.Second() < 2) {
-		return true
-	}
-	return false
-}
@@ -0,0 +1,144 @@
+package stg
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"chive/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+/*
+ 每天的元数据，json格式保存在当天quote库的metaKey下，replay只读取数字的key，不受影响
+
+ 1. 记录交易日的定义和时间范围，换了交易日的配置之后也能知道当时的数据是怎么切分的
+ 2. okex的this_week、next_week、quarter每周五16:00(UTC+8)滚动，记录交易日开始时它们对应的交割日期
+    合约代码是币种加交割日期，比如ltc_usd的this_week在2017-12-25是LTC1229
+ 3. 之前的数据没有元数据，是按录制机器的本地日期切分的
+*/
+type DayMeta struct {
+	Exchange   string            `json:"exchange"`
+	TradingDay string            `json:"trading_day"`
+	Timezone   string            `json:"timezone"`
+	Boundary   string            `json:"boundary"`
+	Start      uint64            `json:"start"`               // 交易日开始时间，毫秒
+	End        uint64            `json:"end"`                 // 交易日结束时间，不包括，毫秒
+	Contracts  map[string]string `json:"contracts,omitempty"` // key: contractType，value: 交割日期，格式060102
+}
+
+var metaKey = []byte("meta")
+
+var okexLoc = time.FixedZone("UTC+8", 8*3600)
+
+func NewDayMeta(exchange string, tradingDay string, td *utils.TradingDay) (*DayMeta, error) {
+	start, end, err := td.Range(tradingDay)
+	if err != nil {
+		return nil, err
+	}
+	m := &DayMeta{
+		Exchange:   exchange,
+		TradingDay: tradingDay,
+		Timezone:   td.Location().String(),
+		Boundary:   td.Boundary(),
+		Start:      uint64(start.UnixNano() / int64(time.Millisecond)),
+		End:        uint64(end.UnixNano() / int64(time.Millisecond)),
+	}
+	if exchange == "okex" {
+		m.Contracts = okexContracts(start)
+	}
+	return m, nil
+}
+
+// 合约代码，比如LTC1229，不知道时返回空
+func (m *DayMeta) ContractCode(symbol string, contractType string) string {
+	d, ok := m.Contracts[contractType]
+	if !ok || len(d) != 6 {
+		return ""
+	}
+	coin := strings.Split(symbol, "_")[0]
+	return strings.ToUpper(coin) + d[2:]
+}
+
+/*
+ t时刻okex交割合约对应的交割日期
+ 周合约每周五16:00(UTC+8)交割，季度合约在3、6、9、12月的最后一个周五交割
+ 季度合约离交割不到两周时变成次周合约，新的季度合约是下一个季度的
+*/
+func okexContracts(t time.Time) map[string]string {
+	thisWeek := okexWeekDelivery(t)
+	nextWeek := thisWeek.AddDate(0, 0, 7)
+	quarter := okexQuarterDelivery(nextWeek)
+	return map[string]string{
+		"this_week": thisWeek.Format("060102"),
+		"next_week": nextWeek.Format("060102"),
+		"quarter":   quarter.Format("060102"),
+	}
+}
+
+// t之后最近的周五16:00
+func okexWeekDelivery(t time.Time) time.Time {
+	t = t.In(okexLoc)
+	d := time.Date(t.Year(), t.Month(), t.Day(), 16, 0, 0, 0, okexLoc)
+	d = d.AddDate(0, 0, (int(time.Friday)-int(d.Weekday())+7)%7)
+	if !d.After(t) {
+		d = d.AddDate(0, 0, 7)
+	}
+	return d
+}
+
+// t之后最近的季度末月份的最后一个周五16:00
+func okexQuarterDelivery(t time.Time) time.Time {
+	t = t.In(okexLoc)
+	year, month := t.Year(), (int(t.Month())+2)/3*3
+	for {
+		// 季度末月份的最后一天，往前找到周五
+		d := time.Date(year, time.Month(month+1), 0, 16, 0, 0, 0, okexLoc)
+		d = d.AddDate(0, 0, -((int(d.Weekday()) - int(time.Friday) + 7) % 7))
+		if d.After(t) {
+			return d
+		}
+		if month += 3; month > 12 {
+			year, month = year+1, 3
+		}
+	}
+}
+
+func writeMeta(db *leveldb.DB, m *DayMeta) error {
+	v, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return db.Put(metaKey, v, nil)
+}
+
+/*
+ 读取一天的元数据，之前录制的数据没有元数据，返回nil
+*/
+func ReadMeta(path string, exchange string, tradingDay string) (*DayMeta, error) {
+	dir, cleanup, err := OpenDayDir(path, exchange, tradingDay)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	db, err := leveldb.OpenFile(dir, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	v, err := db.Get(metaKey, nil)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	m := &DayMeta{}
+	if err := json.Unmarshal(v, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,264 @@
+package stg
+
+import (
+	"errors"
+	"sort"
+	"strings"
+
+	"chive/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+/*
+ DayReader --- 读取一个交易所一天的行情
+
+ 按合约和时间范围查询时使用索引，只读取命中的记录
+ 没有索引或者索引不完整时逐条扫描，结果和顺序都一样，只是慢
+*/
+type DayReader struct {
+	exchange string
+	filename string
+	db       *leveldb.DB
+	count    uint64
+	indexed  uint64
+	cleanup  func() // 打包的日期解压的临时目录
+}
+
+// 查询条件，为空的条件不限制
+type Query struct {
+	Instruments []string // exchange_symbol_contractType
+	Start       uint64   // 毫秒，包括
+	End         uint64   // 毫秒，包括
+	Fids        []uint32
+}
+
+type Record struct {
+	Seq        uint64
+	Ts         uint64 // 行情里的时间戳，毫秒
+	Tid        uint32
+	Instrument string // exchange_symbol_contractType，不是行情消息时为空
+	Value      []byte // FixPackage的字节流
+}
+
+var errStop = errors.New("stop")
+
+func OpenDayReader(path string, exchange string, tradingDay string) (*DayReader, error) {
+	filename := makeDBFileName(path, exchange, tradingDay)
+	dir, cleanup, err := OpenQuoteDir(filename)
+	if err != nil {
+		return nil, err
+	}
+	db, err := leveldb.OpenFile(dir, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		cleanup()
+		return nil, err
+	}
+
+	r := &DayReader{exchange: exchange, filename: filename, db: db, cleanup: cleanup}
+	v, err := db.Get(countKey, nil)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	r.count = utils.BytesToUint(v)
+	if r.indexed, err = indexedCount(db); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *DayReader) Count() uint64 {
+	return r.count
+}
+
+// 全部记录是否都有索引
+func (r *DayReader) Indexed() bool {
+	return r.indexed >= r.count
+}
+
+func (r *DayReader) FileName() string {
+	return r.filename
+}
+
+func (r *DayReader) Close() error {
+	err := r.db.Close()
+	r.cleanup()
+	return err
+}
+
+func (r *DayReader) Get(seq uint64) ([]byte, error) {
+	return r.db.Get(utils.UintTobytes(seq), nil)
+}
+
+/*
+ 当天有行情的全部合约，需要索引
+*/
+func (r *DayReader) Instruments() ([]string, error) {
+	if !r.Indexed() {
+		return nil, errors.New(r.filename + " has no index, run stgtool index first")
+	}
+	ret := []string{}
+	it := r.db.NewIterator(util.BytesPrefix([]byte(index_prefix)), nil)
+	defer it.Release()
+	for ok := it.First(); ok; {
+		inst, _, _, valid := parseIndexKey(it.Key())
+		if !valid {
+			ok = it.Next()
+			continue
+		}
+		ret = append(ret, inst)
+		// 跳到下一个合约
+		ok = it.Seek([]byte(instrumentPrefix(inst) + "~"))
+	}
+	return ret, it.Error()
+}
+
+/*
+ 按条件查询，fn返回false时停止
+ 按合约分组，每个合约内按时间排序，时间一样时按序号，
+ 指定了合约时按指定的顺序，否则合约按名称排序
+ 没有索引时逐条扫描后按同样的顺序返回
+*/
+func (r *DayReader) Query(q *Query, fn func(rec *Record) bool) error {
+	var err error
+	if r.Indexed() {
+		err = r.queryIndex(q, fn)
+	} else {
+		err = r.scan(q, fn)
+	}
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+func (q *Query) matchFid(tid uint32) bool {
+	if len(q.Fids) == 0 {
+		return true
+	}
+	for _, v := range q.Fids {
+		if v == tid {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Query) matchTime(ts uint64) bool {
+	return (q.Start == 0 || ts >= q.Start) && (q.End == 0 || ts <= q.End)
+}
+
+func (r *DayReader) queryIndex(q *Query, fn func(rec *Record) bool) error {
+	insts := q.Instruments
+	if len(insts) == 0 {
+		var err error
+		if insts, err = r.Instruments(); err != nil {
+			return err
+		}
+	}
+
+	for _, inst := range insts {
+		prefix := instrumentPrefix(inst)
+		rg := util.BytesPrefix([]byte(prefix))
+		if q.Start > 0 {
+			rg.Start = makeIndexKey(inst, q.Start, 0)
+		}
+		if q.End > 0 {
+			rg.Limit = makeIndexKey(inst, q.End+1, 0)
+		}
+		if err := r.iterate(rg, q, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *DayReader) iterate(rg *util.Range, q *Query, fn func(rec *Record) bool) error {
+	it := r.db.NewIterator(rg, nil)
+	defer it.Release()
+	for it.Next() {
+		inst, ts, seq, ok := parseIndexKey(it.Key())
+		if !ok {
+			continue
+		}
+		tid := uint32(utils.BytesToUint(it.Value()))
+		if !q.matchFid(tid) {
+			continue
+		}
+		val, err := r.Get(seq)
+		if err != nil {
+			return err
+		}
+		if !fn(&Record{Seq: seq, Ts: ts, Tid: tid, Instrument: inst, Value: val}) {
+			return errStop
+		}
+	}
+	return it.Error()
+}
+
+// 扫描时命中的记录，排好序后再读取
+type scanHit struct {
+	inst string
+	ts   uint64
+	seq  uint64
+	tid  uint32
+}
+
+/*
+ 逐条扫描，先记下命中的记录，再按和索引一样的顺序读取
+*/
+func (r *DayReader) scan(q *Query, fn func(rec *Record) bool) error {
+	// 合约的顺序，指定了合约时按指定的顺序
+	insts := make(map[string]int)
+	for i, v := range q.Instruments {
+		if _, ok := insts[strings.TrimSpace(v)]; !ok {
+			insts[strings.TrimSpace(v)] = i
+		}
+	}
+
+	hits := []scanHit{}
+
+	for seq := uint64(0); seq < r.count; seq++ {
+		val, err := r.Get(seq)
+		if err != nil {
+			return err
+		}
+		tid, sinfo := DecodeQuote(val)
+		if sinfo == nil || !q.matchFid(tid) || !q.matchTime(sinfo.GetTimestamp()) {
+			continue
+		}
+		inst := utils.MakeupSinfo(r.exchange, sinfo.GetSymbol(), sinfo.GetContractType())
+		if _, ok := insts[inst]; len(insts) > 0 && !ok {
+			continue
+		}
+		hits = append(hits, scanHit{inst: inst, ts: sinfo.GetTimestamp(), seq: seq, tid: tid})
+	}
+
+	sort.Slice(hits, func(i, j int) bool {
+		a, b := hits[i], hits[j]
+		if a.inst != b.inst {
+			if len(insts) > 0 {
+				return insts[a.inst] < insts[b.inst]
+			}
+			return instrumentPrefix(a.inst) < instrumentPrefix(b.inst)
+		}
+		if a.ts != b.ts {
+			return a.ts < b.ts
+		}
+		return a.seq < b.seq
+	})
+	for _, h := range hits {
+		val, err := r.Get(h.seq)
+		if err != nil {
+			return err
+		}
+		if !fn(&Record{Seq: h.seq, Ts: h.ts, Tid: h.tid, Instrument: h.inst, Value: val}) {
+			return errStop
+		}
+	}
+	return nil
+}
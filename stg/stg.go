@@ -1,7 +1,10 @@
 package stg
 
 import (
-	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"chive/config"
@@ -17,6 +20,14 @@ import (
  path的格式为：/usr/slash/data/
  data下是各个交易所名称，交易所下面是日期
  /usr/slash/data/okex/2017-12-04/quote
+
+ 日期是配置里定义的交易日，默认和okex的结算对齐，每天16:00(UTC+8)切换
+ 每天的元数据保存在quote库里，见DayMeta
+
+ 记录和countKey放在同一个batch里写，攒够batch_size条或者过了flush_interval毫秒写一次，
+ countKey不会落后于记录。打开库时从countKey往后扫描，修复之前版本异常退出时落后的计数
+ 行情记录同时写入按合约和时间的索引，见index.go
+ archer的请求和回应写到同一天的journal库里，见journal.go
 */
 
 const (
@@ -24,11 +35,23 @@ const (
 	STG_CMD_SWITCH_TRADINGDAY = 2
 )
 
+// 一个交易所当天的库
+type dayDB struct {
+	filename string
+	db       *leveldb.DB
+	batch    *leveldb.Batch
+	curr     uint64 // 下一条记录的序号
+	flushed  uint64 // 已经写入库的记录数
+	journal  bool   // 交易日志，没有索引
+}
+
 type storage struct {
 	path       string
 	tradingDay string
-	dbm        map[string]*leveldb.DB
-	currm      map[string]uint64
+	td         *utils.TradingDay
+	batchSize  int
+	dbm        map[string]*dayDB
+	jdbm       map[string]*dayDB // 交易日志，key是交易所
 }
 
 var stgt *storage
@@ -37,34 +60,37 @@ var countKey []byte
 
 func init() {
 	stgt = &storage{
-		dbm:   make(map[string]*leveldb.DB),
-		currm: make(map[string]uint64),
+		dbm:  make(map[string]*dayDB),
+		jdbm: make(map[string]*dayDB),
 	}
 	dbName = "quote"
 	countKey = []byte("-1")
 }
 
 func StartStorage(ch chan int) error {
+	td, err := utils.NewTradingDay(config.T.TradingDay.Timezone, config.T.TradingDay.Boundary)
+	if err != nil {
+		logs.Error("stg trading day config error [%s]", err.Error())
+		return err
+	}
+	stgt.td = td
 	stgt.tradingDay = getCurrDate()
 	stgt.path = config.T.StgPath
+	stgt.batchSize = config.T.Storage.BatchSize
 
 	for _, exchange := range config.T.Exchanges {
-		filename := makeDBFileName(stgt.path, exchange, stgt.tradingDay)
-
-		db, err := leveldb.OpenFile(filename, nil)
-		if err != nil {
-			logs.Error("open leveldb file error [%s]", err.Error())
+		if err := openDB(exchange); err != nil {
 			return err
 		}
-		stgt.dbm[exchange] = db
-		curr, err := db.Get(countKey, nil)
+	}
+
+	if expr := config.T.Storage.MaintainCron; expr != "" {
+		spec, err := utils.ParseCron(expr)
 		if err != nil {
-			stgt.currm[exchange] = 0
-			db.Put(countKey, utils.UintTobytes(0), nil)
-		} else {
-			stgt.currm[exchange] = utils.BytesToUint(curr)
-			logs.Info("open leveldb [%s], has %d records", filename, stgt.currm[exchange])
+			logs.Error("stg maintain cron [%s] error [%s]", expr, err.Error())
+			return err
 		}
+		go maintainLoop(spec)
 	}
 
 	go stgLoop(ch)
@@ -72,8 +98,87 @@ func StartStorage(ch chan int) error {
 }
 
 func getCurrDate() string {
-	t := time.Now()
-	return fmt.Sprintf("%04d-%02d-%02d", t.Year(), t.Month(), t.Day())
+	return stgt.td.Day(utils.Now())
+}
+
+// 打开交易所当前交易日的库，写入当天的元数据
+func openDB(exchange string) error {
+	filename := makeDBFileName(stgt.path, exchange, stgt.tradingDay)
+	db, err := leveldb.OpenFile(filename, nil)
+	if err != nil {
+		logs.Error("open leveldb file error [%s]", err.Error())
+		return err
+	}
+
+	curr, count, err := recoverCount(db)
+	if err != nil {
+		db.Close()
+		logs.Error("stg recover count of [%s] error [%s]", filename, err.Error())
+		return err
+	}
+	if curr != count {
+		logs.Info("leveldb [%s] count is %d, but has %d records, repaired", filename, count, curr)
+	} else if curr > 0 {
+		logs.Info("open leveldb [%s], has %d records", filename, curr)
+	}
+	if n, err := buildIndex(db, exchange, curr); err != nil {
+		db.Close()
+		logs.Error("stg build index of [%s] error [%s]", filename, err.Error())
+		return err
+	} else if n > 0 {
+		logs.Info("leveldb [%s] build index of %d records", filename, n)
+	}
+	stgt.dbm[exchange] = &dayDB{
+		filename: filename,
+		db:       db,
+		batch:    new(leveldb.Batch),
+		curr:     curr,
+		flushed:  curr,
+	}
+
+	meta, err := NewDayMeta(exchange, stgt.tradingDay, stgt.td)
+	if err == nil {
+		err = writeMeta(db, meta)
+	}
+	if err != nil {
+		logs.Error("stg write meta of [%s] error [%s]", filename, err.Error())
+		return err
+	}
+	logs.Info("exchange [%s] tradingDay [%s], contracts %v", exchange, stgt.tradingDay, meta.Contracts)
+	return nil
+}
+
+/*
+ 从countKey记录的数量往后扫描，找到最后一条连续的记录，修复countKey
+ 返回实际的记录数和countKey里原来的数量
+*/
+func recoverCount(db *leveldb.DB) (uint64, uint64, error) {
+	var count uint64
+	v, err := db.Get(countKey, nil)
+	missing := err == leveldb.ErrNotFound
+	if err == nil {
+		count = utils.BytesToUint(v)
+	} else if !missing {
+		return 0, 0, err
+	}
+
+	curr := count
+	for {
+		ok, err := db.Has(utils.UintTobytes(curr), nil)
+		if err != nil {
+			return 0, 0, err
+		}
+		if !ok {
+			break
+		}
+		curr += 1
+	}
+	if curr != count || missing {
+		if err := db.Put(countKey, utils.UintTobytes(curr), nil); err != nil {
+			return 0, 0, err
+		}
+	}
+	return curr, count, nil
 }
 
 func makeDBFileName(path string, exchange string, tradingDay string) string {
@@ -81,14 +186,51 @@ func makeDBFileName(path string, exchange string, tradingDay string) string {
 	return name
 }
 
+/*
+ 交易所已经存储的全部日期，按日期排序
+*/
+func ListDays(path string, exchange string) ([]string, error) {
+	infos, err := ioutil.ReadDir(path + exchange)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, info := range infos {
+		if !info.IsDir() {
+			// 打包的日期
+			if day := strings.TrimSuffix(info.Name(), archive_ext); day != info.Name() {
+				if _, err := os.Stat(dayDir(path, exchange, day)); err != nil {
+					ret = append(ret, day)
+				}
+			}
+			continue
+		}
+		if _, err := os.Stat(makeDBFileName(path, exchange, info.Name())); err == nil {
+			ret = append(ret, info.Name())
+		}
+	}
+	sort.Strings(ret)
+	return ret, nil
+}
+
 func stgLoop(ch chan int) {
 	defer doStgExit()
 
+	interval := time.Duration(config.T.Storage.FlushInterval) * time.Millisecond
+	if interval <= 0 {
+		interval = time.Second
+	}
+	tc := time.NewTicker(interval)
+	defer tc.Stop()
+
 	for {
 		select {
 		case msg := <-kfc.ReadMessages():
 			handleStgMsg(msg)
 
+		case <-tc.C:
+			flushAll()
+
 		case cmd, ok := <-ch:
 			if !ok || cmd == STG_CMD_EXIT {
 				return
@@ -103,8 +245,12 @@ func stgLoop(ch chan int) {
 }
 
 func doStgExit() {
+	flushAll()
 	for _, v := range stgt.dbm {
-		v.Close()
+		v.db.Close()
+	}
+	for _, v := range stgt.jdbm {
+		v.db.Close()
 	}
 }
 
@@ -114,25 +260,25 @@ func switchTradingDay() bool {
 		return true
 	}
 
+	flushAll()
 	oldTradingDay := stgt.tradingDay
 	stgt.tradingDay = newTradingDay
 	keys := []string{}
 	for k, v := range stgt.dbm {
-		v.Close()
+		v.db.Close()
 		keys = append(keys, k)
 	}
+	// 交易日志收到新一天的第一条消息时再打开
+	for k, v := range stgt.jdbm {
+		v.db.Close()
+		delete(stgt.jdbm, k)
+	}
 
 	for _, key := range keys {
-		filename := makeDBFileName(stgt.path, key, stgt.tradingDay)
-		db, err := leveldb.OpenFile(filename, nil)
-		if err != nil {
+		if err := openDB(key); err != nil {
 			logs.Error("stg switch tradingday, openfile error: %s", err.Error())
 			return false
 		}
-		db.Put(countKey, utils.UintTobytes(0), nil)
-		stgt.dbm[key] = db
-		stgt.currm[key] = 0
-
 		logs.Info("exchange [%s] has switch tradingDay [%s] -> [%s]", key, oldTradingDay, newTradingDay)
 	}
 
@@ -140,22 +286,53 @@ func switchTradingDay() bool {
 }
 
 func handleStgMsg(msg *sarama.ConsumerMessage) bool {
+	if dir, ok := journalDir(msg.Topic); ok {
+		return handleJournalMsg(msg, dir)
+	}
+
 	msgKey := string(msg.Key)
-	db, ok := stgt.dbm[msgKey]
+	d, ok := stgt.dbm[msgKey]
 	if !ok {
 		logs.Error("stg not supported msg, key: %s", msgKey)
 		return false
 	}
 
-	key := utils.UintTobytes(stgt.currm[msgKey])
-	err := db.Put(key, msg.Value, nil)
+	d.batch.Put(utils.UintTobytes(d.curr), msg.Value)
+	putIndex(d.batch, msgKey, d.curr, msg.Value)
+	d.curr += 1
+	if d.curr-d.flushed >= uint64(stgt.batchSize) {
+		return d.flush()
+	}
+	return true
+}
+
+/*
+ 记录、索引和countKey一起写入，写失败时丢弃这一批，序号退回到已经写入的位置
+*/
+func (d *dayDB) flush() bool {
+	if d.batch.Len() == 0 {
+		return true
+	}
+	d.batch.Put(countKey, utils.UintTobytes(d.curr))
+	if !d.journal {
+		d.batch.Put(indexKey, utils.UintTobytes(d.curr))
+	}
+	err := d.db.Write(d.batch, nil)
+	d.batch.Reset()
 	if err != nil {
-		filename := makeDBFileName(stgt.path, msgKey, stgt.tradingDay)
-		logs.Error("stg write [%s] error [%s]", filename, err.Error())
+		logs.Error("stg write [%s] error [%s], lost %d records", d.filename, err.Error(), d.curr-d.flushed)
+		d.curr = d.flushed
 		return false
 	}
-
-	stgt.currm[msgKey] += 1
-	db.Put(countKey, utils.UintTobytes(stgt.currm[msgKey]), nil)
+	d.flushed = d.curr
 	return true
 }
+
+func flushAll() {
+	for _, d := range stgt.dbm {
+		d.flush()
+	}
+	for _, d := range stgt.jdbm {
+		d.flush()
+	}
+}
@@ -0,0 +1,245 @@
+package stg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/Shopify/sarama"
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func TestRecoverCount(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := leveldb.OpenFile(dir+"/quote", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// 异常退出，计数落后于记录
+	for i := uint64(0); i < 5; i++ {
+		db.Put(utils.UintTobytes(i), []byte("x"), nil)
+	}
+	db.Put(countKey, utils.UintTobytes(2), nil)
+
+	curr, count, err := recoverCount(db)
+	if err != nil || curr != 5 || count != 2 {
+		t.Fatalf("recover count %d %d %v", curr, count, err)
+	}
+
+	d := &dayDB{db: db, batch: new(leveldb.Batch), curr: curr, flushed: curr}
+	for i := 0; i < 3; i++ {
+		d.batch.Put(utils.UintTobytes(d.curr), []byte("y"))
+		d.curr += 1
+	}
+	if !d.flush() || d.flushed != 8 {
+		t.Fatalf("flush to %d", d.flushed)
+	}
+	v, _ := db.Get(countKey, nil)
+	if utils.BytesToUint(v) != 8 {
+		t.Fatalf("count is %s after flush", v)
+	}
+}
+
+func TestQuery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/"
+
+	w, err := NewDayWriter(path, "okex", "2017-12-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, v := range []struct {
+		symbol string
+		ts     uint64
+	}{{"ltc_usd", 3000}, {"btc_usd", 1000}, {"ltc_usd", 1000}, {"ltc_usd", 2000}} {
+		tick := &protocol.PBFutureTick{Sinfo: &protocol.PBQuoteSymbol{
+			Exchange:     proto.String("okex"),
+			Symbol:       proto.String(v.symbol),
+			ContractType: proto.String("this_week"),
+			Timestamp:    proto.Uint64(v.ts),
+		}, Last: proto.Float32(float32(i))}
+		bin, _ := utils.PackMessage(protocol.FID_QUOTE_TICK, 0, tick)
+		if err := w.Write(bin); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	query := func(q *Query) []uint64 {
+		r, err := OpenDayReader(path, "okex", "2017-12-25")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		seqs := []uint64{}
+		if err := r.Query(q, func(rec *Record) bool {
+			seqs = append(seqs, rec.Seq)
+			return true
+		}); err != nil {
+			t.Fatal(err)
+		}
+		return seqs
+	}
+
+	// 按时间排序，只包括时间范围内的记录
+	seqs := query(&Query{Instruments: []string{"okex_ltc_usd_this_week"}, Start: 1500, End: 3000})
+	if len(seqs) != 2 || seqs[0] != 3 || seqs[1] != 0 {
+		t.Fatalf("query ltc_usd returns %v", seqs)
+	}
+	all := query(&Query{})
+	if len(all) != 4 || all[0] != 1 {
+		t.Fatalf("query all returns %v", all)
+	}
+
+	// 没有索引时逐条扫描，顺序和有索引时一样
+	db, _ := leveldb.OpenFile(path+"okex/2017-12-25/quote", nil)
+	db.Delete(indexKey, nil)
+	db.Close()
+	seqs = query(&Query{Instruments: []string{"okex_ltc_usd_this_week"}, Start: 1500, End: 3000})
+	if len(seqs) != 2 || seqs[0] != 3 || seqs[1] != 0 {
+		t.Fatalf("scan ltc_usd returns %v", seqs)
+	}
+	if seqs = query(&Query{}); fmt.Sprint(seqs) != fmt.Sprint(all) {
+		t.Fatalf("scan all returns %v, query returns %v", seqs, all)
+	}
+	if n, err := BuildIndex(path, "okex", "2017-12-25"); err != nil || n != 4 {
+		t.Fatalf("build index of %d records, %v", n, err)
+	}
+}
+
+func TestMaintain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := dir + "/"
+
+	days := []string{"2017-12-20", "2017-12-23", "2017-12-24", "2017-12-25"}
+	for _, day := range days {
+		w, err := NewDayWriter(path, "okex", day)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tick := &protocol.PBFutureTick{Sinfo: &protocol.PBQuoteSymbol{
+			Exchange:     proto.String("okex"),
+			Symbol:       proto.String("ltc_usd"),
+			ContractType: proto.String("this_week"),
+			Timestamp:    proto.Uint64(1000),
+		}}
+		bin, _ := utils.PackMessage(protocol.FID_QUOTE_TICK, 0, tick)
+		if err := w.Write(bin); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	// 20号超过保留天数，23号打包，24号压实，25号是当天不处理
+	// 23号的库没有关闭，打包失败不影响后面的日期
+	db, err := leveldb.OpenFile(makeDBFileName(path, "okex", "2017-12-23"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := MaintainPolicy{Compact: true, ArchiveAfter: 2, RetainDays: 5}
+	ret, err := Maintain(path, "okex", "2017-12-25", policy)
+	if err == nil || len(ret.Failed) != 1 || ret.Failed[0] != "2017-12-23" {
+		t.Fatalf("maintain a locked day returns %+v, %v", ret, err)
+	}
+	if len(ret.Removed) != 1 || len(ret.Archived) != 0 || len(ret.Compacted) != 1 || ret.Compacted[0] != "2017-12-24" {
+		t.Fatalf("maintain returns %+v", ret)
+	}
+	db.Close()
+	ret, err = Maintain(path, "okex", "2017-12-25", policy)
+	if err != nil || len(ret.Archived) != 1 || ret.Archived[0] != "2017-12-23" || len(ret.Failed) != 0 {
+		t.Fatalf("maintain after close returns %+v, %v", ret, err)
+	}
+	if ds, _ := ListDays(path, "okex"); len(ds) != 3 || ds[0] != "2017-12-23" {
+		t.Fatalf("list days %v", ds)
+	}
+
+	// 打包的日期可以直接读取
+	r, err := OpenDayReader(path, "okex", "2017-12-23")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count() != 1 || !r.Indexed() {
+		t.Fatalf("archived day has %d records", r.Count())
+	}
+	r.Close()
+
+	// 再次维护不重复处理
+	if ret, err = Maintain(path, "okex", "2017-12-25", policy); err != nil ||
+		len(ret.Archived)+len(ret.Compacted)+len(ret.Removed) != 0 {
+		t.Fatalf("maintain again returns %+v, %v", ret, err)
+	}
+}
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stgt.path = dir + "/"
+	stgt.tradingDay = "2017-12-25"
+	stgt.batchSize = 1000
+	if stgt.td, err = utils.NewTradingDay("", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := openDB("okex"); err != nil {
+		t.Fatal(err)
+	}
+
+	req := (&protocol.FixPackage{Tid: protocol.FID_ReqSetOrder, ReqSerial: 7}).SerialToArray()
+	rsp := (&protocol.FixPackage{Tid: protocol.FID_RspSetOrder, ReqSerial: 7}).SerialToArray()
+	handleStgMsg(&sarama.ConsumerMessage{Topic: protocol.TOPIC_OKEX_ARCHER_REQ, Key: []byte("okex"), Value: req})
+	handleStgMsg(&sarama.ConsumerMessage{Topic: protocol.TOPIC_OKEX_ARCHER_RSP, Key: []byte("okex"), Value: rsp})
+	doStgExit()
+	stgt.dbm = make(map[string]*dayDB)
+	stgt.jdbm = make(map[string]*dayDB)
+
+	// archer的消息只写到交易日志里
+	r, err := OpenDayReader(stgt.path, "okex", "2017-12-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Count() != 0 {
+		t.Fatalf("quote has %d records", r.Count())
+	}
+	r.Close()
+
+	j, err := OpenJournalReader(stgt.path, "okex", "2017-12-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if j.Count() != 2 {
+		t.Fatalf("journal has %d records", j.Count())
+	}
+	for i, dir := range []byte{JOURNAL_REQ, JOURNAL_RSP} {
+		e, err := j.Get(uint64(i))
+		if err != nil || e.Dir != dir || e.Recv == 0 {
+			t.Fatalf("journal %d: %+v, %v", i, e, err)
+		}
+	}
+	if name, _ := FormatMessage(req); name != "PBFReqSetOrder" {
+		t.Fatalf("message name %s", name)
+	}
+}
@@ -0,0 +1,328 @@
+package stg
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"chive/protocol"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+/*
+ 检查和修复一天的行情
+
+ stg异常退出后，countKey可能和实际的记录对不上，也可能有不完整的记录
+ 1. 检查：扫描全部记录，解包FixPackage，再按消息类型解码protobuf，报告缺失的序号、损坏的记录和countKey的问题
+ 2. 修复：把完好的记录按原来的顺序重新编号写到新库里，序号连续，countKey等于记录数，重建索引，
+    原来的库改名为quote.bak保留，确认没有问题后手动删除
+*/
+
+// 修复前的库改名后的后缀
+const repair_backup_ext = ".bak"
+
+// 一段缺失的序号，包括From和To
+type Gap struct {
+	From uint64
+	To   uint64
+}
+
+// 损坏的记录
+type BadRecord struct {
+	Seq    uint64
+	Reason string
+}
+
+type VerifyReport struct {
+	Exchange   string
+	TradingDay string
+	Count      uint64 // countKey记录的条数
+	HasCount   bool   // 是否有countKey
+	Records    uint64 // 实际的记录数
+	Last       uint64 // 最大的序号加1
+	Gaps       []Gap
+	Corrupt    []BadRecord
+}
+
+// 记录是否完整，countKey是否正确
+func (v *VerifyReport) OK() bool {
+	return v.HasCount && v.Count == v.Last && len(v.Gaps) == 0 && len(v.Corrupt) == 0
+}
+
+func (v *VerifyReport) String() string {
+	s := fmt.Sprintf("%s %s: count %d, records %d, last seq %d, gaps %d, corrupt %d",
+		v.Exchange, v.TradingDay, v.Count, v.Records, int64(v.Last)-1, len(v.Gaps), len(v.Corrupt))
+	if !v.HasCount {
+		s += ", no count key"
+	}
+	return s
+}
+
+// 消息类型对应的protobuf，不认识的类型返回nil
+func NewMessage(tid uint32) proto.Message {
+	switch tid {
+	case protocol.FID_QUOTE_TICK:
+		return &protocol.PBFutureTick{}
+	case protocol.FID_QUOTE_KLine:
+		return &protocol.PBFutureKLine{}
+	case protocol.FID_QUOTE_Depth:
+		return &protocol.PBFutureDepth{}
+	case protocol.FID_QUOTE_Trade:
+		return &protocol.PBFutureTrade{}
+	case protocol.FID_QUOTE_Index:
+		return &protocol.PBFutureIndex{}
+	case protocol.FID_ReqQryMoneyInfo:
+		return &protocol.PBFReqQryMoneyInfo{}
+	case protocol.FID_RspQryMoneyInfo:
+		return &protocol.PBFRspQryMoneyInfo{}
+	case protocol.FID_ReqQryPosInfo:
+		return &protocol.PBFReqQryPosInfo{}
+	case protocol.FID_RspQryPosInfo:
+		return &protocol.PBFRspQryPosInfo{}
+	case protocol.FID_ReqSetOrder:
+		return &protocol.PBFReqSetOrder{}
+	case protocol.FID_RspSetOrder:
+		return &protocol.PBFRspSetOrder{}
+	case protocol.FID_ReqQryOrders:
+		return &protocol.PBFReqQryOrders{}
+	case protocol.FID_RspQryOrders:
+		return &protocol.PBFRspQryOrders{}
+	case protocol.FID_ReqCancelOrders:
+		return &protocol.PBFReqCancelOrders{}
+	case protocol.FID_RspCancelOrders:
+		return &protocol.PBFRspCancelOrders{}
+	case protocol.FID_ReqTransferMoney:
+		return &protocol.PBFReqTransferMoney{}
+	case protocol.FID_RspTransferMoney:
+		return &protocol.PBFRspTransferMoney{}
+	}
+	return nil
+}
+
+/*
+ 把一条FixPackage解码成文本，返回消息类型的名字和protobuf的文本格式，复盘和查看交易日志时使用
+*/
+func FormatMessage(val []byte) (string, string) {
+	p := &protocol.FixPackage{}
+	if !p.ParseFromArray(val) {
+		return "unknown", "bad package"
+	}
+	pb := NewMessage(p.GetTid())
+	if pb == nil {
+		return fmt.Sprintf("tid_%d", p.GetTid()), ""
+	}
+	name := proto.MessageName(pb)
+	name = name[strings.LastIndex(name, ".")+1:]
+	if err := proto.Unmarshal(p.GetPayload(), pb); err != nil {
+		return name, "decode error: " + err.Error()
+	}
+	return name, strings.TrimSpace(proto.CompactTextString(pb))
+}
+
+/*
+ 检查一条记录，完好时返回nil
+*/
+func CheckRecord(val []byte) error {
+	p := &protocol.FixPackage{}
+	if !p.ParseFromArray(val) {
+		return errors.New("truncated package")
+	}
+	if len(val) != protocol.FIX_PACKAGE_HEADERLEN+int(p.GetBodyLen()) {
+		return fmt.Errorf("package length %d, body length %d", len(val), p.GetBodyLen())
+	}
+	pb := NewMessage(p.GetTid())
+	if pb == nil {
+		return fmt.Errorf("unknown tid %d", p.GetTid())
+	}
+	if err := proto.Unmarshal(p.GetPayload(), pb); err != nil {
+		return fmt.Errorf("decode tid %d: %s", p.GetTid(), err.Error())
+	}
+	return nil
+}
+
+// 记录的key是十进制的序号，其它的key返回false
+func parseSeqKey(key []byte) (uint64, bool) {
+	if len(key) == 0 || key[0] < '0' || key[0] > '9' {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(string(key), 10, 64)
+	return seq, err == nil
+}
+
+// 扫描一个库的全部记录，结果放到v里
+func verifyDB(db *leveldb.DB, v *VerifyReport) error {
+	val, err := db.Get(countKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+	if err == nil {
+		v.HasCount = true
+		v.Count = utils.BytesToUint(val)
+	}
+
+	// 序号的位图，找出缺失的序号
+	seen := []uint64{}
+	it := db.NewIterator(nil, nil)
+	for it.Next() {
+		seq, ok := parseSeqKey(it.Key())
+		if !ok {
+			continue
+		}
+		for uint64(len(seen)) <= seq/64 {
+			seen = append(seen, 0)
+		}
+		seen[seq/64] |= 1 << (seq % 64)
+		v.Records += 1
+		if seq+1 > v.Last {
+			v.Last = seq + 1
+		}
+		if err := CheckRecord(it.Value()); err != nil {
+			v.Corrupt = append(v.Corrupt, BadRecord{Seq: seq, Reason: err.Error()})
+		}
+	}
+	it.Release()
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	// countKey比记录多时，后面缺失的部分也算缺口
+	last := v.Last
+	if v.Count > last {
+		last = v.Count
+	}
+	for seq := uint64(0); seq < last; seq++ {
+		if seq/64 < uint64(len(seen)) && seen[seq/64]&(1<<(seq%64)) != 0 {
+			continue
+		}
+		if n := len(v.Gaps); n > 0 && v.Gaps[n-1].To+1 == seq {
+			v.Gaps[n-1].To = seq
+		} else {
+			v.Gaps = append(v.Gaps, Gap{From: seq, To: seq})
+		}
+	}
+	sort.Slice(v.Corrupt, func(i, j int) bool {
+		return v.Corrupt[i].Seq < v.Corrupt[j].Seq
+	})
+	return nil
+}
+
+/*
+ 检查一天的行情，打包的日期也可以检查
+*/
+func VerifyDay(path string, exchange string, tradingDay string) (*VerifyReport, error) {
+	dir, cleanup, err := OpenDayDir(path, exchange, tradingDay)
+	if err != nil {
+		return nil, err
+	}
+	defer cleanup()
+	db, err := leveldb.OpenFile(dir, &opt.Options{ReadOnly: true, ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	v := &VerifyReport{Exchange: exchange, TradingDay: tradingDay}
+	if err := verifyDB(db, v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+/*
+ 修复一天的行情，返回修复前的检查结果
+ 完好的记录按原来的序号顺序重新编号，丢掉损坏的记录，元数据原样复制，索引重建
+ 打包的日期和正在写入的日期不能修复
+*/
+func RepairDay(path string, exchange string, tradingDay string) (*VerifyReport, error) {
+	if IsArchived(path, exchange, tradingDay) {
+		return nil, errors.New("day is archived")
+	}
+	filename := makeDBFileName(path, exchange, tradingDay)
+	backup := filename + repair_backup_ext
+	if _, err := os.Stat(backup); err == nil {
+		return nil, errors.New(backup + " already exists")
+	}
+	tmp := filename + ".repair"
+	if err := os.RemoveAll(tmp); err != nil {
+		return nil, err
+	}
+
+	old, err := leveldb.OpenFile(filename, &opt.Options{ErrorIfMissing: true})
+	if err != nil {
+		return nil, err
+	}
+	v := &VerifyReport{Exchange: exchange, TradingDay: tradingDay}
+	err = verifyDB(old, v)
+	if err == nil {
+		err = copyGoodRecords(old, tmp, exchange, v)
+	}
+	if cerr := old.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+
+	if err := os.Rename(filename, backup); err != nil {
+		os.RemoveAll(tmp)
+		return nil, err
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return nil, err
+	}
+	// 新库还没有压实
+	os.Remove(dayDir(path, exchange, tradingDay) + "/" + compacted_marker)
+	return v, nil
+}
+
+// 按序号顺序把完好的记录复制到新库
+func copyGoodRecords(old *leveldb.DB, filename string, exchange string, v *VerifyReport) error {
+	db, err := leveldb.OpenFile(filename, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	bad := make(map[uint64]bool, len(v.Corrupt))
+	for _, r := range v.Corrupt {
+		bad[r.Seq] = true
+	}
+
+	batch := new(leveldb.Batch)
+	if m, err := old.Get(metaKey, nil); err == nil {
+		batch.Put(metaKey, m)
+	}
+	curr := uint64(0)
+	for seq := uint64(0); seq < v.Last; seq++ {
+		if bad[seq] {
+			continue
+		}
+		val, err := old.Get(utils.UintTobytes(seq), nil)
+		if err == leveldb.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		batch.Put(utils.UintTobytes(curr), val)
+		putIndex(batch, exchange, curr, val)
+		curr += 1
+		if batch.Len() >= 10000 {
+			if err := db.Write(batch, nil); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+	}
+	batch.Put(countKey, utils.UintTobytes(curr))
+	batch.Put(indexKey, utils.UintTobytes(curr))
+	return db.Write(batch, nil)
+}
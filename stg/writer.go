@@ -0,0 +1,75 @@
+package stg
+
+import (
+	"chive/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+/*
+ DayWriter --- 按stg的格式写一个交易所一天的行情
+
+ 合成或者导入的行情使用，写出的数据和stg录制的一样，replay可以直接回放
+ 记录的key从0开始递增，countKey保存记录数，打开已有的数据时修复计数后接着往后写
+*/
+type DayWriter struct {
+	exchange string
+	filename string
+	db       *leveldb.DB
+	curr     uint64
+}
+
+func NewDayWriter(path string, exchange string, tradingDay string) (*DayWriter, error) {
+	filename := makeDBFileName(path, exchange, tradingDay)
+	db, err := leveldb.OpenFile(filename, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	curr, _, err := recoverCount(db)
+	if err == nil {
+		_, err = buildIndex(db, exchange, curr)
+	}
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &DayWriter{exchange: exchange, filename: filename, db: db, curr: curr}, nil
+}
+
+// 写一条FixPackage的字节流
+func (w *DayWriter) Write(value []byte) error {
+	batch := new(leveldb.Batch)
+	batch.Put(utils.UintTobytes(w.curr), value)
+	putIndex(batch, w.exchange, w.curr, value)
+	batch.Put(countKey, utils.UintTobytes(w.curr+1))
+	batch.Put(indexKey, utils.UintTobytes(w.curr+1))
+	if err := w.db.Write(batch, nil); err != nil {
+		return err
+	}
+	w.curr += 1
+	return nil
+}
+
+// 写入当天的元数据
+func (w *DayWriter) WriteMeta(m *DayMeta) error {
+	return writeMeta(w.db, m)
+}
+
+// 已经写入的记录数
+func (w *DayWriter) Count() uint64 {
+	return w.curr
+}
+
+func (w *DayWriter) FileName() string {
+	return w.filename
+}
+
+func (w *DayWriter) Close() error {
+	return w.db.Close()
+}
+
+// 一个交易所一天的行情目录
+func DayFileName(path string, exchange string, tradingDay string) string {
+	return makeDBFileName(path, exchange, tradingDay)
+}
@@ -0,0 +1,491 @@
+/*
+ stgtool --- stg存储的行情数据的维护工具
+
+    ./stgtool -c lapf.cnf [参数] <命令>
+
+ index    给没有索引的日期补建索引，没有指定日期时处理全部日期
+ query    按合约、时间和行情类型查询，每条记录打印时间、序号、类型和合约
+ export   按同样的条件导出成csv、jsonl或者parquet，每个合约的每种行情类型一个文件
+ import   按配置文件的import节导入命令行后面的历史行情文件，./stgtool -c lapf.cnf import a.csv b.csv
+ compact  压实指定的日期，没有指定日期时压实今天之前的全部日期
+ archive  把指定的日期打包成<day>.tar.gz，replay可以直接回放打包的日期
+ prune    删除指定的日期，包括打包文件，必须用-days指定
+ maintain 按配置文件的storage节压实、打包和删除今天之前的日期，和stg后台维护一样
+ verify   检查记录是否完整，报告缺失的序号和损坏的记录，没有指定日期时检查全部日期
+ repair   丢掉损坏的记录，重新编号并修复countKey，原来的库保留为quote.bak，只处理检查有问题的日期
+ journal  打印archer的请求和回应，可以用-start和-end按stg收到的时间过滤
+*/
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"chive/config"
+	"chive/export"
+	"chive/importer"
+	"chive/logs"
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+)
+
+var (
+	exchanges = flag.String("ex", "", "exchanges, separated by ;, default all exchanges in config")
+	days      = flag.String("days", "", "trading days, separated by ;")
+	start     = flag.String("start", "", "start time, local time like 2017-12-25 14:00:00")
+	end       = flag.String("end", "", "end time, same format as start")
+	sinfo     = flag.String("sinfo", "", "instruments like okex_btc_usd_quarter, separated by ;")
+	fids      = flag.String("fids", "", "quote types tick;kline;depth;trade;index")
+	format    = flag.String("format", export.FORMAT_CSV, "export format, csv, jsonl or parquet")
+	outDir    = flag.String("o", "export", "export directory")
+	force     = flag.Bool("f", false, "overwrite the existing days when import")
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func() error
+}
+
+var commands = []command{
+	{"index", "build index for the days without index", runIndex},
+	{"query", "print the records matching -sinfo, -start, -end and -fids", runQuery},
+	{"export", "export the records matching -sinfo, -start, -end and -fids to -o in -format", runExport},
+	{"import", "import the files after the command, see the import section in config", runImport},
+	{"compact", "compact the days before today", runCompact},
+	{"archive", "pack the days given by -days into compressed archives", runArchive},
+	{"prune", "delete the days given by -days", runPrune},
+	{"maintain", "compact, archive and delete the days before today as the storage section in config", runMaintain},
+	{"verify", "check missing and corrupt records", runVerify},
+	{"repair", "rewrite the days with bad records, the old data is kept as quote.bak", runRepair},
+	{"journal", "print the archer requests and responses received between -start and -end", runJournal},
+}
+
+// 检查结果最多打印多少条缺口和损坏的记录
+const max_report_lines = 20
+
+func main() {
+	utils.InitCnf()
+	utils.InitLogger("stgtool", logs.LevelInfo)
+
+	for _, c := range commands {
+		if c.name == flag.Arg(0) {
+			if err := c.run(); err != nil {
+				fmt.Println(err)
+				os.Exit(-1)
+			}
+			return
+		}
+	}
+
+	fmt.Println("usage: stgtool [flags] <command>")
+	for _, c := range commands {
+		fmt.Printf("    %-8s %s\n", c.name, c.usage)
+	}
+	flag.PrintDefaults()
+	os.Exit(-1)
+}
+
+func splitList(s string) []string {
+	ret := []string{}
+	for _, v := range strings.Split(s, ";") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func targetExchanges() []string {
+	if *exchanges != "" {
+		return splitList(*exchanges)
+	}
+	return config.T.Exchanges
+}
+
+func parseTime(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.ParseInLocation(protocol.TM_LAYOUT_STR, s, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(t.UnixNano() / int64(time.Millisecond)), nil
+}
+
+func parseQuery() (*stg.Query, error) {
+	q := &stg.Query{Instruments: splitList(*sinfo)}
+	var err error
+	if q.Start, err = parseTime(*start); err != nil {
+		return nil, err
+	}
+	if q.End, err = parseTime(*end); err != nil {
+		return nil, err
+	}
+	for _, v := range splitList(*fids) {
+		fid, ok := utils.QuoteFidNames[strings.ToLower(v)]
+		if !ok {
+			return nil, errors.New("unknown fid " + v)
+		}
+		q.Fids = append(q.Fids, fid)
+	}
+	return q, nil
+}
+
+/*
+ 要处理的日期，依次使用-days、-start和-end算出的交易日，all为true时使用全部日期
+*/
+func targetDays(exchange string, q *stg.Query, all bool) ([]string, error) {
+	if *days != "" {
+		return splitList(*days), nil
+	}
+	if q != nil && q.Start > 0 && q.End > 0 {
+		td, err := utils.NewTradingDay(config.T.TradingDay.Timezone, config.T.TradingDay.Boundary)
+		if err != nil {
+			return nil, err
+		}
+		return td.Days(msTime(q.Start), msTime(q.End)), nil
+	}
+	if all {
+		return stg.ListDays(config.T.StgPath, exchange)
+	}
+	return nil, errors.New("set -days, or both -start and -end")
+}
+
+func msTime(ms uint64) time.Time {
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+}
+
+func runIndex() error {
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, nil, true)
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			if stg.IsArchived(config.T.StgPath, ex, d) {
+				// 打包前已经补建过索引
+				continue
+			}
+			n, err := stg.BuildIndex(config.T.StgPath, ex, d)
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			fmt.Printf("%s %s: index %d records\n", ex, d, n)
+			logs.Info("[%s_%s]补建索引[%d]条记录", ex, d, n)
+		}
+	}
+	return nil
+}
+
+func runQuery() error {
+	q, err := parseQuery()
+	if err != nil {
+		return err
+	}
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, q, false)
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			r, err := stg.OpenDayReader(config.T.StgPath, ex, d)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			count := 0
+			err = r.Query(q, func(rec *stg.Record) bool {
+				fmt.Printf("%s %s %8d %-5s %s\n", d, msTime(rec.Ts).Format("2006-01-02 15:04:05.000"),
+					rec.Seq, utils.QuoteFidStr(rec.Tid), rec.Instrument)
+				count += 1
+				return true
+			})
+			r.Close()
+			if err != nil {
+				return err
+			}
+			if !r.Indexed() {
+				fmt.Printf("%s %s has no index, scanned all records\n", ex, d)
+			}
+			fmt.Printf("%s %s: %d records\n", ex, d, count)
+		}
+	}
+	return nil
+}
+
+func runExport() error {
+	q, err := parseQuery()
+	if err != nil {
+		return err
+	}
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, q, true)
+		if err != nil {
+			return err
+		}
+		e := export.NewExporter(export.Options{
+			Path:     config.T.StgPath,
+			Exchange: ex,
+			Days:     ds,
+			Query:    *q,
+			Format:   *format,
+			Out:      *outDir,
+		})
+		ret, err := e.Run(func(day string, records uint64) {
+			fmt.Printf("%s %s: %d records\n", ex, day, records)
+		})
+		if err != nil {
+			return err
+		}
+
+		names := make([]string, 0, len(ret.Files))
+		for name := range ret.Files {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("    %s: %d rows\n", filepath.Join(*outDir, name), ret.Files[name])
+		}
+		if ret.Skipped > 0 {
+			fmt.Printf("%s: skip %d bad records\n", ex, ret.Skipped)
+		}
+		logs.Info("[%s]导出[%d]条记录到[%s]", ex, ret.Records, *outDir)
+	}
+	return nil
+}
+
+func runImport() error {
+	files := flag.Args()[1:]
+	if len(files) == 0 {
+		return errors.New("no file to import")
+	}
+	im, err := importer.NewImporter(config.T, config.T.StgPath)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		n, err := im.Read(f)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d rows\n", f, n)
+	}
+	if im.Skipped > 0 {
+		fmt.Printf("skip %d bad rows, see the log for details\n", im.Skipped)
+	}
+
+	ex := config.T.Import.Exchange
+	return im.Write(*force, func(day string, count uint64) {
+		fmt.Printf("%s: %d records\n", stg.DayFileName(config.T.StgPath, ex, day), count)
+		logs.Info("导入[%s_%s]共[%d]条记录", ex, day, count)
+	})
+}
+
+func today() (string, error) {
+	td, err := utils.NewTradingDay(config.T.TradingDay.Timezone, config.T.TradingDay.Boundary)
+	if err != nil {
+		return "", err
+	}
+	return td.Day(utils.Now()), nil
+}
+
+func runCompact() error {
+	t, err := today()
+	if err != nil {
+		return err
+	}
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, nil, true)
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			if (*days == "" && d >= t) || stg.IsArchived(config.T.StgPath, ex, d) {
+				continue
+			}
+			done, err := stg.CompactDay(config.T.StgPath, ex, d)
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			if done {
+				fmt.Printf("%s %s: compacted\n", ex, d)
+				logs.Info("[%s_%s]压实完成", ex, d)
+			}
+		}
+	}
+	return nil
+}
+
+func runArchive() error {
+	if *days == "" {
+		return errors.New("set -days to archive")
+	}
+	for _, ex := range targetExchanges() {
+		for _, d := range splitList(*days) {
+			if stg.IsArchived(config.T.StgPath, ex, d) {
+				continue
+			}
+			if err := stg.ArchiveDay(config.T.StgPath, ex, d); err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			fmt.Printf("%s %s: archived to %s\n", ex, d, stg.ArchiveFileName(config.T.StgPath, ex, d))
+			logs.Info("[%s_%s]打包完成", ex, d)
+		}
+	}
+	return nil
+}
+
+func runPrune() error {
+	if *days == "" {
+		return errors.New("set -days to delete")
+	}
+	for _, ex := range targetExchanges() {
+		for _, d := range splitList(*days) {
+			if err := stg.RemoveDay(config.T.StgPath, ex, d); err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			fmt.Printf("%s %s: deleted\n", ex, d)
+			logs.Info("[%s_%s]已删除", ex, d)
+		}
+	}
+	return nil
+}
+
+func runMaintain() error {
+	t, err := today()
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, ex := range targetExchanges() {
+		ret, err := stg.Maintain(config.T.StgPath, ex, t, stg.CnfPolicy())
+		if ret != nil {
+			fmt.Printf("%s: compacted %v, archived %v, deleted %v, failed %v\n", ex, ret.Compacted, ret.Archived, ret.Removed, ret.Failed)
+			logs.Info("stg维护[%s]完成, 压实%v, 打包%v, 删除%v, 失败%v", ex, ret.Compacted, ret.Archived, ret.Removed, ret.Failed)
+		}
+		// 一个交易所失败不影响其它交易所
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func printReport(v *stg.VerifyReport) {
+	fmt.Println(v.String())
+	for i, g := range v.Gaps {
+		if i >= max_report_lines {
+			fmt.Printf("    ... %d gaps\n", len(v.Gaps))
+			break
+		}
+		fmt.Printf("    missing %d-%d\n", g.From, g.To)
+	}
+	for i, r := range v.Corrupt {
+		if i >= max_report_lines {
+			fmt.Printf("    ... %d corrupt records\n", len(v.Corrupt))
+			break
+		}
+		fmt.Printf("    corrupt %d: %s\n", r.Seq, r.Reason)
+	}
+}
+
+func runVerify() error {
+	bad := 0
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, nil, true)
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			v, err := stg.VerifyDay(config.T.StgPath, ex, d)
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			printReport(v)
+			if !v.OK() {
+				bad += 1
+				logs.Error("检查[%s_%s]有问题: %s", ex, d, v.String())
+			}
+		}
+	}
+	if bad > 0 {
+		return fmt.Errorf("%d days have bad records, run repair to fix", bad)
+	}
+	return nil
+}
+
+func runRepair() error {
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, nil, true)
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			v, err := stg.VerifyDay(config.T.StgPath, ex, d)
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			if v.OK() {
+				continue
+			}
+			if v, err = stg.RepairDay(config.T.StgPath, ex, d); err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			printReport(v)
+			fmt.Printf("%s %s: repaired, the old data is kept in %s.bak\n", ex, d, stg.DayFileName(config.T.StgPath, ex, d))
+			logs.Info("修复[%s_%s]完成: %s", ex, d, v.String())
+		}
+	}
+	return nil
+}
+
+func runJournal() error {
+	q, err := parseQuery()
+	if err != nil {
+		return err
+	}
+	for _, ex := range targetExchanges() {
+		ds, err := targetDays(ex, q, false)
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			r, err := stg.OpenJournalReader(config.T.StgPath, ex, d)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("%s %s: %s", ex, d, err.Error())
+			}
+			count := 0
+			for seq := uint64(0); seq < r.Count(); seq++ {
+				e, err := r.Get(seq)
+				if err != nil {
+					r.Close()
+					return fmt.Errorf("%s %s %d: %s", ex, d, seq, err.Error())
+				}
+				if (q.Start > 0 && e.Recv < q.Start) || (q.End > 0 && e.Recv > q.End) {
+					continue
+				}
+				name, text := stg.FormatMessage(e.Value)
+				fmt.Printf("%s %s %8d %s %s %s\n", d, msTime(e.Recv).Format("2006-01-02 15:04:05.000"),
+					seq, stg.JournalDirStr(e.Dir), name, text)
+				count += 1
+			}
+			r.Close()
+			fmt.Printf("%s %s: %d journal records\n", ex, d, count)
+		}
+	}
+	return nil
+}
@@ -1,6 +1,9 @@
 package strategy
 
 import (
+	"errors"
+	"time"
+
 	"chive/krang"
 	"chive/logs"
 )
@@ -24,7 +27,7 @@ const (
 */
 type FSMState interface {
 	Name() string
-	Init()
+	Init(ctx krang.Context)
 	Enter(ctx krang.Context)
 	Decide(ctx krang.Context, tick *krang.Tick, e *EventCompose) string
 }
@@ -95,6 +98,7 @@ type FSM struct {
 	handlers []FSMHandler
 	evc      *EventCompose
 	states   map[string]FSMState // 全部的状态
+	since    time.Time           // 进入当前状态的时间
 }
 
 func NewFSM(name string) *FSM {
@@ -111,12 +115,45 @@ func (t *FSM) GetState() FSMState {
 	return t.state
 }
 
-func (t *FSM) SetState(stn string) {
+// 进入时间使用ctx.Now()，回放和优化器都跟着行情的时间走
+func (t *FSM) SetState(ctx krang.Context, stn string) {
+	t.setState(stn, ctx.Now())
+}
+
+func (t *FSM) setState(stn string, since time.Time) {
 	st, ok := t.states[stn]
 	if !ok {
 		panic("SetState param invalid")
 	}
 	t.state = st
+	t.since = since
+}
+
+// 进入当前状态的时间，回放时是回放行情的时间
+func (t *FSM) StateSince() time.Time {
+	return t.since
+}
+
+// 从快照恢复当前状态，不会调用状态的Enter
+func (t *FSM) RestoreState(stn string, since time.Time) {
+	t.setState(stn, since)
+}
+
+// 强制切换到stn状态，和正常跳转一样会调用状态的Enter
+func (t *FSM) ForceState(ctx krang.Context, stn string) error {
+	st, ok := t.states[stn]
+	if !ok {
+		return errors.New("fsm has no state " + stn)
+	}
+	old := "none"
+	if t.state != nil {
+		old = t.state.Name()
+	}
+	t.state = st
+	t.since = ctx.Now()
+	logs.Info("[%s]fsm 被强制从[%s]状态切换到[%s]状态", t.name, old, stn)
+	st.Enter(ctx)
+	return nil
 }
 
 func (t *FSM) AddHandler(h FSMHandler) {
@@ -154,9 +191,9 @@ func (t *FSM) Call(ctx krang.Context, tick *krang.Tick) {
 
 	oldst := t.GetState()
 	newStname := oldst.Decide(ctx, tick, t.evc)
-	t.SetState(newStname)
 
 	if oldst.Name() != newStname {
+		t.SetState(ctx, newStname)
 		logs.Info("[%s]fsm 从[%s]状态跳转到[%s]状态", t.name, oldst.Name(), newStname)
 		t.GetState().Enter(ctx)
 	}
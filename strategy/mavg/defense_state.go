@@ -3,6 +3,7 @@ package mavg
 import (
 	"time"
 
+	"chive/config"
 	"chive/krang"
 	"chive/logs"
 	"chive/protocol"
@@ -15,12 +16,13 @@ import (
 */
 
 type defenseState struct {
+	mv    *MavgStrategy
 	spm   map[string]*symbolParam // key:symbol
 	ts    int64                   // 上次进入该状态的时间
 	times int32                   // 进本状态次数
 }
 
-func NewDefenseState() strategy.FSMState {
+func NewDefenseState(mv *MavgStrategy, ps config.StrategyParams, symbols []string) strategy.FSMState {
 	ltcParam := &symbolParam{
 		klkind:         protocol.KL5Min,
 		stopLoseRate:   -0.10,
@@ -43,12 +45,14 @@ func NewDefenseState() strategy.FSMState {
 	}
 
 	st := &defenseState{
+		mv:    mv,
 		spm:   make(map[string]*symbolParam),
 		ts:    0,
 		times: 0,
 	}
 	st.spm["ltc_usd"] = ltcParam
 	st.spm["etc_usd"] = etcParam
+	loadSymbolParams(ps, STATE_NAME_DEFENSE, symbols, st.spm)
 	return st
 }
 
@@ -56,23 +60,27 @@ func (t *defenseState) Name() string {
 	return STATE_NAME_DEFENSE
 }
 
-func (t *defenseState) Init() {
+func (t *defenseState) Init(ctx krang.Context) {
 }
 
 func (t *defenseState) Enter(ctx krang.Context) {
-	t.ts = time.Now().Unix()
+	t.ts = ctx.Now().Unix()
 	t.times += 1
 
 	// 重新读取头寸信息
-	mavg.queryAllPos(ctx)
-	logs.Info("亏损次数[%d]达到限制[%d]，进入状态[%s], 进入次数[%d]", mavgStatis.lossTimes, mavgStatis.lossTimesLimit, t.Name(), t.times)
+	t.mv.queryAllPos(ctx)
+	logs.Info("亏损次数[%d]达到限制[%d]，进入状态[%s], 进入次数[%d]", t.mv.statis.lossTimes, t.mv.statis.lossTimesLimit, t.Name(), t.times)
+}
+
+func (t *defenseState) setSince(ts int64) {
+	t.ts = ts
 }
 
 func (t *defenseState) Decide(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose) string {
 	t.handleLongPart(ctx, tick, evc)
 	t.handleShortPart(ctx, tick, evc)
 
-	n := time.Now()
+	n := ctx.Now()
 	old := time.Unix(t.ts, 0)
 	d := n.Sub(old)
 	if d.Hours() >= 1 {
@@ -109,13 +117,13 @@ func (t *defenseState) handleLongPart(ctx krang.Context, tick *krang.Tick, evc *
 	// 有多头头寸情况
 	if s == strategy.SIGNAL_EMERGENCY {
 		reason := "紧急情况"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
 		return
 	}
 
 	if evc.Pos.LongFloatPRate <= sp.stopLoseRate || evc.Pos.LongFloatPRate >= sp.stopProfitRate {
 		reason := "超出止盈止损范围"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
 		return
 	}
 }
@@ -138,13 +146,13 @@ func (t *defenseState) handleShortPart(ctx krang.Context, tick *krang.Tick, evc
 	// 有空头头寸情况
 	if s == strategy.SIGNAL_EMERGENCY {
 		reason := "紧急情况"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
 		return
 	}
 
 	if evc.Pos.ShortFloatPRate <= sp.stopLoseRate || evc.Pos.ShortFloatPRate >= sp.stopProfitRate {
 		reason := "超出止盈止损范围"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
 		return
 	}
 }
@@ -1,6 +1,8 @@
 package mavg
 
 import (
+	"time"
+
 	"chive/krang"
 	"chive/logs"
 	"chive/strategy"
@@ -21,13 +23,19 @@ type MavgStrategy struct {
 	contractTypes []string
 	follows       []string
 	fsm           *strategy.FSM
+	statis        *totalStatis
 }
 
-var mavg = &MavgStrategy{}
+func NewMavgStrategy() *MavgStrategy {
+	return &MavgStrategy{}
+}
 
 // 本策略名称
 const THIS_STRATEGY_NAME = "mavg"
 
+// 定时查询资金和头寸的间隔
+const QUERY_POS_INTERVAL = 30 * time.Second
+
 // 策略状态名称
 const (
 	STATE_NAME_SHUTDOWN = "shutdown"
@@ -36,8 +44,6 @@ const (
 	STATE_NAME_DEFENSE  = "defense"
 )
 
-const FB_MAX_CHECKTIMES = 3 // 反馈中未完成命令检查次数
-
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
 /*
@@ -46,70 +52,80 @@ const FB_MAX_CHECKTIMES = 3 // 反馈中未完成命令检查次数
 func (t *MavgStrategy) Init(ctx krang.Context) {
 	logs.Info("mavg strategy is working now ...")
 
-	// 初始化统计数据工作
-	InitMavgStatis()
-
-	// 需要关注的交易所和商品合约
-	t.exchange = "okex"
-	t.symbols = []string{"ltc_usd", "etc_usd"}
-	t.contractTypes = []string{"this_week"}
+	// 需要关注的交易所和商品合约，没有配置时使用默认值
+	cnf := ctx.GetStrategyCnf(THIS_STRATEGY_NAME)
+	t.exchange = defaultExchange
+	t.symbols = defaultSymbols
+	t.contractTypes = defaultContractTypes
+	if cnf.Exchange != "" {
+		t.exchange = cnf.Exchange
+	}
+	if len(cnf.Symbols) > 0 {
+		t.symbols = cnf.Symbols
+	}
+	if len(cnf.ContractTypes) > 0 {
+		t.contractTypes = cnf.ContractTypes
+	}
+	t.follows = t.follows[:0]
 	t.makeupFllows()
+	logs.Info("mavg策略关注[%s], 品种%v, 合约%v", t.exchange, t.symbols, t.contractTypes)
+
+	// 初始化统计数据工作，每个关注的品种一份
+	t.statis = newMavgStatis(t.symbols)
 
 	// 初始化FSM
 	t.fsm = strategy.NewFSM(THIS_STRATEGY_NAME)
 
 	shutst := NewShutdownState()
-	shutst.Init()
+	shutst.Init(ctx)
 	t.fsm.AddState(shutst)
 
-	normalst := NewNormalState()
-	normalst.Init()
+	normalst := NewNormalState(t, cnf.Params, t.symbols)
+	normalst.Init(ctx)
 	t.fsm.AddState(normalst)
 
 	radicalst := NewRadicalState()
-	radicalst.Init()
+	radicalst.Init(ctx)
 	t.fsm.AddState(radicalst)
 
-	defense := NewDefenseState()
-	defense.Init()
+	defense := NewDefenseState(t, cnf.Params, t.symbols)
+	defense.Init(ctx)
 	t.fsm.AddState(defense)
 
 	// 默认状态是正常状态
-	t.fsm.SetState(STATE_NAME_NORMAL)
+	t.fsm.SetState(ctx, STATE_NAME_NORMAL)
 
 	ph := NewPosHandler()
 	t.fsm.AddHandler(ph)
 
-	mh := NewMACDHandler()
+	mh := NewMACDHandler(cnf.Params)
 	t.fsm.AddHandler(mh)
 
-	// 查询全部关注的资金和头寸
+	// 查询全部关注的资金和头寸，之后定时查询
 	t.queryAllPos(ctx)
+	ctx.Every(QUERY_POS_INTERVAL, func(ctx krang.Context) {
+		t.queryAllPos(ctx)
+	})
 }
 
 /*
   检查反馈函数
-  可以在这里检测该策略之前的下单和撤单是否成功执行
-  然后决定是否继续执行策略
+  本策略还有没有回应的下单和撤单时，暂停执行策略
+  请求超时由krang的Tracker处理，超时后会通过OnOrderState通知
 */
 func (t *MavgStrategy) CheckFeedBack(ctx krang.Context) bool {
-	fb := ctx.GetKeeper().GetFeedBack()
-	datas := fb.FindByStrategy(THIS_STRATEGY_NAME)
-	if len(datas) <= 0 {
-		return true
-	}
-
-	for _, v := range datas {
-		logs.Info("[%s]策略回馈中没有执行完成的命令：tid[%d], reqserial[%d]", THIS_STRATEGY_NAME, v.Tid, v.ReqSerial)
-		v.CheckTimes += 1
-		if v.CheckTimes >= FB_MAX_CHECKTIMES {
-			fb.Remove(v.ReqSerial)
-		}
+	return !ctx.GetKeeper().GetTracker().HasPending(THIS_STRATEGY_NAME)
+}
 
-		// 没有反馈都去及时更新头寸
-		t.queryAllPos(ctx)
+/*
+  委托状态变化
+  被拒绝或者超时后头寸可能一直是无效状态，重新查询头寸
+*/
+func (t *MavgStrategy) OnOrderState(ctx krang.Context, item *krang.TrackItem) {
+	if item.State != krang.ORDER_STATE_REJECTED && item.State != krang.ORDER_STATE_TIMEOUT {
+		return
 	}
-	return true
+	t.queryAllPos(ctx)
 }
 
 /*
@@ -124,11 +140,37 @@ func (t *MavgStrategy) OnTick(ctx krang.Context, tick *krang.Tick) {
 	t.fsm.Call(ctx, tick)
 }
 
+/*
+  委托被风控拒绝
+  头寸在OnOrderState里重新查询
+*/
+func (t *MavgStrategy) OnRiskReject(ctx krang.Context, cmd krang.SetOrderCmd, reason string) {
+	logs.Info("[%s]策略委托被风控拒绝, [%s_%s_%s], 原因[%s]", THIS_STRATEGY_NAME, cmd.Exchange, cmd.Symbol, cmd.ContractType, reason)
+}
+
+/*
+  当前状态，实现krang.StateReporter接口
+*/
+func (t *MavgStrategy) CurrentState() (string, time.Time) {
+	st := t.fsm.GetState()
+	if st == nil {
+		return "", time.Time{}
+	}
+	return st.Name(), t.fsm.StateSince()
+}
+
+/*
+  强制切换状态，实现krang.StateForcer接口
+*/
+func (t *MavgStrategy) ForceState(ctx krang.Context, state string) error {
+	return t.fsm.ForceState(ctx, state)
+}
+
 /*
 	将本策略注册到krang的策略管理器里
 */
 func RegisStrategy() {
-	krang.RegisterStrategy(THIS_STRATEGY_NAME, mavg)
+	krang.RegisterStrategy(THIS_STRATEGY_NAME, NewMavgStrategy())
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////
@@ -24,7 +24,7 @@ type symbolParam struct {
 	level          int32   // 本策略支持的杠杆
 }
 
-func ArcherOpenPos(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose, ot int32, reason string, sp *symbolParam) {
+func (mv *MavgStrategy) ArcherOpenPos(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose, ot int32, reason string, sp *symbolParam) {
 	trader := ctx.GetTrader(evc.Exchange)
 	if trader == nil {
 		return
@@ -65,13 +65,13 @@ func ArcherOpenPos(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompo
 	}
 	trader.SetOrder(cmd)
 	evc.Pos.Disable()
-	UpdateOpenStatis(evc.Symbol)
+	mv.statis.UpdateOpenStatis(evc.Symbol)
 
 	logs.Info("策略mavg开仓, [%s_%s_%s], 合约张数[%d], 币数量[%f], 订单类型[%s], 杠杆[%d], 原因[%s]",
 		cmd.Exchange, cmd.Symbol, cmd.ContractType, cmd.Amount, cmd.Vol, utils.OrderTypeStr(cmd.OrderType), sp.level, reason)
 }
 
-func ArcherClosePos(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose, ot int32, reason string, sp *symbolParam) {
+func (mv *MavgStrategy) ArcherClosePos(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose, ot int32, reason string, sp *symbolParam) {
 	trader := ctx.GetTrader(evc.Exchange)
 	if trader == nil {
 		return
@@ -115,5 +115,5 @@ func ArcherClosePos(ctx krang.Context, tick *krang.Tick, evc *strategy.EventComp
 		rate*100, profit)
 
 	// 盈亏统计
-	UpdateCloseStatis(evc.Symbol, profit)
+	mv.statis.UpdateCloseStatis(evc.Symbol, profit)
 }
@@ -0,0 +1,86 @@
+package mavg
+
+import (
+	"chive/config"
+	"chive/logs"
+	"chive/protocol"
+	"chive/utils"
+)
+
+/*
+ 从策略配置读取mavg的参数，没有配置的参数使用代码里的默认值
+
+    "params": {
+        "normal":  {"ltc_usd": {"klkind": "KL5Min", "stop_lose_rate": -0.13, "stop_profit_rate": 0.17,
+                                "min_vol": 0.1, "max_vol": 0.5, "step_rate": 0.1, "market_st": true, "level": 10}},
+        "defense": {"ltc_usd": {"stop_lose_rate": -0.10}},
+        "macd":    {"KL5Min": {"distance": 3, "fkrate": 0.20, "skrate": 0.12, "dkrate": 0.11, "fsdiff": 1.3}}
+    }
+
+ 新加的品种没有默认值，以ltc_usd的参数为模板
+*/
+
+const template_symbol = "ltc_usd"
+
+var defaultSymbols = []string{"ltc_usd", "etc_usd"}
+var defaultContractTypes = []string{"this_week"}
+
+const defaultExchange = "okex"
+
+func loadSymbolParams(ps config.StrategyParams, state string, symbols []string, spm map[string]*symbolParam) {
+	for _, s := range symbols {
+		sp, ok := spm[s]
+		if !ok {
+			tp, ok := spm[template_symbol]
+			if !ok {
+				continue
+			}
+			cp := *tp
+			sp = &cp
+			spm[s] = sp
+		}
+
+		prefix := state + "::" + s + "::"
+		if !ps.Has(state + "::" + s) {
+			continue
+		}
+		sp.klkind = parseKLKind(ps.String(prefix+"klkind", ""), sp.klkind)
+		sp.stopLoseRate = float32(ps.Float(prefix+"stop_lose_rate", float64(sp.stopLoseRate)))
+		sp.stopProfitRate = float32(ps.Float(prefix+"stop_profit_rate", float64(sp.stopProfitRate)))
+		sp.minVol = float32(ps.Float(prefix+"min_vol", float64(sp.minVol)))
+		sp.maxVol = float32(ps.Float(prefix+"max_vol", float64(sp.maxVol)))
+		sp.stepRate = float32(ps.Float(prefix+"step_rate", float64(sp.stepRate)))
+		sp.marketSt = ps.Bool(prefix+"market_st", sp.marketSt)
+		sp.level = int32(ps.Int(prefix+"level", int(sp.level)))
+		logs.Info("mavg策略[%s]状态[%s]使用配置的参数: %+v", state, s, *sp)
+	}
+}
+
+func loadKlParam(ps config.StrategyParams, kp *klParam) {
+	section := "macd::" + utils.KLineStr(kp.klkind)
+	if !ps.Has(section) {
+		return
+	}
+	prefix := section + "::"
+	kp.distance = int32(ps.Int(prefix+"distance", int(kp.distance)))
+	kp.fkrate = float32(ps.Float(prefix+"fkrate", float64(kp.fkrate)))
+	kp.skrate = float32(ps.Float(prefix+"skrate", float64(kp.skrate)))
+	kp.dkrate = float32(ps.Float(prefix+"dkrate", float64(kp.dkrate)))
+	kp.fsdiff = float32(ps.Float(prefix+"fsdiff", float64(kp.fsdiff)))
+	logs.Info("mavg策略macd[%s]使用配置的参数: %+v", utils.KLineStr(kp.klkind), *kp)
+}
+
+// K线名称转成K线类型，名称和utils.KLineStr一样
+func parseKLKind(s string, def int32) int32 {
+	if s == "" {
+		return def
+	}
+	for _, k := range []int32{protocol.KL1Min, protocol.KL3Min, protocol.KL5Min, protocol.KL15Min,
+		protocol.KL30Min, protocol.KL1H, protocol.KL1D} {
+		if utils.KLineStr(k) == s {
+			return k
+		}
+	}
+	logs.Error("mavg策略配置的K线类型[%s]不存在", s)
+	return def
+}
@@ -1,8 +1,7 @@
 package mavg
 
 import (
-	"fmt"
-
+	"chive/config"
 	"chive/krang"
 	"chive/logs"
 	"chive/protocol"
@@ -46,28 +45,14 @@ func (p *posHandler) OnTick(ctx krang.Context, tick *krang.Tick, e *strategy.Eve
 	e.Money.Balance = money.Balance
 }
 
+// 头寸写到调试日志，参数优化时多个回测同时运行，不能输出到标准输出
 func printPos(pos *krang.Pos) {
-	fmt.Println("-------------")
-	fmt.Println(pos.Symbol, pos.ContractType)
-	fmt.Println("多头合约张数：", pos.LongAmount)
-	fmt.Println("多头可平合约张数: ", pos.LongAvai)
-	fmt.Println("多头保证金: ", pos.LongBond)
-	fmt.Println("多头强平价格：", pos.LongFlatPrice)
-	fmt.Println("多头开仓平均价：", pos.LongPriceAvg)
-	fmt.Println("多头结算基准价：", pos.LongPriceCost)
-	fmt.Println("多头平仓盈亏：", pos.LongCloseProfit)
-	fmt.Println("多头浮动盈亏：", pos.LongFloatProfit)
-	fmt.Println("多头浮动盈亏比例：", pos.LongFloatPRate)
-	fmt.Println(" ")
-	fmt.Println("空头合约张数：", pos.ShortAmount)
-	fmt.Println("空头可平合约张数: ", pos.ShortAvai)
-	fmt.Println("空头保证金: ", pos.ShortBond)
-	fmt.Println("空头开仓平均价：", pos.ShortPriceAvg)
-	fmt.Println("空头结算基准价：", pos.ShortPriceCost)
-	fmt.Println("空头平仓盈亏：", pos.ShortCloseProfit)
-	fmt.Println("空头浮动盈亏：", pos.ShortFloatProfit)
-	fmt.Println("空头浮动盈亏比例：", pos.ShortFloatPRate)
-	fmt.Println("-------------")
+	logs.Debug("[%s_%s]多头合约张数[%f], 可平[%f], 保证金[%f], 强平价格[%f], 开仓均价[%f], 结算基准价[%f], 平仓盈亏[%f], 浮动盈亏[%f], 浮动盈亏比例[%f]",
+		pos.Symbol, pos.ContractType, pos.LongAmount, pos.LongAvai, pos.LongBond, pos.LongFlatPrice, pos.LongPriceAvg,
+		pos.LongPriceCost, pos.LongCloseProfit, pos.LongFloatProfit, pos.LongFloatPRate)
+	logs.Debug("[%s_%s]空头合约张数[%f], 可平[%f], 保证金[%f], 开仓均价[%f], 结算基准价[%f], 平仓盈亏[%f], 浮动盈亏[%f], 浮动盈亏比例[%f]",
+		pos.Symbol, pos.ContractType, pos.ShortAmount, pos.ShortAvai, pos.ShortBond, pos.ShortPriceAvg,
+		pos.ShortPriceCost, pos.ShortCloseProfit, pos.ShortFloatProfit, pos.ShortFloatPRate)
 }
 
 //////////////////////////////////////////////////////////////////////////
@@ -97,7 +82,7 @@ type macdHandler struct {
 	k15mp *klParam
 }
 
-func NewMACDHandler() strategy.FSMHandler {
+func NewMACDHandler(ps config.StrategyParams) strategy.FSMHandler {
 	p1 := &klParam{
 		klkind:   protocol.KL1Min,
 		distance: 6,
@@ -126,6 +111,10 @@ func NewMACDHandler() strategy.FSMHandler {
 		fsdiff:   1.2,
 	}
 
+	for _, p := range []*klParam{p1, p5, p15} {
+		loadKlParam(ps, p)
+	}
+
 	return &macdHandler{
 		k1mp:  p1,
 		k5mp:  p5,
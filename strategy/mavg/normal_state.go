@@ -1,6 +1,7 @@
 package mavg
 
 import (
+	"chive/config"
 	"chive/krang"
 	"chive/logs"
 	"chive/protocol"
@@ -16,10 +17,11 @@ import (
 */
 
 type normalState struct {
+	mv  *MavgStrategy
 	spm map[string]*symbolParam // key:symbol
 }
 
-func NewNormalState() strategy.FSMState {
+func NewNormalState(mv *MavgStrategy, ps config.StrategyParams, symbols []string) strategy.FSMState {
 	ltcParam := &symbolParam{
 		klkind:         protocol.KL5Min,
 		stopLoseRate:   -0.13,
@@ -42,10 +44,12 @@ func NewNormalState() strategy.FSMState {
 	}
 
 	st := &normalState{
+		mv:  mv,
 		spm: make(map[string]*symbolParam),
 	}
 	st.spm["ltc_usd"] = ltcParam
 	st.spm["etc_usd"] = etcParam
+	loadSymbolParams(ps, STATE_NAME_NORMAL, symbols, st.spm)
 	return st
 }
 
@@ -55,17 +59,17 @@ func (t *normalState) Name() string {
 	return STATE_NAME_NORMAL
 }
 
-func (t *normalState) Init() {
+func (t *normalState) Init(ctx krang.Context) {
 }
 
 func (t *normalState) Enter(ctx krang.Context) {
 	logs.Info("进入状态[%s]", t.Name())
 
 	// 重新进入normal state，增加限制
-	UpLossTimesLimit()
+	t.mv.statis.UpLossTimesLimit()
 
 	// 重新读取头寸信息
-	mavg.queryAllPos(ctx)
+	t.mv.queryAllPos(ctx)
 }
 
 /*
@@ -82,7 +86,7 @@ func (t *normalState) Enter(ctx krang.Context) {
 */
 func (t *normalState) Decide(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose) string {
 	// 超过亏损限制，进入保守状态
-	if IsOverLossLimit() {
+	if t.mv.statis.IsOverLossLimit() {
 		return STATE_NAME_DEFENSE
 	}
 
@@ -120,7 +124,7 @@ func (t *normalState) handleLongPart(ctx krang.Context, tick *krang.Tick, evc *s
 	if evc.Pos.LongAvai <= 0 {
 		if s == strategy.SIGNAL_BUY {
 			reason := "买入信号"
-			ArcherOpenPos(ctx, tick, evc, protocol.ORDERTYPE_OPENLONG, reason, sp)
+			t.mv.ArcherOpenPos(ctx, tick, evc, protocol.ORDERTYPE_OPENLONG, reason, sp)
 		}
 		return
 	}
@@ -128,19 +132,19 @@ func (t *normalState) handleLongPart(ctx krang.Context, tick *krang.Tick, evc *s
 	// 有多头头寸情况
 	if s == strategy.SIGNAL_EMERGENCY {
 		reason := "紧急情况"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
 		return
 	}
 
 	if evc.Pos.LongFloatPRate <= sp.stopLoseRate || evc.Pos.LongFloatPRate >= sp.stopProfitRate {
 		reason := "超出止盈止损范围"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
 		return
 	}
 
 	if s == strategy.SIGNAL_SELL {
 		reason := "卖出信号，平多"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSELONG, reason, sp)
 	}
 }
 
@@ -158,7 +162,7 @@ func (t *normalState) handleShortPart(ctx krang.Context, tick *krang.Tick, evc *
 	if evc.Pos.ShortAvai <= 0 {
 		if s == strategy.SIGNAL_SELL {
 			reason := "卖出信号"
-			ArcherOpenPos(ctx, tick, evc, protocol.ORDERTYPE_OPENSHORT, reason, sp)
+			t.mv.ArcherOpenPos(ctx, tick, evc, protocol.ORDERTYPE_OPENSHORT, reason, sp)
 		}
 		return
 	}
@@ -166,18 +170,18 @@ func (t *normalState) handleShortPart(ctx krang.Context, tick *krang.Tick, evc *
 	// 有空头头寸情况
 	if s == strategy.SIGNAL_EMERGENCY {
 		reason := "紧急情况"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
 		return
 	}
 
 	if evc.Pos.ShortFloatPRate <= sp.stopLoseRate || evc.Pos.ShortFloatPRate >= sp.stopProfitRate {
 		reason := "超出止盈止损范围"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
 		return
 	}
 
 	if s == strategy.SIGNAL_BUY {
 		reason := "买入信号，平空"
-		ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
+		t.mv.ArcherClosePos(ctx, tick, evc, protocol.ORDERTYPE_CLOSESHORT, reason, sp)
 	}
 }
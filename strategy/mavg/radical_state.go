@@ -22,7 +22,7 @@ func (t *radicalState) Name() string {
 	return STATE_NAME_RADICAL
 }
 
-func (t *radicalState) Init() {
+func (t *radicalState) Init(ctx krang.Context) {
 }
 
 func (t *radicalState) Enter(ctx krang.Context) {
@@ -27,19 +27,23 @@ func (t *shutdownState) Name() string {
 	return STATE_NAME_SHUTDOWN
 }
 
-func (t *shutdownState) Init() {
-	t.ts = time.Now().Unix()
+func (t *shutdownState) Init(ctx krang.Context) {
+	t.ts = ctx.Now().Unix()
 }
 
 func (t *shutdownState) Enter(ctx krang.Context) {
-	t.ts = time.Now().Unix()
+	t.ts = ctx.Now().Unix()
 	t.shutdownTimes += 1
 	logs.Info("进入状态[%s], 次数[%d]", t.Name(), t.shutdownTimes)
 }
 
+func (t *shutdownState) setSince(ts int64) {
+	t.ts = ts
+}
+
 // 关闭后，暂停半个小时后重开
 func (t *shutdownState) Decide(ctx krang.Context, tick *krang.Tick, evc *strategy.EventCompose) string {
-	n := time.Now()
+	n := ctx.Now()
 	old := time.Unix(t.ts, 0)
 	d := n.Sub(old)
 	if d.Minutes() >= 20 {
@@ -0,0 +1,61 @@
+package mavg
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"chive/krang"
+)
+
+/*
+ mavg策略的快照，krang重启后恢复FSM的状态和亏损统计
+ 实现krang.Snapshotter接口
+*/
+
+type mavgSnap struct {
+	State          string // FSM当前状态
+	Since          int64  // 进入当前状态的时间，秒
+	LossTimes      int32  // 总亏损次数
+	LossTimesLimit int32  // 总亏损次数限制
+}
+
+// 需要记录进入时间的状态
+type sinceSetter interface {
+	setSince(ts int64)
+}
+
+func (t *MavgStrategy) Snapshot() ([]byte, error) {
+	st := t.fsm.GetState()
+	if st == nil {
+		return nil, errors.New("mavg fsm has no state")
+	}
+	snap := &mavgSnap{
+		State:          st.Name(),
+		Since:          t.fsm.StateSince().Unix(),
+		LossTimes:      t.statis.lossTimes,
+		LossTimesLimit: t.statis.lossTimesLimit,
+	}
+	return json.Marshal(snap)
+}
+
+func (t *MavgStrategy) Restore(ctx krang.Context, data []byte) error {
+	snap := &mavgSnap{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return err
+	}
+
+	switch snap.State {
+	case STATE_NAME_SHUTDOWN, STATE_NAME_NORMAL, STATE_NAME_RADICAL, STATE_NAME_DEFENSE:
+	default:
+		return errors.New("mavg snapshot has unknown state " + snap.State)
+	}
+
+	t.fsm.RestoreState(snap.State, time.Unix(snap.Since, 0))
+	if s, ok := t.fsm.GetState().(sinceSetter); ok {
+		s.setSince(snap.Since)
+	}
+	t.statis.lossTimes = snap.LossTimes
+	t.statis.lossTimesLimit = snap.LossTimesLimit
+	return nil
+}
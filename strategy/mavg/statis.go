@@ -32,55 +32,55 @@ type totalStatis struct {
 	m              map[string]*symStatis // 各个商品的统计
 }
 
-var mavgStatis *totalStatis
-
 ////////////////////////////////////////////////////////////////////////////////////////////////////
 
-func InitMavgStatis() {
-	mavgStatis = &totalStatis{
+func newMavgStatis(symbols []string) *totalStatis {
+	ts := &totalStatis{
 		lossTimesLimit: LOSSTIMES_STEP,
 		m:              make(map[string]*symStatis),
 	}
-	mavgStatis.m["ltc_usd"] = &symStatis{}
-	mavgStatis.m["etc_usd"] = &symStatis{}
+	for _, s := range symbols {
+		ts.m[s] = &symStatis{}
+	}
+	return ts
 }
 
-func UpLossTimesLimit() {
-	mavgStatis.lossTimesLimit += LOSSTIMES_STEP
+func (s *totalStatis) UpLossTimesLimit() {
+	s.lossTimesLimit += LOSSTIMES_STEP
 }
 
-func IsOverLossLimit() bool {
-	return mavgStatis.lossTimes >= mavgStatis.lossTimesLimit
+func (s *totalStatis) IsOverLossLimit() bool {
+	return s.lossTimes >= s.lossTimesLimit
 }
 
-func UpdateOpenStatis(symbol string) {
-	ss, ok := mavgStatis.m[symbol]
+func (s *totalStatis) UpdateOpenStatis(symbol string) {
+	ss, ok := s.m[symbol]
 	if !ok {
 		return
 	}
 	ss.opTimes += 1
 	ss.openTimes += 1
-	mavgStatis.opTimes += 1
-	mavgStatis.openTimes += 1
+	s.opTimes += 1
+	s.openTimes += 1
 }
 
-func UpdateCloseStatis(symbol string, profit float32) {
-	ss, ok := mavgStatis.m[symbol]
+func (s *totalStatis) UpdateCloseStatis(symbol string, profit float32) {
+	ss, ok := s.m[symbol]
 	if !ok {
 		return
 	}
 
-	mavgStatis.opTimes += 1
-	mavgStatis.closeTimes += 1
+	s.opTimes += 1
+	s.closeTimes += 1
 	ss.opTimes += 1
 	ss.closeTimes += 1
 
 	if profit > 0 {
-		mavgStatis.profitTimes += 1
+		s.profitTimes += 1
 		ss.profitTimes += 1
 		ss.profitVol += profit
 	} else {
-		mavgStatis.lossTimes += 1
+		s.lossTimes += 1
 		ss.lossTimes += 1
 		ss.lossVol += (profit * -1)
 	}
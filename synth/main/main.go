@@ -0,0 +1,80 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"chive/config"
+	"chive/logs"
+	"chive/stg"
+	"chive/synth"
+	"chive/utils"
+)
+
+// 设置后覆盖配置文件里的synth节
+var (
+	outPath = flag.String("o", "", "write to this stg path instead of the stg in config")
+	days    = flag.String("days", "", "days to generate, separated by ;")
+	seed    = flag.Int64("seed", 0, "random seed")
+)
+
+// 目录已经存在时，默认不覆盖录制的行情
+var force = flag.Bool("f", false, "overwrite the existing days")
+
+func main() {
+	utils.InitCnf()
+	utils.InitLogger("synth", logs.LevelInfo)
+	applyFlags()
+
+	if err := run(); err != nil {
+		fmt.Println(err)
+		os.Exit(-1)
+	}
+}
+
+func applyFlags() {
+	sc := &config.T.Synth
+	if *days != "" {
+		sc.Days = strings.Split(*days, ";")
+	}
+	if *seed != 0 {
+		sc.Seed = *seed
+	}
+}
+
+func run() error {
+	path := config.T.StgPath
+	if *outPath != "" {
+		path = *outPath
+		if !strings.HasSuffix(path, "/") {
+			path += "/"
+		}
+	}
+
+	sc := config.T.Synth
+	for _, d := range sc.Days {
+		filename := stg.DayFileName(path, sc.Exchange, d)
+		if _, err := os.Stat(filename); err != nil && !stg.IsArchived(path, sc.Exchange, d) {
+			continue
+		}
+		if !*force {
+			return fmt.Errorf("%s already exists, use -f to overwrite", filename)
+		}
+		// 打包文件和交易日志也要删除，否则打包的旧数据会一直留着
+		if err := stg.RemoveDay(path, sc.Exchange, d); err != nil {
+			return err
+		}
+	}
+
+	g, err := synth.NewGenerator(config.T, path)
+	if err != nil {
+		return err
+	}
+	return g.Run(func(day string, count uint64) {
+		filename := stg.DayFileName(path, sc.Exchange, day)
+		fmt.Printf("%s: %d records\n", filename, count)
+		logs.Info("合成行情[%s]完成，共[%d]条记录", filename, count)
+	})
+}
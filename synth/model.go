@@ -0,0 +1,186 @@
+package synth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+)
+
+/*
+ 价格模型，配置文件synth节的model
+
+    "model": {
+        "type": "jump",
+        "mu": 0,
+        "sigma": 0.8,
+        "jump_rate": 4,
+        "jump_mean": -0.01,
+        "jump_std": 0.02
+    }
+
+ 1. gbm: 几何布朗运动，mu是年化漂移率，sigma是年化波动率
+ 2. jump: 跳跃扩散，在gbm上加泊松跳跃，jump_rate是平均每天跳跃的次数，跳跃的对数幅度服从N(jump_mean, jump_std)
+ 3. regime: 状态切换，regimes里每个状态有自己的mu、sigma和跳跃参数，duration是平均持续的秒数
+    状态结束后随机切换到另一个状态
+
+    "model": {
+        "type": "regime",
+        "regimes": [
+            {"mu": 0, "sigma": 0.3, "duration": 7200},
+            {"mu": -2, "sigma": 1.5, "duration": 1800, "jump_rate": 20, "jump_mean": -0.005, "jump_std": 0.01}
+        ]
+    }
+
+ 没有配置model时使用sigma为0.8的gbm
+*/
+
+const (
+	MODEL_GBM    = "gbm"
+	MODEL_JUMP   = "jump"
+	MODEL_REGIME = "regime"
+)
+
+const (
+	year_seconds = 365 * 24 * 3600
+	day_seconds  = 24 * 3600
+)
+
+const default_sigma = 0.8
+
+// 价格模型，每个合约一个实例
+type Model interface {
+	// dt秒内价格的对数收益率
+	Step(dt float64, rnd *rand.Rand) float64
+}
+
+// 参数都是年化的，跳跃次数是每天的
+type diffusion struct {
+	mu       float64
+	sigma    float64
+	jumpRate float64
+	jumpMean float64
+	jumpStd  float64
+}
+
+func (d *diffusion) Step(dt float64, rnd *rand.Rand) float64 {
+	t := dt / year_seconds
+	r := (d.mu-0.5*d.sigma*d.sigma)*t + d.sigma*math.Sqrt(t)*rnd.NormFloat64()
+	if d.jumpRate > 0 && rnd.Float64() < d.jumpRate*dt/day_seconds {
+		r += d.jumpMean + d.jumpStd*rnd.NormFloat64()
+	}
+	return r
+}
+
+type regime struct {
+	diffusion
+	duration float64 // 平均持续的秒数
+}
+
+type regimeSwitch struct {
+	regimes []*regime
+	curr    int
+}
+
+func (m *regimeSwitch) Step(dt float64, rnd *rand.Rand) float64 {
+	g := m.regimes[m.curr]
+	if len(m.regimes) > 1 && rnd.Float64() < dt/g.duration {
+		n := rnd.Intn(len(m.regimes) - 1)
+		if n >= m.curr {
+			n += 1
+		}
+		m.curr = n
+	}
+	return m.regimes[m.curr].Step(dt, rnd)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+func floatParam(m map[string]interface{}, key string, def float64) (float64, error) {
+	v, ok := m[key]
+	if !ok {
+		return def, nil
+	}
+	f, ok := v.(float64)
+	if !ok {
+		return 0, fmt.Errorf("synth param %s should be a number", key)
+	}
+	return f, nil
+}
+
+func parseDiffusion(m map[string]interface{}) (diffusion, error) {
+	d := diffusion{}
+	var err error
+	for _, p := range []struct {
+		key string
+		v   *float64
+		def float64
+	}{
+		{"mu", &d.mu, 0},
+		{"sigma", &d.sigma, default_sigma},
+		{"jump_rate", &d.jumpRate, 0},
+		{"jump_mean", &d.jumpMean, 0},
+		{"jump_std", &d.jumpStd, 0},
+	} {
+		if *p.v, err = floatParam(m, p.key, p.def); err != nil {
+			return d, err
+		}
+	}
+	if d.sigma < 0 || d.jumpRate < 0 || d.jumpStd < 0 {
+		return d, errors.New("synth sigma, jump_rate and jump_std should not be negative")
+	}
+	return d, nil
+}
+
+/*
+ 按配置创建价格模型，每次调用返回一个新的实例
+*/
+func NewModel(m map[string]interface{}) (Model, error) {
+	if m == nil {
+		m = map[string]interface{}{}
+	}
+	typ, _ := m["type"].(string)
+	switch typ {
+	case "", MODEL_GBM:
+		d, err := parseDiffusion(m)
+		if err != nil {
+			return nil, err
+		}
+		d.jumpRate = 0
+		return &d, nil
+
+	case MODEL_JUMP:
+		d, err := parseDiffusion(m)
+		if err != nil {
+			return nil, err
+		}
+		return &d, nil
+
+	case MODEL_REGIME:
+		rs, ok := m["regimes"].([]interface{})
+		if !ok || len(rs) == 0 {
+			return nil, errors.New("synth regime model needs regimes")
+		}
+		ret := &regimeSwitch{}
+		for _, v := range rs {
+			rm, ok := v.(map[string]interface{})
+			if !ok {
+				return nil, errors.New("synth regime should be an object")
+			}
+			d, err := parseDiffusion(rm)
+			if err != nil {
+				return nil, err
+			}
+			duration, err := floatParam(rm, "duration", 0)
+			if err != nil {
+				return nil, err
+			}
+			if duration <= 0 {
+				return nil, errors.New("synth regime duration should be greater than 0")
+			}
+			ret.regimes = append(ret.regimes, &regime{diffusion: d, duration: duration})
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("unknown synth model %s", typ)
+}
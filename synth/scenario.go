@@ -0,0 +1,191 @@
+package synth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"chive/protocol"
+)
+
+/*
+ 预设的行情事件，配置文件synth节的scenario，叠加在价格模型上
+
+    "scenario": [
+        {"type": "crash", "at": "2017-12-25 10:00:00", "size": -0.3, "duration": 300, "recover": 0.6, "recover_duration": 3600},
+        {"type": "gap", "at": "2017-12-25 16:00:00", "size": 0.05, "symbols": "btc_usd"},
+        {"type": "flat", "at": "2017-12-25 18:00:00", "duration": 7200},
+        {"type": "vol", "at": "2017-12-25 21:00:00", "duration": 1800, "multiplier": 5}
+    ]
+
+ 1. crash: duration秒内价格线性地变化size的比例，之后recover_duration秒内收回recover比例的跌幅
+ 2. gap: 价格在at时刻跳空size的比例
+ 3. flat: duration秒内价格模型不动，价格保持不变
+ 4. vol: duration秒内价格模型的收益率放大multiplier倍
+ 5. at是本地时间，格式2006-01-02 15:04:05，symbols限定事件作用的品种，用;分隔，为空时作用于全部品种
+
+ crash和gap的影响是永久的，事件之后的价格在变化后的水平上继续按模型运动
+*/
+
+const (
+	EVENT_CRASH = "crash"
+	EVENT_GAP   = "gap"
+	EVENT_FLAT  = "flat"
+	EVENT_VOL   = "vol"
+)
+
+type event struct {
+	typ             string
+	at              uint64 // 毫秒
+	size            float64
+	duration        uint64 // 毫秒
+	recover         float64
+	recoverDuration uint64 // 毫秒
+	multiplier      float64
+	symbols         map[string]bool
+}
+
+func (e *event) match(symbol string) bool {
+	return len(e.symbols) == 0 || e.symbols[symbol]
+}
+
+func (e *event) during(ts uint64) bool {
+	return ts >= e.at && ts < e.at+e.duration
+}
+
+// 事件在ts时对数价格的偏移
+func (e *event) offset(ts uint64) float64 {
+	if ts < e.at || (e.typ != EVENT_CRASH && e.typ != EVENT_GAP) {
+		return 0
+	}
+	target := math.Log(1 + e.size)
+	if e.typ == EVENT_GAP {
+		return target
+	}
+
+	if ts < e.at+e.duration {
+		return target * float64(ts-e.at) / float64(e.duration)
+	}
+	if e.recover == 0 {
+		return target
+	}
+	p := 1.0
+	if e.recoverDuration > 0 {
+		p = math.Min(1, float64(ts-e.at-e.duration)/float64(e.recoverDuration))
+	}
+	return target * (1 - e.recover*p)
+}
+
+type scenario []*event
+
+// 品种在ts时全部事件的对数价格偏移
+func (s scenario) offset(symbol string, ts uint64) float64 {
+	var ret float64
+	for _, e := range s {
+		if e.match(symbol) {
+			ret += e.offset(ts)
+		}
+	}
+	return ret
+}
+
+// 模型收益率的倍数，flat为0
+func (s scenario) scale(symbol string, ts uint64) float64 {
+	ret := 1.0
+	for _, e := range s {
+		if !e.match(symbol) || !e.during(ts) {
+			continue
+		}
+		if e.typ == EVENT_FLAT {
+			return 0
+		}
+		if e.typ == EVENT_VOL {
+			ret *= e.multiplier
+		}
+	}
+	return ret
+}
+
+func secondsParam(m map[string]interface{}, key string) (uint64, error) {
+	f, err := floatParam(m, key, 0)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 {
+		return 0, fmt.Errorf("synth scenario %s should not be negative", key)
+	}
+	return uint64(f * 1000), nil
+}
+
+func parseEvent(v interface{}) (*event, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("synth scenario event should be an object")
+	}
+
+	e := &event{symbols: make(map[string]bool)}
+	e.typ, _ = m["type"].(string)
+	at, _ := m["at"].(string)
+	tm, err := time.ParseInLocation(protocol.TM_LAYOUT_STR, at, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("synth scenario event at [%s] error: %s", at, err.Error())
+	}
+	e.at = uint64(tm.UnixNano() / int64(time.Millisecond))
+	if s, ok := m["symbols"].(string); ok {
+		for _, v := range strings.Split(s, ";") {
+			if v = strings.TrimSpace(v); v != "" {
+				e.symbols[v] = true
+			}
+		}
+	}
+
+	if e.size, err = floatParam(m, "size", 0); err != nil {
+		return nil, err
+	}
+	if e.recover, err = floatParam(m, "recover", 0); err != nil {
+		return nil, err
+	}
+	if e.multiplier, err = floatParam(m, "multiplier", 1); err != nil {
+		return nil, err
+	}
+	if e.duration, err = secondsParam(m, "duration"); err != nil {
+		return nil, err
+	}
+	if e.recoverDuration, err = secondsParam(m, "recover_duration"); err != nil {
+		return nil, err
+	}
+
+	switch e.typ {
+	case EVENT_CRASH, EVENT_GAP:
+		if e.size <= -1 {
+			return nil, errors.New("synth scenario size should be greater than -1")
+		}
+		if e.typ == EVENT_CRASH && e.duration == 0 {
+			return nil, errors.New("synth crash needs a duration")
+		}
+	case EVENT_FLAT, EVENT_VOL:
+		if e.duration == 0 {
+			return nil, fmt.Errorf("synth %s needs a duration", e.typ)
+		}
+		if e.multiplier < 0 {
+			return nil, errors.New("synth vol multiplier should not be negative")
+		}
+	default:
+		return nil, fmt.Errorf("unknown synth scenario event %s", e.typ)
+	}
+	return e, nil
+}
+
+func parseScenario(events []interface{}) (scenario, error) {
+	ret := scenario{}
+	for _, v := range events {
+		e, err := parseEvent(v)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
@@ -0,0 +1,268 @@
+/*
+ synth --- 合成行情
+
+ 1. 按价格模型生成每个合约的价格路径，叠加预设的行情事件，比如闪崩、跳空、长时间横盘
+ 2. 每个interval毫秒生成一个tick，每个kline_interval毫秒推送一次还没有结束的k线，k线结束时推送最终的k线
+ 3. 写到<stg>/<exchange>/<day>/quote，格式和stg录制的一样，replay可以直接回放
+    每天的时间范围按配置的交易日计算，同时写入当天的元数据
+ 4. 多天的价格是连续的，相同的配置和种子生成相同的行情
+*/
+package synth
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"chive/config"
+	"chive/protocol"
+	"chive/stg"
+	"chive/utils"
+
+	"github.com/golang/protobuf/proto"
+)
+
+// 成交量和价格变化的幅度正相关
+const volume_impact = 1000
+
+type bar struct {
+	ts     uint64 // k线开始时间
+	open   float64
+	high   float64
+	low    float64
+	close  float64
+	amount float64 // 张
+	vol    float64 // 币
+}
+
+type instrument struct {
+	symbol       string
+	contractType string
+	price0       float64
+	unitAmount   float64
+	model        Model
+	drift        float64 // 模型累计的对数收益率
+	logPrice     float64 // 上一个tick的对数价格
+	bars         map[int32]*bar
+
+	dayHigh float64
+	dayLow  float64
+	dayVol  float64
+}
+
+type Generator struct {
+	cnf      *config.AppCnf
+	path     string
+	kinds    []int32
+	insts    []*instrument
+	scenario scenario
+	td       *utils.TradingDay
+	rnd      *rand.Rand
+}
+
+/*
+ path是存储目录，格式和配置文件里的stg一样
+*/
+func NewGenerator(cnf *config.AppCnf, path string) (*Generator, error) {
+	sc := cnf.Synth
+	if len(sc.Days) == 0 {
+		return nil, errors.New("synth days is not set")
+	}
+	if len(sc.Symbols) == 0 {
+		return nil, errors.New("synth symbols is not set")
+	}
+	if sc.Interval <= 0 || sc.KLineInterval <= 0 {
+		return nil, errors.New("synth interval and kline_interval should be greater than 0")
+	}
+
+	g := &Generator{
+		cnf:  cnf,
+		path: path,
+		rnd:  rand.New(rand.NewSource(sc.Seed)),
+	}
+	for _, s := range sc.KLines {
+		k, ok := utils.ParseKLine(s)
+		if !ok {
+			return nil, errors.New("unknown synth kline " + s)
+		}
+		g.kinds = append(g.kinds, k)
+	}
+
+	var err error
+	if g.scenario, err = parseScenario(sc.Scenario); err != nil {
+		return nil, err
+	}
+	if g.td, err = utils.NewTradingDay(cnf.TradingDay.Timezone, cnf.TradingDay.Boundary); err != nil {
+		return nil, err
+	}
+
+	// 按品种排序，保证随机数的使用顺序一样
+	symbols := make([]string, 0, len(sc.Symbols))
+	for s := range sc.Symbols {
+		symbols = append(symbols, s)
+	}
+	sort.Strings(symbols)
+	for _, s := range symbols {
+		ss := sc.Symbols[s]
+		if ss.Price <= 0 || ss.UnitAmount <= 0 {
+			return nil, errors.New("synth price and unit_amount of " + s + " should be greater than 0")
+		}
+		for _, c := range sc.ContractTypes {
+			m, err := NewModel(sc.Model)
+			if err != nil {
+				return nil, err
+			}
+			g.insts = append(g.insts, &instrument{
+				symbol:       s,
+				contractType: c,
+				price0:       ss.Price,
+				unitAmount:   ss.UnitAmount,
+				model:        m,
+				logPrice:     math.Log(ss.Price),
+				bars:         make(map[int32]*bar),
+			})
+		}
+	}
+	return g, nil
+}
+
+// 按日期顺序生成全部行情，每写完一天回调一次
+func (g *Generator) Run(progress func(day string, count uint64)) error {
+	days := append([]string{}, g.cnf.Synth.Days...)
+	sort.Strings(days)
+	for _, day := range days {
+		count, err := g.writeDay(day)
+		if err != nil {
+			return err
+		}
+		if progress != nil {
+			progress(day, count)
+		}
+	}
+	return nil
+}
+
+func (g *Generator) writeDay(day string) (uint64, error) {
+	meta, err := stg.NewDayMeta(g.cnf.Synth.Exchange, day, g.td)
+	if err != nil {
+		return 0, err
+	}
+	start, end := meta.Start, meta.End
+
+	w, err := stg.NewDayWriter(g.path, g.cnf.Synth.Exchange, day)
+	if err != nil {
+		return 0, err
+	}
+	defer w.Close()
+	if err := w.WriteMeta(meta); err != nil {
+		return 0, err
+	}
+
+	for _, inst := range g.insts {
+		inst.dayHigh, inst.dayLow, inst.dayVol = 0, 0, 0
+	}
+	interval := uint64(g.cnf.Synth.Interval)
+	for ts := start; ts < end; ts += interval {
+		for _, inst := range g.insts {
+			if err := g.step(w, inst, ts); err != nil {
+				return w.Count(), err
+			}
+		}
+	}
+	return w.Count(), nil
+}
+
+/*
+ 生成一个tick，更新k线，到了推送间隔或者k线结束时推送k线
+*/
+func (g *Generator) step(w *stg.DayWriter, inst *instrument, ts uint64) error {
+	sc := g.cnf.Synth
+	dt := float64(sc.Interval) / 1000
+	inst.drift += inst.model.Step(dt, g.rnd) * g.scenario.scale(inst.symbol, ts)
+	logPrice := math.Log(inst.price0) + inst.drift + g.scenario.offset(inst.symbol, ts)
+	change := math.Abs(logPrice - inst.logPrice)
+	inst.logPrice = logPrice
+
+	price := math.Exp(logPrice)
+	amount := math.Floor(sc.Volume*g.rnd.ExpFloat64()*(1+volume_impact*change) + 0.5)
+	if inst.dayHigh == 0 || price > inst.dayHigh {
+		inst.dayHigh = price
+	}
+	if inst.dayLow == 0 || price < inst.dayLow {
+		inst.dayLow = price
+	}
+	inst.dayVol += amount
+
+	tick := &protocol.PBFutureTick{
+		Last:    proto.Float32(float32(price)),
+		Bid:     proto.Float32(float32(price * (1 - sc.Spread/2))),
+		Ask:     proto.Float32(float32(price * (1 + sc.Spread/2))),
+		Vol:     proto.Float32(float32(amount)),
+		DayHigh: proto.Float32(float32(inst.dayHigh)),
+		DayLow:  proto.Float32(float32(inst.dayLow)),
+		DayVol:  proto.Float32(float32(inst.dayVol)),
+		Sinfo:   g.sinfo(inst, ts),
+	}
+	if err := g.write(w, protocol.FID_QUOTE_TICK, tick); err != nil {
+		return err
+	}
+
+	next := ts + uint64(sc.Interval)
+	for _, k := range g.kinds {
+		kts := barStart(ts, utils.KLineMillis(k), g.td.Location())
+		b, ok := inst.bars[k]
+		if !ok || b.ts != kts {
+			b = &bar{ts: kts, open: price, high: price, low: price}
+			inst.bars[k] = b
+		}
+		b.high = math.Max(b.high, price)
+		b.low = math.Min(b.low, price)
+		b.close = price
+		b.amount += amount
+		b.vol += amount * inst.unitAmount / price
+
+		if ts%uint64(sc.KLineInterval) != 0 && barStart(next, utils.KLineMillis(k), g.td.Location()) == kts {
+			continue
+		}
+		kl := &protocol.PBFutureKLine{
+			Open:   proto.Float32(float32(b.open)),
+			High:   proto.Float32(float32(b.high)),
+			Low:    proto.Float32(float32(b.low)),
+			Close:  proto.Float32(float32(b.close)),
+			Amount: proto.Float32(float32(b.amount)),
+			Vol:    proto.Float32(float32(b.vol)),
+			Kind:   proto.Int32(k),
+			Sinfo:  g.sinfo(inst, kts),
+		}
+		if err := g.write(w, protocol.FID_QUOTE_KLine, kl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// k线按交易日的时区对齐，日线从该时区的0点开始
+func barStart(ts uint64, unit uint64, loc *time.Location) uint64 {
+	_, offset := time.Unix(int64(ts/1000), 0).In(loc).Zone()
+	local := int64(ts) + int64(offset)*1000
+	return ts - uint64(local%int64(unit))
+}
+
+func (g *Generator) sinfo(inst *instrument, ts uint64) *protocol.PBQuoteSymbol {
+	return &protocol.PBQuoteSymbol{
+		Exchange:     proto.String(g.cnf.Synth.Exchange),
+		Symbol:       proto.String(inst.symbol),
+		ContractType: proto.String(inst.contractType),
+		Timestamp:    proto.Uint64(ts),
+	}
+}
+
+func (g *Generator) write(w *stg.DayWriter, tid int, pb proto.Message) error {
+	bin, err := utils.PackMessage(tid, 0, pb)
+	if err != nil {
+		return err
+	}
+	return w.Write(bin)
+}
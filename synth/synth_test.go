@@ -0,0 +1,113 @@
+package synth
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"testing"
+
+	"chive/config"
+	"chive/protocol"
+	"chive/replay"
+	"chive/stg"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func TestScenario(t *testing.T) {
+	sc, err := parseScenario([]interface{}{
+		map[string]interface{}{"type": "crash", "at": "2017-12-25 10:00:00", "size": -0.3, "duration": 300.0, "recover": 0.5, "recover_duration": 600.0},
+		map[string]interface{}{"type": "flat", "at": "2017-12-25 12:00:00", "duration": 60.0, "symbols": "btc_usd"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := sc[0].at
+	price := func(ts uint64) float64 {
+		return 100 * math.Exp(sc.offset("ltc_usd", ts))
+	}
+	if p := price(at - 1000); p != 100 {
+		t.Fatalf("price before crash is %f", p)
+	}
+	if p := price(at + 300*1000); math.Abs(p-70) > 1e-6 {
+		t.Fatalf("price at the bottom is %f, want 70", p)
+	}
+	if p := price(at + 2000*1000); math.Abs(p-100*math.Sqrt(0.7)) > 1e-6 {
+		t.Fatalf("price after half recover is %f", p)
+	}
+
+	flat := sc[1].at + 1000
+	if sc.scale("btc_usd", flat) != 0 || sc.scale("ltc_usd", flat) != 1 {
+		t.Fatal("flat should only stop btc_usd")
+	}
+
+	if _, err := parseScenario([]interface{}{map[string]interface{}{"type": "crash", "at": "2017-12-25 10:00:00", "size": -0.3}}); err == nil {
+		t.Fatal("crash without duration should fail")
+	}
+}
+
+func TestGenerator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "synth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cnf := &config.AppCnf{StgPath: dir + "/", Exchanges: []string{"okex"}}
+	cnf.Replay.Days = []string{"2017-12-25"}
+	sc := &cnf.Synth
+	sc.Exchange = "okex"
+	sc.Days = []string{"2017-12-25"}
+	sc.ContractTypes = []string{"this_week"}
+	sc.Symbols = map[string]config.SynthSymbol{"ltc_usd": {Price: 100, UnitAmount: 10}}
+	sc.Interval = 60 * 1000
+	sc.KLineInterval = 5 * 60 * 1000
+	sc.KLines = []string{"KL15Min"}
+	sc.Volume = 10
+	sc.Seed = 1
+	sc.Model = map[string]interface{}{"type": "gbm", "sigma": 0.0}
+	sc.Scenario = []interface{}{map[string]interface{}{"type": "gap", "at": "2017-12-25 12:00:00", "size": 0.1}}
+
+	g, err := NewGenerator(cnf, cnf.StgPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Run(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	msgs, err := replay.LoadMessages(cnf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ticks, klines := 0, 0
+	var last float32
+	for _, m := range msgs {
+		p := protocol.FixPackage{}
+		p.ParseFromArray(m.Value)
+		switch p.Tid {
+		case protocol.FID_QUOTE_TICK:
+			pb := &protocol.PBFutureTick{}
+			proto.Unmarshal(p.Payload, pb)
+			last = pb.GetLast()
+			ticks += 1
+		case protocol.FID_QUOTE_KLine:
+			klines += 1
+		}
+	}
+	// 每分钟一个tick，15分钟k线每5分钟推送一次，结束时再推送一次
+	if ticks != 24*60 || klines != 24*4*4 {
+		t.Fatalf("generate %d ticks and %d klines", ticks, klines)
+	}
+	if math.Abs(float64(last)-110) > 1e-3 {
+		t.Fatalf("last price is %f, want 110 after gap", last)
+	}
+
+	meta, err := stg.ReadMeta(cnf.StgPath, "okex", "2017-12-25")
+	if err != nil || meta == nil {
+		t.Fatalf("read meta error %v", err)
+	}
+	if code := meta.ContractCode("ltc_usd", "this_week"); code != "LTC1229" || meta.Contracts["quarter"] != "180330" {
+		t.Fatalf("this_week is %s, contracts %v", code, meta.Contracts)
+	}
+}
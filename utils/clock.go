@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+/*
+ 时钟，程序里需要当前时间的地方都从这里取
+
+ 1. 默认是本机时钟
+ 2. 回放时使用虚拟时钟，时间由回放的行情推进，保证回放的结果和实盘一致并且可以重现
+*/
+
+type Clock interface {
+	Now() time.Time
+}
+
+type wallClock struct {
+}
+
+func (c *wallClock) Now() time.Time {
+	return time.Now()
+}
+
+func NewWallClock() Clock {
+	return &wallClock{}
+}
+
+/*
+ 虚拟时钟，时间只会向前走
+ 还没有推进过的虚拟时钟返回零点时间(1970-01-01)
+*/
+type VirtualClock struct {
+	ms uint64
+}
+
+func NewVirtualClock() *VirtualClock {
+	return &VirtualClock{}
+}
+
+func (c *VirtualClock) Now() time.Time {
+	ms := atomic.LoadUint64(&c.ms)
+	return time.Unix(int64(ms/1000), int64(ms%1000)*int64(time.Millisecond))
+}
+
+// 推进时钟，ms是毫秒时间戳，比当前时间早的忽略
+func (c *VirtualClock) Advance(ms uint64) {
+	for {
+		old := atomic.LoadUint64(&c.ms)
+		if ms <= old || atomic.CompareAndSwapUint64(&c.ms, old, ms) {
+			return
+		}
+	}
+}
+
+// 是否已经推进过
+func (c *VirtualClock) Started() bool {
+	return atomic.LoadUint64(&c.ms) > 0
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////
+
+var clock atomic.Value
+
+func SetClock(c Clock) {
+	clock.Store(&c)
+}
+
+func GetClock() Clock {
+	return *(clock.Load().(*Clock))
+}
+
+func Now() time.Time {
+	return GetClock().Now()
+}
+
+// 当前时间，毫秒
+func NowMs() uint64 {
+	return uint64(Now().UnixNano() / int64(time.Millisecond))
+}
+
+func init() {
+	SetClock(NewWallClock())
+}
@@ -12,10 +12,20 @@ import (
 )
 
 func PackAndReplyToBroker(topic string, key string, tid int, reqSerial int, pb proto.Message) error {
-	data, err := proto.Marshal(pb)
+	bin, err := PackMessage(tid, reqSerial, pb)
 	if err != nil {
 		return err
 	}
+	kfc.SendMessage(topic, key, bin)
+	return nil
+}
+
+// 打包成FixPackage的字节流，和kafka里的消息一样
+func PackMessage(tid int, reqSerial int, pb proto.Message) ([]byte, error) {
+	data, err := proto.Marshal(pb)
+	if err != nil {
+		return nil, err
+	}
 
 	pket := &protocol.FixPackage{}
 	pket.BodyLen = uint32(len(data))
@@ -23,10 +33,7 @@ func PackAndReplyToBroker(topic string, key string, tid int, reqSerial int, pb p
 	pket.ReqSerial = uint32(reqSerial)
 	pket.Attribute = 0
 	pket.Payload = data
-
-	bin := pket.SerialToArray()
-	kfc.SendMessage(topic, key, bin)
-	return nil
+	return pket.SerialToArray(), nil
 }
 
 func MakeupSinfo(ex string, symbol string, contractType string) string {
@@ -79,6 +86,50 @@ func KLineStr(kl int32) string {
 	return "未知"
 }
 
+// k线的周期，毫秒
+var klineMillis = map[int32]uint64{
+	protocol.KL1Min:  60 * 1000,
+	protocol.KL3Min:  3 * 60 * 1000,
+	protocol.KL5Min:  5 * 60 * 1000,
+	protocol.KL15Min: 15 * 60 * 1000,
+	protocol.KL30Min: 30 * 60 * 1000,
+	protocol.KL1H:    3600 * 1000,
+	protocol.KL1D:    24 * 3600 * 1000,
+}
+
+// k线的周期，毫秒，未知的类型返回0
+func KLineMillis(kl int32) uint64 {
+	return klineMillis[kl]
+}
+
+// KLineStr的反向转换
+func ParseKLine(s string) (int32, bool) {
+	for k := range klineMillis {
+		if KLineStr(k) == s {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// 行情消息类型的名称，replay和stgtool的fids参数使用
+var QuoteFidNames = map[string]uint32{
+	"tick":  protocol.FID_QUOTE_TICK,
+	"kline": protocol.FID_QUOTE_KLine,
+	"depth": protocol.FID_QUOTE_Depth,
+	"trade": protocol.FID_QUOTE_Trade,
+	"index": protocol.FID_QUOTE_Index,
+}
+
+func QuoteFidStr(fid uint32) string {
+	for k, v := range QuoteFidNames {
+		if v == fid {
+			return k
+		}
+	}
+	return "未知"
+}
+
 func FcsStr(fcs int32) string {
 	switch fcs {
 	case protocol.FCS_NONE:
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 简单的cron表达式，5个字段：分 时 日 月 星期
+// 每个字段支持 *、*/n、a、a-b、a-b/n 以及用,分割的多个值
+// 星期0和7都表示星期日，日和星期都不是*时，满足其中一个即可
+
+type CronSpec struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool
+	anyDow bool
+}
+
+type cronField struct {
+	min int
+	max int
+}
+
+var cronFields = []cronField{
+	{0, 59}, // 分
+	{0, 23}, // 时
+	{1, 31}, // 日
+	{1, 12}, // 月
+	{0, 7},  // 星期
+}
+
+// 最多向后查找5年
+const cron_max_years = 5
+
+func ParseCron(expr string) (*CronSpec, error) {
+	fs := strings.Fields(expr)
+	if len(fs) != len(cronFields) {
+		return nil, errors.New("cron expression should have 5 fields")
+	}
+
+	bits := make([]uint64, len(fs))
+	for i, f := range fs {
+		b, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("cron field [%s] error: %s", f, err.Error())
+		}
+		bits[i] = b
+	}
+
+	// 星期7和星期0一样
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &CronSpec{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: fs[2] == "*",
+		anyDow: fs[4] == "*",
+	}, nil
+}
+
+func parseCronField(f string, cf cronField) (uint64, error) {
+	var bits uint64 = 0
+	for _, part := range strings.Split(f, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx >= 0 {
+			n, err := strconv.Atoi(part[idx+1:])
+			if err != nil || n <= 0 {
+				return 0, errors.New("invalid step")
+			}
+			step = n
+			part = part[:idx]
+		}
+
+		lo, hi := cf.min, cf.max
+		if part != "*" {
+			rg := strings.SplitN(part, "-", 2)
+			n, err := strconv.Atoi(rg[0])
+			if err != nil {
+				return 0, errors.New("invalid number")
+			}
+			lo, hi = n, n
+			if len(rg) == 2 {
+				n, err = strconv.Atoi(rg[1])
+				if err != nil {
+					return 0, errors.New("invalid number")
+				}
+				hi = n
+			} else if step > 1 {
+				hi = cf.max
+			}
+		}
+		if lo < cf.min || hi > cf.max || lo > hi {
+			return 0, errors.New("out of range")
+		}
+
+		for i := lo; i <= hi; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+	return bits, nil
+}
+
+func (c *CronSpec) matchDay(t time.Time) bool {
+	d := c.dom&(1<<uint(t.Day())) != 0
+	w := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return d && w
+	}
+	return d || w
+}
+
+/*
+ 返回t之后第一个满足表达式的时间，秒数为0
+ 找不到时返回零值
+*/
+func (c *CronSpec) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	end := t.AddDate(cron_max_years, 0, 0)
+
+	for t.Before(end) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
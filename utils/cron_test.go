@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	base := time.Date(2018, 1, 1, 10, 7, 30, 0, time.UTC) // 星期一
+
+	cases := []struct {
+		expr string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2018, 1, 1, 10, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2018, 1, 1, 10, 15, 0, 0, time.UTC)},
+		{"0 16 * * *", time.Date(2018, 1, 1, 16, 0, 0, 0, time.UTC)},
+		{"30 9 * * *", time.Date(2018, 1, 2, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 5", time.Date(2018, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 2 *", time.Date(2018, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 8-10/2 * * *", time.Date(2018, 1, 2, 8, 0, 0, 0, time.UTC)},
+		{"0 0 15 * 0", time.Date(2018, 1, 7, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, c := range cases {
+		spec, err := ParseCron(c.expr)
+		if err != nil {
+			t.Fatalf("parse [%s] error: %s", c.expr, err.Error())
+		}
+		if n := spec.Next(base); !n.Equal(c.next) {
+			t.Fatalf("[%s] next should be %s, got %s", c.expr, c.next, n)
+		}
+	}
+
+	for _, expr := range []string{"* * * *", "60 * * * *", "a * * * *", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := ParseCron(expr); err == nil {
+			t.Fatalf("[%s] should be invalid", expr)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/*
+ 交易日
+
+ 1. 交易日D在时区loc里的D日boundary时刻结束，开始于前一天的boundary时刻
+    boundary为0时就是D日的0点到24点
+ 2. okex每天16:00(UTC+8)结算，周五16:00交割周合约，默认的交易日和它对齐
+ 3. 时区可以是+08:00这样的固定偏移，也可以是Asia/Shanghai这样的名称，为空时使用本地时区
+*/
+type TradingDay struct {
+	loc      *time.Location
+	boundary time.Duration // 交易日结束的时刻，距离0点
+}
+
+const day_layout = "2006-01-02"
+
+func NewTradingDay(timezone string, boundary string) (*TradingDay, error) {
+	loc, err := ParseTimezone(timezone)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &TradingDay{loc: loc}
+	if boundary = strings.TrimSpace(boundary); boundary != "" {
+		t, err := time.Parse("15:04:05", boundary)
+		if err != nil {
+			return nil, errors.New("trading day boundary should be like 16:00:00")
+		}
+		d.boundary = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+			time.Duration(t.Second())*time.Second
+	}
+	if d.boundary == 0 {
+		d.boundary = 24 * time.Hour
+	}
+	return d, nil
+}
+
+// 解析时区，+08:00、-05:00、UTC或者时区名称
+func ParseTimezone(s string) (*time.Location, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Local, nil
+	}
+	if s[0] == '+' || s[0] == '-' {
+		parts := strings.Split(s[1:], ":")
+		h, err := strconv.Atoi(parts[0])
+		m := 0
+		if err == nil && len(parts) > 1 {
+			m, err = strconv.Atoi(parts[1])
+		}
+		if err != nil || len(parts) > 2 || h > 14 || m >= 60 {
+			return nil, errors.New("timezone should be like +08:00")
+		}
+		offset := h*3600 + m*60
+		if s[0] == '-' {
+			offset = -offset
+		}
+		return time.FixedZone("UTC"+s, offset), nil
+	}
+	return time.LoadLocation(s)
+}
+
+func (d *TradingDay) Location() *time.Location {
+	return d.loc
+}
+
+// 交易日结束的时刻，格式15:04:05
+func (d *TradingDay) Boundary() string {
+	b := d.boundary % (24 * time.Hour)
+	return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC).Add(b).Format("15:04:05")
+}
+
+// t所属的交易日
+func (d *TradingDay) Day(t time.Time) string {
+	t = t.In(d.loc)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, d.loc)
+	if !t.Before(day.Add(d.boundary)) {
+		day = day.AddDate(0, 0, 1)
+	}
+	return day.Format(day_layout)
+}
+
+// 交易日的开始和结束时间，左闭右开
+func (d *TradingDay) Range(day string) (time.Time, time.Time, error) {
+	t, err := time.ParseInLocation(day_layout, day, d.loc)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	end := t.Add(d.boundary)
+	return end.AddDate(0, 0, -1), end, nil
+}
+
+// 从first到last的全部交易日，包括两端
+func (d *TradingDay) Days(first time.Time, last time.Time) []string {
+	ret := []string{}
+	day := d.Day(first)
+	for end := d.Day(last); day <= end; {
+		ret = append(ret, day)
+		t, _ := time.ParseInLocation(day_layout, day, d.loc)
+		day = t.AddDate(0, 0, 1).Format(day_layout)
+	}
+	return ret
+}
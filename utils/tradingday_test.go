@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTradingDay(t *testing.T) {
+	td, err := NewTradingDay("+08:00", "16:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loc := td.Location()
+
+	cases := []struct {
+		t   time.Time
+		day string
+	}{
+		{time.Date(2017, 12, 25, 15, 59, 59, 0, loc), "2017-12-25"},
+		{time.Date(2017, 12, 25, 16, 0, 0, 0, loc), "2017-12-26"},
+		{time.Date(2017, 12, 25, 0, 0, 0, 0, loc), "2017-12-25"},
+		{time.Date(2017, 12, 31, 20, 0, 0, 0, time.UTC), "2018-01-01"}, // 2018-01-01 04:00 UTC+8
+	}
+	for _, c := range cases {
+		if day := td.Day(c.t); day != c.day {
+			t.Errorf("%s in trading day %s, want %s", c.t, day, c.day)
+		}
+	}
+
+	start, end, err := td.Range("2017-12-25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !start.Equal(time.Date(2017, 12, 24, 16, 0, 0, 0, loc)) || !end.Equal(time.Date(2017, 12, 25, 16, 0, 0, 0, loc)) {
+		t.Fatalf("range of 2017-12-25 is [%s, %s)", start, end)
+	}
+
+	days := td.Days(time.Date(2017, 12, 24, 10, 0, 0, 0, loc), time.Date(2017, 12, 25, 17, 0, 0, 0, loc))
+	if len(days) != 3 || days[0] != "2017-12-24" || days[2] != "2017-12-26" {
+		t.Fatalf("days are %v", days)
+	}
+
+	// 0点切换就是自然日
+	cd, err := NewTradingDay("UTC", "00:00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if day := cd.Day(time.Date(2017, 12, 25, 23, 59, 0, 0, time.UTC)); day != "2017-12-25" || cd.Boundary() != "00:00:00" {
+		t.Fatalf("calendar day is %s, boundary %s", day, cd.Boundary())
+	}
+
+	if _, err := NewTradingDay("+8", "16:00"); err == nil {
+		t.Fatal("boundary 16:00 should fail")
+	}
+}